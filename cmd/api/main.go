@@ -1,12 +1,36 @@
 package main
 
 import (
-	"log"
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/Lamafout/online-store-api/internal/auth"
 	"github.com/Lamafout/online-store-api/internal/bll/services"
+	"github.com/Lamafout/online-store-api/internal/bll/workers"
+	"github.com/Lamafout/online-store-api/internal/cache"
+	"github.com/Lamafout/online-store-api/internal/clientip"
 	"github.com/Lamafout/online-store-api/internal/config"
+	"github.com/Lamafout/online-store-api/internal/dal/memory"
+	"github.com/Lamafout/online-store-api/internal/dal/replica"
+	"github.com/Lamafout/online-store-api/internal/features"
+	dal "github.com/Lamafout/online-store-api/internal/dal/unit_of_work"
 	v1 "github.com/Lamafout/online-store-api/internal/handlers/v1"
+	"github.com/Lamafout/online-store-api/internal/health"
+	"github.com/Lamafout/online-store-api/internal/logging"
+	"github.com/Lamafout/online-store-api/internal/metrics"
+	"github.com/Lamafout/online-store-api/internal/migrate"
+	"github.com/Lamafout/online-store-api/internal/ratelimit"
+	"github.com/Lamafout/online-store-api/internal/tenant"
+	"github.com/Lamafout/online-store-api/internal/tracing"
+	"github.com/Lamafout/online-store-api/migrations"
 	"github.com/go-chi/chi/v5"
 	"github.com/jmoiron/sqlx"
 	"github.com/joho/godotenv"
@@ -21,32 +45,382 @@ import (
 // @description API for managing orders in an online store.
 // @host localhost:8080
 // @BasePath /api/v1
+// @securityDefinitions.apikey BearerAuth
+// @in header
+// @name Authorization
+// @description JWT or API key as "Bearer <token>"
 func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
 	if err := godotenv.Load(); err != nil {
-		log.Printf("No .env file found: %v", err)
+		slog.Info("No .env file found", "error", err)
 	}
 
-	cfg, err := config.LoadConfig()
+	configFile := flag.String("config", os.Getenv(config.FileEnv), "YAML or TOML config file; environment variables override its settings")
+	printConfig := flag.Bool("print-config", false, "Print the effective configuration with secrets redacted and exit")
+	flag.Parse()
+
+	cfg, err := config.Load(*configFile)
 	if err != nil {
-		log.Fatalf("Failed to load config: %v", err)
+		fatal("Failed to load config", err)
+	}
+	if *printConfig {
+		if err := cfg.WriteRedacted(os.Stdout); err != nil {
+			fatal("Failed to print config", err)
+		}
+		return
 	}
 
-	db, err := sqlx.Connect("pgx", cfg.DbSettings.ConnectionString)
+	logLevel := new(slog.LevelVar)
+	level, err := logging.ParseLevel(cfg.LogSettings.Level)
 	if err != nil {
-		log.Fatalf("Failed to connect to database: %v", err)
+		fatal("Failed to configure logging", err)
 	}
-	defer db.Close()
+	logLevel.Set(level)
+	logger, err := logging.New(os.Stdout, logLevel, cfg.LogSettings.Format)
+	if err != nil {
+		fatal("Failed to configure logging", err)
+	}
+	slog.SetDefault(logger)
+
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Options{
+		Exporter:     cfg.TracingSettings.Exporter,
+		OTLPEndpoint: cfg.TracingSettings.OTLPEndpoint,
+		SampleRatio:  cfg.TracingSettings.SampleRatio,
+	})
+	if err != nil {
+		fatal("Failed to configure tracing", err)
+	}
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			slog.Error("Failed to flush traces", "error", err)
+		}
+	}()
+
+	healthHandler := health.NewHandler()
+
+	var db *sqlx.DB
+	var replicas *replica.Pool
+	var uowFactory *dal.UnitOfWorkFactory
+	if cfg.DbSettings.Storage == "memory" {
+		slog.Warn("Orders are kept in memory and lost on exit; jobs, API keys and role assignments are unavailable")
+		uowFactory = dal.NewMemoryUnitOfWorkFactory(memory.NewStore())
+	} else {
+		db, err = sqlx.Connect("pgx", cfg.DbSettings.ConnectionString)
+		if err != nil {
+			fatal("Failed to connect to database", err)
+		}
+		configurePool(db, cfg.DbSettings.Pool)
+
+		if cfg.DbSettings.AutoMigrate {
+			if err := autoMigrate(ctx, cfg.DbSettings.MigrationConnectionString); err != nil {
+				fatal("Failed to migrate database", err)
+			}
+		}
+
+		healthHandler.AddCheck("database", health.DatabaseCheck(db))
+		migrationsCheck, err := health.MigrationsCheck(db, migrations.FS)
+		if err != nil {
+			fatal("Failed to configure readiness", err)
+		}
+		healthHandler.AddCheck("migrations", migrationsCheck)
+
+		// Replicas are opened without connecting: one that is down at startup is only
+		// skipped until its health check passes
+		if len(cfg.DbSettings.ReplicaConnectionStrings) > 0 {
+			replicaDBs := make([]*sqlx.DB, len(cfg.DbSettings.ReplicaConnectionStrings))
+			for i, connString := range cfg.DbSettings.ReplicaConnectionStrings {
+				if replicaDBs[i], err = sqlx.Open("pgx", connString); err != nil {
+					fatal("Failed to open read replica", err)
+				}
+				configurePool(replicaDBs[i], cfg.DbSettings.Pool)
+			}
+			replicas = replica.NewPool(replicaDBs)
+			go replicas.Run(ctx, cfg.DbSettings.ReplicaCheckInterval)
+		}
+
+		uowFactory = dal.NewUnitOfWorkFactory(db, replicas, cfg.DbSettings.RowLevelSecurity)
+	}
+	isolationLevel, err := dal.ParseIsolationLevel(cfg.DbSettings.IsolationLevel)
+	if err != nil {
+		fatal("Invalid DB_ISOLATION_LEVEL", err)
+	}
+	txRunner := dal.NewTxRunner(uowFactory, &sql.TxOptions{Isolation: isolationLevel}, dal.RetryPolicy{
+		MaxAttempts: cfg.DbSettings.RetryMaxAttempts,
+		BaseDelay:   cfg.DbSettings.RetryBaseDelay,
+		MaxDelay:    cfg.DbSettings.RetryMaxDelay,
+	})
 
 	orderService := services.NewOrderService()
+	if cfg.CacheSettings.Enabled {
+		orderService = services.NewCachedOrderService(cache.NewLRU(cfg.CacheSettings.Size), cfg.CacheSettings.TTL)
+	}
+	jobService := services.NewJobService(orderService)
+	toggles := features.NewToggles(featureFlags(cfg.FeatureSettings))
+
+	// Workers stop claiming jobs on shutdown; a job cut short keeps its lease and is
+	// resumed by another instance once the lease expires
+	workersDone := make(chan struct{})
+	if cfg.JobSettings.Workers > 0 && db != nil {
+		pool := workers.NewJobWorkerPool(uowFactory, jobService, cfg.JobSettings.Workers, cfg.JobSettings.PollInterval)
+		go func() {
+			pool.Run(ctx)
+			close(workersDone)
+		}()
+	} else {
+		close(workersDone)
+	}
+
+	apiKeyService := services.NewAPIKeyService()
+	roleService := services.NewRoleService()
+
+	authMiddleware := auth.Anonymous()
+	if cfg.AuthSettings.Enabled {
+		var validator *auth.JWTValidator
+		if cfg.AuthSettings.HS256Secret != "" || cfg.AuthSettings.RS256PublicKeyFile != "" || cfg.AuthSettings.JWKSFile != "" {
+			validator, err = auth.NewJWTValidator(auth.JWTOptions{
+				HS256Secret:        cfg.AuthSettings.HS256Secret,
+				RS256PublicKeyFile: cfg.AuthSettings.RS256PublicKeyFile,
+				JWKSFile:           cfg.AuthSettings.JWKSFile,
+				Issuer:             cfg.AuthSettings.Issuer,
+				Audience:           cfg.AuthSettings.Audience,
+			})
+			if err != nil {
+				fatal("Failed to configure authentication", err)
+			}
+		} else {
+			slog.Warn("No JWT keys configured, only API keys are accepted")
+		}
+
+		authMiddleware = auth.Middleware(validator, func(ctx context.Context, key string) (*auth.Principal, error) {
+			return apiKeyService.Authenticate(ctx, uowFactory.Create(), key)
+		})
+	} else {
+		slog.Warn("Authentication is disabled, all requests are treated as admin")
+	}
+
+	rateLimitMiddleware := func(next http.Handler) http.Handler { return next }
+	preAuthRateLimitMiddleware := rateLimitMiddleware
+	var rateLimits, preAuthRateLimits *ratelimit.Rules
+	if cfg.RateLimitSettings.Enabled {
+		rules, fallback, err := rateLimitRules(cfg.RateLimitSettings)
+		if err != nil {
+			fatal("Failed to configure rate limits", err)
+		}
+		rateLimits = ratelimit.NewRules(rules, fallback)
+		preAuth, err := preAuthRateLimitRule(cfg.RateLimitSettings)
+		if err != nil {
+			fatal("Failed to configure rate limits", err)
+		}
+		preAuthRateLimits = ratelimit.NewRules(nil, preAuth)
+		// Throttling by IP ahead of authentication must stay cheap, so its buckets are
+		// always kept in memory
+		preAuthRateLimitMiddleware = ratelimit.PreAuthMiddleware(ratelimit.NewMemoryStore(), preAuthRateLimits)
+
+		var store ratelimit.Store = ratelimit.NewMemoryStore()
+		if cfg.RateLimitSettings.Store == "postgres" {
+			store = ratelimit.NewPostgresStore(uowFactory)
+		}
+		rateLimitMiddleware = ratelimit.Middleware(store, rateLimits)
+	}
+
+	// Log level, rate limits and feature flags follow the config file while the server
+	// runs. A reload is applied only once all of them are known to be valid.
+	if *configFile != "" {
+		watcher, err := config.NewWatcher(*configFile, cfg, func(next *config.Config) error {
+			level, err := logging.ParseLevel(next.LogSettings.Level)
+			if err != nil {
+				return err
+			}
+			var rules []ratelimit.Rule
+			var fallback, preAuth ratelimit.Rule
+			if rateLimits != nil {
+				if rules, fallback, err = rateLimitRules(next.RateLimitSettings); err != nil {
+					return err
+				}
+				if preAuth, err = preAuthRateLimitRule(next.RateLimitSettings); err != nil {
+					return err
+				}
+			}
+
+			logLevel.Set(level)
+			if rateLimits != nil {
+				rateLimits.Store(rules, fallback)
+				preAuthRateLimits.Store(nil, preAuth)
+			}
+			toggles.Store(featureFlags(next.FeatureSettings))
+			return nil
+		})
+		if err != nil {
+			fatal("Failed to watch config file", err)
+		}
+		go watcher.Run(ctx)
+	}
 
 	r := chi.NewRouter()
+	r.Use(tracing.Middleware)
+	r.Use(clientip.Middleware(cfg.ServerSettings.TrustedProxies))
+	r.Use(logging.RequestID(logger))
+	r.Use(logging.AccessLog())
+	if cfg.MetricsSettings.Enabled {
+		if db != nil {
+			metrics.RegisterDBStats(db)
+		}
+		r.Use(metrics.Middleware)
+		r.Handle("/metrics", metrics.Handler())
+	}
+	r.Get("/healthz", healthHandler.Live)
+	r.Get("/readyz", healthHandler.Ready)
 	r.Route("/api/v1", func(r chi.Router) {
-		r.Mount("/orders", v1.NewOrderHandler(db, orderService).Routes())
+		r.Use(preAuthRateLimitMiddleware)
+		r.Use(authMiddleware)
+		r.Use(tenant.Middleware(tenant.Resolver{
+			Header:  cfg.TenantSettings.Header,
+			Hosts:   cfg.TenantSettings.Hosts,
+			Default: cfg.TenantSettings.Default,
+		}))
+		r.Use(auth.AssignedRoles(func(ctx context.Context, subject string) ([]string, error) {
+			return roleService.RolesForSubject(ctx, uowFactory.Create(), subject)
+		}))
+		r.Use(rateLimitMiddleware)
+		r.Use(cache.Middleware)
+		if replicas != nil {
+			r.Use(replica.Middleware(int(cfg.DbSettings.ReadYourWritesTTL.Seconds())))
+		}
+		r.Mount("/orders", v1.NewOrderHandler(uowFactory, txRunner, orderService, jobService, toggles).Routes())
+		r.Mount("/jobs", v1.NewJobHandler(uowFactory, jobService).Routes())
+		r.Mount("/api-keys", v1.NewAPIKeyHandler(uowFactory, apiKeyService).Routes())
+		r.Mount("/role-assignments", v1.NewRoleHandler(uowFactory, roleService).Routes())
 	})
 	r.Get("/swagger/*", httpSwagger.WrapHandler)
 
-	log.Printf("Starting server on :%s", cfg.ServerPort)
-	if err := http.ListenAndServe(":"+cfg.ServerPort, r); err != nil {
-		log.Fatalf("Server failed: %v", err)
+	server := &http.Server{
+		Addr:         ":" + cfg.ServerPort,
+		Handler:      r,
+		ReadTimeout:  cfg.ServerSettings.ReadTimeout,
+		WriteTimeout: cfg.ServerSettings.WriteTimeout,
+		IdleTimeout:  cfg.ServerSettings.IdleTimeout,
+		ErrorLog:     slog.NewLogLogger(logger.Handler(), slog.LevelWarn),
+	}
+
+	serverErr := make(chan error, 1)
+	go func() {
+		slog.Info("Starting server", "port", cfg.ServerPort)
+		serverErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serverErr:
+		fatal("Server failed", err)
+	case <-ctx.Done():
+	}
+	stop()
+
+	slog.Info("Shutting down", "delay", cfg.ServerSettings.ShutdownDelay, "timeout", cfg.ServerSettings.ShutdownTimeout)
+	healthHandler.Drain()
+	time.Sleep(cfg.ServerSettings.ShutdownDelay)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ServerSettings.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Error("In-flight requests did not finish in time", "error", err)
+	}
+	select {
+	case <-workersDone:
+	case <-shutdownCtx.Done():
+		slog.Error("Job workers did not stop in time")
+	}
+
+	if db != nil {
+		if err := db.Close(); err != nil {
+			slog.Error("Failed to close database pool", "error", err)
+		}
+	}
+	if replicas != nil {
+		if err := replicas.Close(); err != nil {
+			slog.Error("Failed to close replica pools", "error", err)
+		}
+	}
+	slog.Info("Server stopped")
+}
+
+// fatal logs err and exits, for failures the server cannot start or keep running after
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
+
+func configurePool(db *sqlx.DB, settings config.PoolSettings) {
+	db.SetMaxOpenConns(settings.MaxOpenConns)
+	db.SetMaxIdleConns(settings.MaxIdleConns)
+	db.SetConnMaxLifetime(settings.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(settings.ConnMaxIdleTime)
+}
+
+// rateLimitRules builds the rate limiter's rules from settings. Configured routes come
+// first, then batch creation and import, which are charged per order, then the default
+// limit.
+func rateLimitRules(settings config.RateLimitSettings) ([]ratelimit.Rule, ratelimit.Rule, error) {
+	defaultLimit, err := ratelimit.ParseLimit(settings.Default)
+	if err != nil {
+		return nil, ratelimit.Rule{}, fmt.Errorf("invalid rate_limit.default (RATE_LIMIT_DEFAULT): %w", err)
+	}
+	batchLimit, err := ratelimit.ParseLimit(settings.BatchOrders)
+	if err != nil {
+		return nil, ratelimit.Rule{}, fmt.Errorf("invalid rate_limit.batch_orders (RATE_LIMIT_BATCH_ORDERS): %w", err)
+	}
+	rules, err := ratelimit.ParseRules(settings.Routes)
+	if err != nil {
+		return nil, ratelimit.Rule{}, fmt.Errorf("invalid rate_limit.routes (RATE_LIMIT_ROUTES): %w", err)
+	}
+	rules = append(rules, ratelimit.Rule{
+		Name:    "batch-create",
+		Method:  http.MethodPost,
+		Pattern: "/api/v1/orders/batch-create",
+		Limit:   batchLimit,
+		Cost:    ratelimit.JSONArrayCost("orders"),
+	}, ratelimit.Rule{
+		// Imports draw on the same per-order quota, chunk by chunk as they stream in
+		Name:     "batch-create",
+		Method:   http.MethodPost,
+		Pattern:  "/api/v1/orders/import",
+		Limit:    batchLimit,
+		Deferred: true,
+	})
+	return rules, ratelimit.Rule{Name: "default", Limit: defaultLimit}, nil
+}
+
+// preAuthRateLimitRule builds the rule of the per-IP limiter running before authentication
+func preAuthRateLimitRule(settings config.RateLimitSettings) (ratelimit.Rule, error) {
+	limit, err := ratelimit.ParseLimit(settings.PreAuth)
+	if err != nil {
+		return ratelimit.Rule{}, fmt.Errorf("invalid rate_limit.pre_auth (RATE_LIMIT_PRE_AUTH): %w", err)
+	}
+	return ratelimit.Rule{Name: "pre-auth", Limit: limit}, nil
+}
+
+func featureFlags(settings config.FeatureSettings) features.Flags {
+	return features.Flags{
+		OrderImport:  settings.OrderImport,
+		OrderExport:  settings.OrderExport,
+		AsyncBatches: settings.AsyncBatches,
+	}
+}
+
+// autoMigrate applies pending migrations with the migration credentials. Other
+// instances starting at the same time wait for the advisory lock, then find nothing
+// left to apply.
+func autoMigrate(ctx context.Context, connString string) error {
+	db, err := sqlx.Connect("pgx", connString)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	results, err := migrate.Up(ctx, db.DB)
+	for _, result := range results {
+		slog.Info("Applied migration", "version", result.Source.Version, "duration", result.Duration)
 	}
+	return err
 }
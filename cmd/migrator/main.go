@@ -1,37 +1,273 @@
 package main
 
 import (
-	_"context"
+	"context"
 	"fmt"
-	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"text/tabwriter"
+	"time"
 
+	core "github.com/Lamafout/online-store-api/core/models/common"
+	"github.com/Lamafout/online-store-api/internal/bll/services"
+	"github.com/Lamafout/online-store-api/internal/config"
+	"github.com/Lamafout/online-store-api/internal/dal/unit_of_work"
+	"github.com/Lamafout/online-store-api/internal/migrate"
+	"github.com/Lamafout/online-store-api/internal/tenant"
+	"github.com/Lamafout/online-store-api/migrations"
+	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/jmoiron/sqlx"
 	"github.com/pressly/goose/v3"
-	_"github.com/jackc/pgx/v5/stdlib"
-	"github.com/Lamafout/online-store-api/internal/config"
+	"github.com/urfave/cli/v2"
 )
 
 func main() {
-	cfg, err := config.LoadConfig()
-	if err != nil {
-		log.Fatalf("Failed to load config: %v", err)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	app := &cli.App{
+		Name:  "migrator",
+		Usage: "manage the database schema",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "dsn",
+				Usage:   "database to migrate, instead of the one configured by the DB_* variables",
+				EnvVars: []string{"MIGRATION_DSN"},
+			},
+			&cli.StringFlag{
+				Name:    "config",
+				Usage:   "YAML or TOML config file to read the database settings from",
+				EnvVars: []string{config.FileEnv},
+			},
+		},
+		Commands: []*cli.Command{
+			{
+				Name:  "up",
+				Usage: "apply all pending migrations",
+				Action: withProvider(func(ctx context.Context, provider *goose.Provider, _ cli.Args) error {
+					results, err := provider.Up(ctx)
+					printResults(results)
+					return err
+				}),
+			},
+			{
+				Name:      "up-to",
+				Usage:     "apply pending migrations up to and including a version",
+				ArgsUsage: "VERSION",
+				Action: withProvider(func(ctx context.Context, provider *goose.Provider, args cli.Args) error {
+					version, err := versionArg(args)
+					if err != nil {
+						return err
+					}
+					results, err := provider.UpTo(ctx, version)
+					printResults(results)
+					return err
+				}),
+			},
+			{
+				Name:  "down",
+				Usage: "roll back the last applied migration",
+				Action: withProvider(func(ctx context.Context, provider *goose.Provider, _ cli.Args) error {
+					result, err := provider.Down(ctx)
+					printResults([]*goose.MigrationResult{result})
+					return err
+				}),
+			},
+			{
+				Name:      "down-to",
+				Usage:     "roll back migrations until a version is the latest applied, 0 rolls back all",
+				ArgsUsage: "VERSION",
+				Action: withProvider(func(ctx context.Context, provider *goose.Provider, args cli.Args) error {
+					version, err := versionArg(args)
+					if err != nil {
+						return err
+					}
+					results, err := provider.DownTo(ctx, version)
+					printResults(results)
+					return err
+				}),
+			},
+			{
+				Name:  "redo",
+				Usage: "roll back the last applied migration and apply it again",
+				Action: withProvider(func(ctx context.Context, provider *goose.Provider, _ cli.Args) error {
+					result, err := provider.Down(ctx)
+					printResults([]*goose.MigrationResult{result})
+					if err != nil {
+						return err
+					}
+					result, err = provider.UpByOne(ctx)
+					printResults([]*goose.MigrationResult{result})
+					return err
+				}),
+			},
+			{
+				Name:  "status",
+				Usage: "list the migrations and whether they are applied",
+				Action: withProvider(func(ctx context.Context, provider *goose.Provider, _ cli.Args) error {
+					statuses, err := provider.Status(ctx)
+					if err != nil {
+						return err
+					}
+					w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+					fmt.Fprintln(w, "VERSION\tMIGRATION\tAPPLIED AT")
+					for _, status := range statuses {
+						appliedAt := "pending"
+						if status.State == goose.StateApplied {
+							appliedAt = status.AppliedAt.Format(time.RFC3339)
+						}
+						fmt.Fprintf(w, "%d\t%s\t%s\n", status.Source.Version, status.Source.Path, appliedAt)
+					}
+					return w.Flush()
+				}),
+			},
+			{
+				Name:  "version",
+				Usage: "print the version the schema is at",
+				Action: withProvider(func(ctx context.Context, provider *goose.Provider, _ cli.Args) error {
+					version, err := provider.GetDBVersion(ctx)
+					if err != nil {
+						return err
+					}
+					fmt.Println(version)
+					return nil
+				}),
+			},
+			{
+				Name:  "bootstrap-api-key",
+				Usage: "issue the first admin API key of a tenant and print it",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "tenant",
+						Usage:    "tenant the key belongs to",
+						Required: true,
+					},
+					&cli.StringFlag{
+						Name:  "name",
+						Usage: "name of the key",
+						Value: "bootstrap",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					db, err := connect(c)
+					if err != nil {
+						return err
+					}
+					defer db.Close()
+
+					ctx := tenant.WithTenant(c.Context, c.String("tenant"))
+					uow := dal.NewUnitOfWorkFactory(db, nil, false).Create()
+					var key *core.APIKey
+					var plaintext string
+					err = uow.WithinTransaction(ctx, func(ctx context.Context) error {
+						key, plaintext, err = services.NewAPIKeyService().BootstrapAPIKey(ctx, uow, c.String("name"), "migrator")
+						return err
+					})
+					if err != nil {
+						return err
+					}
+					fmt.Fprintf(os.Stderr, "Created API key %d for tenant %s; it is shown only once\n", key.ID, c.String("tenant"))
+					fmt.Println(plaintext)
+					return nil
+				},
+			},
+			{
+				Name:      "create",
+				Usage:     "add an empty migration after the last one",
+				ArgsUsage: "NAME",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "dir",
+						Usage: "directory of the migration sources",
+						Value: "migrations",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					if c.Args().Len() != 1 {
+						return fmt.Errorf("expected a migration name")
+					}
+					file, err := migrate.Create(c.String("dir"), c.Args().First())
+					if err != nil {
+						return err
+					}
+					fmt.Println("Created", file)
+					return nil
+				},
+			},
+			{
+				Name:  "validate",
+				Usage: "check the embedded migrations without connecting to the database",
+				Action: func(c *cli.Context) error {
+					if err := migrate.Validate(migrations.FS); err != nil {
+						return err
+					}
+					fmt.Println("Migrations are valid")
+					return nil
+				},
+			},
+		},
+	}
+
+	if err := app.RunContext(ctx, os.Args); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+}
+
+// withProvider connects to the database to migrate and hands a provider of the embedded
+// migrations to action
+func withProvider(action func(ctx context.Context, provider *goose.Provider, args cli.Args) error) cli.ActionFunc {
+	return func(c *cli.Context) error {
+		db, err := connect(c)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		provider, err := migrate.NewProvider(db.DB)
+		if err != nil {
+			return err
+		}
+		return action(c.Context, provider, c.Args())
 	}
+}
 
-	connString := cfg.DbSettings.MigrationConnectionString
-	if connString == "" {
-		log.Fatal("MigrationConnectionString is not set")
+// connect opens the database given by --dsn, or else by the configured migration
+// connection string
+func connect(c *cli.Context) (*sqlx.DB, error) {
+	dsn := c.String("dsn")
+	if dsn == "" {
+		cfg, err := config.Load(c.String("config"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to load config: %w", err)
+		}
+		dsn = cfg.DbSettings.MigrationConnectionString
 	}
 
-	db, err := sqlx.Connect("pgx", connString)
+	db, err := sqlx.Connect("pgx", dsn)
 	if err != nil {
-		log.Fatalf("Unable to connect to database: %v", err)
+		return nil, fmt.Errorf("unable to connect to database: %w", err)
 	}
-	defer db.Close()
+	return db, nil
+}
 
-	goose.SetDialect("postgres")
-	if err := goose.Up(db.DB, "migrations"); err != nil {
-		log.Fatalf("Failed to run migrations: %v", err)
+func versionArg(args cli.Args) (int64, error) {
+	if args.Len() != 1 {
+		return 0, fmt.Errorf("expected a version")
+	}
+	version, err := strconv.ParseInt(args.First(), 10, 64)
+	if err != nil || version < 0 {
+		return 0, fmt.Errorf("invalid version %q", args.First())
 	}
+	return version, nil
+}
 
-	fmt.Println("Migrations applied successfully")
-}
\ No newline at end of file
+func printResults(results []*goose.MigrationResult) {
+	for _, result := range results {
+		if result != nil {
+			fmt.Println(result)
+		}
+	}
+}
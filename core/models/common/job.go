@@ -0,0 +1,47 @@
+package common
+
+import "time"
+
+const (
+	JobTypeBatchCreateOrders = "batch_create_orders"
+
+	JobStatusPending   = "pending"
+	JobStatusRunning   = "running"
+	JobStatusCompleted = "completed"
+	JobStatusFailed    = "failed"
+)
+
+type Job struct {
+	ID             int64       `json:"id"`
+	TenantID       string      `json:"tenant_id"`
+	Type           string      `json:"type"`
+	Status         string      `json:"status"`
+	TotalItems     int         `json:"total_items"`
+	ProcessedItems int         `json:"processed_items"`
+	FailedItems    int         `json:"failed_items"`
+	ErrorCode      string      `json:"error_code,omitempty"`
+	Error          string      `json:"error,omitempty"`
+	Attempts       int         `json:"attempts"`
+	CreatedBy      string      `json:"created_by"`
+	CreatedAt      time.Time   `json:"created_at"`
+	UpdatedAt      time.Time   `json:"updated_at"`
+	StartedAt      *time.Time  `json:"started_at,omitempty"`
+	FinishedAt     *time.Time  `json:"finished_at,omitempty"`
+	Results        []JobResult `json:"results"`
+}
+
+type JobResult struct {
+	Index     int          `json:"index"`
+	OrderID   int64        `json:"order_id,omitempty"`
+	ErrorCode string       `json:"error_code,omitempty"`
+	Error     string       `json:"error,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+}
+
+// FieldError is a validation failure of one field of an order
+type FieldError struct {
+	FieldPath string `json:"field_path"`
+	Rule      string `json:"rule"`
+	Param     string `json:"param,omitempty"`
+	Message   string `json:"message"`
+}
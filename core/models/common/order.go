@@ -10,5 +10,5 @@ type Order struct {
 	TotalPriceCurrency string      `json:"total_price_currency" validate:"required,oneof=USD EUR"`
 	CreatedAt          time.Time   `json:"created_at"`
 	UpdatedAt          time.Time   `json:"updated_at"`
-	Items              []OrderItem `json:"items"`
+	Items              []OrderItem `json:"items" validate:"dive"`
 }
\ No newline at end of file
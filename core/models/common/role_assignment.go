@@ -0,0 +1,10 @@
+package common
+
+import "time"
+
+type RoleAssignment struct {
+	Subject   string    `json:"subject"`
+	Role      string    `json:"role"`
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -28,3 +28,13 @@ type V1QueryOrdersRequest struct {
 	PageSize          *int    `json:"page_size"`
 	IncludeOrderItems bool    `json:"include_order_items"`
 }
+
+type V1CreateAPIKeyRequest struct {
+	Name   string   `json:"name" validate:"required,max=255"`
+	Scopes []string `json:"scopes" validate:"required,min=1,dive,oneof=orders:read orders:write admin"`
+}
+
+type V1AssignRoleRequest struct {
+	Subject string `json:"subject" validate:"required,max=255"`
+	Role    string `json:"role" validate:"required,oneof=staff support warehouse finance admin"`
+}
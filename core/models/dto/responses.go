@@ -10,4 +10,81 @@ type V1CreateOrderResponse struct {
 
 type V1QueryOrdersResponse struct {
     Orders []common.Order `json:"orders"`
-}
\ No newline at end of file
+}
+type V1ImportOrdersResponse struct {
+    Imported int                    `json:"imported"`
+    Failed   int                    `json:"failed"`
+    Stopped  bool                   `json:"stopped"`
+    Error    string                 `json:"error,omitempty"`
+    Created  []V1ImportOrderCreated `json:"created"`
+    // CreatedTruncated is set when more records were imported than are listed
+    CreatedTruncated bool                   `json:"created_truncated,omitempty"`
+    Failures         []V1ImportOrderFailure `json:"failures"`
+    // FailuresTruncated is set when more records failed than are listed
+    FailuresTruncated bool `json:"failures_truncated,omitempty"`
+}
+
+type V1ImportOrderCreated struct {
+    Line     int    `json:"line"`
+    SourceID string `json:"source_id,omitempty"`
+    OrderID  int64  `json:"order_id"`
+}
+
+type V1ImportOrderFailure struct {
+    Line     int    `json:"line"`
+    SourceID string `json:"source_id,omitempty"`
+    Code     string `json:"code"`
+    Error    string `json:"error"`
+}
+
+type V1JobAcceptedResponse struct {
+    JobID     int64  `json:"job_id"`
+    Status    string `json:"status"`
+    StatusURL string `json:"status_url"`
+}
+
+type V1BatchCreateOrdersPartialResponse struct {
+    Created int                        `json:"created"`
+    Failed  int                        `json:"failed"`
+    Results []V1BatchCreateOrderResult `json:"results"`
+}
+
+type V1BatchCreateOrderResult struct {
+    Index  int            `json:"index"`
+    Status string         `json:"status"`
+    Order  *common.Order  `json:"order,omitempty"`
+    Errors []V1FieldError `json:"errors,omitempty"`
+    Code   string         `json:"code,omitempty"`
+    Error  string         `json:"error,omitempty"`
+}
+
+type V1Problem struct {
+    Type      string         `json:"type"`
+    Title     string         `json:"title"`
+    Status    int            `json:"status"`
+    Detail    string         `json:"detail,omitempty"`
+    Instance  string         `json:"instance,omitempty"`
+    Code      string         `json:"code"`
+    RequestID string         `json:"request_id,omitempty"`
+    Errors    []V1FieldError `json:"errors,omitempty"`
+}
+
+type V1FieldError struct {
+    FieldPath string `json:"field_path"`
+    Rule      string `json:"rule"`
+    Param     string `json:"param,omitempty"`
+    Message   string `json:"message"`
+}
+
+type V1APIKeySecretResponse struct {
+    APIKey common.APIKey `json:"api_key"`
+    Key    string        `json:"key"`
+}
+
+type V1ListAPIKeysResponse struct {
+    APIKeys []common.APIKey `json:"api_keys"`
+}
+
+type V1ListRoleAssignmentsResponse struct {
+    RoleAssignments []common.RoleAssignment `json:"role_assignments"`
+}
@@ -0,0 +1,196 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Claims are the JWT claims the API understands on top of the registered ones
+type Claims struct {
+	jwt.RegisteredClaims
+	Role       string `json:"role"`
+	CustomerID int64  `json:"customer_id,omitempty"`
+	TenantID   string `json:"tenant_id,omitempty"`
+}
+
+// JWTValidator verifies HS256 and RS256 tokens and turns them into principals
+type JWTValidator struct {
+	hmacSecret []byte
+	rsaKeys    map[string]*rsa.PublicKey
+	issuer     string
+	audience   string
+}
+
+// JWTOptions configures a JWTValidator. At least one key source must be set.
+type JWTOptions struct {
+	HS256Secret        string
+	RS256PublicKeyFile string
+	JWKSFile           string
+	Issuer             string
+	Audience           string
+}
+
+// NewJWTValidator loads the configured keys and creates a new JWTValidator
+func NewJWTValidator(opts JWTOptions) (*JWTValidator, error) {
+	v := &JWTValidator{
+		rsaKeys:  make(map[string]*rsa.PublicKey),
+		issuer:   opts.Issuer,
+		audience: opts.Audience,
+	}
+
+	if opts.HS256Secret != "" {
+		v.hmacSecret = []byte(opts.HS256Secret)
+	}
+
+	if opts.RS256PublicKeyFile != "" {
+		key, err := loadRSAPublicKeyPEM(opts.RS256PublicKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		v.rsaKeys[""] = key
+	}
+
+	if opts.JWKSFile != "" {
+		keys, err := loadJWKS(opts.JWKSFile)
+		if err != nil {
+			return nil, err
+		}
+		for kid, key := range keys {
+			v.rsaKeys[kid] = key
+		}
+	}
+
+	if v.hmacSecret == nil && len(v.rsaKeys) == 0 {
+		return nil, fmt.Errorf("no JWT verification keys configured")
+	}
+	return v, nil
+}
+
+// Validate verifies the token signature and claims and returns the principal it describes
+func (v *JWTValidator) Validate(tokenString string) (*Principal, error) {
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg(), jwt.SigningMethodRS256.Alg()}),
+		jwt.WithExpirationRequired(),
+	}
+	if v.issuer != "" {
+		opts = append(opts, jwt.WithIssuer(v.issuer))
+	}
+	if v.audience != "" {
+		opts = append(opts, jwt.WithAudience(v.audience))
+	}
+
+	var claims Claims
+	if _, err := jwt.ParseWithClaims(tokenString, &claims, v.key, opts...); err != nil {
+		return nil, fmt.Errorf("invalid token: %w", err)
+	}
+
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("invalid token: missing subject")
+	}
+	switch claims.Role {
+	case RoleStaff, RoleSupport, RoleWarehouse, RoleFinance, RoleAdmin:
+	case RoleCustomer:
+		if claims.CustomerID <= 0 {
+			return nil, fmt.Errorf("invalid token: customer token without customer_id")
+		}
+	default:
+		return nil, fmt.Errorf("invalid token: unknown role %q", claims.Role)
+	}
+
+	return &Principal{
+		Subject:    claims.Subject,
+		Role:       claims.Role,
+		CustomerID: claims.CustomerID,
+		TenantID:   claims.TenantID,
+	}, nil
+}
+
+// key picks the verification key for the token's algorithm and key ID
+func (v *JWTValidator) key(token *jwt.Token) (interface{}, error) {
+	switch token.Method.Alg() {
+	case jwt.SigningMethodHS256.Alg():
+		if v.hmacSecret == nil {
+			return nil, errors.New("HS256 tokens are not accepted")
+		}
+		return v.hmacSecret, nil
+	case jwt.SigningMethodRS256.Alg():
+		kid, _ := token.Header["kid"].(string)
+		if key, ok := v.rsaKeys[kid]; ok {
+			return key, nil
+		}
+		// Tokens without a matching kid fall back to the single PEM key, if any
+		if key, ok := v.rsaKeys[""]; ok {
+			return key, nil
+		}
+		return nil, fmt.Errorf("unknown key ID %q", kid)
+	default:
+		return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+	}
+}
+
+func loadRSAPublicKeyPEM(path string) (*rsa.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read RS256 public key: %w", err)
+	}
+	key, err := jwt.ParseRSAPublicKeyFromPEM(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse RS256 public key: %w", err)
+	}
+	return key, nil
+}
+
+type jwks struct {
+	Keys []struct {
+		Kty string `json:"kty"`
+		Kid string `json:"kid"`
+		Use string `json:"use"`
+		Alg string `json:"alg"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+	} `json:"keys"`
+}
+
+// loadJWKS reads the RSA signing keys of a JSON Web Key Set file, indexed by key ID
+func loadJWKS(path string) (map[string]*rsa.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JWKS file: %w", err)
+	}
+
+	var set jwks
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("failed to parse JWKS file: %w", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") || (k.Alg != "" && k.Alg != jwt.SigningMethodRS256.Alg()) {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus of JWKS key %q: %w", k.Kid, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exponent of JWKS key %q: %w", k.Kid, err)
+		}
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("JWKS file contains no RS256 signing keys")
+	}
+	return keys, nil
+}
@@ -0,0 +1,304 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const testSecret = "test-secret-test-secret-test-secret"
+
+func generateRSAKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate RSA key: %v", err)
+	}
+	return key
+}
+
+// writePublicKeyPEM writes the public half of key to a PEM file and returns its path and contents
+func writePublicKeyPEM(t *testing.T, key *rsa.PrivateKey) (string, []byte) {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("failed to marshal public key: %v", err)
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	path := filepath.Join(t.TempDir(), "public.pem")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("failed to write public key: %v", err)
+	}
+	return path, data
+}
+
+// writeJWKS writes a JWKS file holding the public halves of keys under their key IDs
+func writeJWKS(t *testing.T, keys map[string]*rsa.PrivateKey) string {
+	t.Helper()
+	type jwk struct {
+		Kty string `json:"kty"`
+		Kid string `json:"kid"`
+		Use string `json:"use"`
+		Alg string `json:"alg"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	for kid, key := range keys {
+		set.Keys = append(set.Keys, jwk{
+			Kty: "RSA",
+			Kid: kid,
+			Use: "sig",
+			Alg: "RS256",
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		})
+	}
+	data, err := json.Marshal(set)
+	if err != nil {
+		t.Fatalf("failed to marshal JWKS: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("failed to write JWKS: %v", err)
+	}
+	return path
+}
+
+func validClaims() Claims {
+	return Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   "user-1",
+			Issuer:    "https://issuer.example",
+			Audience:  jwt.ClaimStrings{"online-store-api"},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+		Role: RoleStaff,
+	}
+}
+
+func sign(t *testing.T, method jwt.SigningMethod, kid string, claims Claims, key interface{}) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+	return signed
+}
+
+func newValidator(t *testing.T, opts JWTOptions) *JWTValidator {
+	t.Helper()
+	v, err := NewJWTValidator(opts)
+	if err != nil {
+		t.Fatalf("NewJWTValidator() error = %v", err)
+	}
+	return v
+}
+
+func TestJWTValidatorValidate(t *testing.T) {
+	rsaKey := generateRSAKey(t)
+	pemPath, pemData := writePublicKeyPEM(t, rsaKey)
+
+	hsOnly := newValidator(t, JWTOptions{HS256Secret: testSecret, Issuer: "https://issuer.example", Audience: "online-store-api"})
+	rsOnly := newValidator(t, JWTOptions{RS256PublicKeyFile: pemPath, Issuer: "https://issuer.example", Audience: "online-store-api"})
+
+	withoutExp := validClaims()
+	withoutExp.ExpiresAt = nil
+	expired := validClaims()
+	expired.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+	otherIssuer := validClaims()
+	otherIssuer.Issuer = "https://evil.example"
+	otherAudience := validClaims()
+	otherAudience.Audience = jwt.ClaimStrings{"another-api"}
+	customerWithoutID := validClaims()
+	customerWithoutID.Role = RoleCustomer
+	unknownRole := validClaims()
+	unknownRole.Role = "root"
+
+	noneToken := sign(t, jwt.SigningMethodNone, "", validClaims(), jwt.UnsafeAllowNoneSignatureType)
+
+	tests := []struct {
+		name      string
+		validator *JWTValidator
+		token     string
+		wantErr   bool
+	}{
+		{name: "valid HS256", validator: hsOnly, token: sign(t, jwt.SigningMethodHS256, "", validClaims(), []byte(testSecret))},
+		{name: "valid RS256", validator: rsOnly, token: sign(t, jwt.SigningMethodRS256, "", validClaims(), rsaKey)},
+		{name: "alg none against HS256", validator: hsOnly, token: noneToken, wantErr: true},
+		{name: "alg none against RS256", validator: rsOnly, token: noneToken, wantErr: true},
+		{
+			// The classic confusion attack: the public key, which is no secret, used as an HMAC key
+			name:      "HS256 signed with the RS256 public key",
+			validator: rsOnly,
+			token:     sign(t, jwt.SigningMethodHS256, "", validClaims(), pemData),
+			wantErr:   true,
+		},
+		{name: "RS256 without RSA keys", validator: hsOnly, token: sign(t, jwt.SigningMethodRS256, "", validClaims(), rsaKey), wantErr: true},
+		{name: "wrong HS256 secret", validator: hsOnly, token: sign(t, jwt.SigningMethodHS256, "", validClaims(), []byte("another-secret")), wantErr: true},
+		{name: "RS384 is not accepted", validator: rsOnly, token: sign(t, jwt.SigningMethodRS384, "", validClaims(), rsaKey), wantErr: true},
+		{name: "missing exp", validator: hsOnly, token: sign(t, jwt.SigningMethodHS256, "", withoutExp, []byte(testSecret)), wantErr: true},
+		{name: "expired", validator: hsOnly, token: sign(t, jwt.SigningMethodHS256, "", expired, []byte(testSecret)), wantErr: true},
+		{name: "issuer mismatch", validator: hsOnly, token: sign(t, jwt.SigningMethodHS256, "", otherIssuer, []byte(testSecret)), wantErr: true},
+		{name: "audience mismatch", validator: hsOnly, token: sign(t, jwt.SigningMethodHS256, "", otherAudience, []byte(testSecret)), wantErr: true},
+		{name: "customer without customer_id", validator: hsOnly, token: sign(t, jwt.SigningMethodHS256, "", customerWithoutID, []byte(testSecret)), wantErr: true},
+		{name: "unknown role", validator: hsOnly, token: sign(t, jwt.SigningMethodHS256, "", unknownRole, []byte(testSecret)), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			principal, err := tt.validator.Validate(tt.token)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Validate() = %+v, want error", principal)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Validate() error = %v", err)
+			}
+			if principal.Subject != "user-1" || principal.Role != RoleStaff {
+				t.Fatalf("Validate() = %+v, want subject user-1 with role %s", principal, RoleStaff)
+			}
+		})
+	}
+}
+
+func TestJWTValidatorSelectsJWKSKeyByKeyID(t *testing.T) {
+	first := generateRSAKey(t)
+	second := generateRSAKey(t)
+	v := newValidator(t, JWTOptions{JWKSFile: writeJWKS(t, map[string]*rsa.PrivateKey{"first": first, "second": second})})
+
+	tests := []struct {
+		name    string
+		kid     string
+		key     *rsa.PrivateKey
+		wantErr bool
+	}{
+		{name: "first key", kid: "first", key: first},
+		{name: "second key", kid: "second", key: second},
+		{name: "signed by another key than kid names", kid: "second", key: first, wantErr: true},
+		{name: "unknown kid", kid: "third", key: first, wantErr: true},
+		{name: "missing kid", kid: "", key: first, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := v.Validate(sign(t, jwt.SigningMethodRS256, tt.kid, validClaims(), tt.key))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestJWTValidatorFallsBackToPEMKeyForUnknownKeyID(t *testing.T) {
+	pemKey := generateRSAKey(t)
+	jwksKey := generateRSAKey(t)
+	pemPath, _ := writePublicKeyPEM(t, pemKey)
+	v := newValidator(t, JWTOptions{
+		RS256PublicKeyFile: pemPath,
+		JWKSFile:           writeJWKS(t, map[string]*rsa.PrivateKey{"jwks": jwksKey}),
+	})
+
+	if _, err := v.Validate(sign(t, jwt.SigningMethodRS256, "jwks", validClaims(), jwksKey)); err != nil {
+		t.Fatalf("Validate() with JWKS key error = %v", err)
+	}
+	if _, err := v.Validate(sign(t, jwt.SigningMethodRS256, "other", validClaims(), pemKey)); err != nil {
+		t.Fatalf("Validate() with PEM key error = %v", err)
+	}
+	// A token naming a JWKS key is verified with that key only, not the PEM key
+	if _, err := v.Validate(sign(t, jwt.SigningMethodRS256, "jwks", validClaims(), pemKey)); err == nil {
+		t.Fatal("Validate() accepted a token signed by another key than its kid names")
+	}
+}
+
+func TestNewJWTValidatorRequiresAKey(t *testing.T) {
+	if _, err := NewJWTValidator(JWTOptions{Issuer: "https://issuer.example"}); err == nil {
+		t.Fatal("NewJWTValidator() without keys succeeded, want error")
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	v := newValidator(t, JWTOptions{HS256Secret: testSecret})
+	apiKeys := func(_ context.Context, key string) (*Principal, error) {
+		if key != APIKeyPrefix+"valid" {
+			return nil, errors.New("unknown API key")
+		}
+		return &Principal{Subject: "key-1", Role: RoleService, Scopes: []string{ScopeOrdersRead}}, nil
+	}
+	handler := Middleware(v, apiKeys)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, _ := PrincipalFromContext(r.Context())
+		w.Write([]byte(principal.Subject))
+	}))
+
+	tests := []struct {
+		name          string
+		authorization string
+		wantStatus    int
+		wantSubject   string
+	}{
+		{name: "JWT", authorization: "Bearer " + sign(t, jwt.SigningMethodHS256, "", validClaims(), []byte(testSecret)), wantStatus: http.StatusOK, wantSubject: "user-1"},
+		{name: "API key", authorization: "Bearer " + APIKeyPrefix + "valid", wantStatus: http.StatusOK, wantSubject: "key-1"},
+		{name: "missing header", wantStatus: http.StatusUnauthorized},
+		{name: "other scheme", authorization: "Basic dXNlcjpwYXNz", wantStatus: http.StatusUnauthorized},
+		{name: "invalid JWT", authorization: "Bearer not-a-token", wantStatus: http.StatusUnauthorized},
+		{name: "unknown API key", authorization: "Bearer " + APIKeyPrefix + "unknown", wantStatus: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/orders", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+				t.Fatal("401 without WWW-Authenticate header")
+			}
+			if tt.wantSubject != "" && rec.Body.String() != tt.wantSubject {
+				t.Fatalf("subject = %q, want %q", rec.Body.String(), tt.wantSubject)
+			}
+		})
+	}
+}
+
+func TestMiddlewareWithoutValidatorOnlyAcceptsAPIKeys(t *testing.T) {
+	handler := Middleware(nil, func(context.Context, string) (*Principal, error) {
+		return &Principal{Subject: "key-1", Role: RoleService}, nil
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/orders", nil)
+	req.Header.Set("Authorization", "Bearer "+sign(t, jwt.SigningMethodHS256, "", validClaims(), []byte(testSecret)))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,108 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/Lamafout/online-store-api/internal/logging"
+	"github.com/Lamafout/online-store-api/internal/problem"
+)
+
+// APIKeyPrefix starts every API key, which tells them apart from JWTs
+const APIKeyPrefix = "osk_"
+
+// APIKeyAuthenticator resolves an API key to the principal it was issued to
+type APIKeyAuthenticator func(ctx context.Context, key string) (*Principal, error)
+
+// Middleware rejects requests without a valid bearer credential and stores the
+// authenticated principal in the request context. The credential is either an
+// API key or, when validator is not nil, a JWT.
+func Middleware(validator *JWTValidator, apiKeys APIKeyAuthenticator) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, ok := bearerToken(r)
+			if !ok {
+				unauthorized(w, r, "Missing bearer token")
+				return
+			}
+
+			var principal *Principal
+			var err error
+			switch {
+			case strings.HasPrefix(token, APIKeyPrefix):
+				principal, err = apiKeys(r.Context(), token)
+			case validator != nil:
+				principal, err = validator.Validate(token)
+			default:
+				unauthorized(w, r, "Only API keys are accepted")
+				return
+			}
+			if err != nil {
+				unauthorized(w, r, "Invalid token")
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), principal)))
+		})
+	}
+}
+
+// Anonymous gives every request an unrestricted admin principal. It is meant for
+// local development with authentication switched off.
+func Anonymous() func(http.Handler) http.Handler {
+	principal := &Principal{Subject: "anonymous", Role: RoleAdmin, Scopes: []string{ScopeAdmin}}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), principal)))
+		})
+	}
+}
+
+// RoleLoader returns the roles assigned to a subject within the tenant of ctx
+type RoleLoader func(ctx context.Context, subject string) ([]string, error)
+
+// AssignedRoles adds the roles assigned to the authenticated subject to its principal.
+// It must run after the request has been scoped to a tenant, since assignments are
+// per tenant.
+func AssignedRoles(loader RoleLoader) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, ok := PrincipalFromContext(r.Context())
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			roles, err := loader(r.Context(), principal.Subject)
+			if err != nil {
+				args := []any{"subject", principal.Subject, "error", err}
+				logging.FromContext(r.Context()).Error("Failed to load role assignments", append(args, logging.ErrorAttrs(err)...)...)
+				problem.Error(w, r, http.StatusInternalServerError, problem.CodeInternal, "Failed to load role assignments")
+				return
+			}
+			if len(roles) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			withRoles := *principal
+			withRoles.AssignedRoles = roles
+			next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), &withRoles)))
+		})
+	}
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+func unauthorized(w http.ResponseWriter, r *http.Request, message string) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="online-store-api"`)
+	problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthenticated, message)
+}
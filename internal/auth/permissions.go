@@ -0,0 +1,71 @@
+package auth
+
+import "slices"
+
+const (
+	PermissionOrdersRead   = "orders:read"
+	PermissionOrdersCreate = "orders:create"
+	PermissionOrdersCancel = "orders:cancel"
+	PermissionOrdersShip   = "orders:ship"
+	PermissionOrdersRefund = "orders:refund"
+	PermissionOrdersExport = "orders:export"
+	PermissionOrdersImport = "orders:import"
+	PermissionJobsRead     = "jobs:read"
+	PermissionAPIKeysAdmin = "api_keys:manage"
+	PermissionRolesAdmin   = "roles:manage"
+)
+
+// AllPermissions lists every permission known to the API
+var AllPermissions = []string{
+	PermissionOrdersRead, PermissionOrdersCreate, PermissionOrdersCancel, PermissionOrdersShip,
+	PermissionOrdersRefund, PermissionOrdersExport, PermissionOrdersImport, PermissionJobsRead,
+	PermissionAPIKeysAdmin, PermissionRolesAdmin,
+}
+
+// rolePermissions is the policy granting permissions to roles, whether they come from a
+// token or from a role assignment
+var rolePermissions = map[string][]string{
+	RoleCustomer: {PermissionOrdersRead, PermissionOrdersCreate, PermissionJobsRead},
+	RoleStaff: {
+		PermissionOrdersRead, PermissionOrdersCreate, PermissionOrdersExport,
+		PermissionOrdersImport, PermissionJobsRead,
+	},
+	RoleSupport:   {PermissionOrdersRead, PermissionOrdersCancel, PermissionJobsRead},
+	RoleWarehouse: {PermissionOrdersRead, PermissionOrdersShip},
+	RoleFinance:   {PermissionOrdersRead, PermissionOrdersRefund, PermissionOrdersExport},
+	RoleAdmin:     AllPermissions,
+}
+
+// scopePermissions grants permissions to the scopes an API key can carry
+var scopePermissions = map[string][]string{
+	ScopeOrdersRead:  {PermissionOrdersRead, PermissionOrdersExport, PermissionJobsRead},
+	ScopeOrdersWrite: {PermissionOrdersCreate, PermissionOrdersImport},
+	ScopeAdmin:       AllPermissions,
+}
+
+// AssignableRoles lists the roles that can be assigned to a subject within a tenant.
+// Customer is not among them because a customer role needs a customer ID from the token.
+var AssignableRoles = []string{RoleStaff, RoleSupport, RoleWarehouse, RoleFinance, RoleAdmin}
+
+// IsAssignableRole reports whether the role can be given out through a role assignment
+func IsAssignableRole(role string) bool {
+	return slices.Contains(AssignableRoles, role)
+}
+
+// HasPermission reports whether any of the principal's roles or scopes grants the permission
+func (p *Principal) HasPermission(permission string) bool {
+	if slices.Contains(rolePermissions[p.Role], permission) {
+		return true
+	}
+	for _, role := range p.AssignedRoles {
+		if slices.Contains(rolePermissions[role], permission) {
+			return true
+		}
+	}
+	for _, scope := range p.Scopes {
+		if slices.Contains(scopePermissions[scope], permission) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,42 @@
+package auth
+
+import "testing"
+
+func TestHasPermission(t *testing.T) {
+	tests := []struct {
+		name       string
+		principal  Principal
+		permission string
+		want       bool
+	}{
+		{name: "customer reads", principal: Principal{Role: RoleCustomer}, permission: PermissionOrdersRead, want: true},
+		{name: "customer cannot export", principal: Principal{Role: RoleCustomer}, permission: PermissionOrdersExport, want: false},
+		{name: "support cancels", principal: Principal{Role: RoleSupport}, permission: PermissionOrdersCancel, want: true},
+		{name: "support cannot refund", principal: Principal{Role: RoleSupport}, permission: PermissionOrdersRefund, want: false},
+		{name: "warehouse ships", principal: Principal{Role: RoleWarehouse}, permission: PermissionOrdersShip, want: true},
+		{name: "warehouse cannot create", principal: Principal{Role: RoleWarehouse}, permission: PermissionOrdersCreate, want: false},
+		{name: "finance refunds", principal: Principal{Role: RoleFinance}, permission: PermissionOrdersRefund, want: true},
+		{name: "finance exports", principal: Principal{Role: RoleFinance}, permission: PermissionOrdersExport, want: true},
+		{name: "finance cannot import", principal: Principal{Role: RoleFinance}, permission: PermissionOrdersImport, want: false},
+		{name: "admin manages roles", principal: Principal{Role: RoleAdmin}, permission: PermissionRolesAdmin, want: true},
+		{name: "staff cannot manage roles", principal: Principal{Role: RoleStaff}, permission: PermissionRolesAdmin, want: false},
+		{
+			name:       "assigned role adds permissions",
+			principal:  Principal{Role: RoleStaff, AssignedRoles: []string{RoleFinance}},
+			permission: PermissionOrdersRefund,
+			want:       true,
+		},
+		{name: "read scope exports", principal: Principal{Role: RoleService, Scopes: []string{ScopeOrdersRead}}, permission: PermissionOrdersExport, want: true},
+		{name: "read scope cannot create", principal: Principal{Role: RoleService, Scopes: []string{ScopeOrdersRead}}, permission: PermissionOrdersCreate, want: false},
+		{name: "admin scope grants all", principal: Principal{Role: RoleService, Scopes: []string{ScopeAdmin}}, permission: PermissionAPIKeysAdmin, want: true},
+		{name: "service role alone grants nothing", principal: Principal{Role: RoleService}, permission: PermissionOrdersRead, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.principal.HasPermission(tt.permission); got != tt.want {
+				t.Fatalf("HasPermission(%q) = %v, want %v", tt.permission, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package auth
+
+import "context"
+
+const (
+	RoleCustomer = "customer"
+	RoleStaff    = "staff"
+	RoleAdmin    = "admin"
+	// RoleSupport handles customer requests such as cancellations
+	RoleSupport = "support"
+	// RoleWarehouse fulfils orders
+	RoleWarehouse = "warehouse"
+	// RoleFinance handles refunds and financial exports
+	RoleFinance = "finance"
+	// RoleService is the role of callers authenticated with an API key; what it may do
+	// is decided by the key's scopes alone
+	RoleService = "service"
+)
+
+// Scopes are the coarse grants carried by API keys; see scopePermissions for what they allow
+const (
+	ScopeOrdersRead  = "orders:read"
+	ScopeOrdersWrite = "orders:write"
+	// ScopeAdmin grants every permission
+	ScopeAdmin = "admin"
+)
+
+// AllScopes lists every scope that can be granted to an API key
+var AllScopes = []string{ScopeOrdersRead, ScopeOrdersWrite, ScopeAdmin}
+
+// Principal is the authenticated caller of a request
+type Principal struct {
+	Subject    string
+	Role       string
+	CustomerID int64
+	Scopes     []string
+	// TenantID pins the principal to one storefront; empty for platform-wide principals
+	TenantID string
+	// AssignedRoles are the roles given to the subject within the request's tenant,
+	// on top of Role
+	AssignedRoles []string
+}
+
+// CanAccessAllCustomers reports whether the principal may act on behalf of any customer
+func (p *Principal) CanAccessAllCustomers() bool {
+	return p.Role != RoleCustomer
+}
+
+// CanAccessCustomer reports whether the principal may create or read orders of the customer
+func (p *Principal) CanAccessCustomer(customerID int64) bool {
+	return p.CanAccessAllCustomers() || p.CustomerID == customerID
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns a copy of ctx carrying the principal
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// PrincipalFromContext returns the principal stored in ctx, if any
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+	return p, ok && p != nil
+}
@@ -0,0 +1,242 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	core "github.com/Lamafout/online-store-api/core/models/common"
+	"github.com/Lamafout/online-store-api/core/models/dto"
+	"github.com/Lamafout/online-store-api/internal/auth"
+	"github.com/Lamafout/online-store-api/internal/dal/models"
+	"github.com/Lamafout/online-store-api/internal/dal/unit_of_work"
+	"github.com/Lamafout/online-store-api/internal/validation"
+	"github.com/go-playground/validator/v10"
+)
+
+// apiKeyTouchInterval throttles last-used updates so busy keys don't write on every request
+const apiKeyTouchInterval = time.Minute
+
+type APIKeyService struct {
+	validate *validator.Validate
+}
+
+func NewAPIKeyService() *APIKeyService {
+	return &APIKeyService{
+		validate: validation.Validator(),
+	}
+}
+
+// CreateAPIKey issues a new API key. The plaintext key is returned only here; the
+// database keeps its SHA-256 hash.
+func (s *APIKeyService) CreateAPIKey(
+	ctx context.Context,
+	uow dal.UnitOfWork,
+	req *dto.V1CreateAPIKeyRequest,
+	createdBy string,
+) (*core.APIKey, string, error) {
+	if err := s.validate.Struct(req); err != nil {
+		return nil, "", &ValidationError{Err: err}
+	}
+
+	prefix, key, err := generateAPIKey()
+	if err != nil {
+		return nil, "", err
+	}
+
+	now := time.Now()
+	dalKey := &models.V1APIKeyDal{
+		Name:      req.Name,
+		Prefix:    prefix,
+		KeyHash:   hashAPIKey(key),
+		Scopes:    strings.Join(req.Scopes, " "),
+		CreatedBy: createdBy,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := uow.GetAPIKeyRepo().CreateAPIKey(ctx, dalKey); err != nil {
+		return nil, "", fmt.Errorf("failed to create api key: %w", err)
+	}
+
+	return apiKeyFromDal(dalKey), key, nil
+}
+
+// BootstrapAPIKey issues the first admin key of the tenant of ctx, which is how a tenant
+// gets access before any key exists to call the API key endpoints with. It fails with a
+// ConflictError once the tenant has an active key.
+func (s *APIKeyService) BootstrapAPIKey(
+	ctx context.Context,
+	uow dal.UnitOfWork,
+	name string,
+	createdBy string,
+) (*core.APIKey, string, error) {
+	existing, err := uow.GetAPIKeyRepo().ListAPIKeys(ctx)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to list api keys: %w", err)
+	}
+	for _, key := range existing {
+		if !key.RevokedAt.Valid {
+			return nil, "", &ConflictError{ErrCode: CodeAPIKeysExist, Message: "tenant already has an active api key"}
+		}
+	}
+
+	return s.CreateAPIKey(ctx, uow, &dto.V1CreateAPIKeyRequest{Name: name, Scopes: []string{auth.ScopeAdmin}}, createdBy)
+}
+
+// ListAPIKeys returns every API key without secrets
+func (s *APIKeyService) ListAPIKeys(
+	ctx context.Context,
+	uow dal.UnitOfWork,
+) ([]*core.APIKey, error) {
+	dalKeys, err := uow.GetAPIKeyRepo().ListAPIKeys(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list api keys: %w", err)
+	}
+
+	keys := make([]*core.APIKey, len(dalKeys))
+	for i := range dalKeys {
+		keys[i] = apiKeyFromDal(&dalKeys[i])
+	}
+	return keys, nil
+}
+
+// RotateAPIKey replaces the secret of an active API key and returns the new plaintext key.
+// The previous key stops working immediately.
+func (s *APIKeyService) RotateAPIKey(
+	ctx context.Context,
+	uow dal.UnitOfWork,
+	id int64,
+) (*core.APIKey, string, error) {
+	dalKey, err := uow.GetAPIKeyRepo().GetAPIKeyByID(ctx, id)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get api key: %w", notFound(err, "api key", id))
+	}
+	if dalKey.RevokedAt.Valid {
+		return nil, "", &ConflictError{ErrCode: CodeAPIKeyRevoked, Message: fmt.Sprintf("api key %d is revoked", id)}
+	}
+
+	prefix, key, err := generateAPIKey()
+	if err != nil {
+		return nil, "", err
+	}
+
+	now := time.Now()
+	if err := uow.GetAPIKeyRepo().UpdateAPIKeySecret(ctx, id, prefix, hashAPIKey(key), now); err != nil {
+		return nil, "", fmt.Errorf("failed to rotate api key: %w", err)
+	}
+	dalKey.Prefix = prefix
+	dalKey.UpdatedAt = now
+
+	return apiKeyFromDal(dalKey), key, nil
+}
+
+// RevokeAPIKey permanently disables an API key
+func (s *APIKeyService) RevokeAPIKey(
+	ctx context.Context,
+	uow dal.UnitOfWork,
+	id int64,
+) error {
+	if _, err := uow.GetAPIKeyRepo().GetAPIKeyByID(ctx, id); err != nil {
+		return fmt.Errorf("failed to get api key: %w", notFound(err, "api key", id))
+	}
+	if err := uow.GetAPIKeyRepo().RevokeAPIKey(ctx, id, time.Now()); err != nil {
+		return fmt.Errorf("failed to revoke api key: %w", err)
+	}
+	return nil
+}
+
+// Authenticate resolves a plaintext API key to a service principal carrying the
+// key's scopes, and records the use of the key
+func (s *APIKeyService) Authenticate(
+	ctx context.Context,
+	uow dal.UnitOfWork,
+	key string,
+) (*auth.Principal, error) {
+	prefix, ok := parseAPIKeyPrefix(key)
+	if !ok {
+		return nil, fmt.Errorf("malformed api key")
+	}
+
+	dalKey, err := uow.GetAPIKeyRepo().GetAPIKeyByPrefix(ctx, prefix)
+	if err != nil {
+		return nil, fmt.Errorf("unknown api key: %w", err)
+	}
+	if subtle.ConstantTimeCompare([]byte(hashAPIKey(key)), []byte(dalKey.KeyHash)) != 1 {
+		return nil, fmt.Errorf("unknown api key")
+	}
+	if dalKey.RevokedAt.Valid {
+		return nil, fmt.Errorf("api key %d is revoked", dalKey.ID)
+	}
+
+	now := time.Now()
+	if !dalKey.LastUsedAt.Valid || now.Sub(dalKey.LastUsedAt.Time) >= apiKeyTouchInterval {
+		if err := uow.GetAPIKeyRepo().TouchAPIKey(ctx, dalKey.ID, now); err != nil {
+			return nil, err
+		}
+	}
+
+	return &auth.Principal{
+		Subject:  "api-key:" + strconv.FormatInt(dalKey.ID, 10),
+		Role:     auth.RoleService,
+		Scopes:   strings.Fields(dalKey.Scopes),
+		TenantID: dalKey.TenantID,
+	}, nil
+}
+
+// generateAPIKey returns a random key of the form osk_<prefix>_<secret> and its prefix.
+// The prefix is stored in clear to look the key up; the secret only as part of the hash.
+func generateAPIKey() (string, string, error) {
+	prefixBytes := make([]byte, 6)
+	secretBytes := make([]byte, 32)
+	if _, err := rand.Read(prefixBytes); err != nil {
+		return "", "", fmt.Errorf("failed to generate api key: %w", err)
+	}
+	if _, err := rand.Read(secretBytes); err != nil {
+		return "", "", fmt.Errorf("failed to generate api key: %w", err)
+	}
+
+	prefix := hex.EncodeToString(prefixBytes)
+	key := auth.APIKeyPrefix + prefix + "_" + base64.RawURLEncoding.EncodeToString(secretBytes)
+	return prefix, key, nil
+}
+
+func parseAPIKeyPrefix(key string) (string, bool) {
+	rest, ok := strings.CutPrefix(key, auth.APIKeyPrefix)
+	if !ok {
+		return "", false
+	}
+	prefix, secret, ok := strings.Cut(rest, "_")
+	return prefix, ok && prefix != "" && secret != ""
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// apiKeyFromDal maps an API key row to the core model
+func apiKeyFromDal(dalKey *models.V1APIKeyDal) *core.APIKey {
+	key := &core.APIKey{
+		ID:        dalKey.ID,
+		Name:      dalKey.Name,
+		Prefix:    dalKey.Prefix,
+		Scopes:    strings.Fields(dalKey.Scopes),
+		CreatedBy: dalKey.CreatedBy,
+		CreatedAt: dalKey.CreatedAt,
+		UpdatedAt: dalKey.UpdatedAt,
+	}
+	if dalKey.LastUsedAt.Valid {
+		key.LastUsedAt = &dalKey.LastUsedAt.Time
+	}
+	if dalKey.RevokedAt.Valid {
+		key.RevokedAt = &dalKey.RevokedAt.Time
+	}
+	return key
+}
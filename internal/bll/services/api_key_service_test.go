@@ -0,0 +1,274 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Lamafout/online-store-api/core/models/dto"
+	"github.com/Lamafout/online-store-api/internal/auth"
+	"github.com/Lamafout/online-store-api/internal/dal/interfaces"
+	"github.com/Lamafout/online-store-api/internal/dal/models"
+	dal "github.com/Lamafout/online-store-api/internal/dal/unit_of_work"
+	"github.com/Lamafout/online-store-api/internal/tenant"
+)
+
+// apiKeyUnitOfWork serves API keys from an in-memory repository, which the memory store
+// does not have
+type apiKeyUnitOfWork struct {
+	dal.UnitOfWork
+	repo *memoryAPIKeyRepository
+}
+
+func (u *apiKeyUnitOfWork) GetAPIKeyRepo() interfaces.IAPIKeyRepository {
+	return u.repo
+}
+
+type memoryAPIKeyRepository struct {
+	keys    []*models.V1APIKeyDal
+	touches int
+}
+
+func (r *memoryAPIKeyRepository) CreateAPIKey(ctx context.Context, key *models.V1APIKeyDal) error {
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return err
+	}
+	key.ID = int64(len(r.keys) + 1)
+	key.TenantID = tenantID
+	stored := *key
+	r.keys = append(r.keys, &stored)
+	return nil
+}
+
+func (r *memoryAPIKeyRepository) GetAPIKeyByID(ctx context.Context, id int64) (*models.V1APIKeyDal, error) {
+	tenantID, _ := tenant.FromContext(ctx)
+	for _, key := range r.keys {
+		if key.ID == id && key.TenantID == tenantID {
+			found := *key
+			return &found, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (r *memoryAPIKeyRepository) GetAPIKeyByPrefix(_ context.Context, prefix string) (*models.V1APIKeyDal, error) {
+	for _, key := range r.keys {
+		if key.Prefix == prefix {
+			found := *key
+			return &found, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (r *memoryAPIKeyRepository) ListAPIKeys(ctx context.Context) ([]models.V1APIKeyDal, error) {
+	tenantID, _ := tenant.FromContext(ctx)
+	var keys []models.V1APIKeyDal
+	for _, key := range r.keys {
+		if key.TenantID == tenantID {
+			keys = append(keys, *key)
+		}
+	}
+	return keys, nil
+}
+
+func (r *memoryAPIKeyRepository) UpdateAPIKeySecret(_ context.Context, id int64, prefix, keyHash string, updatedAt time.Time) error {
+	r.keys[id-1].Prefix = prefix
+	r.keys[id-1].KeyHash = keyHash
+	r.keys[id-1].UpdatedAt = updatedAt
+	return nil
+}
+
+func (r *memoryAPIKeyRepository) RevokeAPIKey(_ context.Context, id int64, revokedAt time.Time) error {
+	r.keys[id-1].RevokedAt = sql.NullTime{Time: revokedAt, Valid: true}
+	return nil
+}
+
+func (r *memoryAPIKeyRepository) TouchAPIKey(_ context.Context, id int64, usedAt time.Time) error {
+	r.touches++
+	r.keys[id-1].LastUsedAt = sql.NullTime{Time: usedAt, Valid: true}
+	return nil
+}
+
+func newAPIKeyTestStore() (context.Context, *apiKeyUnitOfWork) {
+	ctx, factory := newTestStore()
+	return ctx, &apiKeyUnitOfWork{UnitOfWork: factory.Create(), repo: &memoryAPIKeyRepository{}}
+}
+
+func TestAPIKeyServiceCreateStoresOnlyHash(t *testing.T) {
+	ctx, uow := newAPIKeyTestStore()
+	service := NewAPIKeyService()
+
+	key, plaintext, err := service.CreateAPIKey(ctx, uow, &dto.V1CreateAPIKeyRequest{Name: "ci", Scopes: []string{auth.ScopeOrdersRead}}, "admin")
+	if err != nil {
+		t.Fatalf("CreateAPIKey() error = %v", err)
+	}
+
+	prefix, ok := parseAPIKeyPrefix(plaintext)
+	if !ok || prefix != key.Prefix {
+		t.Fatalf("key %q does not carry its prefix %q", plaintext, key.Prefix)
+	}
+	stored := uow.repo.keys[0]
+	if stored.KeyHash != hashAPIKey(plaintext) || strings.Contains(stored.KeyHash, plaintext) {
+		t.Fatalf("stored hash = %q, want the SHA-256 of the key only", stored.KeyHash)
+	}
+	if stored.Scopes != auth.ScopeOrdersRead || stored.TenantID != "test" {
+		t.Fatalf("stored key = %+v, want scope %q in tenant test", stored, auth.ScopeOrdersRead)
+	}
+
+	_, other, err := service.CreateAPIKey(ctx, uow, &dto.V1CreateAPIKeyRequest{Name: "ci", Scopes: []string{auth.ScopeOrdersRead}}, "admin")
+	if err != nil {
+		t.Fatalf("CreateAPIKey() error = %v", err)
+	}
+	if other == plaintext || uow.repo.keys[1].KeyHash == stored.KeyHash {
+		t.Fatal("two keys share a secret")
+	}
+}
+
+func TestAPIKeyServiceCreateValidatesScopes(t *testing.T) {
+	ctx, uow := newAPIKeyTestStore()
+
+	_, _, err := NewAPIKeyService().CreateAPIKey(ctx, uow, &dto.V1CreateAPIKeyRequest{Name: "ci", Scopes: []string{"root"}}, "admin")
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("CreateAPIKey() error = %v, want ValidationError", err)
+	}
+}
+
+func TestAPIKeyServiceAuthenticate(t *testing.T) {
+	ctx, uow := newAPIKeyTestStore()
+	service := NewAPIKeyService()
+	key, plaintext, err := service.CreateAPIKey(ctx, uow, &dto.V1CreateAPIKeyRequest{Name: "ci", Scopes: []string{auth.ScopeOrdersRead, auth.ScopeOrdersWrite}}, "admin")
+	if err != nil {
+		t.Fatalf("CreateAPIKey() error = %v", err)
+	}
+
+	// Authentication happens before the request is bound to a tenant
+	principal, err := service.Authenticate(context.Background(), uow, plaintext)
+	if err != nil {
+		t.Fatalf("Authenticate() error = %v", err)
+	}
+	if principal.Role != auth.RoleService || principal.TenantID != "test" || len(principal.Scopes) != 2 {
+		t.Fatalf("principal = %+v, want a service principal of tenant test with two scopes", principal)
+	}
+
+	tests := []struct {
+		name string
+		key  string
+	}{
+		{name: "right prefix, wrong secret", key: auth.APIKeyPrefix + key.Prefix + "_wrong"},
+		{name: "unknown prefix", key: auth.APIKeyPrefix + "000000000000_" + strings.Split(plaintext, "_")[2]},
+		{name: "without secret", key: auth.APIKeyPrefix + key.Prefix + "_"},
+		{name: "not an API key", key: "Bearer " + plaintext},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if principal, err := service.Authenticate(context.Background(), uow, tt.key); err == nil {
+				t.Fatalf("Authenticate() = %+v, want error", principal)
+			}
+		})
+	}
+}
+
+func TestAPIKeyServiceRevokeAndRotate(t *testing.T) {
+	ctx, uow := newAPIKeyTestStore()
+	service := NewAPIKeyService()
+	key, plaintext, err := service.CreateAPIKey(ctx, uow, &dto.V1CreateAPIKeyRequest{Name: "ci", Scopes: []string{auth.ScopeAdmin}}, "admin")
+	if err != nil {
+		t.Fatalf("CreateAPIKey() error = %v", err)
+	}
+
+	_, rotated, err := service.RotateAPIKey(ctx, uow, key.ID)
+	if err != nil {
+		t.Fatalf("RotateAPIKey() error = %v", err)
+	}
+	if _, err := service.Authenticate(context.Background(), uow, plaintext); err == nil {
+		t.Fatal("Authenticate() accepted the key replaced by rotation")
+	}
+	if _, err := service.Authenticate(context.Background(), uow, rotated); err != nil {
+		t.Fatalf("Authenticate() with rotated key error = %v", err)
+	}
+
+	if err := service.RevokeAPIKey(ctx, uow, key.ID); err != nil {
+		t.Fatalf("RevokeAPIKey() error = %v", err)
+	}
+	if _, err := service.Authenticate(context.Background(), uow, rotated); err == nil {
+		t.Fatal("Authenticate() accepted a revoked key")
+	}
+
+	_, _, err = service.RotateAPIKey(ctx, uow, key.ID)
+	var conflict *ConflictError
+	if !errors.As(err, &conflict) || conflict.Code() != CodeAPIKeyRevoked {
+		t.Fatalf("RotateAPIKey() of revoked key error = %v, want %s", err, CodeAPIKeyRevoked)
+	}
+
+	var notFound *NotFoundError
+	if err := service.RevokeAPIKey(tenant.WithTenant(context.Background(), "other"), uow, key.ID); !errors.As(err, &notFound) {
+		t.Fatalf("RevokeAPIKey() from another tenant error = %v, want NotFoundError", err)
+	}
+}
+
+func TestAPIKeyServiceAuthenticateThrottlesLastUsed(t *testing.T) {
+	ctx, uow := newAPIKeyTestStore()
+	service := NewAPIKeyService()
+	_, plaintext, err := service.CreateAPIKey(ctx, uow, &dto.V1CreateAPIKeyRequest{Name: "ci", Scopes: []string{auth.ScopeOrdersRead}}, "admin")
+	if err != nil {
+		t.Fatalf("CreateAPIKey() error = %v", err)
+	}
+
+	for range 3 {
+		if _, err := service.Authenticate(context.Background(), uow, plaintext); err != nil {
+			t.Fatalf("Authenticate() error = %v", err)
+		}
+	}
+	if uow.repo.touches != 1 {
+		t.Fatalf("last used recorded %d times within the interval, want 1", uow.repo.touches)
+	}
+
+	uow.repo.keys[0].LastUsedAt.Time = time.Now().Add(-apiKeyTouchInterval)
+	if _, err := service.Authenticate(context.Background(), uow, plaintext); err != nil {
+		t.Fatalf("Authenticate() error = %v", err)
+	}
+	if uow.repo.touches != 2 {
+		t.Fatalf("last used recorded %d times, want 2 once the interval passed", uow.repo.touches)
+	}
+}
+
+func TestAPIKeyServiceBootstrapAPIKey(t *testing.T) {
+	ctx, uow := newAPIKeyTestStore()
+	service := NewAPIKeyService()
+
+	key, plaintext, err := service.BootstrapAPIKey(ctx, uow, "bootstrap", "migrator")
+	if err != nil {
+		t.Fatalf("BootstrapAPIKey() error = %v", err)
+	}
+	principal, err := service.Authenticate(context.Background(), uow, plaintext)
+	if err != nil {
+		t.Fatalf("Authenticate() error = %v", err)
+	}
+	if !principal.HasPermission(auth.PermissionAPIKeysAdmin) {
+		t.Fatalf("bootstrap key principal = %+v, want it to manage API keys", principal)
+	}
+
+	var conflict *ConflictError
+	if _, _, err := service.BootstrapAPIKey(ctx, uow, "again", "migrator"); !errors.As(err, &conflict) || conflict.Code() != CodeAPIKeysExist {
+		t.Fatalf("second BootstrapAPIKey() error = %v, want %s", err, CodeAPIKeysExist)
+	}
+
+	// Other tenants are bootstrapped independently
+	if _, _, err := service.BootstrapAPIKey(tenant.WithTenant(context.Background(), "other"), uow, "bootstrap", "migrator"); err != nil {
+		t.Fatalf("BootstrapAPIKey() for another tenant error = %v", err)
+	}
+
+	// Once every key is revoked, the tenant can be bootstrapped again
+	if err := service.RevokeAPIKey(ctx, uow, key.ID); err != nil {
+		t.Fatalf("RevokeAPIKey() error = %v", err)
+	}
+	if _, _, err := service.BootstrapAPIKey(ctx, uow, "again", "migrator"); err != nil {
+		t.Fatalf("BootstrapAPIKey() after revocation error = %v", err)
+	}
+}
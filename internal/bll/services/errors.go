@@ -0,0 +1,162 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	core "github.com/Lamafout/online-store-api/core/models/common"
+	ut "github.com/go-playground/universal-translator"
+	"github.com/go-playground/validator/v10"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Error codes of the domain errors. They reach clients unchanged, so a code is never
+// renamed once published.
+const (
+	CodeValidationFailed   = "validation_failed"
+	CodeTotalPriceMismatch = "total_price_mismatch"
+	CodeAPIKeyRevoked      = "api_key_revoked"
+	CodeConstraintViolated = "constraint_violated"
+	CodeAPIKeysExist       = "api_keys_exist"
+)
+
+// errEmptyBatch rejects batch requests without orders
+var errEmptyBatch = &ValidationError{Err: errors.New("orders list cannot be empty")}
+
+// NotFoundError reports that a resource does not exist, or is not visible to the
+// tenant of the request
+type NotFoundError struct {
+	Resource string
+	ID       int64
+	Err      error
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("%s %d not found", e.Resource, e.ID)
+}
+
+func (e *NotFoundError) Unwrap() error {
+	return e.Err
+}
+
+// Code identifies the resource, e.g. "order_not_found"
+func (e *NotFoundError) Code() string {
+	return strings.ReplaceAll(e.Resource, " ", "_") + "_not_found"
+}
+
+// ValidationError reports input that breaks the model rules. Err is usually the
+// validator.ValidationErrors listing the offending fields, whose paths are relative to
+// Path when it is set.
+type ValidationError struct {
+	Err  error
+	Path string
+}
+
+func (e *ValidationError) Error() string {
+	return "validation failed: " + e.Err.Error()
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// Code returns CodeValidationFailed
+func (e *ValidationError) Code() string {
+	return CodeValidationFailed
+}
+
+// TotalMismatchError reports an order whose total differs from the sum of its items
+type TotalMismatchError struct {
+	Expected int64
+	Got      int64
+}
+
+func (e *TotalMismatchError) Error() string {
+	return fmt.Sprintf("total price mismatch for order: expected %d, got %d", e.Expected, e.Got)
+}
+
+// Code returns CodeTotalPriceMismatch
+func (e *TotalMismatchError) Code() string {
+	return CodeTotalPriceMismatch
+}
+
+// ConflictError reports a request that is valid on its own but clashes with the
+// current state of a resource, e.g. rotating a revoked API key
+type ConflictError struct {
+	ErrCode string
+	Message string
+}
+
+func (e *ConflictError) Error() string {
+	return e.Message
+}
+
+// Code returns the code the error was created with
+func (e *ConflictError) Code() string {
+	return e.ErrCode
+}
+
+// notFound wraps err in a NotFoundError when it is a missing row, and returns it
+// unchanged otherwise
+func notFound(err error, resource string, id int64) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return &NotFoundError{Resource: resource, ID: id, Err: err}
+	}
+	return err
+}
+
+// orderRejection returns the error to report for one order of a partial batch when
+// creating it failed with err, or nil when the failure is not down to the order. Invalid
+// orders and orders breaking a database constraint are rejected on their own; anything
+// else, such as a serialization failure or a lost connection, is not about the order
+// and fails the whole batch so that it can be retried.
+func orderRejection(err error) error {
+	var (
+		invalid  *ValidationError
+		mismatch *TotalMismatchError
+		pgErr    *pgconn.PgError
+	)
+	switch {
+	case errors.As(err, &invalid), errors.As(err, &mismatch):
+		return err
+	case errors.As(err, &pgErr) && strings.HasPrefix(pgErr.Code, "23"):
+		// Class 23 is integrity constraint violation; its details stay in the log
+		return &ConflictError{ErrCode: CodeConstraintViolated, Message: "order conflicts with existing data"}
+	}
+	return nil
+}
+
+// FieldErrors extracts per-field validation failures from err, or returns nil when err
+// is not a validation error. Paths use the JSON names of the request body and messages
+// are translated with trans.
+func FieldErrors(err error, trans ut.Translator) []core.FieldError {
+	var validationErrors validator.ValidationErrors
+	if !errors.As(err, &validationErrors) {
+		return nil
+	}
+
+	var prefix string
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) && validationErr.Path != "" {
+		prefix = validationErr.Path + "."
+	}
+
+	result := make([]core.FieldError, len(validationErrors))
+	for i, fe := range validationErrors {
+		// Drop the root struct name: "V1CreateOrderRequest.orders[3].customer_id" -> "orders[3].customer_id"
+		path := fe.Namespace()
+		if _, rest, found := strings.Cut(path, "."); found {
+			path = rest
+		}
+
+		result[i] = core.FieldError{
+			FieldPath: prefix + path,
+			Rule:      fe.Tag(),
+			Param:     fe.Param(),
+			Message:   fe.Translate(trans),
+		}
+	}
+	return result
+}
@@ -0,0 +1,316 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	core "github.com/Lamafout/online-store-api/core/models/common"
+	"github.com/Lamafout/online-store-api/internal/dal/models"
+	"github.com/Lamafout/online-store-api/internal/dal/unit_of_work"
+	"github.com/Lamafout/online-store-api/internal/logging"
+	"github.com/Lamafout/online-store-api/internal/problem"
+	"github.com/Lamafout/online-store-api/internal/validation"
+)
+
+// jobUnexpectedError is recorded for job failures that are not down to the orders. Jobs
+// are shown to clients, so the cause is logged instead.
+const jobUnexpectedError = "An unexpected error occurred"
+
+type JobService struct {
+	orderService *OrderService
+}
+
+func NewJobService(orderService *OrderService) *JobService {
+	return &JobService{
+		orderService: orderService,
+	}
+}
+
+// EnqueueBatchCreateOrders stores the orders as a pending batch-create job owned by createdBy.
+// Orders are validated one by one when the job runs, so every order gets its own result.
+func (s *JobService) EnqueueBatchCreateOrders(
+	ctx context.Context,
+	uow dal.UnitOfWork,
+	orders []*core.Order,
+	createdBy string,
+) (*core.Job, error) {
+	if len(orders) == 0 {
+		return nil, errEmptyBatch
+	}
+
+	payload, err := json.Marshal(orders)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode job payload: %w", err)
+	}
+
+	now := time.Now()
+	dalJob := &models.V1JobDal{
+		Type:       core.JobTypeBatchCreateOrders,
+		Status:     core.JobStatusPending,
+		Payload:    payload,
+		TotalItems: len(orders),
+		CreatedBy:  createdBy,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+	if err := uow.GetJobRepo().CreateJob(ctx, dalJob); err != nil {
+		return nil, fmt.Errorf("failed to create job: %w", err)
+	}
+
+	return jobFromDal(dalJob, nil), nil
+}
+
+// GetJob returns a job with the results recorded so far
+func (s *JobService) GetJob(
+	ctx context.Context,
+	uow dal.UnitOfWork,
+	id int64,
+) (*core.Job, error) {
+	dalJob, err := uow.GetJobRepo().GetJobByID(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get job: %w", notFound(err, "job", id))
+	}
+
+	dalResults, err := uow.GetJobRepo().GetJobResults(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get job results: %w", err)
+	}
+
+	return jobFromDal(dalJob, dalResults), nil
+}
+
+// ClaimNextJob leases the next available job of any tenant to the caller. Running jobs
+// whose lease expired on their last attempt are failed first. Returns nil when the queue
+// is empty.
+func (s *JobService) ClaimNextJob(
+	ctx context.Context,
+	uow dal.UnitOfWork,
+	lease time.Duration,
+	maxAttempts int,
+) (*core.Job, []byte, error) {
+	if _, err := uow.GetJobRepo().FailExpiredJobs(ctx, maxAttempts); err != nil {
+		return nil, nil, err
+	}
+
+	dalJob, err := uow.GetJobRepo().ClaimNextJob(ctx, lease, maxAttempts)
+	if err != nil {
+		return nil, nil, err
+	}
+	if dalJob == nil {
+		return nil, nil, nil
+	}
+
+	return jobFromDal(dalJob, nil), dalJob.Payload, nil
+}
+
+// ProcessBatchCreateChunk creates the orders of one chunk of a batch-create job, starting
+// at job.ProcessedItems, records a result per order and advances the job progress. Invalid
+// orders are recorded as failed, with the code and field errors the synchronous endpoints
+// report, and do not prevent the rest of the chunk from being created.
+// Everything happens inside the caller's transaction, so a retried job resumes after the
+// last committed chunk. When the caller lost the lease the error wraps
+// interfaces.ErrJobLeaseLost and the transaction must be rolled back.
+func (s *JobService) ProcessBatchCreateChunk(
+	ctx context.Context,
+	uow dal.UnitOfWork,
+	job *core.Job,
+	orders []*core.Order,
+	lease time.Duration,
+) error {
+	offset := job.ProcessedItems
+	results := make([]models.V1JobResultDal, len(orders))
+	var valid []*core.Order
+	var validIndexes []int
+
+	for i, order := range orders {
+		results[i] = models.V1JobResultDal{JobID: job.ID, ItemIndex: offset + i}
+		if err := s.orderService.ValidateOrder(order); err != nil {
+			setJobResultError(&results[i], jobOrderError(ctx, err))
+			continue
+		}
+		valid = append(valid, order)
+		validIndexes = append(validIndexes, i)
+	}
+
+	if len(valid) > 0 {
+		created, err := s.orderService.BatchCreateOrders(ctx, uow, valid)
+		if err != nil {
+			return err
+		}
+		for j, order := range created {
+			results[validIndexes[j]].OrderID = sql.NullInt64{Int64: order.ID, Valid: true}
+		}
+	}
+
+	return s.recordChunk(ctx, uow, job, results, lease)
+}
+
+// FailBatchCreateChunk records every order of a chunk that could not be written as failed.
+// A chunk rejected for conflicting with existing data is recorded as such; any other
+// chunkErr is logged here and recorded as an internal error.
+func (s *JobService) FailBatchCreateChunk(
+	ctx context.Context,
+	uow dal.UnitOfWork,
+	job *core.Job,
+	size int,
+	chunkErr error,
+	lease time.Duration,
+) error {
+	failure := jobOrderError(ctx, chunkErr)
+	results := make([]models.V1JobResultDal, size)
+	for i := range results {
+		results[i] = models.V1JobResultDal{JobID: job.ID, ItemIndex: job.ProcessedItems + i}
+		setJobResultError(&results[i], failure)
+	}
+
+	return s.recordChunk(ctx, uow, job, results, lease)
+}
+
+// FinishJob moves the job to its final status. A jobErr fails the job; it is logged here
+// and the job records it as an internal error. FinishJob fails with
+// interfaces.ErrJobLeaseLost when another worker took the job over, whose outcome then
+// stands.
+func (s *JobService) FinishJob(
+	ctx context.Context,
+	uow dal.UnitOfWork,
+	job *core.Job,
+	jobErr error,
+) error {
+	status := core.JobStatusCompleted
+	code, message := "", ""
+	if jobErr != nil {
+		logging.FromContext(ctx).Error("Job failed", append([]any{"error", jobErr}, logging.ErrorAttrs(jobErr)...)...)
+		status = core.JobStatusFailed
+		code, message = problem.CodeInternal, jobUnexpectedError
+	}
+
+	if err := uow.GetJobRepo().FinishJob(ctx, jobLease(job), status, code, message); err != nil {
+		return err
+	}
+	job.Status = status
+	job.ErrorCode = code
+	job.Error = message
+	return nil
+}
+
+// jobFailure is what a job result records about an order that was not created
+type jobFailure struct {
+	code    string
+	message string
+	fields  []core.FieldError
+}
+
+// jobOrderError returns what to record for an order of a job that failed with err.
+// Rejections of the order are recorded as the synchronous endpoints report them, with
+// English field messages since nobody's language is known when the job runs. Anything
+// else is logged and recorded as an internal error.
+func jobOrderError(ctx context.Context, err error) jobFailure {
+	rejection := orderRejection(err)
+	if rejection == nil {
+		logging.FromContext(ctx).Error("Job orders failed", append([]any{"error", err}, logging.ErrorAttrs(err)...)...)
+		return jobFailure{code: problem.CodeInternal, message: jobUnexpectedError}
+	}
+
+	var invalid *ValidationError
+	if errors.As(rejection, &invalid) {
+		if fields := FieldErrors(invalid, validation.Translator("")); fields != nil {
+			// The raw validator message would only repeat the field list, less readably
+			return jobFailure{code: invalid.Code(), message: "One or more fields are invalid", fields: fields}
+		}
+	}
+	var coded interface {
+		error
+		Code() string
+	}
+	errors.As(rejection, &coded)
+	return jobFailure{code: coded.Code(), message: coded.Error()}
+}
+
+// setJobResultError marks result as failed with failure
+func setJobResultError(result *models.V1JobResultDal, failure jobFailure) {
+	result.ErrorCode = sql.NullString{String: failure.code, Valid: true}
+	result.Error = sql.NullString{String: failure.message, Valid: true}
+	if failure.fields != nil {
+		// Field errors are plain strings; encoding them cannot fail
+		result.FieldErrors, _ = json.Marshal(failure.fields)
+	}
+}
+
+func (s *JobService) recordChunk(
+	ctx context.Context,
+	uow dal.UnitOfWork,
+	job *core.Job,
+	results []models.V1JobResultDal,
+	lease time.Duration,
+) error {
+	failed := 0
+	for _, result := range results {
+		if result.Error.Valid {
+			failed++
+		}
+	}
+
+	// The progress update goes first: it locks the job row and fails once the lease is
+	// lost, before anything else is written under it
+	processed := job.ProcessedItems + len(results)
+	failed += job.FailedItems
+	if err := uow.GetJobRepo().UpdateJobProgress(ctx, jobLease(job), processed, failed, lease); err != nil {
+		return err
+	}
+	if err := uow.GetJobRepo().InsertJobResults(ctx, results); err != nil {
+		return err
+	}
+
+	job.ProcessedItems = processed
+	job.FailedItems = failed
+	return nil
+}
+
+// jobLease is the lease under which the caller works on job
+func jobLease(job *core.Job) models.JobLease {
+	return models.JobLease{JobID: job.ID, Attempt: job.Attempts, ProcessedItems: job.ProcessedItems}
+}
+
+// jobFromDal maps a job row and its result rows to the core model
+func jobFromDal(dalJob *models.V1JobDal, dalResults []models.V1JobResultDal) *core.Job {
+	job := &core.Job{
+		ID:             dalJob.ID,
+		TenantID:       dalJob.TenantID,
+		Type:           dalJob.Type,
+		Status:         dalJob.Status,
+		TotalItems:     dalJob.TotalItems,
+		ProcessedItems: dalJob.ProcessedItems,
+		FailedItems:    dalJob.FailedItems,
+		ErrorCode:      dalJob.ErrorCode.String,
+		Error:          dalJob.Error.String,
+		Attempts:       dalJob.Attempts,
+		CreatedBy:      dalJob.CreatedBy,
+		CreatedAt:      dalJob.CreatedAt,
+		UpdatedAt:      dalJob.UpdatedAt,
+		Results:        make([]core.JobResult, len(dalResults)),
+	}
+	if dalJob.StartedAt.Valid {
+		job.StartedAt = &dalJob.StartedAt.Time
+	}
+	if dalJob.FinishedAt.Valid {
+		job.FinishedAt = &dalJob.FinishedAt.Time
+	}
+
+	for i, result := range dalResults {
+		job.Results[i] = core.JobResult{
+			Index:     result.ItemIndex,
+			OrderID:   result.OrderID.Int64,
+			ErrorCode: result.ErrorCode.String,
+			Error:     result.Error.String,
+		}
+		if result.FieldErrors != nil {
+			_ = json.Unmarshal(result.FieldErrors, &job.Results[i].Errors)
+		}
+	}
+
+	return job
+}
@@ -0,0 +1,184 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	core "github.com/Lamafout/online-store-api/core/models/common"
+	"github.com/Lamafout/online-store-api/internal/dal/interfaces"
+	"github.com/Lamafout/online-store-api/internal/dal/memory"
+	"github.com/Lamafout/online-store-api/internal/dal/models"
+	dal "github.com/Lamafout/online-store-api/internal/dal/unit_of_work"
+	"github.com/Lamafout/online-store-api/internal/logging"
+	"github.com/Lamafout/online-store-api/internal/problem"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// jobUnitOfWork serves jobs from an in-memory repository, which the memory store does
+// not have
+type jobUnitOfWork struct {
+	dal.UnitOfWork
+	repo *memoryJobRepository
+}
+
+func (u *jobUnitOfWork) GetJobRepo() interfaces.IJobRepository {
+	return u.repo
+}
+
+// memoryJobRepository keeps a single job and its results
+type memoryJobRepository struct {
+	memory.UnsupportedRepository
+	job     models.V1JobDal
+	results []models.V1JobResultDal
+}
+
+func (r *memoryJobRepository) GetJobByID(context.Context, int64) (*models.V1JobDal, error) {
+	job := r.job
+	return &job, nil
+}
+
+func (r *memoryJobRepository) UpdateJobProgress(_ context.Context, _ models.JobLease, processed, failed int, _ time.Duration) error {
+	r.job.ProcessedItems = processed
+	r.job.FailedItems = failed
+	return nil
+}
+
+func (r *memoryJobRepository) FinishJob(_ context.Context, _ models.JobLease, status, errCode, errMessage string) error {
+	r.job.Status = status
+	r.job.ErrorCode = sql.NullString{String: errCode, Valid: errCode != ""}
+	r.job.Error = sql.NullString{String: errMessage, Valid: errMessage != ""}
+	return nil
+}
+
+func (r *memoryJobRepository) InsertJobResults(_ context.Context, results []models.V1JobResultDal) error {
+	r.results = append(r.results, results...)
+	return nil
+}
+
+func (r *memoryJobRepository) GetJobResults(context.Context, int64) ([]models.V1JobResultDal, error) {
+	return r.results, nil
+}
+
+// newJobServiceTest returns a running job and a context whose logger writes to logs
+func newJobServiceTest() (context.Context, *jobUnitOfWork, *core.Job, *bytes.Buffer) {
+	ctx, factory := newTestStore()
+	var logs bytes.Buffer
+	ctx = logging.WithLogger(ctx, slog.New(slog.NewJSONHandler(&logs, nil)))
+
+	repo := &memoryJobRepository{job: models.V1JobDal{ID: 1, Status: core.JobStatusRunning, TotalItems: 3, Attempts: 1}}
+	job := jobFromDal(&repo.job, nil)
+	return ctx, &jobUnitOfWork{UnitOfWork: factory.Create(), repo: repo}, job, &logs
+}
+
+func getJob(t *testing.T, ctx context.Context, uow dal.UnitOfWork, service *JobService) *core.Job {
+	t.Helper()
+	job, err := service.GetJob(ctx, uow, 1)
+	if err != nil {
+		t.Fatalf("GetJob() error = %v", err)
+	}
+	return job
+}
+
+func TestJobServiceRecordsRejectedOrdersWithCodes(t *testing.T) {
+	ctx, uow, job, logs := newJobServiceTest()
+	service := NewJobService(NewOrderService())
+
+	invalid := testOrder(2, 100)
+	invalid.Items[0].ProductURL = "not a url"
+	mismatch := testOrder(3, 100)
+	mismatch.TotalPriceCents = 90
+	err := uow.WithinTransaction(ctx, func(ctx context.Context) error {
+		return service.ProcessBatchCreateChunk(ctx, uow, job, []*core.Order{testOrder(1, 100), invalid, mismatch}, time.Minute)
+	})
+	if err != nil {
+		t.Fatalf("ProcessBatchCreateChunk() error = %v", err)
+	}
+
+	results := getJob(t, ctx, uow, service).Results
+	if len(results) != 3 || results[0].OrderID == 0 || results[0].ErrorCode != "" {
+		t.Fatalf("results = %+v, want the first order created", results)
+	}
+
+	rejected := results[1]
+	if rejected.ErrorCode != CodeValidationFailed || rejected.Error != "One or more fields are invalid" {
+		t.Fatalf("invalid order result = %+v, want %s with a generic message", rejected, CodeValidationFailed)
+	}
+	if len(rejected.Errors) != 1 || rejected.Errors[0].FieldPath != "items[0].product_url" || rejected.Errors[0].Rule != "url" || rejected.Errors[0].Message == "" {
+		t.Fatalf("field errors = %+v, want items[0].product_url failing url", rejected.Errors)
+	}
+
+	if results[2].ErrorCode != CodeTotalPriceMismatch || results[2].Errors != nil {
+		t.Fatalf("mismatch result = %+v, want %s", results[2], CodeTotalPriceMismatch)
+	}
+	if logs.Len() != 0 {
+		t.Fatalf("rejected orders were logged: %s", logs.String())
+	}
+}
+
+func TestJobServiceFailBatchCreateChunkHidesDatabaseErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		wantCode string
+		wantLog  bool
+	}{
+		{
+			name:     "constraint violation",
+			err:      &pgconn.PgError{Code: "23505", Message: "duplicate key value violates unique constraint", ConstraintName: "orders_pkey"},
+			wantCode: CodeConstraintViolated,
+		},
+		{
+			name:     "unexpected",
+			err:      &pgconn.PgError{Code: "XX000", Message: `relation "orders_pkey" is corrupt`},
+			wantCode: problem.CodeInternal,
+			wantLog:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, uow, job, logs := newJobServiceTest()
+			service := NewJobService(NewOrderService())
+
+			if err := service.FailBatchCreateChunk(ctx, uow, job, 2, tt.err, time.Minute); err != nil {
+				t.Fatalf("FailBatchCreateChunk() error = %v", err)
+			}
+
+			stored := getJob(t, ctx, uow, service)
+			if stored.FailedItems != 2 || len(stored.Results) != 2 {
+				t.Fatalf("job = %+v, want 2 failed results", stored)
+			}
+			for _, result := range stored.Results {
+				if result.ErrorCode != tt.wantCode || strings.Contains(result.Error, "orders_pkey") || strings.Contains(result.Error, "SQLSTATE") {
+					t.Fatalf("result = %+v, want %s without database details", result, tt.wantCode)
+				}
+			}
+			if logged := strings.Count(logs.String(), "orders_pkey"); (logged == 1) != tt.wantLog || logged > 1 {
+				t.Fatalf("cause logged %d times, want logged = %v: %s", logged, tt.wantLog, logs.String())
+			}
+		})
+	}
+}
+
+func TestJobServiceFinishJobLogsCauseAndStoresCode(t *testing.T) {
+	ctx, uow, job, logs := newJobServiceTest()
+	service := NewJobService(NewOrderService())
+
+	if err := service.FinishJob(ctx, uow, job, errors.New("failed to record chunk failure: connection to 10.0.0.5 refused")); err != nil {
+		t.Fatalf("FinishJob() error = %v", err)
+	}
+
+	stored := getJob(t, ctx, uow, service)
+	if stored.Status != core.JobStatusFailed || stored.ErrorCode != problem.CodeInternal || strings.Contains(stored.Error, "10.0.0.5") {
+		t.Fatalf("job = %+v, want a failed job with a client-safe error", stored)
+	}
+	if !strings.Contains(logs.String(), "10.0.0.5") {
+		t.Fatalf("cause was not logged: %s", logs.String())
+	}
+}
@@ -0,0 +1,154 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/Lamafout/online-store-api/core/models/dto"
+	"github.com/Lamafout/online-store-api/internal/cache"
+	"github.com/Lamafout/online-store-api/internal/logging"
+	"github.com/Lamafout/online-store-api/internal/metrics"
+	"github.com/Lamafout/online-store-api/internal/tenant"
+)
+
+// Names of the caches in metrics
+const (
+	orderCacheName         = "order"
+	customerOrderCacheName = "customer_orders"
+)
+
+// orderCache caches orders by ID and the query results of single customers. Orders
+// never change once created, but every new order changes the results of its
+// customer's queries. Instead of finding those, query keys include a generation of
+// the customer that is replaced after each write, so earlier results stop being found.
+// A nil orderCache caches nothing.
+type orderCache struct {
+	cache cache.Cache
+	ttl   time.Duration
+}
+
+// orderKey returns the key of an order, or "" when nothing is to be cached
+func (c *orderCache) orderKey(ctx context.Context, id int64) string {
+	if c == nil {
+		return ""
+	}
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("order:%s:%d", tenantID, id)
+}
+
+// queryKey returns the key of a query for the orders of a single customer, or "" when
+// nothing is to be cached. The generation is read before the query runs, so results
+// read before a write are stored where no reader looks after it.
+func (c *orderCache) queryKey(ctx context.Context, req *dto.V1QueryOrdersRequest) string {
+	if c == nil || len(req.CustomerIDs) != 1 {
+		return ""
+	}
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return ""
+	}
+	filters, err := json.Marshal(req)
+	if err != nil {
+		return ""
+	}
+	generation := c.generation(ctx, tenantID, req.CustomerIDs[0])
+	if generation == "" {
+		return ""
+	}
+	return fmt.Sprintf("customer_orders:%s:%d:%s:%s", tenantID, req.CustomerIDs[0], generation, filters)
+}
+
+// get decodes the value under key into out and reports whether it was found
+func (c *orderCache) get(ctx context.Context, name, key string, out any) bool {
+	if key == "" {
+		return false
+	}
+	if cache.Bypassed(ctx) {
+		metrics.CacheRequestsTotal.WithLabelValues(name, "bypass").Inc()
+		return false
+	}
+	data, ok, err := c.cache.Get(ctx, key)
+	if err != nil {
+		logging.FromContext(ctx).Warn("Failed to read from cache", "cache", name, "error", err)
+	}
+	if !ok || err != nil || json.Unmarshal(data, out) != nil {
+		metrics.CacheRequestsTotal.WithLabelValues(name, "miss").Inc()
+		return false
+	}
+	metrics.CacheRequestsTotal.WithLabelValues(name, "hit").Inc()
+	return true
+}
+
+// set stores value under key
+func (c *orderCache) set(ctx context.Context, name, key string, value any) {
+	if key == "" {
+		return
+	}
+	data, err := json.Marshal(value)
+	if err == nil {
+		err = c.cache.Set(ctx, key, data, c.ttl)
+	}
+	if err != nil {
+		logging.FromContext(ctx).Warn("Failed to write to cache", "cache", name, "error", err)
+	}
+}
+
+// invalidate forgets what was cached about orders written by ctx. It runs after the
+// write committed, when the request may already be cancelled.
+func (c *orderCache) invalidate(ctx context.Context, customerIDs []int64, orderIDs []int64) {
+	if c == nil {
+		return
+	}
+	ctx = context.WithoutCancel(ctx)
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return
+	}
+	keys := make([]string, len(orderIDs))
+	for i, id := range orderIDs {
+		keys[i] = c.orderKey(ctx, id)
+	}
+	if err := c.cache.Delete(ctx, keys...); err != nil {
+		logging.FromContext(ctx).Warn("Failed to invalidate cached orders", "error", err)
+	}
+	for _, customerID := range customerIDs {
+		if err := c.cache.Set(ctx, generationKey(tenantID, customerID), newGeneration(), c.ttl); err != nil {
+			logging.FromContext(ctx).Warn("Failed to invalidate cached queries", "customer_id", customerID, "error", err)
+		}
+	}
+}
+
+// generation returns the current generation of a customer, starting one if it has
+// none. It returns "" if the cache is unavailable.
+func (c *orderCache) generation(ctx context.Context, tenantID string, customerID int64) string {
+	key := generationKey(tenantID, customerID)
+	if value, ok, err := c.cache.Get(ctx, key); err != nil {
+		return ""
+	} else if ok {
+		return string(value)
+	}
+	generation := newGeneration()
+	// The generation lives as long as the results under it; once it expires the next
+	// reader starts a new one, which only costs misses
+	if err := c.cache.Set(ctx, key, generation, c.ttl); err != nil {
+		return ""
+	}
+	return string(generation)
+}
+
+func generationKey(tenantID string, customerID int64) string {
+	return fmt.Sprintf("customer_orders_generation:%s:%d", tenantID, customerID)
+}
+
+func newGeneration() []byte {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return []byte(hex.EncodeToString(b))
+}
@@ -0,0 +1,132 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	core "github.com/Lamafout/online-store-api/core/models/common"
+	"github.com/Lamafout/online-store-api/core/models/dto"
+	"github.com/Lamafout/online-store-api/internal/cache"
+	"github.com/Lamafout/online-store-api/internal/dal/memory"
+	"github.com/Lamafout/online-store-api/internal/dal/models"
+	dal "github.com/Lamafout/online-store-api/internal/dal/unit_of_work"
+	"github.com/Lamafout/online-store-api/internal/tenant"
+)
+
+func createOrder(t *testing.T, ctx context.Context, service *OrderService, factory *dal.UnitOfWorkFactory, order *core.Order) {
+	t.Helper()
+	uow := factory.Create()
+	if err := uow.WithinTransaction(ctx, func(ctx context.Context) error {
+		return service.CreateOrder(ctx, uow, order)
+	}); err != nil {
+		t.Fatalf("CreateOrder() error = %v", err)
+	}
+}
+
+func TestCachedOrderServiceGetOrder(t *testing.T) {
+	ctx, factory := newTestStore()
+	service := NewCachedOrderService(cache.NewLRU(100), time.Minute)
+	order := testOrder(7, 1000)
+	createOrder(t, ctx, service, factory, order)
+
+	if _, err := service.GetOrder(ctx, factory.Create(), order.ID); err != nil {
+		t.Fatalf("GetOrder() error = %v", err)
+	}
+
+	// An empty store proves the order comes from the cache
+	empty := dal.NewMemoryUnitOfWorkFactory(memory.NewStore())
+	got, err := service.GetOrder(ctx, empty.Create(), order.ID)
+	if err != nil || got.ID != order.ID || len(got.Items) != 1 {
+		t.Fatalf("cached GetOrder() = %+v, %v", got, err)
+	}
+
+	if _, err := service.GetOrder(cache.WithBypass(ctx), empty.Create(), order.ID); err == nil {
+		t.Fatal("GetOrder() bypassing the cache found the order")
+	}
+	other := tenant.WithTenant(context.Background(), "other")
+	if _, err := service.GetOrder(other, empty.Create(), order.ID); err == nil {
+		t.Fatal("GetOrder() of another tenant found the cached order")
+	}
+}
+
+func TestCachedOrderServiceInvalidatesCustomerQueries(t *testing.T) {
+	ctx, factory := newTestStore()
+	service := NewCachedOrderService(cache.NewLRU(100), time.Minute)
+	createOrder(t, ctx, service, factory, testOrder(7, 1000))
+
+	query := func(ctx context.Context) int {
+		t.Helper()
+		orders, err := service.QueryOrders(ctx, factory.Create(), &dto.V1QueryOrdersRequest{CustomerIDs: []int64{7}})
+		if err != nil {
+			t.Fatalf("QueryOrders() error = %v", err)
+		}
+		return len(orders)
+	}
+	if n := query(ctx); n != 1 {
+		t.Fatalf("QueryOrders() = %d orders, want 1", n)
+	}
+
+	// Written through the service, the new order shows up right away
+	createOrder(t, ctx, service, factory, testOrder(7, 500))
+	uow := factory.Create()
+	if err := uow.WithinTransaction(ctx, func(ctx context.Context) error {
+		_, err := service.BatchCreateOrders(ctx, uow, []*core.Order{testOrder(7, 100), testOrder(8, 100)})
+		return err
+	}); err != nil {
+		t.Fatalf("BatchCreateOrders() error = %v", err)
+	}
+	if n := query(ctx); n != 3 {
+		t.Fatalf("QueryOrders() after writes = %d orders, want 3", n)
+	}
+
+	// Written behind its back, it is only seen when bypassing the cache
+	if err := factory.Create().GetOrderRepo().CreateOrder(ctx, &models.V1OrderDal{CustomerID: 7}); err != nil {
+		t.Fatalf("CreateOrder() error = %v", err)
+	}
+	if n := query(ctx); n != 3 {
+		t.Fatalf("cached QueryOrders() = %d orders, want 3", n)
+	}
+	if n := query(cache.WithBypass(ctx)); n != 4 {
+		t.Fatalf("QueryOrders() bypassing the cache = %d orders, want 4", n)
+	}
+	// The bypassing read refreshed the cache
+	if n := query(ctx); n != 4 {
+		t.Fatalf("QueryOrders() after the refresh = %d orders, want 4", n)
+	}
+}
+
+// replicaUnitOfWork reads from a replica, here a store that has not replayed the writes
+type replicaUnitOfWork struct {
+	dal.UnitOfWork
+}
+
+func (u *replicaUnitOfWork) ReadsFromReplica() bool {
+	return true
+}
+
+func TestCachedOrderServiceDoesNotCacheReplicaQueries(t *testing.T) {
+	ctx, factory := newTestStore()
+	service := NewCachedOrderService(cache.NewLRU(100), time.Minute)
+	req := &dto.V1QueryOrdersRequest{CustomerIDs: []int64{7}}
+
+	// The write starts a new generation, which a lagging replica answers without it
+	createOrder(t, ctx, service, factory, testOrder(7, 1000))
+	lagging := &replicaUnitOfWork{UnitOfWork: dal.NewMemoryUnitOfWorkFactory(memory.NewStore()).Create()}
+	orders, err := service.QueryOrders(ctx, lagging, req)
+	if err != nil || len(orders) != 0 {
+		t.Fatalf("QueryOrders() on the replica = %d orders, %v; want 0", len(orders), err)
+	}
+
+	// The writer reading its own write must not get the replica's answer
+	orders, err = service.QueryOrders(ctx, factory.Create(), req)
+	if err != nil || len(orders) != 1 {
+		t.Fatalf("QueryOrders() after the replica read = %d orders, %v; want 1", len(orders), err)
+	}
+
+	// Results read from the primary are cached and served to replica readers
+	orders, err = service.QueryOrders(ctx, lagging, req)
+	if err != nil || len(orders) != 1 {
+		t.Fatalf("cached QueryOrders() on the replica = %d orders, %v; want 1", len(orders), err)
+	}
+}
@@ -7,28 +7,50 @@ import (
 
 	core "github.com/Lamafout/online-store-api/core/models/common"
 	"github.com/Lamafout/online-store-api/core/models/dto"
+	"github.com/Lamafout/online-store-api/internal/cache"
 	"github.com/Lamafout/online-store-api/internal/dal/models"
 	"github.com/Lamafout/online-store-api/internal/dal/unit_of_work"
+	"github.com/Lamafout/online-store-api/internal/logging"
+	"github.com/Lamafout/online-store-api/internal/metrics"
+	"github.com/Lamafout/online-store-api/internal/validation"
 	"github.com/go-playground/validator/v10"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var tracer = otel.Tracer("github.com/Lamafout/online-store-api/internal/bll/services")
+
 type OrderService struct {
 	validate *validator.Validate
+	cache    *orderCache
 }
 
 func NewOrderService() *OrderService {
 	return &OrderService{
-		validate: validator.New(),
+		validate: validation.Validator(),
 	}
 }
 
+// NewCachedOrderService creates an OrderService that keeps orders and the query
+// results of single customers in c for up to ttl. Writes through the service
+// invalidate them once committed.
+func NewCachedOrderService(c cache.Cache, ttl time.Duration) *OrderService {
+	s := NewOrderService()
+	s.cache = &orderCache{cache: c, ttl: ttl}
+	return s
+}
+
 func (s *OrderService) CreateOrder(
 	ctx context.Context,
-	uow *dal.UnitOfWork,
+	uow dal.UnitOfWork,
 	order *core.Order,
 ) error {
+	ctx, span := tracer.Start(ctx, "OrderService.CreateOrder", trace.WithAttributes(attribute.Int("order.items", len(order.Items))))
+	defer span.End()
+
 	if err := s.validate.Struct(order); err != nil {
-		return fmt.Errorf("validation failed: %w", err)
+		return &ValidationError{Err: err}
 	}
 
 	dalOrder := &models.V1OrderDal{
@@ -44,11 +66,13 @@ func (s *OrderService) CreateOrder(
 		return fmt.Errorf("failed to create order: %w", err)
 	}
 	order.ID = dalOrder.ID
+	// Everything logged for the items below refers to this order
+	ctx = logging.With(ctx, "order_id", order.ID)
 
 	for i := range order.Items {
 		item := &order.Items[i]
 		if err := s.validate.Struct(item); err != nil {
-			return fmt.Errorf("validation failed for item: %w", err)
+			return &ValidationError{Err: err}
 		}
 
 		dalItem := &models.V1OrderItemDal{
@@ -71,17 +95,35 @@ func (s *OrderService) CreateOrder(
 		item.OrderID = dalItem.OrderID
 	}
 
+	logging.FromContext(ctx).Debug("Order created", "customer_id", order.CustomerID, "items", len(order.Items))
+	itemCount := len(order.Items)
+	customerID, orderID := order.CustomerID, order.ID
+	uow.AfterCommit(func() {
+		metrics.OrdersCreatedTotal.Inc()
+		metrics.OrderItemsCreatedTotal.Add(float64(itemCount))
+		s.cache.invalidate(ctx, []int64{customerID}, []int64{orderID})
+	})
 	return nil
 }
 
 func (s *OrderService) GetOrder(
 	ctx context.Context,
-	uow *dal.UnitOfWork,
+	uow dal.UnitOfWork,
 	id int64,
 ) (*core.Order, error) {
+	ctx, span := tracer.Start(ctx, "OrderService.GetOrder", trace.WithAttributes(attribute.Int64("order.id", id)))
+	defer span.End()
+	ctx = logging.With(ctx, "order_id", id)
+
+	cacheKey := s.cache.orderKey(ctx, id)
+	var cached core.Order
+	if s.cache.get(ctx, orderCacheName, cacheKey, &cached) {
+		return &cached, nil
+	}
+
 	dalOrder, err := uow.GetOrderRepo().GetOrderByID(ctx, id)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get order: %w", err)
+		return nil, fmt.Errorf("failed to get order: %w", notFound(err, "order", id))
 	}
 
 	dalItems, err := uow.GetOrderItemRepo().GetOrderItemsByOrderID(ctx, id)
@@ -115,40 +157,42 @@ func (s *OrderService) GetOrder(
 		}
 	}
 
+	s.cache.set(ctx, orderCacheName, cacheKey, order)
 	return order, nil
 }
 
 func (s *OrderService) BatchCreateOrders(
 	ctx context.Context,
-	uow *dal.UnitOfWork,
+	uow dal.UnitOfWork,
 	orders []*core.Order,
 ) ([]*core.Order, error) {
+	ctx, span := tracer.Start(ctx, "OrderService.BatchCreateOrders", trace.WithAttributes(attribute.Int("orders.count", len(orders))))
+	defer span.End()
+
 	if len(orders) == 0 {
-		return nil, fmt.Errorf("orders list cannot be empty")
+		return nil, errEmptyBatch
 	}
 
 	for _, order := range orders {
-		if err := s.validate.Struct(order); err != nil {
-			return nil, fmt.Errorf("validation failed: %w", err)
-		}
-		total := int64(0)
-		for _, item := range order.Items {
-			total += item.PriceCents * int64(item.Quantity)
-		}
-		if total != order.TotalPriceCents {
-			return nil, fmt.Errorf("total price mismatch for order: expected %d, got %d", total, order.TotalPriceCents)
+		if err := s.ValidateOrder(order); err != nil {
+			return nil, err
 		}
 	}
 
 	now := time.Now()
 	bulkOrders := make([]models.BulkOrderDalModel, len(orders))
 	for i, order := range orders {
+		// Imported history keeps its original timestamps
+		createdAt := now
+		if !order.CreatedAt.IsZero() {
+			createdAt = order.CreatedAt
+		}
 		bulkOrders[i] = models.BulkOrderDalModel{
 			CustomerID:         order.CustomerID,
 			DeliveryAddress:    order.DeliveryAddress,
 			TotalPriceCents:    order.TotalPriceCents,
 			TotalPriceCurrency: order.TotalPriceCurrency,
-			CreatedAt:          now,
+			CreatedAt:          createdAt,
 			UpdatedAt:          now,
 		}
 	}
@@ -158,14 +202,18 @@ func (s *OrderService) BatchCreateOrders(
 		return nil, fmt.Errorf("failed to bulk insert orders: %w", err)
 	}
 
+	if len(insertedOrders) != len(orders) {
+		return nil, fmt.Errorf("failed to bulk insert orders: expected %d rows, got %d", len(orders), len(insertedOrders))
+	}
+
+	var bulkItems []models.BulkOrderItemDalModel
 	for i := range insertedOrders {
 		orders[i].ID = insertedOrders[i].ID
 		orders[i].CreatedAt = insertedOrders[i].CreatedAt
 		orders[i].UpdatedAt = insertedOrders[i].UpdatedAt
 
-		for j := range orders[i].Items {
-			item := &orders[i].Items[j]
-			dalItem := &models.V1OrderItemDal{
+		for _, item := range orders[i].Items {
+			bulkItems = append(bulkItems, models.BulkOrderItemDalModel{
 				OrderID:       insertedOrders[i].ID,
 				ProductID:     item.ProductID,
 				Quantity:      item.Quantity,
@@ -173,27 +221,166 @@ func (s *OrderService) BatchCreateOrders(
 				ProductURL:    item.ProductURL,
 				PriceCents:    item.PriceCents,
 				PriceCurrency: item.PriceCurrency,
-				CreatedAt:     now,
+				CreatedAt:     insertedOrders[i].CreatedAt,
 				UpdatedAt:     now,
+			})
+		}
+	}
+
+	insertedItems, err := uow.GetOrderItemRepo().BulkInsertOrderItems(ctx, bulkItems)
+	if err != nil {
+		return nil, fmt.Errorf("failed to bulk insert order items: %w", err)
+	}
+	if len(insertedItems) != len(bulkItems) {
+		return nil, fmt.Errorf("failed to bulk insert order items: expected %d rows, got %d", len(bulkItems), len(insertedItems))
+	}
+
+	// Items come back in the order they were sent: grouped by order, in item order
+	next := 0
+	for _, order := range orders {
+		for j := range order.Items {
+			item := &order.Items[j]
+			item.ID = insertedItems[next].ID
+			item.OrderID = insertedItems[next].OrderID
+			item.CreatedAt = insertedItems[next].CreatedAt
+			item.UpdatedAt = insertedItems[next].UpdatedAt
+			next++
+		}
+	}
+
+	logging.FromContext(ctx).Debug("Orders created", "orders", len(orders), "items", len(insertedItems),
+		"first_order_id", insertedOrders[0].ID, "last_order_id", insertedOrders[len(insertedOrders)-1].ID)
+	var customerIDs []int64
+	seen := make(map[int64]bool)
+	orderIDs := make([]int64, len(orders))
+	for i, order := range orders {
+		orderIDs[i] = order.ID
+		if !seen[order.CustomerID] {
+			seen[order.CustomerID] = true
+			customerIDs = append(customerIDs, order.CustomerID)
+		}
+	}
+	uow.AfterCommit(func() {
+		metrics.OrdersCreatedTotal.Add(float64(len(insertedOrders)))
+		metrics.OrderItemsCreatedTotal.Add(float64(len(insertedItems)))
+		s.cache.invalidate(ctx, customerIDs, orderIDs)
+	})
+	return orders, nil
+}
+
+// BatchCreateOutcome is the result of creating one order of a partial batch:
+// either the created order or the reason it was rejected
+type BatchCreateOutcome struct {
+	Order *core.Order
+	Err   error
+}
+
+// BatchCreateOrdersPartial creates each order under its own savepoint so that invalid
+// orders, and orders violating a database constraint, are rolled back individually
+// while the rest of the batch is kept. Any other failure, e.g. a serialization failure
+// the TxRunner retries, is returned and fails the whole batch.
+func (s *OrderService) BatchCreateOrdersPartial(
+	ctx context.Context,
+	uow dal.UnitOfWork,
+	orders []*core.Order,
+) ([]BatchCreateOutcome, error) {
+	ctx, span := tracer.Start(ctx, "OrderService.BatchCreateOrdersPartial", trace.WithAttributes(attribute.Int("orders.count", len(orders))))
+	defer span.End()
+
+	if len(orders) == 0 {
+		return nil, errEmptyBatch
+	}
+
+	outcomes := make([]BatchCreateOutcome, len(orders))
+	for i, order := range orders {
+		if err := s.ValidateOrder(order); err != nil {
+			outcomes[i].Err = err
+			continue
+		}
+
+		savepoint := fmt.Sprintf("batch_order_%d", i)
+		if err := uow.Savepoint(ctx, savepoint); err != nil {
+			return nil, err
+		}
+
+		if _, err := s.BatchCreateOrders(ctx, uow, []*core.Order{order}); err != nil {
+			rejected := orderRejection(err)
+			if rejected == nil {
+				return nil, err
 			}
-			if err := uow.GetOrderItemRepo().CreateOrderItem(ctx, dalItem); err != nil {
-				return nil, fmt.Errorf("failed to create order item: %w", err)
+			if rbErr := uow.RollbackToSavepoint(ctx, savepoint); rbErr != nil {
+				return nil, rbErr
 			}
-			item.ID = dalItem.ID
-			item.OrderID = dalItem.OrderID
-			item.CreatedAt = dalItem.CreatedAt
-			item.UpdatedAt = dalItem.UpdatedAt
+			logging.FromContext(ctx).Warn("Order of partial batch rolled back", "index", i, "customer_id", order.CustomerID, "error", err)
+			outcomes[i].Err = rejected
+			continue
 		}
+
+		if err := uow.ReleaseSavepoint(ctx, savepoint); err != nil {
+			return nil, err
+		}
+		outcomes[i].Order = order
 	}
 
-	return orders, nil
+	return outcomes, nil
+}
+
+// ValidateCreateOrdersRequest checks a batch request against the request rules. Field
+// paths of the returned ValidationError point into the request body.
+func (s *OrderService) ValidateCreateOrdersRequest(req *dto.V1CreateOrderRequest) error {
+	if err := s.validate.Struct(req); err != nil {
+		return &ValidationError{Err: err}
+	}
+	return nil
+}
+
+// ValidateCreateOrder checks the order at index of a batch request on its own, reporting
+// field paths under orders[index]
+func (s *OrderService) ValidateCreateOrder(index int, order *dto.V1CreateOrder) error {
+	if err := s.validate.Struct(order); err != nil {
+		return &ValidationError{Err: err, Path: fmt.Sprintf("orders[%d]", index)}
+	}
+	return nil
+}
+
+// ValidateOrder checks an order and its items against the model rules and makes
+// sure the order total matches the sum of its items
+func (s *OrderService) ValidateOrder(order *core.Order) error {
+	if err := s.validate.Struct(order); err != nil {
+		return &ValidationError{Err: err}
+	}
+
+	total := int64(0)
+	for _, item := range order.Items {
+		total += item.PriceCents * int64(item.Quantity)
+	}
+	if total != order.TotalPriceCents {
+		return &TotalMismatchError{Expected: total, Got: order.TotalPriceCents}
+	}
+
+	return nil
 }
 
 func (s *OrderService) QueryOrders(
 	ctx context.Context,
-	uow *dal.UnitOfWork,
+	uow dal.UnitOfWork,
 	req *dto.V1QueryOrdersRequest,
 ) ([]*core.Order, error) {
+	ctx, span := tracer.Start(ctx, "OrderService.QueryOrders", trace.WithAttributes(attribute.Bool("orders.include_items", req.IncludeOrderItems)))
+	defer span.End()
+
+	cacheKey := s.cache.queryKey(ctx, req)
+	var cached []*core.Order
+	if s.cache.get(ctx, customerOrderCacheName, cacheKey, &cached) {
+		span.SetAttributes(attribute.Int("orders.count", len(cached)))
+		return cached, nil
+	}
+	// A lagging replica may miss writes made before the generation in the key was
+	// started, so only results read from the primary are stored under it
+	if uow.ReadsFromReplica() {
+		cacheKey = ""
+	}
+
 	dalReq := &models.QueryOrdersDalModel{
 		IDs:         req.IDs,
 		CustomerIDs: req.CustomerIDs,
@@ -213,6 +400,7 @@ func (s *OrderService) QueryOrders(
 	}
 
 	if len(dalOrders) == 0 {
+		s.cache.set(ctx, customerOrderCacheName, cacheKey, []*core.Order{})
 		return []*core.Order{}, nil
 	}
 
@@ -271,5 +459,90 @@ func (s *OrderService) QueryOrders(
 		orders[i] = order
 	}
 
+	s.cache.set(ctx, customerOrderCacheName, cacheKey, orders)
+	span.SetAttributes(attribute.Int("orders.count", len(orders)))
 	return orders, nil
 }
+
+// ExportOrders streams every order matching the request filters to fn in batches,
+// ignoring pagination. The unit of work must be inside a transaction because the
+// orders are read through a server-side cursor.
+func (s *OrderService) ExportOrders(
+	ctx context.Context,
+	uow dal.UnitOfWork,
+	req *dto.V1QueryOrdersRequest,
+	batchSize int,
+	fn func([]*core.Order) error,
+) error {
+	ctx, span := tracer.Start(ctx, "OrderService.ExportOrders", trace.WithAttributes(attribute.Bool("orders.include_items", req.IncludeOrderItems)))
+	defer span.End()
+
+	dalReq := &models.QueryOrdersDalModel{
+		IDs:         req.IDs,
+		CustomerIDs: req.CustomerIDs,
+	}
+
+	err := uow.GetOrderRepo().IterateOrders(ctx, dalReq, batchSize, func(dalOrders []models.V1OrderDal) error {
+		var orderItemsLookup map[int64][]models.V1OrderItemDal
+		if req.IncludeOrderItems {
+			orderIDs := make([]int64, len(dalOrders))
+			for i, order := range dalOrders {
+				orderIDs[i] = order.ID
+			}
+
+			itemsReq := &models.QueryOrderItemsDalModel{OrderIDs: orderIDs}
+			dalItems, err := uow.GetOrderItemRepo().QueryOrderItems(ctx, itemsReq)
+			if err != nil {
+				return fmt.Errorf("failed to query order items: %w", err)
+			}
+
+			orderItemsLookup = make(map[int64][]models.V1OrderItemDal)
+			for _, item := range dalItems {
+				orderItemsLookup[item.OrderID] = append(orderItemsLookup[item.OrderID], item)
+			}
+		}
+
+		orders := make([]*core.Order, len(dalOrders))
+		for i, dalOrder := range dalOrders {
+			orders[i] = orderFromDal(dalOrder, orderItemsLookup[dalOrder.ID])
+		}
+
+		return fn(orders)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to export orders: %w", err)
+	}
+
+	return nil
+}
+
+// orderFromDal maps an order row and its item rows to the core model
+func orderFromDal(dalOrder models.V1OrderDal, dalItems []models.V1OrderItemDal) *core.Order {
+	order := &core.Order{
+		ID:                 dalOrder.ID,
+		CustomerID:         dalOrder.CustomerID,
+		DeliveryAddress:    dalOrder.DeliveryAddress,
+		TotalPriceCents:    dalOrder.TotalPriceCents,
+		TotalPriceCurrency: dalOrder.TotalPriceCurrency,
+		CreatedAt:          dalOrder.CreatedAt,
+		UpdatedAt:          dalOrder.UpdatedAt,
+		Items:              make([]core.OrderItem, len(dalItems)),
+	}
+
+	for i, item := range dalItems {
+		order.Items[i] = core.OrderItem{
+			ID:            item.ID,
+			OrderID:       item.OrderID,
+			ProductID:     item.ProductID,
+			Quantity:      item.Quantity,
+			ProductTitle:  item.ProductTitle,
+			ProductURL:    item.ProductURL,
+			PriceCents:    item.PriceCents,
+			PriceCurrency: item.PriceCurrency,
+			CreatedAt:     item.CreatedAt,
+			UpdatedAt:     item.UpdatedAt,
+		}
+	}
+
+	return order
+}
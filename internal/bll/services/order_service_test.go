@@ -0,0 +1,302 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	core "github.com/Lamafout/online-store-api/core/models/common"
+	"github.com/Lamafout/online-store-api/core/models/dto"
+	"github.com/Lamafout/online-store-api/internal/dal/interfaces"
+	"github.com/Lamafout/online-store-api/internal/dal/memory"
+	"github.com/Lamafout/online-store-api/internal/dal/models"
+	dal "github.com/Lamafout/online-store-api/internal/dal/unit_of_work"
+	"github.com/Lamafout/online-store-api/internal/tenant"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// testOrder builds a valid order with one item per price
+func testOrder(customerID int64, prices ...int64) *core.Order {
+	order := &core.Order{
+		CustomerID:         customerID,
+		DeliveryAddress:    "1 Test Street",
+		TotalPriceCurrency: "USD",
+	}
+	for i, price := range prices {
+		order.Items = append(order.Items, core.OrderItem{
+			ProductID:     int64(i + 1),
+			Quantity:      1,
+			ProductTitle:  "Product",
+			ProductURL:    "https://example.com/product",
+			PriceCents:    price,
+			PriceCurrency: "USD",
+		})
+		order.TotalPriceCents += price
+	}
+	return order
+}
+
+func newTestStore() (context.Context, *dal.UnitOfWorkFactory) {
+	return tenant.WithTenant(context.Background(), "test"), dal.NewMemoryUnitOfWorkFactory(memory.NewStore())
+}
+
+func TestOrderServiceCreateAndGetOrder(t *testing.T) {
+	ctx, factory := newTestStore()
+	service := NewOrderService()
+
+	order := testOrder(7, 1000, 250)
+	uow := factory.Create()
+	err := uow.WithinTransaction(ctx, func(ctx context.Context) error {
+		return service.CreateOrder(ctx, uow, order)
+	})
+	if err != nil {
+		t.Fatalf("CreateOrder() error = %v", err)
+	}
+	if order.ID == 0 || order.Items[0].ID == 0 || order.Items[1].OrderID != order.ID {
+		t.Fatalf("created order has no IDs: %+v", order)
+	}
+
+	got, err := service.GetOrder(ctx, factory.Create(), order.ID)
+	if err != nil {
+		t.Fatalf("GetOrder() error = %v", err)
+	}
+	if got.CustomerID != 7 || got.TotalPriceCents != 1250 || len(got.Items) != 2 || got.Items[1].PriceCents != 250 {
+		t.Fatalf("GetOrder() = %+v", got)
+	}
+
+	_, err = service.GetOrder(ctx, factory.Create(), order.ID+100)
+	var notFound *NotFoundError
+	if !errors.As(err, &notFound) || notFound.Code() != "order_not_found" {
+		t.Fatalf("GetOrder() of a missing order error = %v, want NotFoundError", err)
+	}
+}
+
+func TestOrderServiceCreateOrderRollsBack(t *testing.T) {
+	ctx, factory := newTestStore()
+	service := NewOrderService()
+
+	// The order row is written before the invalid item is rejected
+	order := testOrder(7, 1000)
+	order.Items[0].ProductURL = "not a url"
+	uow := factory.Create()
+	err := uow.WithinTransaction(ctx, func(ctx context.Context) error {
+		return service.CreateOrder(ctx, uow, order)
+	})
+	var invalid *ValidationError
+	if !errors.As(err, &invalid) {
+		t.Fatalf("CreateOrder() error = %v, want ValidationError", err)
+	}
+
+	orders, err := service.QueryOrders(ctx, factory.Create(), &dto.V1QueryOrdersRequest{})
+	if err != nil {
+		t.Fatalf("QueryOrders() error = %v", err)
+	}
+	if len(orders) != 0 {
+		t.Fatalf("rolled back order is visible: %+v", orders[0])
+	}
+}
+
+func TestOrderServiceBatchCreateOrders(t *testing.T) {
+	ctx, factory := newTestStore()
+	service := NewOrderService()
+
+	uow := factory.Create()
+	var created []*core.Order
+	err := uow.WithinTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		created, err = service.BatchCreateOrders(ctx, uow, []*core.Order{testOrder(1, 100), testOrder(2, 200, 300), testOrder(3, 400)})
+		return err
+	})
+	if err != nil {
+		t.Fatalf("BatchCreateOrders() error = %v", err)
+	}
+	for i, order := range created {
+		if order.ID == 0 {
+			t.Fatalf("order %d has no ID", i)
+		}
+		for _, item := range order.Items {
+			if item.ID == 0 || item.OrderID != order.ID {
+				t.Fatalf("item of order %d = %+v", i, item)
+			}
+		}
+	}
+
+	got, err := service.GetOrder(ctx, factory.Create(), created[1].ID)
+	if err != nil {
+		t.Fatalf("GetOrder() error = %v", err)
+	}
+	if len(got.Items) != 2 || got.Items[0].ID != created[1].Items[0].ID {
+		t.Fatalf("GetOrder() items = %+v, want those of %+v", got.Items, created[1])
+	}
+
+	_, err = service.BatchCreateOrders(ctx, factory.Create(), []*core.Order{testOrder(4, 100), {CustomerID: 5, DeliveryAddress: "x", TotalPriceCents: 100, TotalPriceCurrency: "USD"}})
+	var mismatch *TotalMismatchError
+	if !errors.As(err, &mismatch) {
+		t.Fatalf("BatchCreateOrders() error = %v, want TotalMismatchError", err)
+	}
+}
+
+func TestOrderServiceBatchCreateOrdersPartial(t *testing.T) {
+	ctx, factory := newTestStore()
+	service := NewOrderService()
+
+	invalid := testOrder(2, 100)
+	invalid.TotalPriceCents = 50
+	uow := factory.Create()
+	var outcomes []BatchCreateOutcome
+	err := uow.WithinTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		outcomes, err = service.BatchCreateOrdersPartial(ctx, uow, []*core.Order{testOrder(1, 100), invalid, testOrder(3, 300)})
+		return err
+	})
+	if err != nil {
+		t.Fatalf("BatchCreateOrdersPartial() error = %v", err)
+	}
+	if outcomes[0].Err != nil || outcomes[2].Err != nil || outcomes[1].Err == nil {
+		t.Fatalf("outcomes = %+v, want only the second to fail", outcomes)
+	}
+
+	orders, err := service.QueryOrders(ctx, factory.Create(), &dto.V1QueryOrdersRequest{})
+	if err != nil {
+		t.Fatalf("QueryOrders() error = %v", err)
+	}
+	if len(orders) != 2 || orders[0].CustomerID != 1 || orders[1].CustomerID != 3 {
+		t.Fatalf("orders = %+v, want those of customers 1 and 3", orders)
+	}
+}
+
+// failingUnitOfWork makes bulk inserts of the orders of one customer fail with err
+type failingUnitOfWork struct {
+	dal.UnitOfWork
+	customerID int64
+	err        error
+}
+
+func (u *failingUnitOfWork) GetOrderRepo() interfaces.IOrderRepository {
+	return &failingOrderRepository{IOrderRepository: u.UnitOfWork.GetOrderRepo(), customerID: u.customerID, err: u.err}
+}
+
+type failingOrderRepository struct {
+	interfaces.IOrderRepository
+	customerID int64
+	err        error
+}
+
+func (r *failingOrderRepository) BulkInsertOrders(ctx context.Context, orders []models.BulkOrderDalModel) ([]models.V1OrderDal, error) {
+	for _, order := range orders {
+		if order.CustomerID == r.customerID {
+			return nil, r.err
+		}
+	}
+	return r.IOrderRepository.BulkInsertOrders(ctx, orders)
+}
+
+func TestOrderServiceBatchCreateOrdersPartialMixedOutcomes(t *testing.T) {
+	ctx, factory := newTestStore()
+	service := NewOrderService()
+
+	invalid := testOrder(2, 100)
+	invalid.Items[0].ProductURL = "not a url"
+	uow := &failingUnitOfWork{
+		UnitOfWork: factory.Create(),
+		customerID: 3,
+		err:        &pgconn.PgError{Code: "23505", Message: "duplicate key value violates unique constraint"},
+	}
+	var outcomes []BatchCreateOutcome
+	err := uow.WithinTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		outcomes, err = service.BatchCreateOrdersPartial(ctx, uow, []*core.Order{testOrder(1, 100), invalid, testOrder(3, 300), testOrder(4, 400)})
+		return err
+	})
+	if err != nil {
+		t.Fatalf("BatchCreateOrdersPartial() error = %v", err)
+	}
+
+	var validationErr *ValidationError
+	var conflict *ConflictError
+	if outcomes[0].Order == nil || outcomes[3].Order == nil || outcomes[3].Order.ID == 0 {
+		t.Fatalf("outcomes = %+v, want the first and last order created", outcomes)
+	}
+	if !errors.As(outcomes[1].Err, &validationErr) {
+		t.Fatalf("outcome of the invalid order = %v, want ValidationError", outcomes[1].Err)
+	}
+	if !errors.As(outcomes[2].Err, &conflict) || conflict.Code() != CodeConstraintViolated || strings.Contains(conflict.Error(), "unique") {
+		t.Fatalf("outcome of the conflicting order = %v, want a constraint violation without details", outcomes[2].Err)
+	}
+
+	orders, err := service.QueryOrders(ctx, factory.Create(), &dto.V1QueryOrdersRequest{})
+	if err != nil {
+		t.Fatalf("QueryOrders() error = %v", err)
+	}
+	if len(orders) != 2 || orders[0].CustomerID != 1 || orders[1].CustomerID != 4 {
+		t.Fatalf("orders = %+v, want those of customers 1 and 4", orders)
+	}
+}
+
+func TestOrderServiceBatchCreateOrdersPartialFailsOnRetryableError(t *testing.T) {
+	ctx, factory := newTestStore()
+	service := NewOrderService()
+
+	// A serialization failure is not the order's fault; the batch must fail as a whole
+	// so that the TxRunner runs it again
+	uow := &failingUnitOfWork{UnitOfWork: factory.Create(), customerID: 2, err: &pgconn.PgError{Code: "40001"}}
+	err := uow.WithinTransaction(ctx, func(ctx context.Context) error {
+		_, err := service.BatchCreateOrdersPartial(ctx, uow, []*core.Order{testOrder(1, 100), testOrder(2, 200)})
+		return err
+	})
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) || pgErr.Code != "40001" {
+		t.Fatalf("BatchCreateOrdersPartial() error = %v, want the serialization failure", err)
+	}
+
+	orders, err := service.QueryOrders(ctx, factory.Create(), &dto.V1QueryOrdersRequest{})
+	if err != nil {
+		t.Fatalf("QueryOrders() error = %v", err)
+	}
+	if len(orders) != 0 {
+		t.Fatalf("orders = %+v, want the whole batch rolled back", orders)
+	}
+}
+
+func TestOrderServiceQueryOrdersPagination(t *testing.T) {
+	ctx, factory := newTestStore()
+	service := NewOrderService()
+
+	orders := make([]*core.Order, 5)
+	for i := range orders {
+		orders[i] = testOrder(int64(i%2+1), 100)
+	}
+	if _, err := service.BatchCreateOrders(ctx, factory.Create(), orders); err != nil {
+		t.Fatalf("BatchCreateOrders() error = %v", err)
+	}
+
+	page, pageSize := 2, 2
+	got, err := service.QueryOrders(ctx, factory.Create(), &dto.V1QueryOrdersRequest{Page: &page, PageSize: &pageSize, IncludeOrderItems: true})
+	if err != nil {
+		t.Fatalf("QueryOrders() error = %v", err)
+	}
+	if len(got) != 2 || got[0].ID != orders[2].ID || got[1].ID != orders[3].ID {
+		t.Fatalf("page 2 = %+v, want orders %d and %d", got, orders[2].ID, orders[3].ID)
+	}
+	if len(got[0].Items) != 1 || got[0].Items[0].OrderID != got[0].ID {
+		t.Fatalf("items of order %d = %+v", got[0].ID, got[0].Items)
+	}
+
+	got, err = service.QueryOrders(ctx, factory.Create(), &dto.V1QueryOrdersRequest{CustomerIDs: []int64{2}})
+	if err != nil {
+		t.Fatalf("QueryOrders() error = %v", err)
+	}
+	if len(got) != 2 || len(got[0].Items) != 0 {
+		t.Fatalf("orders of customer 2 = %+v, want 2 without items", got)
+	}
+
+	// Orders of other tenants are never visible
+	got, err = service.QueryOrders(tenant.WithTenant(context.Background(), "other"), factory.Create(), &dto.V1QueryOrdersRequest{})
+	if err != nil {
+		t.Fatalf("QueryOrders() error = %v", err)
+	}
+	if len(got) != 0 {
+		t.Fatalf("orders of another tenant = %+v", got)
+	}
+}
@@ -0,0 +1,109 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	core "github.com/Lamafout/online-store-api/core/models/common"
+	"github.com/Lamafout/online-store-api/core/models/dto"
+	"github.com/Lamafout/online-store-api/internal/dal/models"
+	"github.com/Lamafout/online-store-api/internal/dal/unit_of_work"
+	"github.com/Lamafout/online-store-api/internal/validation"
+	"github.com/go-playground/validator/v10"
+)
+
+type RoleService struct {
+	validate *validator.Validate
+}
+
+func NewRoleService() *RoleService {
+	return &RoleService{
+		validate: validation.Validator(),
+	}
+}
+
+// AssignRole gives a role to a subject (a token subject or api-key:<id>) within the
+// current tenant. Assigning a role twice keeps the original assignment.
+func (s *RoleService) AssignRole(
+	ctx context.Context,
+	uow dal.UnitOfWork,
+	req *dto.V1AssignRoleRequest,
+	createdBy string,
+) (*core.RoleAssignment, error) {
+	if err := s.validate.Struct(req); err != nil {
+		return nil, &ValidationError{Err: err}
+	}
+
+	dalAssignment := &models.V1RoleAssignmentDal{
+		Subject:   req.Subject,
+		Role:      req.Role,
+		CreatedBy: createdBy,
+		CreatedAt: time.Now(),
+	}
+	if _, err := uow.GetRoleAssignmentRepo().AssignRole(ctx, dalAssignment); err != nil {
+		return nil, fmt.Errorf("failed to assign role: %w", err)
+	}
+
+	return roleAssignmentFromDal(dalAssignment), nil
+}
+
+// RevokeRole takes a role away from a subject, reporting whether the subject had it
+func (s *RoleService) RevokeRole(
+	ctx context.Context,
+	uow dal.UnitOfWork,
+	subject string,
+	role string,
+) (bool, error) {
+	revoked, err := uow.GetRoleAssignmentRepo().RevokeRole(ctx, subject, role)
+	if err != nil {
+		return false, fmt.Errorf("failed to revoke role: %w", err)
+	}
+	return revoked, nil
+}
+
+// ListRoleAssignments returns the role assignments of the current tenant, optionally
+// only those of one subject
+func (s *RoleService) ListRoleAssignments(
+	ctx context.Context,
+	uow dal.UnitOfWork,
+	subject string,
+) ([]*core.RoleAssignment, error) {
+	dalAssignments, err := uow.GetRoleAssignmentRepo().ListRoleAssignments(ctx, subject)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list role assignments: %w", err)
+	}
+
+	assignments := make([]*core.RoleAssignment, len(dalAssignments))
+	for i := range dalAssignments {
+		assignments[i] = roleAssignmentFromDal(&dalAssignments[i])
+	}
+	return assignments, nil
+}
+
+// RolesForSubject returns the names of the roles assigned to a subject in the current tenant
+func (s *RoleService) RolesForSubject(
+	ctx context.Context,
+	uow dal.UnitOfWork,
+	subject string,
+) ([]string, error) {
+	dalAssignments, err := uow.GetRoleAssignmentRepo().ListRoleAssignments(ctx, subject)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load role assignments: %w", err)
+	}
+
+	roles := make([]string, len(dalAssignments))
+	for i, assignment := range dalAssignments {
+		roles[i] = assignment.Role
+	}
+	return roles, nil
+}
+
+func roleAssignmentFromDal(dalAssignment *models.V1RoleAssignmentDal) *core.RoleAssignment {
+	return &core.RoleAssignment{
+		Subject:   dalAssignment.Subject,
+		Role:      dalAssignment.Role,
+		CreatedBy: dalAssignment.CreatedBy,
+		CreatedAt: dalAssignment.CreatedAt,
+	}
+}
@@ -0,0 +1,69 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	core "github.com/Lamafout/online-store-api/core/models/common"
+	"github.com/Lamafout/online-store-api/core/models/dto"
+	"github.com/Lamafout/online-store-api/internal/dal/models"
+	"github.com/Lamafout/online-store-api/internal/tenant"
+)
+
+func TestOrderServiceIsolatesTenants(t *testing.T) {
+	ctx, factory := newTestStore()
+	service := NewOrderService()
+
+	uow := factory.Create()
+	var orders []*core.Order
+	if err := uow.WithinTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		orders, err = service.BatchCreateOrders(ctx, uow, []*core.Order{testOrder(7, 100, 200), testOrder(8, 300)})
+		return err
+	}); err != nil {
+		t.Fatalf("BatchCreateOrders() error = %v", err)
+	}
+	ids := []int64{orders[0].ID, orders[1].ID}
+
+	other := tenant.WithTenant(context.Background(), "other")
+	for _, id := range ids {
+		var notFound *NotFoundError
+		if order, err := service.GetOrder(other, factory.Create(), id); !errors.As(err, &notFound) {
+			t.Fatalf("GetOrder(%d) from another tenant = %+v, %v; want NotFoundError", id, order, err)
+		}
+		items, err := factory.Create().GetOrderItemRepo().GetOrderItemsByOrderID(other, id)
+		if err != nil || len(items) != 0 {
+			t.Fatalf("GetOrderItemsByOrderID(%d) from another tenant = %+v, %v; want none", id, items, err)
+		}
+	}
+
+	queries := []*dto.V1QueryOrdersRequest{
+		{IncludeOrderItems: true},
+		{IDs: ids, IncludeOrderItems: true},
+		{CustomerIDs: []int64{7}, IncludeOrderItems: true},
+	}
+	for _, req := range queries {
+		found, err := service.QueryOrders(other, factory.Create(), req)
+		if err != nil || len(found) != 0 {
+			t.Fatalf("QueryOrders(%+v) from another tenant = %+v, %v; want none", req, found, err)
+		}
+		exported := 0
+		if err := service.ExportOrders(other, factory.Create(), req, 10, func(batch []*core.Order) error {
+			exported += len(batch)
+			return nil
+		}); err != nil || exported != 0 {
+			t.Fatalf("ExportOrders(%+v) from another tenant = %d orders, %v; want none", req, exported, err)
+		}
+	}
+	items, err := factory.Create().GetOrderItemRepo().QueryOrderItems(other, &models.QueryOrderItemsDalModel{OrderIDs: ids})
+	if err != nil || len(items) != 0 {
+		t.Fatalf("QueryOrderItems() from another tenant = %+v, %v; want none", items, err)
+	}
+
+	// The owner still sees everything
+	found, err := service.QueryOrders(ctx, factory.Create(), &dto.V1QueryOrdersRequest{IDs: ids, IncludeOrderItems: true})
+	if err != nil || len(found) != 2 || len(found[0].Items)+len(found[1].Items) != 3 {
+		t.Fatalf("QueryOrders() from the owner = %+v, %v; want both orders with their items", found, err)
+	}
+}
@@ -0,0 +1,186 @@
+package workers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	core "github.com/Lamafout/online-store-api/core/models/common"
+	"github.com/Lamafout/online-store-api/internal/bll/services"
+	"github.com/Lamafout/online-store-api/internal/dal/interfaces"
+	dal "github.com/Lamafout/online-store-api/internal/dal/unit_of_work"
+	"github.com/Lamafout/online-store-api/internal/logging"
+	"github.com/Lamafout/online-store-api/internal/tenant"
+)
+
+const (
+	// jobChunkSize is the number of orders created per transaction
+	jobChunkSize = 500
+	// jobLease is how long a claimed job stays reserved without progress before
+	// another worker may pick it up
+	jobLease = 5 * time.Minute
+	// jobMaxAttempts limits how many times a job is claimed after worker failures
+	jobMaxAttempts = 3
+)
+
+// JobWorkerPool processes queued jobs with a fixed number of workers polling Postgres
+type JobWorkerPool struct {
+	uowFactory   *dal.UnitOfWorkFactory
+	jobService   *services.JobService
+	workers      int
+	pollInterval time.Duration
+}
+
+// NewJobWorkerPool creates a new JobWorkerPool
+func NewJobWorkerPool(uowFactory *dal.UnitOfWorkFactory, jobService *services.JobService, workers int, pollInterval time.Duration) *JobWorkerPool {
+	return &JobWorkerPool{
+		uowFactory:   uowFactory,
+		jobService:   jobService,
+		workers:      workers,
+		pollInterval: pollInterval,
+	}
+}
+
+// Run starts the workers and blocks until ctx is cancelled and all of them have stopped.
+// A job interrupted by cancellation keeps its lease and is resumed after it expires.
+func (p *JobWorkerPool) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := 0; i < p.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.work(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+func (p *JobWorkerPool) work(ctx context.Context) {
+	ticker := time.NewTicker(p.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		// Drain the queue before going back to sleep
+		for {
+			processed, err := p.processNext(ctx)
+			if err != nil {
+				logging.FromContext(ctx).Error("Job worker error", append([]any{"error", err}, logging.ErrorAttrs(err)...)...)
+				break
+			}
+			if !processed {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// processNext claims and runs one job, reporting whether there was one
+func (p *JobWorkerPool) processNext(ctx context.Context) (bool, error) {
+	if ctx.Err() != nil {
+		return false, nil
+	}
+
+	uow := p.uowFactory.Create()
+	job, payload, err := p.jobService.ClaimNextJob(ctx, uow, jobLease, jobMaxAttempts)
+	if err != nil {
+		return false, fmt.Errorf("failed to claim job: %w", err)
+	}
+	if job == nil {
+		return false, nil
+	}
+
+	// Everything the job does from here on belongs to the tenant that enqueued it
+	ctx = tenant.WithTenant(ctx, job.TenantID)
+	ctx = logging.With(ctx, "job_id", job.ID, "job_type", job.Type, "tenant_id", job.TenantID)
+	logging.FromContext(ctx).Info("Job claimed", "attempt", job.Attempts)
+
+	var jobErr error
+	switch job.Type {
+	case core.JobTypeBatchCreateOrders:
+		jobErr = p.runBatchCreateOrders(ctx, job, payload)
+	default:
+		jobErr = fmt.Errorf("unknown job type %q", job.Type)
+	}
+
+	if ctx.Err() != nil {
+		return false, nil
+	}
+	if errors.Is(jobErr, interfaces.ErrJobLeaseLost) {
+		logging.FromContext(ctx).Warn("Job lease lost, leaving the job to its new worker", "error", jobErr)
+		return true, nil
+	}
+
+	err = p.jobService.FinishJob(ctx, p.uowFactory.Create(), job, jobErr)
+	if errors.Is(err, interfaces.ErrJobLeaseLost) {
+		logging.FromContext(ctx).Warn("Job lease lost before finishing, leaving the job to its new worker", "error", err)
+		return true, nil
+	}
+	if err != nil {
+		return true, fmt.Errorf("failed to finish job %d: %w", job.ID, err)
+	}
+	return true, nil
+}
+
+func (p *JobWorkerPool) runBatchCreateOrders(ctx context.Context, job *core.Job, payload []byte) error {
+	var orders []*core.Order
+	if err := json.Unmarshal(payload, &orders); err != nil {
+		return fmt.Errorf("invalid job payload: %w", err)
+	}
+
+	for job.ProcessedItems < len(orders) {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		chunk := orders[job.ProcessedItems:min(job.ProcessedItems+jobChunkSize, len(orders))]
+		snapshot := *job
+
+		chunkErr := p.inTransaction(ctx, func(uow dal.UnitOfWork) error {
+			return p.jobService.ProcessBatchCreateChunk(ctx, uow, job, chunk, jobLease)
+		})
+		if chunkErr == nil {
+			continue
+		}
+
+		*job = snapshot
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		// Another worker owns the job now; the rolled back chunk is its to write
+		if errors.Is(chunkErr, interfaces.ErrJobLeaseLost) {
+			return chunkErr
+		}
+		err := p.inTransaction(ctx, func(uow dal.UnitOfWork) error {
+			return p.jobService.FailBatchCreateChunk(ctx, uow, job, len(chunk), chunkErr, jobLease)
+		})
+		if err != nil {
+			*job = snapshot
+			return fmt.Errorf("failed to record chunk failure: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// inTransaction runs fn in a new transaction and commits it when fn succeeds
+func (p *JobWorkerPool) inTransaction(ctx context.Context, fn func(uow dal.UnitOfWork) error) error {
+	uow := p.uowFactory.Create()
+	if err := uow.Begin(ctx); err != nil {
+		return err
+	}
+	defer uow.Rollback()
+
+	if err := fn(uow); err != nil {
+		return err
+	}
+	return uow.Commit()
+}
@@ -0,0 +1,51 @@
+// Package cache stores serialized read results in front of the database.
+package cache
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Cache stores values under string keys for a limited time. The in-process LRU
+// implements it; a cache shared between instances, e.g. Redis, plugs in by
+// implementing it too. Callers treat errors as misses, so an unavailable cache only
+// costs performance.
+type Cache interface {
+	// Get returns the value stored under key, if it is there and not expired
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	// Set stores value under key for ttl
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// Delete removes keys, ignoring the ones that are not there
+	Delete(ctx context.Context, keys ...string) error
+}
+
+type bypassKey struct{}
+
+// WithBypass returns a copy of ctx whose reads skip the cache. Results read from the
+// database are still stored, refreshing the cache.
+func WithBypass(ctx context.Context) context.Context {
+	return context.WithValue(ctx, bypassKey{}, true)
+}
+
+// Bypassed reports whether reads of ctx skip the cache
+func Bypassed(ctx context.Context) bool {
+	bypass, _ := ctx.Value(bypassKey{}).(bool)
+	return bypass
+}
+
+// Middleware lets clients bypass the cache for a request with Cache-Control: no-cache
+// (or no-store), as browsers send on a hard reload
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, directive := range strings.Split(r.Header.Get("Cache-Control"), ",") {
+			directive = strings.ToLower(strings.TrimSpace(directive))
+			if directive == "no-cache" || directive == "no-store" {
+				r = r.WithContext(WithBypass(r.Context()))
+				break
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,95 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// LRU is an in-process Cache holding at most a fixed number of entries, evicting the
+// least recently used one to make room
+type LRU struct {
+	mu       sync.Mutex
+	capacity int
+	entries  map[string]*list.Element
+	// order lists the entries from most to least recently used
+	order *list.List
+	now   func() time.Time
+}
+
+type lruEntry struct {
+	key       string
+	value     []byte
+	expiresAt time.Time
+}
+
+// NewLRU creates an LRU holding up to capacity entries
+func NewLRU(capacity int) *LRU {
+	return &LRU{
+		capacity: max(capacity, 1),
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+		now:      time.Now,
+	}
+}
+
+// Get returns the value under key and marks it as recently used
+func (c *LRU) Get(_ context.Context, key string) ([]byte, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false, nil
+	}
+	entry := element.Value.(*lruEntry)
+	if !c.now().Before(entry.expiresAt) {
+		c.remove(element)
+		return nil, false, nil
+	}
+	c.order.MoveToFront(element)
+	return entry.value, true, nil
+}
+
+// Set stores value under key, evicting the least recently used entry when full
+func (c *LRU) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	expiresAt := c.now().Add(ttl)
+	if element, ok := c.entries[key]; ok {
+		entry := element.Value.(*lruEntry)
+		entry.value = value
+		entry.expiresAt = expiresAt
+		c.order.MoveToFront(element)
+		return nil
+	}
+	if c.order.Len() >= c.capacity {
+		c.remove(c.order.Back())
+	}
+	c.entries[key] = c.order.PushFront(&lruEntry{key: key, value: value, expiresAt: expiresAt})
+	return nil
+}
+
+// Delete removes keys
+func (c *LRU) Delete(_ context.Context, keys ...string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range keys {
+		if element, ok := c.entries[key]; ok {
+			c.remove(element)
+		}
+	}
+	return nil
+}
+
+// Len returns the number of entries, including expired ones not evicted yet
+func (c *LRU) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+func (c *LRU) remove(element *list.Element) {
+	c.order.Remove(element)
+	delete(c.entries, element.Value.(*lruEntry).key)
+}
@@ -0,0 +1,77 @@
+package cache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLRUEvictsLeastRecentlyUsed(t *testing.T) {
+	ctx := context.Background()
+	c := NewLRU(2)
+	_ = c.Set(ctx, "a", []byte("1"), time.Minute)
+	_ = c.Set(ctx, "b", []byte("2"), time.Minute)
+	// Reading a makes b the least recently used entry
+	if value, ok, _ := c.Get(ctx, "a"); !ok || string(value) != "1" {
+		t.Fatalf("Get(a) = %q, %v", value, ok)
+	}
+	_ = c.Set(ctx, "c", []byte("3"), time.Minute)
+
+	if _, ok, _ := c.Get(ctx, "b"); ok {
+		t.Fatal("b was not evicted")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok, _ := c.Get(ctx, key); !ok {
+			t.Fatalf("%s was evicted", key)
+		}
+	}
+
+	_ = c.Set(ctx, "a", []byte("4"), time.Minute)
+	_ = c.Delete(ctx, "c", "missing")
+	if value, _, _ := c.Get(ctx, "a"); string(value) != "4" || c.Len() != 1 {
+		t.Fatalf("Get(a) = %q with %d entries, want 4 with 1", value, c.Len())
+	}
+}
+
+func TestLRUExpiresEntries(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := NewLRU(10)
+	c.now = func() time.Time { return now }
+
+	_ = c.Set(ctx, "a", []byte("1"), time.Minute)
+	now = now.Add(59 * time.Second)
+	if _, ok, _ := c.Get(ctx, "a"); !ok {
+		t.Fatal("entry expired early")
+	}
+	now = now.Add(time.Second)
+	if _, ok, _ := c.Get(ctx, "a"); ok || c.Len() != 0 {
+		t.Fatal("expired entry was returned or kept")
+	}
+}
+
+func TestMiddlewareBypassesOnNoCache(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{"", false},
+		{"max-age=0", false},
+		{"no-cache", true},
+		{"max-age=0, No-Store", true},
+	}
+	for _, tt := range tests {
+		var bypassed bool
+		h := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			bypassed = Bypassed(r.Context())
+		}))
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Cache-Control", tt.header)
+		h.ServeHTTP(httptest.NewRecorder(), req)
+		if bypassed != tt.want {
+			t.Errorf("Cache-Control %q bypassed = %v, want %v", tt.header, bypassed, tt.want)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+// Package clientip finds the address of the client behind the proxies the server is
+// deployed behind, for the rate limits and logs keyed on it.
+package clientip
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// ForwardedForHeader lists the addresses a request was forwarded for, appended to by
+// every proxy on the way
+const ForwardedForHeader = "X-Forwarded-For"
+
+// Middleware replaces the RemoteAddr of requests relayed by a trusted proxy with the
+// address of the client the proxies forwarded them for. X-Forwarded-For is read from
+// the right, skipping trusted proxies, so the result is the first address no trusted
+// proxy vouches for: entries a client adds itself come before it and are never used.
+// Requests from other peers keep their RemoteAddr, and without trusted proxies the
+// middleware does nothing.
+func Middleware(trusted []netip.Prefix) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if len(trusted) == 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if ip, ok := forwardedFor(r, trusted); ok {
+				r2 := r.Clone(r.Context())
+				r2.RemoteAddr = net.JoinHostPort(ip.String(), "0")
+				r = r2
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// forwardedFor returns the client r was relayed for, if it came from a trusted proxy
+func forwardedFor(r *http.Request, trusted []netip.Prefix) (netip.Addr, bool) {
+	peer, ok := parseAddr(r.RemoteAddr)
+	if !ok || !isTrusted(peer, trusted) {
+		return netip.Addr{}, false
+	}
+
+	var hops []string
+	for _, value := range r.Header.Values(ForwardedForHeader) {
+		hops = append(hops, strings.Split(value, ",")...)
+	}
+	client := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			// Anything left of a malformed entry can't be told apart from what the
+			// client sent, so the last address known to be real is used
+			break
+		}
+		client = ip.Unmap()
+		if !isTrusted(client, trusted) {
+			break
+		}
+	}
+	return client, client != peer
+}
+
+// parseAddr parses the IP of a host:port address, or of a bare IP
+func parseAddr(addr string) (netip.Addr, bool) {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	ip, err := netip.ParseAddr(addr)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return ip.Unmap(), true
+}
+
+func isTrusted(ip netip.Addr, trusted []netip.Prefix) bool {
+	for _, prefix := range trusted {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
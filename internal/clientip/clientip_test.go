@@ -0,0 +1,59 @@
+package clientip
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestMiddleware(t *testing.T) {
+	trusted := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("2001:db8::/32")}
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor []string
+		want         string
+	}{
+		{name: "direct client", remoteAddr: "192.0.2.1:5000", want: "192.0.2.1:5000"},
+		{name: "untrusted peer cannot spoof", remoteAddr: "192.0.2.1:5000", forwardedFor: []string{"198.51.100.9"}, want: "192.0.2.1:5000"},
+		{name: "trusted proxy", remoteAddr: "10.0.0.2:5000", forwardedFor: []string{"192.0.2.1"}, want: "192.0.2.1:0"},
+		{name: "chain of trusted proxies", remoteAddr: "10.0.0.2:5000", forwardedFor: []string{"192.0.2.1, 10.0.0.3"}, want: "192.0.2.1:0"},
+		{name: "entries added by the client are ignored", remoteAddr: "10.0.0.2:5000", forwardedFor: []string{"198.51.100.9, 192.0.2.1", "10.0.0.3"}, want: "192.0.2.1:0"},
+		{name: "malformed entry stops the walk", remoteAddr: "10.0.0.2:5000", forwardedFor: []string{"192.0.2.1, unknown, 10.0.0.3"}, want: "10.0.0.3:0"},
+		{name: "trusted proxy without header", remoteAddr: "10.0.0.2:5000", want: "10.0.0.2:5000"},
+		{name: "IPv6", remoteAddr: "[2001:db8::1]:5000", forwardedFor: []string{"2001:db9::7"}, want: "[2001:db9::7]:0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			handler := Middleware(trusted)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r.RemoteAddr
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for _, value := range tt.forwardedFor {
+				req.Header.Add(ForwardedForHeader, value)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+			if got != tt.want {
+				t.Fatalf("RemoteAddr = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMiddlewareWithoutTrustedProxies(t *testing.T) {
+	var got string
+	handler := Middleware(nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.RemoteAddr
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "10.0.0.2:5000"
+	req.Header.Set(ForwardedForHeader, "192.0.2.1")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if got != "10.0.0.2:5000" {
+		t.Fatalf("RemoteAddr = %q, want the peer", got)
+	}
+}
@@ -1,54 +1,362 @@
 package config
 
 import (
+	"errors"
 	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"net/url"
 	"os"
-	"log"
+	"strconv"
+	"strings"
+	"time"
+
 	"github.com/joho/godotenv"
+	"github.com/spf13/viper"
 )
 
+// FileEnv names the variable pointing at the config file when no --config flag is given
+const FileEnv = "CONFIG_FILE"
+
 type DbSettings struct {
-	ConnectionString           string
+	// Storage is postgres, or memory to keep orders in process memory without a
+	// database, for local demos
+	Storage                   string
+	ConnectionString          string
 	MigrationConnectionString string
+	// ReplicaConnectionStrings are read replicas of the primary serving reads outside
+	// of write transactions; empty reads from the primary only
+	ReplicaConnectionStrings []string
+	// ReplicaCheckInterval is how often replicas are checked for health and lag
+	ReplicaCheckInterval time.Duration
+	// ReadYourWritesTTL is how long a client that wrote keeps reading from replicas that
+	// have replayed its writes, through the read_your_writes cookie
+	ReadYourWritesTTL time.Duration
+	RowLevelSecurity  bool
+	// AutoMigrate applies pending migrations on startup, under an advisory lock so
+	// that instances starting together don't race
+	AutoMigrate bool
+	// IsolationLevel of the transactions of mutating requests: read_committed,
+	// repeatable_read or serializable
+	IsolationLevel string
+	// RetryMaxAttempts bounds how often a transaction is run when it keeps failing
+	// with a serialization failure or deadlock
+	RetryMaxAttempts int
+	RetryBaseDelay   time.Duration
+	RetryMaxDelay    time.Duration
+	Pool             PoolSettings
+}
+
+// PoolSettings size the connection pools of the primary and the replicas
+type PoolSettings struct {
+	MaxOpenConns int
+	MaxIdleConns int
+	// ConnMaxLifetime closes connections after a while, so that they are spread over
+	// new servers behind a load balancer or failover address; 0 keeps them forever
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+}
+
+type ServerSettings struct {
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	IdleTimeout  time.Duration
+	// ShutdownDelay is how long the server keeps serving after readiness starts failing,
+	// so that load balancers notice before the listener closes
+	ShutdownDelay time.Duration
+	// ShutdownTimeout bounds how long in-flight requests may take to finish
+	ShutdownTimeout time.Duration
+	// TrustedProxies are the addresses of the proxies in front of the server, whose
+	// X-Forwarded-For is believed when finding the client of a request
+	TrustedProxies []netip.Prefix
+}
+
+type JobSettings struct {
+	Workers      int
+	PollInterval time.Duration
+}
+
+type AuthSettings struct {
+	Enabled            bool
+	HS256Secret        string
+	RS256PublicKeyFile string
+	JWKSFile           string
+	Issuer             string
+	Audience           string
+}
+
+type TenantSettings struct {
+	Header  string
+	Hosts   map[string]string
+	Default string
+}
+
+type RateLimitSettings struct {
+	Enabled bool
+	// Store is memory or postgres
+	Store string
+	// PreAuth is the rate:burst limit in requests per client IP applied before the
+	// caller is authenticated
+	PreAuth string
+	// Default is the rate:burst limit in requests applied to every route without its own
+	Default string
+	// BatchOrders is the rate:burst limit in orders for batch creation
+	BatchOrders string
+	// Routes lists per-route limits as "METHOD /path/pattern=rate:burst", comma-separated
+	Routes string
+}
+
+type LogSettings struct {
+	// Level is debug, info, warn or error
+	Level string
+	// Format is json or text
+	Format string
+}
+
+type MetricsSettings struct {
+	Enabled bool
+}
+
+// CacheSettings configure the in-process cache of order reads. Each instance has its
+// own cache, so with several instances a write is only seen by the others once their
+// entries expire.
+type CacheSettings struct {
+	Enabled bool
+	// Size is the maximum number of entries
+	Size int
+	TTL  time.Duration
+}
+
+type TracingSettings struct {
+	// Exporter is otlp, to send spans to a collector, or stdout
+	Exporter     string
+	OTLPEndpoint string
+	SampleRatio  float64
+}
+
+// FeatureSettings switch optional endpoints and modes on and off
+type FeatureSettings struct {
+	OrderImport  bool
+	OrderExport  bool
+	AsyncBatches bool
 }
 
 type Config struct {
-	DbSettings DbSettings
-	ServerPort string
+	DbSettings        DbSettings
+	JobSettings       JobSettings
+	AuthSettings      AuthSettings
+	TenantSettings    TenantSettings
+	RateLimitSettings RateLimitSettings
+	LogSettings       LogSettings
+	MetricsSettings   MetricsSettings
+	CacheSettings     CacheSettings
+	TracingSettings   TracingSettings
+	FeatureSettings   FeatureSettings
+	ServerSettings    ServerSettings
+	ServerPort        string
+
+	// settings are the values the Config was built from, by key, for printing
+	settings map[string]any
 }
 
+// LoadConfig loads the configuration from the file named by CONFIG_FILE, if any
 func LoadConfig() (*Config, error) {
+	return Load(os.Getenv(FileEnv))
+}
+
+// Load reads the configuration from file, a YAML or TOML file chosen by extension, or
+// from defaults only when file is empty. Environment variables, also read from a .env
+// file, override the file. Every invalid setting is reported in the returned error.
+func Load(file string) (*Config, error) {
 	if err := godotenv.Load(); err != nil {
-		log.Printf("No .env file found: %v", err)
+		slog.Info("No .env file found", "error", err)
 	} else {
-		log.Println("Successfully loaded .env file")
+		slog.Info("Successfully loaded .env file")
 	}
 
-	user := getEnv("DB_USER", "")
-	password := getEnv("DB_PASSWORD", "")
-	dbName := getEnv("DB_NAME", "postgres")
-	port := getEnv("DB_PORT", "5432")
-	host := getEnv("DB_HOST", "localhost")
-	serverPort := getEnv("SERVER_PORT", "8080")
+	v := viper.New()
+	for _, s := range settings {
+		v.SetDefault(s.key, s.defaultValue)
+		if err := v.BindEnv(s.key, s.env); err != nil {
+			return nil, err
+		}
+	}
+	if file != "" {
+		v.SetConfigFile(file)
+		if err := v.ReadInConfig(); err != nil {
+			return nil, fmt.Errorf("failed to read config file: %w", err)
+		}
+	}
+	return parse(v)
+}
 
-	if user == "" || password == "" || dbName == "" || port == "" || host == "" || serverPort == "" {
-		return nil, fmt.Errorf("missing required environment variables")
+// parse builds and validates a Config from the settings in v
+func parse(v *viper.Viper) (*Config, error) {
+	l := &loader{v: v}
+	for _, key := range v.AllKeys() {
+		if _, ok := lookupSetting(key); !ok {
+			l.errs = append(l.errs, fmt.Errorf("unknown setting %s", key))
+		}
 	}
 
-	connString := fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=disable", user, password, host, port, dbName)
-	migrationConnString := connString
-	return &Config{
-		DbSettings: DbSettings{
-			ConnectionString:           connString,
-			MigrationConnectionString: migrationConnString,
-		},
-		ServerPort: serverPort,
-	}, nil
+	cfg := &Config{settings: v.AllSettings()}
+	cfg.ServerPort = l.string("server.port")
+	port, err := strconv.Atoi(cfg.ServerPort)
+	l.check(err == nil && port > 0 && port < 65536, "server.port", "expected a TCP port")
+	cfg.ServerSettings = ServerSettings{
+		ReadTimeout:     l.duration("server.read_timeout"),
+		WriteTimeout:    l.duration("server.write_timeout"),
+		IdleTimeout:     l.duration("server.idle_timeout"),
+		ShutdownDelay:   l.duration("server.shutdown_delay"),
+		ShutdownTimeout: l.duration("server.shutdown_timeout"),
+		TrustedProxies:  l.prefixList("server.trusted_proxies"),
+	}
+
+	cfg.DbSettings = l.dbSettings()
+
+	cfg.JobSettings = JobSettings{
+		Workers:      l.int("jobs.workers"),
+		PollInterval: l.duration("jobs.poll_interval"),
+	}
+	l.check(cfg.JobSettings.Workers >= 0, "jobs.workers", "must not be negative")
+	l.check(cfg.JobSettings.PollInterval > 0, "jobs.poll_interval", "must be positive")
+
+	cfg.AuthSettings = AuthSettings{
+		Enabled:            l.bool("auth.enabled"),
+		HS256Secret:        l.string("auth.hs256_secret"),
+		RS256PublicKeyFile: l.string("auth.rs256_public_key_file"),
+		JWKSFile:           l.string("auth.jwks_file"),
+		Issuer:             l.string("auth.issuer"),
+		Audience:           l.string("auth.audience"),
+	}
+
+	hosts := make(map[string]string)
+	for host, tenantID := range l.stringMap("tenant.hosts") {
+		hosts[strings.ToLower(host)] = tenantID
+	}
+	cfg.TenantSettings = TenantSettings{
+		Header:  l.string("tenant.header"),
+		Hosts:   hosts,
+		Default: l.string("tenant.default"),
+	}
+
+	cfg.RateLimitSettings = RateLimitSettings{
+		Enabled:     l.bool("rate_limit.enabled"),
+		Store:       l.oneOf("rate_limit.store", "memory", "postgres"),
+		PreAuth:     l.string("rate_limit.pre_auth"),
+		Default:     l.string("rate_limit.default"),
+		BatchOrders: l.string("rate_limit.batch_orders"),
+		Routes:      l.string("rate_limit.routes"),
+	}
+	l.check(cfg.RateLimitSettings.Store != "postgres" || cfg.DbSettings.Storage != "memory",
+		"rate_limit.store", "postgres requires db.storage postgres")
+
+	cfg.LogSettings = LogSettings{
+		Level:  l.oneOf("log.level", "debug", "info", "warn", "error"),
+		Format: l.oneOf("log.format", "json", "text"),
+	}
+	cfg.MetricsSettings = MetricsSettings{Enabled: l.bool("metrics.enabled")}
+
+	cfg.CacheSettings = CacheSettings{
+		Enabled: l.bool("cache.enabled"),
+		Size:    l.int("cache.size"),
+		TTL:     l.duration("cache.ttl"),
+	}
+	l.check(cfg.CacheSettings.Size > 0, "cache.size", "must be positive")
+	l.check(cfg.CacheSettings.TTL > 0, "cache.ttl", "must be positive")
+
+	cfg.TracingSettings = TracingSettings{
+		Exporter:     l.oneOf("tracing.exporter", "otlp", "stdout"),
+		OTLPEndpoint: l.string("tracing.otlp_endpoint"),
+		SampleRatio:  l.float("tracing.sample_ratio"),
+	}
+	l.check(cfg.TracingSettings.SampleRatio >= 0 && cfg.TracingSettings.SampleRatio <= 1,
+		"tracing.sample_ratio", "must be between 0 and 1")
+
+	cfg.FeatureSettings = FeatureSettings{
+		OrderImport:  l.bool("features.order_import"),
+		OrderExport:  l.bool("features.order_export"),
+		AsyncBatches: l.bool("features.async_batches"),
+	}
+
+	if len(l.errs) > 0 {
+		return nil, errors.Join(l.errs...)
+	}
+	return cfg, nil
 }
 
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+func (l *loader) dbSettings() DbSettings {
+	db := DbSettings{
+		Storage:              l.oneOf("db.storage", "postgres", "memory"),
+		ReplicaCheckInterval: l.duration("db.replica_check_interval"),
+		ReadYourWritesTTL:    l.duration("db.read_your_writes_ttl"),
+		RowLevelSecurity:     l.bool("db.row_level_security"),
+		AutoMigrate:          l.bool("db.auto_migrate"),
+		IsolationLevel:       l.string("db.isolation_level"),
+		RetryMaxAttempts:     l.int("db.retry.max_attempts"),
+		RetryBaseDelay:       l.duration("db.retry.base_delay"),
+		RetryMaxDelay:        l.duration("db.retry.max_delay"),
+		Pool: PoolSettings{
+			MaxOpenConns:    l.int("db.pool.max_open_conns"),
+			MaxIdleConns:    l.int("db.pool.max_idle_conns"),
+			ConnMaxLifetime: l.duration("db.pool.conn_max_lifetime"),
+			ConnMaxIdleTime: l.duration("db.pool.conn_max_idle_time"),
+		},
+	}
+	l.check(db.ReplicaCheckInterval > 0, "db.replica_check_interval", "must be positive")
+	l.check(db.ReadYourWritesTTL >= time.Second, "db.read_your_writes_ttl", "must be at least 1s")
+	l.check(db.RetryMaxAttempts >= 1, "db.retry.max_attempts", "must be at least 1")
+	l.check(db.Pool.MaxOpenConns >= 1, "db.pool.max_open_conns", "must be at least 1")
+	l.check(db.Pool.MaxIdleConns >= 0 && db.Pool.MaxIdleConns <= db.Pool.MaxOpenConns,
+		"db.pool.max_idle_conns", "must be between 0 and db.pool.max_open_conns")
+
+	for _, replica := range l.stringList("db.replica_urls") {
+		if _, err := url.Parse(replica); err != nil {
+			l.fail("db.replica_urls", "%s is not a URL", redactURL(replica))
+			continue
+		}
+		db.ReplicaConnectionStrings = append(db.ReplicaConnectionStrings, replica)
+	}
+
+	statementTimeout := l.duration("db.statement_timeout")
+	tlsMode := l.oneOf("db.tls.mode", "disable", "allow", "prefer", "require", "verify-ca", "verify-full")
+	rootCert, cert, key := l.string("db.tls.root_cert"), l.string("db.tls.cert"), l.string("db.tls.key")
+	l.check(rootCert != "" || (tlsMode != "verify-ca" && tlsMode != "verify-full"),
+		"db.tls.root_cert", "is required by tls mode %s", tlsMode)
+	l.check((cert == "") == (key == ""), "db.tls.key", "db.tls.cert and db.tls.key go together")
+
+	user, password := l.string("db.user"), l.string("db.password")
+	host, port, name := l.string("db.host"), l.string("db.port"), l.string("db.name")
+	if db.Storage != "postgres" {
+		return db
+	}
+	l.check(user != "", "db.user", "is required with db.storage postgres")
+	l.check(password != "", "db.password", "is required with db.storage postgres")
+	l.check(host != "", "db.host", "is required with db.storage postgres")
+	l.check(port != "", "db.port", "is required with db.storage postgres")
+	l.check(name != "", "db.name", "is required with db.storage postgres")
+
+	query := url.Values{"sslmode": {tlsMode}}
+	for param, value := range map[string]string{"sslrootcert": rootCert, "sslcert": cert, "sslkey": key} {
+		if value != "" {
+			query.Set(param, value)
+		}
+	}
+	dsn := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(user, password),
+		Host:     net.JoinHostPort(host, port),
+		Path:     "/" + name,
+		RawQuery: query.Encode(),
+	}
+	db.MigrationConnectionString = dsn.String()
+	// Migrations may run longer than any request should, so only the API's own
+	// connections get the statement timeout
+	if statementTimeout > 0 {
+		query.Set("statement_timeout", strconv.FormatInt(statementTimeout.Milliseconds(), 10))
+		dsn.RawQuery = query.Encode()
 	}
-	return defaultValue
+	db.ConnectionString = dsn.String()
+	return db
 }
@@ -0,0 +1,91 @@
+package config
+
+import (
+	"io"
+	"maps"
+	"net/url"
+	"strings"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// redacted replaces secrets in printed configurations
+const redacted = "[REDACTED]"
+
+// WriteRedacted writes the settings the Config was loaded from as YAML, in the layout
+// of a config file. Secrets are replaced, and so are passwords inside URLs.
+func (c *Config) WriteRedacted(w io.Writer) error {
+	settings := redact("", c.settings)
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(settings); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+// redact returns a copy of settings, found under prefix, with secrets replaced
+func redact(prefix string, settings map[string]any) map[string]any {
+	out := maps.Clone(settings)
+	for key, value := range out {
+		path := key
+		if prefix != "" {
+			path = prefix + "." + key
+		}
+		if nested, ok := value.(map[string]any); ok {
+			out[key] = redact(path, nested)
+			continue
+		}
+		s, ok := lookupSetting(path)
+		if !ok || !s.secret {
+			continue
+		}
+		switch value := value.(type) {
+		case string:
+			if strings.Contains(value, "://") {
+				// Lists from the environment stay a comma-separated string
+				urls := strings.Split(value, ",")
+				for i, u := range urls {
+					urls[i] = redactURL(strings.TrimSpace(u))
+				}
+				out[key] = strings.Join(urls, ",")
+			} else if value != "" {
+				out[key] = redacted
+			}
+		case []any:
+			urls := make([]any, len(value))
+			for i, u := range value {
+				urls[i] = redactURL(strings.TrimSpace(toString(u)))
+			}
+			out[key] = urls
+		case []string:
+			urls := make([]string, len(value))
+			for i, u := range value {
+				urls[i] = redactURL(u)
+			}
+			out[key] = urls
+		default:
+			out[key] = redacted
+		}
+	}
+	return out
+}
+
+// redactURL hides the password of a connection URL, or all of it if it can't be parsed
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return redacted
+	}
+	query := u.Query()
+	if query.Has("password") {
+		query.Set("password", redacted)
+		u.RawQuery = query.Encode()
+	}
+	return u.Redacted()
+}
+
+func toString(value any) string {
+	s, _ := value.(string)
+	return s
+}
@@ -0,0 +1,266 @@
+package config
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+)
+
+// setting is a configuration key as written in config files, with the environment
+// variable overriding it and its default
+type setting struct {
+	key          string
+	env          string
+	defaultValue any
+	// secret settings are redacted when the configuration is printed
+	secret bool
+	// reloadable settings are applied to the running server when the config file
+	// changes; the others take effect on restart
+	reloadable bool
+}
+
+// settings lists every key a config file may contain
+var settings = []setting{
+	{key: "server.port", env: "SERVER_PORT", defaultValue: "8080"},
+	{key: "server.read_timeout", env: "SERVER_READ_TIMEOUT", defaultValue: "15s"},
+	{key: "server.write_timeout", env: "SERVER_WRITE_TIMEOUT", defaultValue: "60s"},
+	{key: "server.idle_timeout", env: "SERVER_IDLE_TIMEOUT", defaultValue: "120s"},
+	{key: "server.shutdown_delay", env: "SERVER_SHUTDOWN_DELAY", defaultValue: "5s"},
+	{key: "server.shutdown_timeout", env: "SERVER_SHUTDOWN_TIMEOUT", defaultValue: "30s"},
+	{key: "server.trusted_proxies", env: "TRUSTED_PROXIES", defaultValue: []string{}},
+
+	{key: "db.storage", env: "DB_STORAGE", defaultValue: "postgres"},
+	{key: "db.host", env: "DB_HOST", defaultValue: "localhost"},
+	{key: "db.port", env: "DB_PORT", defaultValue: "5432"},
+	{key: "db.user", env: "DB_USER", defaultValue: ""},
+	{key: "db.password", env: "DB_PASSWORD", defaultValue: "", secret: true},
+	{key: "db.name", env: "DB_NAME", defaultValue: "postgres"},
+	{key: "db.tls.mode", env: "DB_SSLMODE", defaultValue: "prefer"},
+	{key: "db.tls.root_cert", env: "DB_SSLROOTCERT", defaultValue: ""},
+	{key: "db.tls.cert", env: "DB_SSLCERT", defaultValue: ""},
+	{key: "db.tls.key", env: "DB_SSLKEY", defaultValue: ""},
+	{key: "db.pool.max_open_conns", env: "DB_MAX_OPEN_CONNS", defaultValue: 25},
+	{key: "db.pool.max_idle_conns", env: "DB_MAX_IDLE_CONNS", defaultValue: 10},
+	{key: "db.pool.conn_max_lifetime", env: "DB_CONN_MAX_LIFETIME", defaultValue: "30m"},
+	{key: "db.pool.conn_max_idle_time", env: "DB_CONN_MAX_IDLE_TIME", defaultValue: "5m"},
+	{key: "db.statement_timeout", env: "DB_STATEMENT_TIMEOUT", defaultValue: "0s"},
+	{key: "db.replica_urls", env: "DB_REPLICA_URLS", defaultValue: []string{}, secret: true},
+	{key: "db.replica_check_interval", env: "DB_REPLICA_CHECK_INTERVAL", defaultValue: "5s"},
+	{key: "db.read_your_writes_ttl", env: "READ_YOUR_WRITES_TTL", defaultValue: "5m"},
+	{key: "db.row_level_security", env: "DB_ROW_LEVEL_SECURITY", defaultValue: false},
+	{key: "db.auto_migrate", env: "DB_AUTO_MIGRATE", defaultValue: false},
+	{key: "db.isolation_level", env: "DB_ISOLATION_LEVEL", defaultValue: "read_committed"},
+	{key: "db.retry.max_attempts", env: "DB_RETRY_MAX_ATTEMPTS", defaultValue: 3},
+	{key: "db.retry.base_delay", env: "DB_RETRY_BASE_DELAY", defaultValue: "20ms"},
+	{key: "db.retry.max_delay", env: "DB_RETRY_MAX_DELAY", defaultValue: "1s"},
+
+	{key: "jobs.workers", env: "JOB_WORKERS", defaultValue: 2},
+	{key: "jobs.poll_interval", env: "JOB_POLL_INTERVAL", defaultValue: "1s"},
+
+	// A tenant's first API key is issued with `migrator bootstrap-api-key`
+	{key: "auth.enabled", env: "AUTH_ENABLED", defaultValue: true},
+	{key: "auth.hs256_secret", env: "JWT_HS256_SECRET", defaultValue: "", secret: true},
+	{key: "auth.rs256_public_key_file", env: "JWT_RS256_PUBLIC_KEY_FILE", defaultValue: ""},
+	{key: "auth.jwks_file", env: "JWT_JWKS_FILE", defaultValue: ""},
+	{key: "auth.issuer", env: "JWT_ISSUER", defaultValue: ""},
+	{key: "auth.audience", env: "JWT_AUDIENCE", defaultValue: ""},
+
+	{key: "tenant.header", env: "TENANT_HEADER", defaultValue: "X-Tenant-ID"},
+	{key: "tenant.hosts", env: "TENANT_HOSTS", defaultValue: map[string]string{}},
+	{key: "tenant.default", env: "TENANT_DEFAULT", defaultValue: "default"},
+
+	{key: "rate_limit.enabled", env: "RATE_LIMIT_ENABLED", defaultValue: true},
+	{key: "rate_limit.store", env: "RATE_LIMIT_STORE", defaultValue: "memory"},
+	{key: "rate_limit.pre_auth", env: "RATE_LIMIT_PRE_AUTH", defaultValue: "50:100", reloadable: true},
+	{key: "rate_limit.default", env: "RATE_LIMIT_DEFAULT", defaultValue: "20:40", reloadable: true},
+	{key: "rate_limit.batch_orders", env: "RATE_LIMIT_BATCH_ORDERS", defaultValue: "1000:20000", reloadable: true},
+	{key: "rate_limit.routes", env: "RATE_LIMIT_ROUTES", defaultValue: "", reloadable: true},
+
+	{key: "log.level", env: "LOG_LEVEL", defaultValue: "info", reloadable: true},
+	{key: "log.format", env: "LOG_FORMAT", defaultValue: "json"},
+
+	{key: "metrics.enabled", env: "METRICS_ENABLED", defaultValue: true},
+
+	{key: "cache.enabled", env: "CACHE_ENABLED", defaultValue: false},
+	{key: "cache.size", env: "CACHE_SIZE", defaultValue: 10000},
+	{key: "cache.ttl", env: "CACHE_TTL", defaultValue: "30s"},
+
+	{key: "tracing.exporter", env: "TRACING_EXPORTER", defaultValue: "stdout"},
+	{key: "tracing.otlp_endpoint", env: "TRACING_OTLP_ENDPOINT", defaultValue: "http://localhost:4318"},
+	{key: "tracing.sample_ratio", env: "TRACING_SAMPLE_RATIO", defaultValue: 1.0},
+
+	{key: "features.order_import", env: "FEATURE_ORDER_IMPORT", defaultValue: true, reloadable: true},
+	{key: "features.order_export", env: "FEATURE_ORDER_EXPORT", defaultValue: true, reloadable: true},
+	{key: "features.async_batches", env: "FEATURE_ASYNC_BATCHES", defaultValue: true, reloadable: true},
+}
+
+// lookupSetting finds the setting key belongs to. Keys below a map setting such as
+// tenant.hosts belong to it.
+func lookupSetting(key string) (setting, bool) {
+	for _, s := range settings {
+		if key == s.key || strings.HasPrefix(key, s.key+".") {
+			return s, true
+		}
+	}
+	return setting{}, false
+}
+
+// loader reads typed settings from v, collecting every invalid one so they are all
+// reported at once
+type loader struct {
+	v    *viper.Viper
+	errs []error
+}
+
+func (l *loader) fail(key, format string, args ...any) {
+	name := key
+	if s, ok := lookupSetting(key); ok {
+		name = fmt.Sprintf("%s (%s)", key, s.env)
+	}
+	l.errs = append(l.errs, fmt.Errorf("invalid %s: %s", name, fmt.Sprintf(format, args...)))
+}
+
+// check reports key as invalid unless ok
+func (l *loader) check(ok bool, key, format string, args ...any) {
+	if !ok {
+		l.fail(key, format, args...)
+	}
+}
+
+func (l *loader) string(key string) string {
+	value, err := cast.ToStringE(l.v.Get(key))
+	if err != nil {
+		l.fail(key, "expected a string")
+	}
+	return strings.TrimSpace(value)
+}
+
+// oneOf reads a string setting that must be one of allowed
+func (l *loader) oneOf(key string, allowed ...string) string {
+	value := l.string(key)
+	for _, a := range allowed {
+		if value == a {
+			return value
+		}
+	}
+	l.fail(key, "%q is not one of %s", value, strings.Join(allowed, ", "))
+	return value
+}
+
+func (l *loader) bool(key string) bool {
+	value, err := cast.ToBoolE(l.v.Get(key))
+	if err != nil {
+		l.fail(key, "expected true or false")
+	}
+	return value
+}
+
+func (l *loader) int(key string) int {
+	value, err := cast.ToIntE(l.v.Get(key))
+	if err != nil {
+		l.fail(key, "expected an integer")
+	}
+	return value
+}
+
+func (l *loader) float(key string) float64 {
+	value, err := cast.ToFloat64E(l.v.Get(key))
+	if err != nil {
+		l.fail(key, "expected a number")
+	}
+	return value
+}
+
+// duration reads a duration such as 1m30s. Plain numbers are rejected rather than
+// taken as nanoseconds.
+func (l *loader) duration(key string) time.Duration {
+	raw := l.v.Get(key)
+	if d, ok := raw.(time.Duration); ok {
+		return d
+	}
+	value, err := time.ParseDuration(strings.TrimSpace(cast.ToString(raw)))
+	if err != nil || value < 0 {
+		l.fail(key, "expected a non-negative duration such as 30s, got %v", raw)
+	}
+	return value
+}
+
+// stringList reads a list, given as a sequence in files or comma-separated in the
+// environment
+func (l *loader) stringList(key string) []string {
+	raw := l.v.Get(key)
+	var values []string
+	if s, ok := raw.(string); ok {
+		values = strings.Split(s, ",")
+	} else {
+		var err error
+		if values, err = cast.ToStringSliceE(raw); err != nil {
+			l.fail(key, "expected a list")
+			return nil
+		}
+	}
+	var list []string
+	for _, value := range values {
+		if value = strings.TrimSpace(value); value != "" {
+			list = append(list, value)
+		}
+	}
+	return list
+}
+
+// prefixList reads a list of networks in CIDR notation or single IP addresses
+func (l *loader) prefixList(key string) []netip.Prefix {
+	var prefixes []netip.Prefix
+	for _, value := range l.stringList(key) {
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			ip, ipErr := netip.ParseAddr(value)
+			if ipErr != nil {
+				l.fail(key, "%s is not an IP address or network", value)
+				continue
+			}
+			prefix = netip.PrefixFrom(ip, ip.BitLen())
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes
+}
+
+// stringMap reads a map, given as a mapping in files or as comma-separated key=value
+// pairs in the environment
+func (l *loader) stringMap(key string) map[string]string {
+	raw := l.v.Get(key)
+	if s, ok := raw.(string); ok {
+		values, err := parsePairs(s)
+		if err != nil {
+			l.fail(key, "%v", err)
+		}
+		return values
+	}
+	values, err := cast.ToStringMapStringE(raw)
+	if err != nil {
+		l.fail(key, "expected a mapping")
+	}
+	return values
+}
+
+// parsePairs parses a comma-separated list of key=value pairs
+func parsePairs(value string) (map[string]string, error) {
+	pairs := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(k) == "" || strings.TrimSpace(v) == "" {
+			return nil, fmt.Errorf("expected key=value, got %q", pair)
+		}
+		pairs[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	return pairs, nil
+}
@@ -0,0 +1,180 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// reloadDelay lets a burst of writes to the config file settle before it is read, so
+// that a half-written file is not loaded
+const reloadDelay = 200 * time.Millisecond
+
+// Change is a setting whose value differs between two configurations. Secret values
+// are redacted.
+type Change struct {
+	Key        string
+	Old        any
+	New        any
+	Reloadable bool
+}
+
+// Diff lists the settings that differ between old and next, sorted by key
+func Diff(old, next *Config) []Change {
+	oldValues, newValues := flatten("", old.settings), flatten("", next.settings)
+	oldShown, newShown := flatten("", redact("", old.settings)), flatten("", redact("", next.settings))
+
+	var changes []Change
+	for key := range keys(oldValues, newValues) {
+		// Values are compared as printed: a list read from a file and the same list
+		// given as a default differ in type only
+		if fmt.Sprint(oldValues[key]) == fmt.Sprint(newValues[key]) {
+			continue
+		}
+		s, _ := lookupSetting(key)
+		changes = append(changes, Change{Key: key, Old: oldShown[key], New: newShown[key], Reloadable: s.reloadable})
+	}
+	slices.SortFunc(changes, func(a, b Change) int { return strings.Compare(a.Key, b.Key) })
+	return changes
+}
+
+// flatten maps the keys of nested settings, joined with dots, to their values
+func flatten(prefix string, settings map[string]any) map[string]any {
+	flat := make(map[string]any)
+	for key, value := range settings {
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		if nested, ok := value.(map[string]any); ok {
+			// Map settings such as tenant.hosts are compared as a whole
+			if s, ok := lookupSetting(key); !ok || s.key != key {
+				for k, v := range flatten(key, nested) {
+					flat[k] = v
+				}
+				continue
+			}
+		}
+		flat[key] = value
+	}
+	return flat
+}
+
+// keys returns the union of the keys of a and b
+func keys(a, b map[string]any) map[string]struct{} {
+	union := make(map[string]struct{}, len(a))
+	for key := range a {
+		union[key] = struct{}{}
+	}
+	for key := range b {
+		union[key] = struct{}{}
+	}
+	return union
+}
+
+// Watcher reloads a config file when it changes and hands the new configuration to
+// apply. A file that fails validation, or that apply rejects, is logged and ignored, so
+// the running configuration stays in place until the file is fixed.
+//
+// The reloadable settings are the log level, the rate limits and the feature flags.
+// There is no webhook retry policy among them: the service sends no webhooks, so it has
+// no such policy to reload. Whatever delivers webhooks must add its policy as a
+// reloadable setting and apply it in the apply function.
+type Watcher struct {
+	file    string
+	current *Config
+	apply   func(*Config) error
+	watcher *fsnotify.Watcher
+}
+
+// NewWatcher watches file, whose current configuration is current. apply must either
+// apply the reloadable settings of the configuration it is given or return an error
+// without applying any.
+func NewWatcher(file string, current *Config, apply func(*Config) error) (*Watcher, error) {
+	file, err := filepath.Abs(file)
+	if err != nil {
+		return nil, err
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	// The directory is watched rather than the file, since editors and Kubernetes
+	// config maps replace the file instead of writing to it
+	if err := watcher.Add(filepath.Dir(file)); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+	return &Watcher{file: file, current: current, apply: apply, watcher: watcher}, nil
+}
+
+// Run reloads the file on changes until ctx is done
+func (w *Watcher) Run(ctx context.Context) {
+	defer w.watcher.Close()
+
+	timer := time.NewTimer(0)
+	if !timer.Stop() {
+		<-timer.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			if w.affects(event) {
+				timer.Reset(reloadDelay)
+			}
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			slog.Error("Config file watcher failed", "file", w.file, "error", err)
+		case <-timer.C:
+			w.reload()
+		}
+	}
+}
+
+// affects tells whether event may have changed the config file. Kubernetes swaps a
+// ..data symlink in the directory to update the files in it.
+func (w *Watcher) affects(event fsnotify.Event) bool {
+	if event.Op == fsnotify.Chmod {
+		return false
+	}
+	name := filepath.Clean(event.Name)
+	return name == w.file || filepath.Base(name) == "..data"
+}
+
+func (w *Watcher) reload() {
+	next, err := Load(w.file)
+	if err != nil {
+		slog.Error("Rejected config reload, keeping the running configuration", "file", w.file, "error", err)
+		return
+	}
+	changes := Diff(w.current, next)
+	if len(changes) == 0 {
+		return
+	}
+	if err := w.apply(next); err != nil {
+		slog.Error("Rejected config reload, keeping the running configuration", "file", w.file, "error", err)
+		return
+	}
+	w.current = next
+
+	for _, change := range changes {
+		if change.Reloadable {
+			slog.Info("Config setting reloaded", "setting", change.Key, "old", change.Old, "new", change.New)
+		} else {
+			slog.Warn("Config setting changed, restart to apply it", "setting", change.Key, "old", change.Old, "new", change.New)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package config
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestDiff(t *testing.T) {
+	old, err := Load(writeConfig(t, "config.yaml", "db:\n  storage: memory\n  password: old-secret\nlog:\n  level: info\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	next, err := Load(writeConfig(t, "config.yaml", "db:\n  storage: memory\n  password: new-secret\nlog:\n  level: debug\ntenant:\n  hosts:\n    a.example.com: a\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changes := Diff(old, next)
+	want := []Change{
+		{Key: "db.password", Old: redacted, New: redacted},
+		{Key: "log.level", Old: "info", New: "debug", Reloadable: true},
+		{Key: "tenant.hosts"},
+	}
+	if len(changes) != len(want) {
+		t.Fatalf("Diff() = %+v", changes)
+	}
+	for i, change := range changes {
+		if change.Key != want[i].Key || change.Reloadable != want[i].Reloadable {
+			t.Fatalf("change %d = %+v, want %+v", i, change, want[i])
+		}
+		if want[i].Old != nil && (change.Old != want[i].Old || change.New != want[i].New) {
+			t.Fatalf("change %d = %+v, want %+v", i, change, want[i])
+		}
+	}
+}
+
+func TestWatcherAppliesValidChanges(t *testing.T) {
+	file := writeConfig(t, "config.yaml", "db:\n  storage: memory\nlog:\n  level: info\n")
+	current, err := Load(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	applied := make(chan *Config, 10)
+	watcher, err := NewWatcher(file, current, func(cfg *Config) error {
+		applied <- cfg
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go watcher.Run(ctx)
+
+	rewrite := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(file, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// An invalid file is not applied
+	rewrite("db:\n  storage: memory\nlog:\n  level: loud\n")
+	select {
+	case cfg := <-applied:
+		t.Fatalf("invalid config applied: %+v", cfg.LogSettings)
+	case <-time.After(3 * reloadDelay):
+	}
+
+	rewrite("db:\n  storage: memory\nlog:\n  level: warn\nfeatures:\n  order_export: false\n")
+	select {
+	case cfg := <-applied:
+		if cfg.LogSettings.Level != "warn" || cfg.FeatureSettings.OrderExport {
+			t.Fatalf("applied %+v %+v", cfg.LogSettings, cfg.FeatureSettings)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("valid config was not applied")
+	}
+}
@@ -2,6 +2,8 @@ package interfaces
 
 import (
 	"context"
+	"errors"
+	"time"
 
 	"github.com/Lamafout/online-store-api/internal/dal/models"
 )
@@ -11,6 +13,7 @@ type IOrderRepository interface {
 	BulkInsertOrders(ctx context.Context, orders []models.BulkOrderDalModel) ([]models.V1OrderDal, error)
 	GetOrderByID(ctx context.Context, id int64) (*models.V1OrderDal, error)
 	QueryOrders(ctx context.Context, req *models.QueryOrdersDalModel) ([]models.V1OrderDal, error)
+	IterateOrders(ctx context.Context, req *models.QueryOrdersDalModel, batchSize int, fn func([]models.V1OrderDal) error) error
 }
 
 type IOrderItemRepository interface {
@@ -18,4 +21,41 @@ type IOrderItemRepository interface {
 	BulkInsertOrderItems(ctx context.Context, items []models.BulkOrderItemDalModel) ([]models.V1OrderItemDal, error)
 	GetOrderItemsByOrderID(ctx context.Context, orderID int64) ([]models.V1OrderItemDal, error)
 	QueryOrderItems(ctx context.Context, req *models.QueryOrderItemsDalModel) ([]models.V1OrderItemDal, error)
-}
\ No newline at end of file
+}
+
+// ErrJobLeaseLost is returned by IJobRepository when a job is no longer leased to the
+// caller: another worker claimed it after the lease expired, or its progress moved on
+// since the caller last saw it
+var ErrJobLeaseLost = errors.New("job lease lost")
+
+type IJobRepository interface {
+	CreateJob(ctx context.Context, job *models.V1JobDal) error
+	GetJobByID(ctx context.Context, id int64) (*models.V1JobDal, error)
+	ClaimNextJob(ctx context.Context, lease time.Duration, maxAttempts int) (*models.V1JobDal, error)
+	FailExpiredJobs(ctx context.Context, maxAttempts int) (int64, error)
+	UpdateJobProgress(ctx context.Context, lease models.JobLease, processed, failed int, duration time.Duration) error
+	FinishJob(ctx context.Context, lease models.JobLease, status, errCode, errMessage string) error
+	InsertJobResults(ctx context.Context, results []models.V1JobResultDal) error
+	GetJobResults(ctx context.Context, jobID int64) ([]models.V1JobResultDal, error)
+}
+
+type IAPIKeyRepository interface {
+	CreateAPIKey(ctx context.Context, key *models.V1APIKeyDal) error
+	GetAPIKeyByID(ctx context.Context, id int64) (*models.V1APIKeyDal, error)
+	GetAPIKeyByPrefix(ctx context.Context, prefix string) (*models.V1APIKeyDal, error)
+	ListAPIKeys(ctx context.Context) ([]models.V1APIKeyDal, error)
+	UpdateAPIKeySecret(ctx context.Context, id int64, prefix, keyHash string, updatedAt time.Time) error
+	RevokeAPIKey(ctx context.Context, id int64, revokedAt time.Time) error
+	TouchAPIKey(ctx context.Context, id int64, usedAt time.Time) error
+}
+
+type IRoleAssignmentRepository interface {
+	AssignRole(ctx context.Context, assignment *models.V1RoleAssignmentDal) (bool, error)
+	RevokeRole(ctx context.Context, subject, role string) (bool, error)
+	ListRoleAssignments(ctx context.Context, subject string) ([]models.V1RoleAssignmentDal, error)
+}
+
+type IRateLimitRepository interface {
+	TakeTokens(ctx context.Context, key string, cost int, rate float64, burst int) (*models.V1RateLimitBucketDal, error)
+	DeleteIdleBuckets(ctx context.Context, idleFor time.Duration) (int64, error)
+}
@@ -0,0 +1,107 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/Lamafout/online-store-api/internal/dal/models"
+	"github.com/Lamafout/online-store-api/internal/tenant"
+)
+
+// OrderItemRepository keeps order items in memory
+type OrderItemRepository struct {
+	db Executor
+}
+
+// NewOrderItemRepository creates a new OrderItemRepository
+func NewOrderItemRepository(db Executor) *OrderItemRepository {
+	return &OrderItemRepository{db: db}
+}
+
+// CreateOrderItem creates a single order item in the tenant of ctx. Like the foreign key
+// in Postgres, the order must exist in the tenant.
+func (r *OrderItemRepository) CreateOrderItem(ctx context.Context, item *models.V1OrderItemDal) error {
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return err
+	}
+
+	return r.db.write(ctx, func(s *state) error {
+		if _, found := s.findOrder(tenantID, item.OrderID); !found {
+			return fmt.Errorf("failed to create order item: order %d does not exist", item.OrderID)
+		}
+		item.ID = r.db.store().itemIDs.Add(1)
+		item.TenantID = tenantID
+		s.items = append(s.items, *item)
+		return nil
+	})
+}
+
+// BulkInsertOrderItems inserts multiple order items into the tenant of ctx, returning
+// them in input order. Nothing is inserted if any of their orders does not exist.
+func (r *OrderItemRepository) BulkInsertOrderItems(ctx context.Context, items []models.BulkOrderItemDalModel) ([]models.V1OrderItemDal, error) {
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	inserted := make([]models.V1OrderItemDal, len(items))
+	err = r.db.write(ctx, func(s *state) error {
+		for _, item := range items {
+			if _, found := s.findOrder(tenantID, item.OrderID); !found {
+				return fmt.Errorf("order %d does not exist", item.OrderID)
+			}
+		}
+		for i, item := range items {
+			inserted[i] = models.V1OrderItemDal{
+				ID:            r.db.store().itemIDs.Add(1),
+				TenantID:      tenantID,
+				OrderID:       item.OrderID,
+				ProductID:     item.ProductID,
+				Quantity:      item.Quantity,
+				ProductTitle:  item.ProductTitle,
+				ProductURL:    item.ProductURL,
+				PriceCents:    item.PriceCents,
+				PriceCurrency: item.PriceCurrency,
+				CreatedAt:     item.CreatedAt,
+				UpdatedAt:     item.UpdatedAt,
+			}
+		}
+		s.items = append(s.items, inserted...)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to bulk insert order items: %w", err)
+	}
+	return inserted, nil
+}
+
+// GetOrderItemsByOrderID retrieves all order items of the tenant of ctx for a given order ID
+func (r *OrderItemRepository) GetOrderItemsByOrderID(ctx context.Context, orderID int64) ([]models.V1OrderItemDal, error) {
+	return r.QueryOrderItems(ctx, &models.QueryOrderItemsDalModel{OrderIDs: []int64{orderID}})
+}
+
+// QueryOrderItems returns the order items of the tenant of ctx matching the filter,
+// ordered by ID
+func (r *OrderItemRepository) QueryOrderItems(ctx context.Context, req *models.QueryOrderItemsDalModel) ([]models.V1OrderItemDal, error) {
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []models.V1OrderItemDal
+	for _, item := range r.db.read().items {
+		if item.TenantID != tenantID {
+			continue
+		}
+		if len(req.IDs) > 0 && !slices.Contains(req.IDs, item.ID) {
+			continue
+		}
+		if len(req.OrderIDs) > 0 && !slices.Contains(req.OrderIDs, item.OrderID) {
+			continue
+		}
+		items = append(items, item)
+	}
+	return paginate(items, req.Limit, req.Offset), nil
+}
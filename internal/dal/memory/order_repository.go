@@ -0,0 +1,146 @@
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+
+	"github.com/Lamafout/online-store-api/internal/dal/models"
+	"github.com/Lamafout/online-store-api/internal/tenant"
+)
+
+// OrderRepository keeps orders in memory
+type OrderRepository struct {
+	db Executor
+}
+
+// NewOrderRepository creates a new OrderRepository
+func NewOrderRepository(db Executor) *OrderRepository {
+	return &OrderRepository{db: db}
+}
+
+// CreateOrder creates a single order in the tenant of ctx
+func (r *OrderRepository) CreateOrder(ctx context.Context, order *models.V1OrderDal) error {
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return err
+	}
+
+	return r.db.write(ctx, func(s *state) error {
+		order.ID = r.db.store().orderIDs.Add(1)
+		order.TenantID = tenantID
+		s.orders = append(s.orders, *order)
+		return nil
+	})
+}
+
+// BulkInsertOrders inserts multiple orders into the tenant of ctx, returning them in
+// input order
+func (r *OrderRepository) BulkInsertOrders(ctx context.Context, orders []models.BulkOrderDalModel) ([]models.V1OrderDal, error) {
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	inserted := make([]models.V1OrderDal, len(orders))
+	err = r.db.write(ctx, func(s *state) error {
+		for i, order := range orders {
+			inserted[i] = models.V1OrderDal{
+				ID:                 r.db.store().orderIDs.Add(1),
+				TenantID:           tenantID,
+				CustomerID:         order.CustomerID,
+				DeliveryAddress:    order.DeliveryAddress,
+				TotalPriceCents:    order.TotalPriceCents,
+				TotalPriceCurrency: order.TotalPriceCurrency,
+				CreatedAt:          order.CreatedAt,
+				UpdatedAt:          order.UpdatedAt,
+			}
+		}
+		s.orders = append(s.orders, inserted...)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to bulk insert orders: %w", err)
+	}
+	return inserted, nil
+}
+
+// GetOrderByID retrieves an order of the tenant of ctx by its ID. A missing order is
+// reported as sql.ErrNoRows, like the Postgres repository does.
+func (r *OrderRepository) GetOrderByID(ctx context.Context, id int64) (*models.V1OrderDal, error) {
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	s := r.db.read()
+	i, found := s.findOrder(tenantID, id)
+	if !found {
+		return nil, fmt.Errorf("failed to get order by ID %d: %w", id, sql.ErrNoRows)
+	}
+	order := s.orders[i]
+	return &order, nil
+}
+
+// QueryOrders returns the orders of the tenant of ctx matching the filter, ordered by ID
+func (r *OrderRepository) QueryOrders(ctx context.Context, req *models.QueryOrdersDalModel) ([]models.V1OrderDal, error) {
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return paginate(r.filter(tenantID, req), req.Limit, req.Offset), nil
+}
+
+// IterateOrders passes all orders matching the filter to fn in batches of at most
+// batchSize orders, ordered by ID. Limit and Offset are ignored.
+func (r *OrderRepository) IterateOrders(ctx context.Context, req *models.QueryOrdersDalModel, batchSize int, fn func([]models.V1OrderDal) error) error {
+	if batchSize <= 0 {
+		return fmt.Errorf("batch size must be greater than 0")
+	}
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return err
+	}
+
+	for batch := range slices.Chunk(r.filter(tenantID, req), batchSize) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(batch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// filter returns copies of the orders of tenantID matching req, ignoring pagination
+func (r *OrderRepository) filter(tenantID string, req *models.QueryOrdersDalModel) []models.V1OrderDal {
+	var orders []models.V1OrderDal
+	for _, order := range r.db.read().orders {
+		if order.TenantID != tenantID {
+			continue
+		}
+		if len(req.IDs) > 0 && !slices.Contains(req.IDs, order.ID) {
+			continue
+		}
+		if len(req.CustomerIDs) > 0 && !slices.Contains(req.CustomerIDs, order.CustomerID) {
+			continue
+		}
+		orders = append(orders, order)
+	}
+	return orders
+}
+
+// paginate applies LIMIT and OFFSET semantics, where non-positive values mean none
+func paginate[T any](rows []T, limit, offset int) []T {
+	if offset > 0 {
+		rows = rows[min(offset, len(rows)):]
+	}
+	if limit > 0 && limit < len(rows) {
+		rows = rows[:limit]
+	}
+	return rows
+}
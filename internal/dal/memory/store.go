@@ -0,0 +1,202 @@
+// Package memory keeps orders and order items in process memory, for tests and local
+// demos that run without Postgres. Data is lost when the process exits.
+package memory
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"github.com/Lamafout/online-store-api/internal/dal/models"
+)
+
+// ErrNotSupported is returned by the repositories the in-memory storage does not implement
+var ErrNotSupported = errors.New("not supported by the in-memory storage")
+
+// Store holds the committed orders and order items of all tenants.
+//
+// Committed state is never modified in place: writers work on a copy and publish it
+// on commit, so readers never need more than a brief read lock. Writing transactions
+// run one at a time, which makes them serializable without any conflict detection.
+type Store struct {
+	// writer is held by the writing transaction for its whole lifetime
+	writer chan struct{}
+
+	mu        sync.RWMutex
+	committed *state
+
+	// IDs come from sequences outside of the transactional state, so like Postgres
+	// sequences they are never handed out twice, even by transactions rolled back
+	orderIDs atomic.Int64
+	itemIDs  atomic.Int64
+}
+
+// state is one version of the data, ordered by ID
+type state struct {
+	orders []models.V1OrderDal
+	items  []models.V1OrderItemDal
+}
+
+// NewStore creates an empty Store
+func NewStore() *Store {
+	return &Store{
+		writer:    make(chan struct{}, 1),
+		committed: &state{},
+	}
+}
+
+// Executor is what the repositories run against: the Store itself, where every write
+// commits on its own, or a Tx
+type Executor interface {
+	// read returns the state visible to the executor, which must not be modified
+	read() *state
+	// write applies fn to a writable state; fn must not leave it half modified when
+	// it fails
+	write(ctx context.Context, fn func(s *state) error) error
+	store() *Store
+}
+
+func (s *Store) read() *state {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.committed
+}
+
+// write runs fn as a transaction of its own
+func (s *Store) write(ctx context.Context, fn func(s *state) error) error {
+	tx, err := s.Begin(ctx, false)
+	if err != nil {
+		return err
+	}
+	if err := tx.write(ctx, fn); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *Store) store() *Store {
+	return s
+}
+
+// Begin starts a transaction. A read-only transaction sees the data committed when it
+// started and never waits; a writing one waits until no other is running.
+func (s *Store) Begin(ctx context.Context, readOnly bool) (*Tx, error) {
+	if !readOnly {
+		select {
+		case s.writer <- struct{}{}:
+		case <-ctx.Done():
+			return nil, fmt.Errorf("failed to begin transaction: %w", ctx.Err())
+		}
+	}
+	return &Tx{
+		db:       s,
+		readOnly: readOnly,
+		current:  s.read(),
+	}, nil
+}
+
+// Tx is a transaction over a Store. Its writes go to a private copy of the state that
+// is published when it commits and dropped when it rolls back.
+type Tx struct {
+	db       *Store
+	readOnly bool
+	done     bool
+	current  *state
+	// owned reports whether current is the private copy of the transaction, rather
+	// than committed state or a state saved by a savepoint
+	owned bool
+}
+
+// Savepoint is the state of a transaction at some point, to roll back to
+type Savepoint struct {
+	state *state
+}
+
+func (t *Tx) read() *state {
+	return t.current
+}
+
+func (t *Tx) write(_ context.Context, fn func(s *state) error) error {
+	if t.done {
+		return fmt.Errorf("transaction is already finished")
+	}
+	if t.readOnly {
+		return fmt.Errorf("cannot write in a read-only transaction")
+	}
+	if !t.owned {
+		t.current = t.current.clone()
+		t.owned = true
+	}
+	return fn(t.current)
+}
+
+func (t *Tx) store() *Store {
+	return t.db
+}
+
+// Commit publishes the writes of the transaction
+func (t *Tx) Commit() error {
+	if t.done {
+		return fmt.Errorf("transaction is already finished")
+	}
+	t.finish(true)
+	return nil
+}
+
+// Rollback discards the writes of the transaction. Rolling back a finished
+// transaction does nothing.
+func (t *Tx) Rollback() error {
+	if t.done {
+		return nil
+	}
+	t.finish(false)
+	return nil
+}
+
+func (t *Tx) finish(commit bool) {
+	t.done = true
+	if t.readOnly {
+		return
+	}
+	// Holding the writer means nothing was committed since the transaction started, so
+	// its state is the committed state plus its own writes
+	if commit {
+		t.db.mu.Lock()
+		t.db.committed = t.current
+		t.db.mu.Unlock()
+	}
+	<-t.db.writer
+}
+
+// Savepoint remembers the current state of the transaction
+func (t *Tx) Savepoint() Savepoint {
+	// The saved state is shared from now on, so the next write copies it again
+	t.owned = false
+	return Savepoint{state: t.current}
+}
+
+// RollbackTo discards the writes done since sp was taken
+func (t *Tx) RollbackTo(sp Savepoint) {
+	t.current = sp.state
+	t.owned = false
+}
+
+// findOrder returns the index of the order with the given ID in the tenant
+func (s *state) findOrder(tenantID string, id int64) (int, bool) {
+	i, found := slices.BinarySearchFunc(s.orders, id, func(order models.V1OrderDal, id int64) int {
+		return cmp.Compare(order.ID, id)
+	})
+	return i, found && s.orders[i].TenantID == tenantID
+}
+
+func (s *state) clone() *state {
+	return &state{
+		orders: slices.Clone(s.orders),
+		items:  slices.Clone(s.items),
+	}
+}
@@ -0,0 +1,166 @@
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/Lamafout/online-store-api/internal/dal/models"
+	"github.com/Lamafout/online-store-api/internal/tenant"
+)
+
+func createOrder(t *testing.T, ctx context.Context, db Executor, customerID int64) models.V1OrderDal {
+	t.Helper()
+	order := models.V1OrderDal{CustomerID: customerID, DeliveryAddress: "Main st. 1", TotalPriceCents: 100, TotalPriceCurrency: "USD"}
+	if err := NewOrderRepository(db).CreateOrder(ctx, &order); err != nil {
+		t.Fatalf("CreateOrder() error = %v", err)
+	}
+	return order
+}
+
+func TestTxRollbackDiscardsWrites(t *testing.T) {
+	ctx := tenant.WithTenant(context.Background(), "acme")
+	store := NewStore()
+
+	tx, err := store.Begin(ctx, false)
+	if err != nil {
+		t.Fatalf("Begin() error = %v", err)
+	}
+	order := createOrder(t, ctx, tx, 1)
+	if _, err := NewOrderRepository(tx).GetOrderByID(ctx, order.ID); err != nil {
+		t.Fatalf("order is not visible inside its transaction: %v", err)
+	}
+	if _, err := NewOrderRepository(store).GetOrderByID(ctx, order.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("uncommitted order is visible outside its transaction: %v", err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatalf("Rollback() error = %v", err)
+	}
+
+	if _, err := NewOrderRepository(store).GetOrderByID(ctx, order.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("GetOrderByID() after rollback error = %v, want sql.ErrNoRows", err)
+	}
+
+	// The rollback released the writer, so the next transaction does not block
+	tx, err = store.Begin(ctx, false)
+	if err != nil {
+		t.Fatalf("Begin() error = %v", err)
+	}
+	committed := createOrder(t, ctx, tx, 1)
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit() error = %v", err)
+	}
+	if committed.ID == order.ID {
+		t.Fatalf("ID %d of a rolled back order was reused", order.ID)
+	}
+	if _, err := NewOrderRepository(store).GetOrderByID(ctx, committed.ID); err != nil {
+		t.Fatalf("GetOrderByID() after commit error = %v", err)
+	}
+}
+
+func TestTxRollbackToSavepoint(t *testing.T) {
+	ctx := tenant.WithTenant(context.Background(), "acme")
+	store := NewStore()
+
+	tx, err := store.Begin(ctx, false)
+	if err != nil {
+		t.Fatalf("Begin() error = %v", err)
+	}
+	kept := createOrder(t, ctx, tx, 1)
+	sp := tx.Savepoint()
+	undone := createOrder(t, ctx, tx, 2)
+	tx.RollbackTo(sp)
+	again := createOrder(t, ctx, tx, 3)
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit() error = %v", err)
+	}
+
+	orders, err := NewOrderRepository(store).QueryOrders(ctx, &models.QueryOrdersDalModel{})
+	if err != nil {
+		t.Fatalf("QueryOrders() error = %v", err)
+	}
+	if len(orders) != 2 || orders[0].ID != kept.ID || orders[1].ID != again.ID {
+		t.Fatalf("orders = %+v, want %d and %d but not %d", orders, kept.ID, again.ID, undone.ID)
+	}
+}
+
+func TestReadOnlyTxSeesSnapshot(t *testing.T) {
+	ctx := tenant.WithTenant(context.Background(), "acme")
+	store := NewStore()
+
+	tx, err := store.Begin(ctx, true)
+	if err != nil {
+		t.Fatalf("Begin() error = %v", err)
+	}
+	defer tx.Rollback()
+
+	order := createOrder(t, ctx, store, 1)
+	if _, err := NewOrderRepository(tx).GetOrderByID(ctx, order.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("order committed after the snapshot is visible: %v", err)
+	}
+	if err := NewOrderRepository(tx).CreateOrder(ctx, &models.V1OrderDal{CustomerID: 1}); err == nil {
+		t.Fatal("CreateOrder() in a read-only transaction succeeded")
+	}
+}
+
+func TestOrdersAreScopedToTenant(t *testing.T) {
+	store := NewStore()
+	acme := tenant.WithTenant(context.Background(), "acme")
+	globex := tenant.WithTenant(context.Background(), "globex")
+
+	order := createOrder(t, acme, store, 1)
+	if _, err := NewOrderRepository(store).GetOrderByID(globex, order.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("GetOrderByID() from another tenant error = %v, want sql.ErrNoRows", err)
+	}
+
+	_, err := NewOrderItemRepository(store).BulkInsertOrderItems(globex, []models.BulkOrderItemDalModel{{OrderID: order.ID, ProductID: 1, Quantity: 1}})
+	if err == nil {
+		t.Fatal("BulkInsertOrderItems() into an order of another tenant succeeded")
+	}
+	if items, _ := NewOrderItemRepository(store).GetOrderItemsByOrderID(acme, order.ID); len(items) != 0 {
+		t.Fatalf("items = %+v, want none", items)
+	}
+
+	if err := NewOrderRepository(store).CreateOrder(context.Background(), &models.V1OrderDal{}); err == nil {
+		t.Fatal("CreateOrder() without tenant succeeded")
+	}
+}
+
+func TestQueryOrdersPagination(t *testing.T) {
+	ctx := tenant.WithTenant(context.Background(), "acme")
+	store := NewStore()
+	var ids []int64
+	for i := range 5 {
+		ids = append(ids, createOrder(t, ctx, store, int64(i%2+1)).ID)
+	}
+
+	repo := NewOrderRepository(store)
+	tests := []struct {
+		name string
+		req  models.QueryOrdersDalModel
+		want []int64
+	}{
+		{"first page", models.QueryOrdersDalModel{Limit: 2}, ids[:2]},
+		{"second page", models.QueryOrdersDalModel{Limit: 2, Offset: 2}, ids[2:4]},
+		{"past the end", models.QueryOrdersDalModel{Limit: 2, Offset: 10}, nil},
+		{"by customer", models.QueryOrdersDalModel{CustomerIDs: []int64{2}}, []int64{ids[1], ids[3]}},
+		{"by ids", models.QueryOrdersDalModel{IDs: []int64{ids[4], ids[0]}, Limit: 1}, ids[:1]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			orders, err := repo.QueryOrders(ctx, &tt.req)
+			if err != nil {
+				t.Fatalf("QueryOrders() error = %v", err)
+			}
+			if len(orders) != len(tt.want) {
+				t.Fatalf("QueryOrders() returned %d orders, want %d", len(orders), len(tt.want))
+			}
+			for i, order := range orders {
+				if order.ID != tt.want[i] {
+					t.Errorf("order %d ID = %d, want %d", i, order.ID, tt.want[i])
+				}
+			}
+		})
+	}
+}
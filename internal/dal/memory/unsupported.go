@@ -0,0 +1,94 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/Lamafout/online-store-api/internal/dal/models"
+)
+
+// UnsupportedRepository stands in for the job, API key, role assignment and rate limit
+// repositories, which only exist in Postgres. Everything fails with ErrNotSupported,
+// except that nobody has role assignments, so authentication keeps working.
+type UnsupportedRepository struct{}
+
+func (UnsupportedRepository) CreateJob(context.Context, *models.V1JobDal) error {
+	return ErrNotSupported
+}
+
+func (UnsupportedRepository) GetJobByID(context.Context, int64) (*models.V1JobDal, error) {
+	return nil, ErrNotSupported
+}
+
+func (UnsupportedRepository) ClaimNextJob(context.Context, time.Duration, int) (*models.V1JobDal, error) {
+	return nil, ErrNotSupported
+}
+
+func (UnsupportedRepository) FailExpiredJobs(context.Context, int) (int64, error) {
+	return 0, ErrNotSupported
+}
+
+func (UnsupportedRepository) UpdateJobProgress(context.Context, models.JobLease, int, int, time.Duration) error {
+	return ErrNotSupported
+}
+
+func (UnsupportedRepository) FinishJob(context.Context, models.JobLease, string, string, string) error {
+	return ErrNotSupported
+}
+
+func (UnsupportedRepository) InsertJobResults(context.Context, []models.V1JobResultDal) error {
+	return ErrNotSupported
+}
+
+func (UnsupportedRepository) GetJobResults(context.Context, int64) ([]models.V1JobResultDal, error) {
+	return nil, ErrNotSupported
+}
+
+func (UnsupportedRepository) CreateAPIKey(context.Context, *models.V1APIKeyDal) error {
+	return ErrNotSupported
+}
+
+func (UnsupportedRepository) GetAPIKeyByID(context.Context, int64) (*models.V1APIKeyDal, error) {
+	return nil, ErrNotSupported
+}
+
+func (UnsupportedRepository) GetAPIKeyByPrefix(context.Context, string) (*models.V1APIKeyDal, error) {
+	return nil, ErrNotSupported
+}
+
+func (UnsupportedRepository) ListAPIKeys(context.Context) ([]models.V1APIKeyDal, error) {
+	return nil, ErrNotSupported
+}
+
+func (UnsupportedRepository) UpdateAPIKeySecret(context.Context, int64, string, string, time.Time) error {
+	return ErrNotSupported
+}
+
+func (UnsupportedRepository) RevokeAPIKey(context.Context, int64, time.Time) error {
+	return ErrNotSupported
+}
+
+func (UnsupportedRepository) TouchAPIKey(context.Context, int64, time.Time) error {
+	return ErrNotSupported
+}
+
+func (UnsupportedRepository) AssignRole(context.Context, *models.V1RoleAssignmentDal) (bool, error) {
+	return false, ErrNotSupported
+}
+
+func (UnsupportedRepository) RevokeRole(context.Context, string, string) (bool, error) {
+	return false, ErrNotSupported
+}
+
+// ListRoleAssignments reports that nobody has roles assigned
+func (UnsupportedRepository) ListRoleAssignments(context.Context, string) ([]models.V1RoleAssignmentDal, error) {
+	return nil, nil
+}
+
+func (UnsupportedRepository) TakeTokens(context.Context, string, int, float64, int) (*models.V1RateLimitBucketDal, error) {
+	return nil, ErrNotSupported
+}
+
+func (UnsupportedRepository) DeleteIdleBuckets(context.Context, time.Duration) (int64, error) {
+	return 0, ErrNotSupported
+}
@@ -0,0 +1,20 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+type V1APIKeyDal struct {
+	ID         int64        `db:"id"`
+	Name       string       `db:"name"`
+	Prefix     string       `db:"prefix"`
+	KeyHash    string       `db:"key_hash"`
+	Scopes     string       `db:"scopes"`
+	CreatedBy  string       `db:"created_by"`
+	CreatedAt  time.Time    `db:"created_at"`
+	UpdatedAt  time.Time    `db:"updated_at"`
+	LastUsedAt sql.NullTime `db:"last_used_at"`
+	RevokedAt  sql.NullTime `db:"revoked_at"`
+	TenantID   string       `db:"tenant_id"`
+}
@@ -0,0 +1,35 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+type V1JobDal struct {
+	ID             int64          `db:"id"`
+	Type           string         `db:"type"`
+	Status         string         `db:"status"`
+	Payload        []byte         `db:"payload"`
+	TotalItems     int            `db:"total_items"`
+	ProcessedItems int            `db:"processed_items"`
+	FailedItems    int            `db:"failed_items"`
+	ErrorCode      sql.NullString `db:"error_code"`
+	Error          sql.NullString `db:"error"`
+	Attempts       int            `db:"attempts"`
+	LockedUntil    sql.NullTime   `db:"locked_until"`
+	CreatedAt      time.Time      `db:"created_at"`
+	UpdatedAt      time.Time      `db:"updated_at"`
+	StartedAt      sql.NullTime   `db:"started_at"`
+	FinishedAt     sql.NullTime   `db:"finished_at"`
+	CreatedBy      string         `db:"created_by"`
+	TenantID       string         `db:"tenant_id"`
+}
+
+// JobLease identifies the claim of a job by one worker. Attempt is the attempts value the
+// claim set and ProcessedItems the progress the worker continues from; a write under a
+// lease only applies while the job still matches both.
+type JobLease struct {
+	JobID          int64
+	Attempt        int
+	ProcessedItems int
+}
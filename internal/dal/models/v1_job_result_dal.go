@@ -0,0 +1,14 @@
+package models
+
+import "database/sql"
+
+type V1JobResultDal struct {
+	JobID     int64          `db:"job_id"`
+	ItemIndex int            `db:"item_index"`
+	OrderID   sql.NullInt64  `db:"order_id"`
+	ErrorCode sql.NullString `db:"error_code"`
+	Error     sql.NullString `db:"error"`
+	// FieldErrors is the JSON array of core.FieldError of a rejected order, if any
+	FieldErrors []byte `db:"field_errors"`
+	TenantID    string `db:"tenant_id"`
+}
@@ -6,6 +6,7 @@ import (
 
 type V1OrderDal struct {
 	ID                int64     `db:"id"`
+	TenantID          string    `db:"tenant_id"`
 	CustomerID        int64     `db:"customer_id"`
 	DeliveryAddress   string    `db:"delivery_address"`
 	TotalPriceCents   int64     `db:"total_price_cents"`
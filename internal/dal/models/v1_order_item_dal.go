@@ -6,6 +6,7 @@ import (
 
 type V1OrderItemDal struct {
 	ID             int64     `db:"id"`
+	TenantID       string    `db:"tenant_id"`
 	OrderID        int64     `db:"order_id"`
 	ProductID      int64     `db:"product_id"`
 	Quantity       int       `db:"quantity"`
@@ -0,0 +1,11 @@
+package models
+
+import "time"
+
+type V1RateLimitBucketDal struct {
+	TenantID  string    `db:"tenant_id"`
+	Key       string    `db:"key"`
+	Tokens    float64   `db:"tokens"`
+	Allowed   bool      `db:"allowed"`
+	UpdatedAt time.Time `db:"updated_at"`
+}
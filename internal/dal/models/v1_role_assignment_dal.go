@@ -0,0 +1,11 @@
+package models
+
+import "time"
+
+type V1RoleAssignmentDal struct {
+	TenantID  string    `db:"tenant_id"`
+	Subject   string    `db:"subject"`
+	Role      string    `db:"role"`
+	CreatedBy string    `db:"created_by"`
+	CreatedAt time.Time `db:"created_at"`
+}
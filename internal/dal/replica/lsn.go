@@ -0,0 +1,32 @@
+package replica
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// LSN is a position in the Postgres write-ahead log
+type LSN uint64
+
+// ParseLSN parses the textual form Postgres uses for pg_lsn, e.g. 16/B374D848
+func ParseLSN(s string) (LSN, error) {
+	hi, lo, ok := strings.Cut(s, "/")
+	if !ok {
+		return 0, fmt.Errorf("invalid LSN %q", s)
+	}
+	h, err := strconv.ParseUint(hi, 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid LSN %q", s)
+	}
+	l, err := strconv.ParseUint(lo, 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid LSN %q", s)
+	}
+	return LSN(h<<32 | l), nil
+}
+
+// String formats the LSN the way Postgres does
+func (l LSN) String() string {
+	return fmt.Sprintf("%X/%X", uint64(l)>>32, uint64(l)&0xFFFFFFFF)
+}
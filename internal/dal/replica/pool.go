@@ -0,0 +1,124 @@
+// Package replica routes reads to Postgres streaming replicas. Replicas are checked in
+// the background; reads fall back to the primary when none is healthy or caught up
+// with the writes the reading client has to see.
+package replica
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/Lamafout/online-store-api/internal/metrics"
+	"github.com/jmoiron/sqlx"
+)
+
+// checkTimeout bounds each health check, so a hung replica is marked unhealthy
+// instead of stalling the others
+const checkTimeout = 2 * time.Second
+
+// replayedLSNQuery returns how far a replica has replayed the WAL. A server that is
+// not in recovery, e.g. a promoted replica, has everything it wrote.
+const replayedLSNQuery = "SELECT COALESCE(pg_last_wal_replay_lsn(), pg_current_wal_lsn())::text"
+
+// replica is one read-only pool and what its last health check found
+type replica struct {
+	name    string
+	db      *sqlx.DB
+	healthy atomic.Bool
+	// replayed is how far the replica had replayed at the last successful check. It
+	// only grows, so a replica that had replayed an LSN then still has.
+	replayed atomic.Uint64
+}
+
+// Pool picks a replica for each read, round robin among the eligible ones
+type Pool struct {
+	replicas []*replica
+	next     atomic.Uint64
+}
+
+// NewPool creates a Pool over dbs, named replica-0, replica-1... in logs and metrics.
+// Replicas start unhealthy until their first check.
+func NewPool(dbs []*sqlx.DB) *Pool {
+	pool := &Pool{}
+	for i, db := range dbs {
+		pool.replicas = append(pool.replicas, &replica{name: "replica-" + strconv.Itoa(i), db: db})
+	}
+	return pool
+}
+
+// Pick returns a healthy replica that has replayed up to minLSN, or nil when reads have
+// to go to the primary
+func (p *Pool) Pick(minLSN LSN) *sqlx.DB {
+	n := len(p.replicas)
+	if n == 0 {
+		return nil
+	}
+	start := p.next.Add(1)
+	for i := range n {
+		r := p.replicas[(start+uint64(i))%uint64(n)]
+		if r.healthy.Load() && LSN(r.replayed.Load()) >= minLSN {
+			return r.db
+		}
+	}
+	return nil
+}
+
+// Run checks every replica each interval until ctx is done
+func (p *Pool) Run(ctx context.Context, interval time.Duration) {
+	p.CheckAll(ctx)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.CheckAll(ctx)
+		}
+	}
+}
+
+// CheckAll checks every replica once
+func (p *Pool) CheckAll(ctx context.Context) {
+	for _, r := range p.replicas {
+		r.check(ctx)
+	}
+}
+
+// Close closes the connection pools of all replicas
+func (p *Pool) Close() error {
+	var firstErr error
+	for _, r := range p.replicas {
+		if err := r.db.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (r *replica) check(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	var value string
+	err := r.db.GetContext(ctx, &value, replayedLSNQuery)
+	var lsn LSN
+	if err == nil {
+		lsn, err = ParseLSN(value)
+	}
+	if err != nil {
+		if r.healthy.Swap(false) {
+			slog.Warn("Replica is unhealthy, reading from the primary instead", "replica", r.name, "error", err)
+		}
+		metrics.DBReplicaHealthy.WithLabelValues(r.name).Set(0)
+		return
+	}
+
+	r.replayed.Store(max(r.replayed.Load(), uint64(lsn)))
+	if !r.healthy.Swap(true) {
+		slog.Info("Replica is healthy", "replica", r.name, "replayed_lsn", lsn.String())
+	}
+	metrics.DBReplicaHealthy.WithLabelValues(r.name).Set(1)
+}
@@ -0,0 +1,105 @@
+package replica
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func TestParseLSN(t *testing.T) {
+	lsn, err := ParseLSN("16/B374D848")
+	if err != nil {
+		t.Fatalf("ParseLSN() = %v", err)
+	}
+	if lsn != LSN(0x16B374D848) || lsn.String() != "16/B374D848" {
+		t.Fatalf("ParseLSN() = %d (%s)", lsn, lsn)
+	}
+	if lsn, _ := ParseLSN("0/0"); lsn != 0 {
+		t.Fatalf("ParseLSN(0/0) = %d", lsn)
+	}
+
+	for _, invalid := range []string{"", "16", "16/", "/1", "G/1", "1/100000000"} {
+		if _, err := ParseLSN(invalid); err == nil {
+			t.Errorf("ParseLSN(%q) succeeded", invalid)
+		}
+	}
+}
+
+func TestPoolPicksHealthyCaughtUpReplicas(t *testing.T) {
+	first, second := &sqlx.DB{}, &sqlx.DB{}
+	pool := NewPool([]*sqlx.DB{first, second})
+	if db := pool.Pick(0); db != nil {
+		t.Fatal("Pick() returned a replica before any health check")
+	}
+
+	pool.replicas[0].healthy.Store(true)
+	pool.replicas[0].replayed.Store(100)
+	pool.replicas[1].healthy.Store(true)
+	pool.replicas[1].replayed.Store(200)
+
+	picked := map[*sqlx.DB]int{}
+	for range 4 {
+		picked[pool.Pick(50)]++
+	}
+	if picked[first] != 2 || picked[second] != 2 {
+		t.Fatalf("Pick() spread = %v, want round robin", picked)
+	}
+
+	for range 4 {
+		if db := pool.Pick(150); db != second {
+			t.Fatal("Pick() returned a replica that has not replayed the LSN")
+		}
+	}
+	if db := pool.Pick(300); db != nil {
+		t.Fatal("Pick() returned a lagging replica instead of falling back to the primary")
+	}
+
+	pool.replicas[1].healthy.Store(false)
+	if db := pool.Pick(150); db != nil {
+		t.Fatal("Pick() returned an unhealthy replica")
+	}
+}
+
+func TestMiddlewareCarriesTheLSNOfWrites(t *testing.T) {
+	var seen LSN
+	h := Middleware(60)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session, ok := SessionFromContext(r.Context())
+		if !ok {
+			t.Fatal("request has no session")
+		}
+		seen = session.MinLSN()
+		if r.Method == http.MethodPost {
+			session.Observe(seen + 10)
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	serve := func(method string, cookie *http.Cookie) *http.Response {
+		req := httptest.NewRequest(method, "/", nil)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req.WithContext(context.Background()))
+		return rec.Result()
+	}
+
+	resp := serve(http.MethodPost, &http.Cookie{Name: CookieName, Value: "0/20"})
+	if seen != 0x20 {
+		t.Fatalf("session started at %s, want 0/20", seen)
+	}
+	cookies := resp.Cookies()
+	if len(cookies) != 1 || cookies[0].Name != CookieName || cookies[0].Value != "0/2A" || cookies[0].MaxAge != 60 {
+		t.Fatalf("cookies = %v, want read_your_writes=0/2A", cookies)
+	}
+
+	if resp := serve(http.MethodGet, cookies[0]); len(resp.Cookies()) != 0 || seen != 0x2A {
+		t.Fatalf("read started at %s and set %v", seen, resp.Cookies())
+	}
+	if serve(http.MethodGet, &http.Cookie{Name: CookieName, Value: "garbage"}); seen != 0 {
+		t.Fatalf("malformed cookie started the session at %s", seen)
+	}
+}
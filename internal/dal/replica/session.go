@@ -0,0 +1,121 @@
+package replica
+
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
+// CookieName is the cookie carrying the LSN a client has to read at least up to
+const CookieName = "read_your_writes"
+
+// Session tracks the writes of one client, so that its reads skip replicas that have
+// not replayed them yet
+type Session struct {
+	mu     sync.Mutex
+	minLSN LSN
+	wrote  bool
+}
+
+type sessionKey struct{}
+
+// WithSession returns a copy of ctx whose reads and writes are tracked by session
+func WithSession(ctx context.Context, session *Session) context.Context {
+	return context.WithValue(ctx, sessionKey{}, session)
+}
+
+// SessionFromContext returns the session of ctx, if any
+func SessionFromContext(ctx context.Context) (*Session, bool) {
+	session, ok := ctx.Value(sessionKey{}).(*Session)
+	return session, ok && session != nil
+}
+
+// MinLSN returns the position a replica must have replayed to serve the session
+func (s *Session) MinLSN() LSN {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.minLSN
+}
+
+// Observe records a write of the session that committed at lsn
+func (s *Session) Observe(lsn LSN) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if lsn > s.minLSN {
+		s.minLSN = lsn
+		s.wrote = true
+	}
+}
+
+func (s *Session) written() (LSN, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.minLSN, s.wrote
+}
+
+// Middleware gives each request a Session starting at the LSN of its read_your_writes
+// cookie. When the request commits a write, the response sets the cookie to the LSN
+// of that write, so the client's next reads see it even if they land on a replica.
+// A malformed cookie is ignored: the client may read stale data, nothing worse.
+func Middleware(maxAge int) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			session := &Session{}
+			if cookie, err := r.Cookie(CookieName); err == nil {
+				if lsn, err := ParseLSN(cookie.Value); err == nil {
+					session.minLSN = lsn
+				}
+			}
+			sw := &sessionWriter{ResponseWriter: w, session: session, maxAge: maxAge}
+			next.ServeHTTP(sw, r.WithContext(WithSession(r.Context(), session)))
+		})
+	}
+}
+
+// sessionWriter sets the cookie right before the headers are written, which is after
+// the handler committed its writes
+type sessionWriter struct {
+	http.ResponseWriter
+	session     *Session
+	maxAge      int
+	wroteHeader bool
+}
+
+func (w *sessionWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		if lsn, ok := w.session.written(); ok {
+			http.SetCookie(w.ResponseWriter, &http.Cookie{
+				Name:     CookieName,
+				Value:    lsn.String(),
+				Path:     "/",
+				MaxAge:   w.maxAge,
+				HttpOnly: true,
+				SameSite: http.SameSiteLaxMode,
+			})
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *sessionWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush keeps streaming responses such as exports working through the session
+func (w *sessionWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *sessionWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,139 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Lamafout/online-store-api/internal/dal/interfaces"
+	"github.com/Lamafout/online-store-api/internal/dal/models"
+	"github.com/Lamafout/online-store-api/internal/tenant"
+)
+
+// APIKeyRepository handles database operations for API keys
+type APIKeyRepository struct {
+	db interfaces.DBExecuter
+}
+
+// NewAPIKeyRepository creates a new APIKeyRepository
+func NewAPIKeyRepository(db interfaces.DBExecuter) *APIKeyRepository {
+	return &APIKeyRepository{db: traced(db)}
+}
+
+// CreateAPIKey creates a single API key in the tenant of ctx
+func (r *APIKeyRepository) CreateAPIKey(ctx context.Context, key *models.V1APIKeyDal) error {
+	ctx, done := instrument(ctx, "APIKeyRepository", "CreateAPIKey")
+	defer done()
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO api_keys (tenant_id, name, prefix, key_hash, scopes, created_by, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id`
+	var id int64
+	err = r.db.QueryRowxContext(ctx, query, tenantID, key.Name, key.Prefix, key.KeyHash, key.Scopes, key.CreatedBy, key.CreatedAt, key.UpdatedAt).Scan(&id)
+	if err != nil {
+		return reportQueryError(ctx, "CreateAPIKey", fmt.Errorf("failed to create api key: %w", err), "name", key.Name)
+	}
+	key.ID = id
+	key.TenantID = tenantID
+	return nil
+}
+
+// GetAPIKeyByID retrieves an API key of the tenant of ctx by its ID
+func (r *APIKeyRepository) GetAPIKeyByID(ctx context.Context, id int64) (*models.V1APIKeyDal, error) {
+	ctx, done := instrument(ctx, "APIKeyRepository", "GetAPIKeyByID")
+	defer done()
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `SELECT * FROM api_keys WHERE tenant_id = $1 AND id = $2`
+	var key models.V1APIKeyDal
+	err = r.db.GetContext(ctx, &key, query, tenantID, id)
+	if err != nil {
+		return nil, reportQueryError(ctx, "GetAPIKeyByID", fmt.Errorf("failed to get api key by ID %d: %w", id, err), "api_key_id", id)
+	}
+	return &key, nil
+}
+
+// GetAPIKeyByPrefix retrieves an API key by its public prefix. Prefixes are unique across
+// tenants and the lookup happens before the tenant is known, so it is not tenant-scoped;
+// the key's tenant is what the request gets bound to.
+func (r *APIKeyRepository) GetAPIKeyByPrefix(ctx context.Context, prefix string) (*models.V1APIKeyDal, error) {
+	ctx, done := instrument(ctx, "APIKeyRepository", "GetAPIKeyByPrefix")
+	defer done()
+	query := `SELECT * FROM api_keys WHERE prefix = $1`
+	var key models.V1APIKeyDal
+	err := r.db.GetContext(ctx, &key, query, prefix)
+	if err != nil {
+		return nil, reportQueryError(ctx, "GetAPIKeyByPrefix", fmt.Errorf("failed to get api key by prefix: %w", err))
+	}
+	return &key, nil
+}
+
+// ListAPIKeys retrieves all API keys of the tenant of ctx, including revoked ones
+func (r *APIKeyRepository) ListAPIKeys(ctx context.Context) ([]models.V1APIKeyDal, error) {
+	ctx, done := instrument(ctx, "APIKeyRepository", "ListAPIKeys")
+	defer done()
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `SELECT * FROM api_keys WHERE tenant_id = $1 ORDER BY id`
+	var keys []models.V1APIKeyDal
+	err = r.db.SelectContext(ctx, &keys, query, tenantID)
+	if err != nil {
+		return nil, reportQueryError(ctx, "ListAPIKeys", fmt.Errorf("failed to list api keys: %w", err))
+	}
+	return keys, nil
+}
+
+// UpdateAPIKeySecret replaces the secret of an API key, invalidating the previous one
+func (r *APIKeyRepository) UpdateAPIKeySecret(ctx context.Context, id int64, prefix, keyHash string, updatedAt time.Time) error {
+	ctx, done := instrument(ctx, "APIKeyRepository", "UpdateAPIKeySecret")
+	defer done()
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return err
+	}
+
+	query := `UPDATE api_keys SET prefix = $2, key_hash = $3, updated_at = $4 WHERE tenant_id = $5 AND id = $1`
+	if _, err := r.db.ExecContext(ctx, query, id, prefix, keyHash, updatedAt, tenantID); err != nil {
+		return reportQueryError(ctx, "UpdateAPIKeySecret", fmt.Errorf("failed to update secret of api key %d: %w", id, err), "api_key_id", id)
+	}
+	return nil
+}
+
+// RevokeAPIKey marks an API key as revoked
+func (r *APIKeyRepository) RevokeAPIKey(ctx context.Context, id int64, revokedAt time.Time) error {
+	ctx, done := instrument(ctx, "APIKeyRepository", "RevokeAPIKey")
+	defer done()
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return err
+	}
+
+	query := `UPDATE api_keys SET revoked_at = $2, updated_at = $2 WHERE tenant_id = $3 AND id = $1 AND revoked_at IS NULL`
+	if _, err := r.db.ExecContext(ctx, query, id, revokedAt, tenantID); err != nil {
+		return reportQueryError(ctx, "RevokeAPIKey", fmt.Errorf("failed to revoke api key %d: %w", id, err), "api_key_id", id)
+	}
+	return nil
+}
+
+// TouchAPIKey records when an API key was last used. Like GetAPIKeyByPrefix it runs
+// during authentication, before the request is bound to a tenant.
+func (r *APIKeyRepository) TouchAPIKey(ctx context.Context, id int64, usedAt time.Time) error {
+	ctx, done := instrument(ctx, "APIKeyRepository", "TouchAPIKey")
+	defer done()
+	query := `UPDATE api_keys SET last_used_at = $2 WHERE id = $1`
+	if _, err := r.db.ExecContext(ctx, query, id, usedAt); err != nil {
+		return reportQueryError(ctx, "TouchAPIKey", fmt.Errorf("failed to update last use of api key %d: %w", id, err), "api_key_id", id)
+	}
+	return nil
+}
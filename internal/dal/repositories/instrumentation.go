@@ -0,0 +1,157 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Lamafout/online-store-api/internal/dal/interfaces"
+	"github.com/Lamafout/online-store-api/internal/metrics"
+	"github.com/Lamafout/online-store-api/internal/tracing"
+	"github.com/jmoiron/sqlx"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/Lamafout/online-store-api/internal/dal/repositories")
+
+// instrument starts the span of a repository method. The returned function ends it and
+// records the method's duration.
+func instrument(ctx context.Context, repository, method string) (context.Context, func()) {
+	start := time.Now()
+	ctx, span := tracer.Start(ctx, repository+"."+method)
+	return ctx, func() {
+		span.End()
+		metrics.ObserveQuery(repository, method, start)
+	}
+}
+
+// tracedExecuter gives every statement its own client span carrying the sanitized
+// statement and the number of rows it returned or affected
+type tracedExecuter struct {
+	db interfaces.DBExecuter
+}
+
+// traced wraps db so that its statements are traced
+func traced(db interfaces.DBExecuter) interfaces.DBExecuter {
+	if _, ok := db.(tracedExecuter); ok {
+		return db
+	}
+	return tracedExecuter{db: db}
+}
+
+func (t tracedExecuter) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	ctx, span := startStatement(ctx, query)
+	res, err := t.db.ExecContext(ctx, query, args...)
+	endStatement(span, res, err)
+	return res, err
+}
+
+func (t tracedExecuter) NamedExecContext(ctx context.Context, query string, arg interface{}) (sql.Result, error) {
+	ctx, span := startStatement(ctx, query)
+	res, err := t.db.NamedExecContext(ctx, query, arg)
+	endStatement(span, res, err)
+	return res, err
+}
+
+func (t tracedExecuter) QueryRowxContext(ctx context.Context, query string, args ...interface{}) *sqlx.Row {
+	ctx, span := startStatement(ctx, query)
+	row := t.db.QueryRowxContext(ctx, query, args...)
+	endStatement(span, nil, row.Err())
+	return row
+}
+
+// QueryxContext only covers the statement up to its first rows; the caller reads
+// the rest after the span has ended
+func (t tracedExecuter) QueryxContext(ctx context.Context, query string, args ...interface{}) (*sqlx.Rows, error) {
+	ctx, span := startStatement(ctx, query)
+	rows, err := t.db.QueryxContext(ctx, query, args...)
+	endStatement(span, nil, err)
+	return rows, err
+}
+
+func (t tracedExecuter) SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	ctx, span := startStatement(ctx, query)
+	err := t.db.SelectContext(ctx, dest, query, args...)
+	if err == nil {
+		if v := reflect.Indirect(reflect.ValueOf(dest)); v.Kind() == reflect.Slice {
+			span.SetAttributes(semconv.DBResponseReturnedRows(v.Len()))
+		}
+	}
+	endStatement(span, nil, err)
+	return err
+}
+
+func (t tracedExecuter) GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	ctx, span := startStatement(ctx, query)
+	err := t.db.GetContext(ctx, dest, query, args...)
+	switch {
+	case err == nil:
+		span.SetAttributes(semconv.DBResponseReturnedRows(1))
+		endStatement(span, nil, nil)
+	case errors.Is(err, sql.ErrNoRows):
+		// Finding nothing is an answer, not a failure
+		span.SetAttributes(semconv.DBResponseReturnedRows(0))
+		endStatement(span, nil, nil)
+	default:
+		endStatement(span, nil, err)
+	}
+	return err
+}
+
+// rowsAffectedKey records how many rows a statement changed
+const rowsAffectedKey = "db.response.rows_affected"
+
+func startStatement(ctx context.Context, query string) (context.Context, trace.Span) {
+	statement := sanitizeStatement(query)
+	operation := statementOperation(statement)
+	return tracer.Start(ctx, operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemNamePostgreSQL,
+			semconv.DBOperationName(operation),
+			semconv.DBQueryText(statement),
+		),
+	)
+}
+
+func endStatement(span trace.Span, res sql.Result, err error) {
+	if res != nil && err == nil {
+		if affected, rowsErr := res.RowsAffected(); rowsErr == nil {
+			span.SetAttributes(attribute.Int64(rowsAffectedKey, affected))
+		}
+	}
+	tracing.End(span, err)
+}
+
+var (
+	whitespace = regexp.MustCompile(`\s+`)
+	// literals matches quoted strings and numbers, but not placeholders such as $1
+	literals = regexp.MustCompile(`'(?:[^']|'')*'|\$?\b\d+(?:\.\d+)?\b`)
+)
+
+// sanitizeStatement collapses whitespace and replaces literals with ?, so spans never
+// carry values even where a statement inlines one. Arguments are sent separately and
+// are never recorded.
+func sanitizeStatement(query string) string {
+	query = whitespace.ReplaceAllString(strings.TrimSpace(query), " ")
+	return literals.ReplaceAllStringFunc(query, func(literal string) string {
+		if strings.HasPrefix(literal, "$") {
+			return literal
+		}
+		return "?"
+	})
+}
+
+// statementOperation returns the leading keyword of a statement, such as SELECT or
+// INSERT, which names its span. Statements starting with a CTE are named WITH.
+func statementOperation(statement string) string {
+	operation, _, _ := strings.Cut(statement, " ")
+	return strings.ToUpper(operation)
+}
@@ -0,0 +1,75 @@
+package repositories
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Lamafout/online-store-api/internal/tenant"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestSanitizeStatement(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{
+			query: "\n\t\tSELECT *\n\t\tFROM orders WHERE tenant_id = $1 AND id = $2",
+			want:  "SELECT * FROM orders WHERE tenant_id = $1 AND id = $2",
+		},
+		{
+			query: "UPDATE jobs SET status = 'failed', attempts = attempts + 1 WHERE lease < now() - interval '1.5 seconds'",
+			want:  "UPDATE jobs SET status = ?, attempts = attempts + ? WHERE lease < now() - interval ?",
+		},
+		{
+			query: "FETCH FORWARD 500 FROM orders_cursor",
+			want:  "FETCH FORWARD ? FROM orders_cursor",
+		},
+		{
+			query: "SELECT $1::text, * FROM unnest($2::bigint[], $3::timestamptz[])",
+			want:  "SELECT $1::text, * FROM unnest($2::bigint[], $3::timestamptz[])",
+		},
+	}
+
+	for _, tt := range tests {
+		if got := sanitizeStatement(tt.query); got != tt.want {
+			t.Errorf("sanitizeStatement(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestRepositoryCallsAreTraced(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	db, _ := newRecordingDB(t)
+	ctx := tenant.WithTenant(context.Background(), testTenant)
+	if _, err := NewOrderRepository(db).GetOrderByID(ctx, 42); err == nil {
+		t.Fatal("expected no rows from the recording database")
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want the method and its statement", len(spans))
+	}
+	statement, method := spans[0], spans[1]
+	if method.Name() != "OrderRepository.GetOrderByID" {
+		t.Fatalf("method span = %q", method.Name())
+	}
+	if statement.Name() != "SELECT" || statement.Parent().SpanID() != method.SpanContext().SpanID() {
+		t.Fatalf("statement span %q is not a child of the method span", statement.Name())
+	}
+
+	attributes := make(map[string]any)
+	for _, kv := range statement.Attributes() {
+		attributes[string(kv.Key)] = kv.Value.AsInterface()
+	}
+	if attributes["db.query.text"] != "SELECT * FROM orders WHERE tenant_id = $1 AND id = $2" {
+		t.Fatalf("db.query.text = %v", attributes["db.query.text"])
+	}
+	if attributes["db.response.returned_rows"] != int64(0) {
+		t.Fatalf("db.response.returned_rows = %v, want 0", attributes["db.response.returned_rows"])
+	}
+}
@@ -0,0 +1,6 @@
+// Package integration verifies the SQL of the repositories against a real Postgres,
+// directly and through the services, including the row-level security policies that
+// isolate tenants. Every test migrates a schema of its own, dropped when it finishes,
+// and most work inside a transaction that is rolled back, so tests can run in parallel
+// against a shared database. The tests are skipped unless TEST_DB_DSN is set.
+package integration
@@ -0,0 +1,197 @@
+package integration
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	core "github.com/Lamafout/online-store-api/core/models/common"
+	"github.com/Lamafout/online-store-api/internal/bll/services"
+	"github.com/Lamafout/online-store-api/internal/dal/interfaces"
+	dal "github.com/Lamafout/online-store-api/internal/dal/unit_of_work"
+	"github.com/Lamafout/online-store-api/internal/tenant"
+	"github.com/jmoiron/sqlx"
+)
+
+// expiredLease makes a claim or progress update leave the job available to the next
+// worker right away
+const expiredLease = -time.Second
+
+func coreOrders(customerIDs ...int64) []*core.Order {
+	orders := make([]*core.Order, len(customerIDs))
+	for i, customerID := range customerIDs {
+		orders[i] = &core.Order{
+			CustomerID:         customerID,
+			DeliveryAddress:    "1 Test Street",
+			TotalPriceCents:    500,
+			TotalPriceCurrency: "USD",
+			Items: []core.OrderItem{{
+				ProductID:     1,
+				Quantity:      1,
+				ProductTitle:  "Product",
+				ProductURL:    "https://example.com/product",
+				PriceCents:    500,
+				PriceCurrency: "USD",
+			}},
+		}
+	}
+	return orders
+}
+
+// newJobTest enqueues a batch-create job of orders on a fresh schema. Jobs are claimed
+// in their own transactions, so the test works on committed data instead of inside
+// newTestTx.
+func newJobTest(t *testing.T, orders []*core.Order) (context.Context, *sqlx.DB, *dal.UnitOfWorkFactory, *services.JobService) {
+	t.Helper()
+	db := newTestDB(t)
+	ctx := tenant.WithTenant(context.Background(), testTenant)
+	factory := dal.NewUnitOfWorkFactory(db, nil, false)
+	jobs := services.NewJobService(services.NewOrderService())
+
+	if _, err := jobs.EnqueueBatchCreateOrders(ctx, factory.Create(), orders, "user-1"); err != nil {
+		t.Fatalf("EnqueueBatchCreateOrders() error = %v", err)
+	}
+	return ctx, db, factory, jobs
+}
+
+func claimJob(t *testing.T, ctx context.Context, factory *dal.UnitOfWorkFactory, jobs *services.JobService, lease time.Duration) *core.Job {
+	t.Helper()
+	job, _, err := jobs.ClaimNextJob(ctx, factory.Create(), lease, 3)
+	if err != nil {
+		t.Fatalf("ClaimNextJob() error = %v", err)
+	}
+	return job
+}
+
+func countOrders(t *testing.T, ctx context.Context, db *sqlx.DB) int {
+	t.Helper()
+	var count int
+	if err := db.GetContext(ctx, &count, `SELECT count(*) FROM orders`); err != nil {
+		t.Fatalf("failed to count orders: %v", err)
+	}
+	return count
+}
+
+func TestJobWorkerLosingTheLeaseWritesNothing(t *testing.T) {
+	t.Parallel()
+	orders := coreOrders(1, 2, 3)
+	ctx, db, factory, jobs := newJobTest(t, orders)
+
+	// Worker A's lease runs out while it works on the first chunk, and worker B claims
+	// the job again
+	a := claimJob(t, ctx, factory, jobs, expiredLease)
+	b := claimJob(t, ctx, factory, jobs, time.Minute)
+	if a == nil || b == nil || a.ID != b.ID || b.Attempts != a.Attempts+1 {
+		t.Fatalf("claims = %+v, %+v, want the same job twice", a, b)
+	}
+
+	uow := factory.Create()
+	err := uow.WithinTransaction(ctx, func(ctx context.Context) error {
+		return jobs.ProcessBatchCreateChunk(ctx, uow, a, orders, time.Minute)
+	})
+	if !errors.Is(err, interfaces.ErrJobLeaseLost) {
+		t.Fatalf("chunk of worker A error = %v, want ErrJobLeaseLost", err)
+	}
+	if got := countOrders(t, ctx, db); got != 0 {
+		t.Fatalf("worker A left %d orders behind", got)
+	}
+
+	uow = factory.Create()
+	err = uow.WithinTransaction(ctx, func(ctx context.Context) error {
+		return jobs.ProcessBatchCreateChunk(ctx, uow, b, orders, time.Minute)
+	})
+	if err != nil {
+		t.Fatalf("chunk of worker B error = %v", err)
+	}
+	if err := jobs.FinishJob(ctx, factory.Create(), b, nil); err != nil {
+		t.Fatalf("FinishJob() of worker B error = %v", err)
+	}
+	if err := jobs.FinishJob(ctx, factory.Create(), a, errors.New("late failure")); !errors.Is(err, interfaces.ErrJobLeaseLost) {
+		t.Fatalf("FinishJob() of worker A error = %v, want ErrJobLeaseLost", err)
+	}
+
+	job, err := jobs.GetJob(ctx, factory.Create(), b.ID)
+	if err != nil {
+		t.Fatalf("GetJob() error = %v", err)
+	}
+	if job.Status != core.JobStatusCompleted || job.ProcessedItems != len(orders) || len(job.Results) != len(orders) {
+		t.Fatalf("job = %+v, want it completed by worker B", job)
+	}
+	if got := countOrders(t, ctx, db); got != len(orders) {
+		t.Fatalf("orders = %d, want %d", got, len(orders))
+	}
+}
+
+func TestJobWorkerCannotReclaimJobDuringChunk(t *testing.T) {
+	t.Parallel()
+	orders := coreOrders(1, 2)
+	ctx, db, factory, jobs := newJobTest(t, orders)
+
+	a := claimJob(t, ctx, factory, jobs, expiredLease)
+	if a == nil {
+		t.Fatal("ClaimNextJob() found no job")
+	}
+
+	// While worker A's chunk transaction holds the job row, worker B skips the job even
+	// though its lease expired
+	uow := factory.Create()
+	err := uow.WithinTransaction(ctx, func(ctx context.Context) error {
+		if err := jobs.ProcessBatchCreateChunk(ctx, uow, a, orders, expiredLease); err != nil {
+			return err
+		}
+		if b := claimJob(t, ctx, factory, jobs, time.Minute); b != nil {
+			t.Errorf("worker B claimed job %d in the middle of a chunk", b.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("chunk of worker A error = %v", err)
+	}
+
+	// Once the chunk is committed, B resumes after it instead of writing it again
+	b := claimJob(t, ctx, factory, jobs, time.Minute)
+	if b == nil || b.ProcessedItems != len(orders) {
+		t.Fatalf("claim after the chunk = %+v, want the job with its progress", b)
+	}
+	if err := jobs.FinishJob(ctx, factory.Create(), a, nil); !errors.Is(err, interfaces.ErrJobLeaseLost) {
+		t.Fatalf("FinishJob() of worker A error = %v, want ErrJobLeaseLost", err)
+	}
+	if got := countOrders(t, ctx, db); got != len(orders) {
+		t.Fatalf("orders = %d, want %d", got, len(orders))
+	}
+}
+
+func TestJobResultsKeepErrorCodesAndFieldErrors(t *testing.T) {
+	t.Parallel()
+	orders := coreOrders(1, 2)
+	orders[1].Items[0].ProductURL = "not a url"
+	ctx, _, factory, jobs := newJobTest(t, orders)
+
+	job := claimJob(t, ctx, factory, jobs, time.Minute)
+	uow := factory.Create()
+	err := uow.WithinTransaction(ctx, func(ctx context.Context) error {
+		return jobs.ProcessBatchCreateChunk(ctx, uow, job, orders, time.Minute)
+	})
+	if err != nil {
+		t.Fatalf("ProcessBatchCreateChunk() error = %v", err)
+	}
+	if err := jobs.FinishJob(ctx, factory.Create(), job, errors.New("connection refused")); err != nil {
+		t.Fatalf("FinishJob() error = %v", err)
+	}
+
+	stored, err := jobs.GetJob(ctx, factory.Create(), job.ID)
+	if err != nil {
+		t.Fatalf("GetJob() error = %v", err)
+	}
+	if stored.ErrorCode != "internal_error" || stored.Error == "connection refused" {
+		t.Fatalf("job error = %q %q, want a client-safe internal error", stored.ErrorCode, stored.Error)
+	}
+	if len(stored.Results) != 2 || stored.Results[0].OrderID == 0 || stored.Results[0].ErrorCode != "" {
+		t.Fatalf("results = %+v, want the first order created", stored.Results)
+	}
+	rejected := stored.Results[1]
+	if rejected.ErrorCode != services.CodeValidationFailed || len(rejected.Errors) != 1 || rejected.Errors[0].FieldPath != "items[0].product_url" {
+		t.Fatalf("rejected result = %+v, want the field error of items[0].product_url", rejected)
+	}
+}
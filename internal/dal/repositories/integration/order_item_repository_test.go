@@ -0,0 +1,158 @@
+package integration
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/Lamafout/online-store-api/internal/dal/models"
+	"github.com/Lamafout/online-store-api/internal/dal/repositories"
+	"github.com/Lamafout/online-store-api/internal/tenant"
+	"github.com/jmoiron/sqlx"
+)
+
+func bulkItems(orderIDs ...int64) []models.BulkOrderItemDalModel {
+	items := make([]models.BulkOrderItemDalModel, len(orderIDs))
+	for i, orderID := range orderIDs {
+		items[i] = models.BulkOrderItemDalModel{
+			OrderID:       orderID,
+			ProductID:     int64(i + 1),
+			Quantity:      i + 1,
+			ProductTitle:  "Product",
+			ProductURL:    "https://example.com/product",
+			PriceCents:    500,
+			PriceCurrency: "USD",
+			CreatedAt:     testTime,
+			UpdatedAt:     testTime,
+		}
+	}
+	return items
+}
+
+func itemIDs(items []models.V1OrderItemDal) []int64 {
+	ids := make([]int64, len(items))
+	for i, item := range items {
+		ids[i] = item.ID
+	}
+	return ids
+}
+
+// insertOrders creates one order per customer ID and returns their IDs
+func insertOrders(t *testing.T, ctx context.Context, tx *sqlx.Tx, customerIDs ...int64) []int64 {
+	t.Helper()
+	orders, err := repositories.NewOrderRepository(tx).BulkInsertOrders(ctx, bulkOrders(customerIDs...))
+	if err != nil {
+		t.Fatalf("BulkInsertOrders() error = %v", err)
+	}
+	return orderIDs(orders)
+}
+
+func TestOrderItemRepositoryCreateAndGet(t *testing.T) {
+	t.Parallel()
+	ctx, tx := newTestTx(t)
+	repo := repositories.NewOrderItemRepository(tx)
+	orders := insertOrders(t, ctx, tx, 1, 2)
+
+	item := &models.V1OrderItemDal{
+		OrderID:       orders[0],
+		ProductID:     7,
+		Quantity:      3,
+		ProductTitle:  "Book",
+		ProductURL:    "https://example.com/book",
+		PriceCents:    1999,
+		PriceCurrency: "EUR",
+		CreatedAt:     testTime,
+		UpdatedAt:     testTime,
+	}
+	if err := repo.CreateOrderItem(ctx, item); err != nil {
+		t.Fatalf("CreateOrderItem() error = %v", err)
+	}
+	if item.ID == 0 || item.TenantID != testTenant {
+		t.Fatalf("CreateOrderItem() left ID = %d, tenant = %q", item.ID, item.TenantID)
+	}
+	if _, err := repo.BulkInsertOrderItems(ctx, bulkItems(orders[1])); err != nil {
+		t.Fatalf("BulkInsertOrderItems() error = %v", err)
+	}
+
+	got, err := repo.GetOrderItemsByOrderID(ctx, orders[0])
+	if err != nil {
+		t.Fatalf("GetOrderItemsByOrderID() error = %v", err)
+	}
+	if len(got) != 1 || got[0].ID != item.ID || got[0].ProductID != 7 || got[0].Quantity != 3 ||
+		got[0].PriceCents != 1999 || got[0].PriceCurrency != "EUR" || !got[0].CreatedAt.Equal(testTime) {
+		t.Fatalf("GetOrderItemsByOrderID() = %+v, want %+v", got, item)
+	}
+
+	other := tenant.WithTenant(context.Background(), "tenant-b")
+	if got, err := repo.GetOrderItemsByOrderID(other, orders[0]); err != nil || len(got) != 0 {
+		t.Fatalf("GetOrderItemsByOrderID() from another tenant = %+v, %v", got, err)
+	}
+}
+
+func TestOrderItemRepositoryBulkInsertKeepsInputOrder(t *testing.T) {
+	t.Parallel()
+	ctx, tx := newTestTx(t)
+	repo := repositories.NewOrderItemRepository(tx)
+	orders := insertOrders(t, ctx, tx, 1, 2)
+
+	input := bulkItems(orders[1], orders[0], orders[1])
+	inserted, err := repo.BulkInsertOrderItems(ctx, input)
+	if err != nil {
+		t.Fatalf("BulkInsertOrderItems() error = %v", err)
+	}
+	if len(inserted) != len(input) {
+		t.Fatalf("BulkInsertOrderItems() returned %d items, want %d", len(inserted), len(input))
+	}
+	for i, item := range inserted {
+		if item.OrderID != input[i].OrderID || item.ProductID != input[i].ProductID || item.Quantity != input[i].Quantity || item.TenantID != testTenant {
+			t.Errorf("item %d = %+v, want %+v", i, item, input[i])
+		}
+	}
+}
+
+func TestOrderItemRepositoryQueryOrderItems(t *testing.T) {
+	t.Parallel()
+	ctx, tx := newTestTx(t)
+	repo := repositories.NewOrderItemRepository(tx)
+	orders := insertOrders(t, ctx, tx, 1, 2, 3)
+
+	inserted, err := repo.BulkInsertOrderItems(ctx, bulkItems(orders[0], orders[1], orders[0], orders[2], orders[1]))
+	if err != nil {
+		t.Fatalf("BulkInsertOrderItems() error = %v", err)
+	}
+	ids := itemIDs(inserted)
+
+	tests := []struct {
+		name string
+		req  models.QueryOrderItemsDalModel
+		want []int64
+	}{
+		{"all", models.QueryOrderItemsDalModel{}, ids},
+		{"by orders", models.QueryOrderItemsDalModel{OrderIDs: []int64{orders[1], orders[2]}}, []int64{ids[1], ids[3], ids[4]}},
+		{"by ids", models.QueryOrderItemsDalModel{IDs: []int64{ids[4], ids[2]}}, []int64{ids[2], ids[4]}},
+		{"second page", models.QueryOrderItemsDalModel{Limit: 2, Offset: 2}, ids[2:4]},
+		{"page of an order", models.QueryOrderItemsDalModel{OrderIDs: []int64{orders[0]}, Limit: 1, Offset: 1}, []int64{ids[2]}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			items, err := repo.QueryOrderItems(ctx, &tt.req)
+			if err != nil {
+				t.Fatalf("QueryOrderItems() error = %v", err)
+			}
+			if got := itemIDs(items); !slices.Equal(got, tt.want) {
+				t.Fatalf("QueryOrderItems() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOrderItemRepositoryRequiresExistingOrder(t *testing.T) {
+	t.Parallel()
+	ctx, tx := newTestTx(t)
+	repo := repositories.NewOrderItemRepository(tx)
+
+	// The failed statement aborts the transaction, so this has to be the last one
+	if _, err := repo.BulkInsertOrderItems(ctx, bulkItems(12345)); err == nil {
+		t.Fatal("BulkInsertOrderItems() for a missing order succeeded")
+	}
+}
@@ -0,0 +1,177 @@
+package integration
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/Lamafout/online-store-api/internal/dal/models"
+	"github.com/Lamafout/online-store-api/internal/dal/repositories"
+	"github.com/Lamafout/online-store-api/internal/tenant"
+)
+
+// testTime is a timestamp Postgres stores without losing precision
+var testTime = time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+
+func bulkOrders(customerIDs ...int64) []models.BulkOrderDalModel {
+	orders := make([]models.BulkOrderDalModel, len(customerIDs))
+	for i, customerID := range customerIDs {
+		orders[i] = models.BulkOrderDalModel{
+			CustomerID:         customerID,
+			DeliveryAddress:    "1 Test Street",
+			TotalPriceCents:    int64(100 * (i + 1)),
+			TotalPriceCurrency: "USD",
+			CreatedAt:          testTime,
+			UpdatedAt:          testTime,
+		}
+	}
+	return orders
+}
+
+func orderIDs(orders []models.V1OrderDal) []int64 {
+	ids := make([]int64, len(orders))
+	for i, order := range orders {
+		ids[i] = order.ID
+	}
+	return ids
+}
+
+func TestOrderRepositoryCreateAndGet(t *testing.T) {
+	t.Parallel()
+	ctx, tx := newTestTx(t)
+	repo := repositories.NewOrderRepository(tx)
+
+	order := &models.V1OrderDal{
+		CustomerID:         42,
+		DeliveryAddress:    "1 Test Street",
+		TotalPriceCents:    1250,
+		TotalPriceCurrency: "EUR",
+		CreatedAt:          testTime,
+		UpdatedAt:          testTime.Add(time.Minute),
+	}
+	if err := repo.CreateOrder(ctx, order); err != nil {
+		t.Fatalf("CreateOrder() error = %v", err)
+	}
+	if order.ID == 0 || order.TenantID != testTenant {
+		t.Fatalf("CreateOrder() left ID = %d, tenant = %q", order.ID, order.TenantID)
+	}
+
+	got, err := repo.GetOrderByID(ctx, order.ID)
+	if err != nil {
+		t.Fatalf("GetOrderByID() error = %v", err)
+	}
+	if got.CustomerID != 42 || got.DeliveryAddress != order.DeliveryAddress || got.TotalPriceCents != 1250 ||
+		got.TotalPriceCurrency != "EUR" || !got.CreatedAt.Equal(order.CreatedAt) || !got.UpdatedAt.Equal(order.UpdatedAt) {
+		t.Fatalf("GetOrderByID() = %+v, want %+v", got, order)
+	}
+
+	if _, err := repo.GetOrderByID(ctx, order.ID+1000); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("GetOrderByID() of a missing order error = %v, want sql.ErrNoRows", err)
+	}
+
+	other := tenant.WithTenant(context.Background(), "tenant-b")
+	if _, err := repo.GetOrderByID(other, order.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("GetOrderByID() from another tenant error = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func TestOrderRepositoryBulkInsertKeepsInputOrder(t *testing.T) {
+	t.Parallel()
+	ctx, tx := newTestTx(t)
+	repo := repositories.NewOrderRepository(tx)
+
+	input := bulkOrders(5, 3, 9, 1)
+	inserted, err := repo.BulkInsertOrders(ctx, input)
+	if err != nil {
+		t.Fatalf("BulkInsertOrders() error = %v", err)
+	}
+	if len(inserted) != len(input) {
+		t.Fatalf("BulkInsertOrders() returned %d orders, want %d", len(inserted), len(input))
+	}
+	// Rows come back in input order, whichever ids they were given
+	seen := make(map[int64]bool)
+	for i, order := range inserted {
+		if order.CustomerID != input[i].CustomerID || order.TotalPriceCents != input[i].TotalPriceCents || order.TenantID != testTenant {
+			t.Errorf("order %d = %+v, want %+v", i, order, input[i])
+		}
+		if order.ID == 0 || seen[order.ID] {
+			t.Errorf("order %d has ID %d, which is missing or taken", i, order.ID)
+		}
+		seen[order.ID] = true
+	}
+}
+
+func TestOrderRepositoryQueryOrders(t *testing.T) {
+	t.Parallel()
+	ctx, tx := newTestTx(t)
+	repo := repositories.NewOrderRepository(tx)
+
+	inserted, err := repo.BulkInsertOrders(ctx, bulkOrders(1, 2, 1, 2, 1))
+	if err != nil {
+		t.Fatalf("BulkInsertOrders() error = %v", err)
+	}
+	ids := orderIDs(inserted)
+
+	// Orders of another tenant never show up
+	other := tenant.WithTenant(context.Background(), "tenant-b")
+	if _, err := repo.BulkInsertOrders(other, bulkOrders(1, 2)); err != nil {
+		t.Fatalf("BulkInsertOrders() error = %v", err)
+	}
+
+	tests := []struct {
+		name string
+		req  models.QueryOrdersDalModel
+		want []int64
+	}{
+		{"all", models.QueryOrdersDalModel{}, ids},
+		{"first page", models.QueryOrdersDalModel{Limit: 2}, ids[:2]},
+		{"last page", models.QueryOrdersDalModel{Limit: 2, Offset: 4}, ids[4:]},
+		{"by customer", models.QueryOrdersDalModel{CustomerIDs: []int64{2}}, []int64{ids[1], ids[3]}},
+		{"by ids", models.QueryOrdersDalModel{IDs: []int64{ids[4], ids[0]}}, []int64{ids[0], ids[4]}},
+		{"by ids and customer", models.QueryOrdersDalModel{IDs: ids[:3], CustomerIDs: []int64{1}}, []int64{ids[0], ids[2]}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			orders, err := repo.QueryOrders(ctx, &tt.req)
+			if err != nil {
+				t.Fatalf("QueryOrders() error = %v", err)
+			}
+			if got := orderIDs(orders); !slices.Equal(got, tt.want) {
+				t.Fatalf("QueryOrders() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOrderRepositoryIterateOrders(t *testing.T) {
+	t.Parallel()
+	ctx, tx := newTestTx(t)
+	repo := repositories.NewOrderRepository(tx)
+
+	inserted, err := repo.BulkInsertOrders(ctx, bulkOrders(1, 1, 2, 1, 1))
+	if err != nil {
+		t.Fatalf("BulkInsertOrders() error = %v", err)
+	}
+
+	var batches [][]int64
+	err = repo.IterateOrders(ctx, &models.QueryOrdersDalModel{CustomerIDs: []int64{1}, Limit: 1}, 2, func(orders []models.V1OrderDal) error {
+		batches = append(batches, orderIDs(orders))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("IterateOrders() error = %v", err)
+	}
+
+	want := [][]int64{{inserted[0].ID, inserted[1].ID}, {inserted[3].ID, inserted[4].ID}}
+	if len(batches) != len(want) || !slices.Equal(batches[0], want[0]) || !slices.Equal(batches[1], want[1]) {
+		t.Fatalf("IterateOrders() batches = %v, want %v", batches, want)
+	}
+
+	// The cursor is closed, so the transaction can iterate again
+	if err := repo.IterateOrders(ctx, &models.QueryOrdersDalModel{}, 10, func([]models.V1OrderDal) error { return nil }); err != nil {
+		t.Fatalf("second IterateOrders() error = %v", err)
+	}
+}
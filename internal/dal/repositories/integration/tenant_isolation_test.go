@@ -0,0 +1,209 @@
+package integration
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"testing"
+
+	core "github.com/Lamafout/online-store-api/core/models/common"
+	"github.com/Lamafout/online-store-api/core/models/dto"
+	"github.com/Lamafout/online-store-api/internal/bll/services"
+	"github.com/Lamafout/online-store-api/internal/dal/models"
+	dal "github.com/Lamafout/online-store-api/internal/dal/unit_of_work"
+	"github.com/Lamafout/online-store-api/internal/tenant"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/jmoiron/sqlx"
+)
+
+// newRestrictedDB returns a pool on the schema of db that acts as a new role not owning
+// the tables, which row-level security only applies to. The test is skipped when the
+// user of TEST_DB_DSN may not create roles.
+func newRestrictedDB(t *testing.T, db *sqlx.DB) *sqlx.DB {
+	t.Helper()
+	ctx := context.Background()
+
+	var schema string
+	if err := db.GetContext(ctx, &schema, "SELECT current_schema()"); err != nil {
+		t.Fatalf("failed to read schema: %v", err)
+	}
+	// Roles and schemas live in different namespaces, so the unique schema name serves
+	// as the role name as well
+	role := pgx.Identifier{schema}.Sanitize()
+	if _, err := db.ExecContext(ctx, "CREATE ROLE "+role+" NOLOGIN"); err != nil {
+		t.Skipf("cannot create a role for row-level security: %v", err)
+	}
+	t.Cleanup(func() {
+		if _, err := db.ExecContext(ctx, "DROP OWNED BY "+role); err != nil {
+			t.Errorf("failed to drop privileges of role %s: %v", schema, err)
+		}
+		if _, err := db.ExecContext(ctx, "DROP ROLE "+role); err != nil {
+			t.Errorf("failed to drop role %s: %v", schema, err)
+		}
+	})
+	for _, statement := range []string{
+		"GRANT " + role + " TO CURRENT_USER",
+		"GRANT USAGE ON SCHEMA " + role + " TO " + role,
+		"GRANT SELECT, INSERT ON orders, order_items TO " + role,
+		"GRANT USAGE ON ALL SEQUENCES IN SCHEMA " + role + " TO " + role,
+	} {
+		if _, err := db.ExecContext(ctx, statement); err != nil {
+			t.Fatalf("failed to set up role %s: %v", schema, err)
+		}
+	}
+
+	config, err := pgx.ParseConfig(os.Getenv(dsnEnv))
+	if err != nil {
+		t.Fatalf("invalid %s: %v", dsnEnv, err)
+	}
+	config.RuntimeParams["search_path"] = schema
+	config.RuntimeParams["role"] = schema
+	restricted := sqlx.NewDb(stdlib.OpenDB(*config), "pgx")
+	t.Cleanup(func() { restricted.Close() })
+	return restricted
+}
+
+// createTenantOrders creates two orders with three items in the tenant of ctx
+func createTenantOrders(t *testing.T, ctx context.Context, service *services.OrderService, factory *dal.UnitOfWorkFactory) []int64 {
+	t.Helper()
+	order := func(customerID int64, prices ...int64) *core.Order {
+		order := &core.Order{CustomerID: customerID, DeliveryAddress: "1 Test Street", TotalPriceCurrency: "USD"}
+		for i, price := range prices {
+			order.Items = append(order.Items, core.OrderItem{
+				ProductID:     int64(i + 1),
+				Quantity:      1,
+				ProductTitle:  "Product",
+				ProductURL:    "https://example.com/product",
+				PriceCents:    price,
+				PriceCurrency: "USD",
+			})
+			order.TotalPriceCents += price
+		}
+		return order
+	}
+
+	uow := factory.Create()
+	var created []*core.Order
+	if err := uow.WithinTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		created, err = service.BatchCreateOrders(ctx, uow, []*core.Order{order(7, 100, 200), order(8, 300)})
+		return err
+	}); err != nil {
+		t.Fatalf("BatchCreateOrders() error = %v", err)
+	}
+	return []int64{created[0].ID, created[1].ID}
+}
+
+// assertTenantSeesNothing reads the orders ids through every read path as the tenant
+// of ctx, which does not own them
+func assertTenantSeesNothing(t *testing.T, ctx context.Context, service *services.OrderService, factory *dal.UnitOfWorkFactory, ids []int64) {
+	t.Helper()
+	read := func(fn func(uow dal.UnitOfWork) error) {
+		t.Helper()
+		uow := factory.Create()
+		if err := uow.WithinTransactionTx(ctx, &sql.TxOptions{ReadOnly: true}, func(context.Context) error {
+			return fn(uow)
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, id := range ids {
+		read(func(uow dal.UnitOfWork) error {
+			var notFound *services.NotFoundError
+			if order, err := service.GetOrder(ctx, uow, id); !errors.As(err, &notFound) {
+				t.Fatalf("GetOrder(%d) from another tenant = %+v, %v; want NotFoundError", id, order, err)
+			}
+			items, err := uow.GetOrderItemRepo().GetOrderItemsByOrderID(ctx, id)
+			if err != nil || len(items) != 0 {
+				t.Fatalf("GetOrderItemsByOrderID(%d) from another tenant = %+v, %v; want none", id, items, err)
+			}
+			return nil
+		})
+	}
+
+	queries := []*dto.V1QueryOrdersRequest{
+		{IncludeOrderItems: true},
+		{IDs: ids, IncludeOrderItems: true},
+		{CustomerIDs: []int64{7}, IncludeOrderItems: true},
+	}
+	for _, req := range queries {
+		read(func(uow dal.UnitOfWork) error {
+			found, err := service.QueryOrders(ctx, uow, req)
+			if err != nil || len(found) != 0 {
+				t.Fatalf("QueryOrders(%+v) from another tenant = %+v, %v; want none", req, found, err)
+			}
+			exported := 0
+			if err := service.ExportOrders(ctx, uow, req, 10, func(batch []*core.Order) error {
+				exported += len(batch)
+				return nil
+			}); err != nil || exported != 0 {
+				t.Fatalf("ExportOrders(%+v) from another tenant = %d orders, %v; want none", req, exported, err)
+			}
+			return nil
+		})
+	}
+	read(func(uow dal.UnitOfWork) error {
+		items, err := uow.GetOrderItemRepo().QueryOrderItems(ctx, &models.QueryOrderItemsDalModel{OrderIDs: ids})
+		if err != nil || len(items) != 0 {
+			t.Fatalf("QueryOrderItems() from another tenant = %+v, %v; want none", items, err)
+		}
+		return nil
+	})
+}
+
+func TestOrdersAreIsolatedBetweenTenants(t *testing.T) {
+	t.Parallel()
+	db := newTestDB(t)
+	service := services.NewOrderService()
+	factory := dal.NewUnitOfWorkFactory(db, nil, false)
+
+	owner := tenant.WithTenant(context.Background(), testTenant)
+	ids := createTenantOrders(t, owner, service, factory)
+	assertTenantSeesNothing(t, tenant.WithTenant(context.Background(), "tenant-b"), service, factory, ids)
+
+	found, err := service.QueryOrders(owner, factory.Create(), &dto.V1QueryOrdersRequest{IDs: ids, IncludeOrderItems: true})
+	if err != nil || len(found) != 2 || len(found[0].Items)+len(found[1].Items) != 3 {
+		t.Fatalf("QueryOrders() from the owner = %+v, %v; want both orders with their items", found, err)
+	}
+}
+
+func TestOrdersAreIsolatedBetweenTenantsWithRowLevelSecurity(t *testing.T) {
+	t.Parallel()
+	db := newRestrictedDB(t, newTestDB(t))
+	service := services.NewOrderService()
+	factory := dal.NewUnitOfWorkFactory(db, nil, true)
+
+	owner := tenant.WithTenant(context.Background(), testTenant)
+	ids := createTenantOrders(t, owner, service, factory)
+	assertTenantSeesNothing(t, tenant.WithTenant(context.Background(), "tenant-b"), service, factory, ids)
+
+	// The policies filter on their own, without the predicate of the repositories
+	count := func(tenantID string) (orders, items int) {
+		t.Helper()
+		tx, err := db.BeginTxx(context.Background(), &sql.TxOptions{ReadOnly: true})
+		if err != nil {
+			t.Fatalf("failed to begin transaction: %v", err)
+		}
+		defer tx.Rollback()
+		if tenantID != "" {
+			if _, err := tx.Exec("SELECT set_config('app.tenant_id', $1, true)", tenantID); err != nil {
+				t.Fatalf("failed to set tenant: %v", err)
+			}
+		}
+		if err := tx.QueryRow("SELECT (SELECT count(*) FROM orders), (SELECT count(*) FROM order_items)").Scan(&orders, &items); err != nil {
+			t.Fatalf("failed to count rows: %v", err)
+		}
+		return orders, items
+	}
+	if orders, items := count(testTenant); orders != 2 || items != 3 {
+		t.Fatalf("owner sees %d orders and %d items, want 2 and 3", orders, items)
+	}
+	for _, tenantID := range []string{"tenant-b", ""} {
+		if orders, items := count(tenantID); orders != 0 || items != 0 {
+			t.Fatalf("tenant %q sees %d orders and %d items, want none", tenantID, orders, items)
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Lamafout/online-store-api/internal/dal/interfaces"
+	"github.com/Lamafout/online-store-api/internal/dal/models"
+	"github.com/Lamafout/online-store-api/internal/tenant"
+)
+
+// JobRepository handles database operations for background jobs
+type JobRepository struct {
+	db interfaces.DBExecuter
+}
+
+// NewJobRepository creates a new JobRepository
+func NewJobRepository(db interfaces.DBExecuter) *JobRepository {
+	return &JobRepository{db: traced(db)}
+}
+
+// CreateJob inserts a new pending job in the tenant of ctx
+func (r *JobRepository) CreateJob(ctx context.Context, job *models.V1JobDal) error {
+	ctx, done := instrument(ctx, "JobRepository", "CreateJob")
+	defer done()
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO jobs (tenant_id, type, status, payload, total_items, created_by, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id`
+	var id int64
+	err = r.db.QueryRowxContext(ctx, query, tenantID, job.Type, job.Status, job.Payload, job.TotalItems, job.CreatedBy, job.CreatedAt, job.UpdatedAt).Scan(&id)
+	if err != nil {
+		return reportQueryError(ctx, "CreateJob", fmt.Errorf("failed to create job: %w", err), "job_type", job.Type)
+	}
+	job.ID = id
+	job.TenantID = tenantID
+	return nil
+}
+
+// GetJobByID retrieves a job of the tenant of ctx by its ID
+func (r *JobRepository) GetJobByID(ctx context.Context, id int64) (*models.V1JobDal, error) {
+	ctx, done := instrument(ctx, "JobRepository", "GetJobByID")
+	defer done()
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `SELECT * FROM jobs WHERE tenant_id = $1 AND id = $2`
+	var job models.V1JobDal
+	err = r.db.GetContext(ctx, &job, query, tenantID, id)
+	if err != nil {
+		return nil, reportQueryError(ctx, "GetJobByID", fmt.Errorf("failed to get job by ID %d: %w", id, err), "job_id", id)
+	}
+	return &job, nil
+}
+
+// ClaimNextJob marks the oldest available job as running and leases it to the caller.
+// A job is available when it is pending, or running with an expired lease because its
+// worker died. SKIP LOCKED lets concurrent workers claim different jobs without waiting.
+// Jobs of all tenants are considered; the caller scopes further work to the job's tenant.
+// Returns nil when there is nothing to do.
+func (r *JobRepository) ClaimNextJob(ctx context.Context, lease time.Duration, maxAttempts int) (*models.V1JobDal, error) {
+	ctx, done := instrument(ctx, "JobRepository", "ClaimNextJob")
+	defer done()
+	query := `
+		UPDATE jobs
+		SET status = 'running',
+			attempts = attempts + 1,
+			locked_until = now() + $1 * interval '1 millisecond',
+			started_at = COALESCE(started_at, now()),
+			updated_at = now()
+		WHERE id = (
+			SELECT id FROM jobs
+			WHERE (status = 'pending' OR (status = 'running' AND locked_until < now()))
+				AND attempts < $2
+			ORDER BY id
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING *`
+	var job models.V1JobDal
+	err := r.db.GetContext(ctx, &job, query, lease.Milliseconds(), maxAttempts)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, reportQueryError(ctx, "ClaimNextJob", fmt.Errorf("failed to claim job: %w", err))
+	}
+	return &job, nil
+}
+
+// FailExpiredJobs fails running jobs of all tenants whose lease expired after the last
+// allowed attempt
+func (r *JobRepository) FailExpiredJobs(ctx context.Context, maxAttempts int) (int64, error) {
+	ctx, done := instrument(ctx, "JobRepository", "FailExpiredJobs")
+	defer done()
+	query := `
+		UPDATE jobs
+		SET status = 'failed',
+			error = 'job exceeded the maximum number of attempts',
+			locked_until = NULL,
+			finished_at = now(),
+			updated_at = now()
+		WHERE status = 'running' AND locked_until < now() AND attempts >= $1`
+	res, err := r.db.ExecContext(ctx, query, maxAttempts)
+	if err != nil {
+		return 0, reportQueryError(ctx, "FailExpiredJobs", fmt.Errorf("failed to fail expired jobs: %w", err))
+	}
+	return res.RowsAffected()
+}
+
+// UpdateJobProgress records progress and extends the lease of a running job. It fails
+// with interfaces.ErrJobLeaseLost when the job was reclaimed or advanced by another
+// worker, so the caller must roll back whatever it wrote for the chunk.
+func (r *JobRepository) UpdateJobProgress(ctx context.Context, lease models.JobLease, processed, failed int, duration time.Duration) error {
+	ctx, done := instrument(ctx, "JobRepository", "UpdateJobProgress")
+	defer done()
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		UPDATE jobs
+		SET processed_items = $2,
+			failed_items = $3,
+			locked_until = now() + $4 * interval '1 millisecond',
+			updated_at = now()
+		WHERE tenant_id = $5 AND id = $1
+			AND status = 'running' AND attempts = $6 AND processed_items = $7`
+	res, err := r.db.ExecContext(ctx, query, lease.JobID, processed, failed, duration.Milliseconds(), tenantID, lease.Attempt, lease.ProcessedItems)
+	if err != nil {
+		return reportQueryError(ctx, "UpdateJobProgress", fmt.Errorf("failed to update progress of job %d: %w", lease.JobID, err), "job_id", lease.JobID)
+	}
+	return leaseHeld(res, lease)
+}
+
+// FinishJob moves a job to a final status and releases its lease. Like UpdateJobProgress
+// it fails with interfaces.ErrJobLeaseLost when the caller no longer holds the lease.
+func (r *JobRepository) FinishJob(ctx context.Context, lease models.JobLease, status, errCode, errMessage string) error {
+	ctx, done := instrument(ctx, "JobRepository", "FinishJob")
+	defer done()
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		UPDATE jobs
+		SET status = $2,
+			error_code = NULLIF($3, ''),
+			error = NULLIF($4, ''),
+			locked_until = NULL,
+			finished_at = now(),
+			updated_at = now()
+		WHERE tenant_id = $5 AND id = $1
+			AND status = 'running' AND attempts = $6 AND processed_items = $7`
+	res, err := r.db.ExecContext(ctx, query, lease.JobID, status, errCode, errMessage, tenantID, lease.Attempt, lease.ProcessedItems)
+	if err != nil {
+		return reportQueryError(ctx, "FinishJob", fmt.Errorf("failed to finish job %d: %w", lease.JobID, err), "job_id", lease.JobID, "status", status)
+	}
+	return leaseHeld(res, lease)
+}
+
+// leaseHeld turns an update of a leased job that matched no row into ErrJobLeaseLost
+func leaseHeld(res sql.Result, lease models.JobLease) error {
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check lease of job %d: %w", lease.JobID, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("job %d attempt %d at item %d: %w", lease.JobID, lease.Attempt, lease.ProcessedItems, interfaces.ErrJobLeaseLost)
+	}
+	return nil
+}
+
+// InsertJobResults stores per-item outcomes of a job in the tenant of ctx
+func (r *JobRepository) InsertJobResults(ctx context.Context, results []models.V1JobResultDal) error {
+	ctx, done := instrument(ctx, "JobRepository", "InsertJobResults")
+	defer done()
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return err
+	}
+	if len(results) == 0 {
+		return nil
+	}
+
+	jobIDs := make([]int64, len(results))
+	indexes := make([]int64, len(results))
+	orderIDs := make([]*int64, len(results))
+	errCodes := make([]*string, len(results))
+	errs := make([]*string, len(results))
+	fieldErrs := make([]*string, len(results))
+	for i, result := range results {
+		jobIDs[i] = result.JobID
+		indexes[i] = int64(result.ItemIndex)
+		if result.OrderID.Valid {
+			orderIDs[i] = &result.OrderID.Int64
+		}
+		if result.ErrorCode.Valid {
+			errCodes[i] = &result.ErrorCode.String
+		}
+		if result.Error.Valid {
+			errs[i] = &result.Error.String
+		}
+		if result.FieldErrors != nil {
+			fields := string(result.FieldErrors)
+			fieldErrs[i] = &fields
+		}
+	}
+
+	query := `
+		INSERT INTO job_results (tenant_id, job_id, item_index, order_id, error_code, error, field_errors)
+		SELECT $1::text, job_id, item_index, order_id, error_code, error, field_errors::jsonb
+		FROM unnest($2::bigint[], $3::integer[], $4::bigint[], $5::text[], $6::text[], $7::text[])
+			AS t(job_id, item_index, order_id, error_code, error, field_errors)`
+	if _, err := r.db.ExecContext(ctx, query, tenantID, jobIDs, indexes, orderIDs, errCodes, errs, fieldErrs); err != nil {
+		return reportQueryError(ctx, "InsertJobResults", fmt.Errorf("failed to insert job results: %w", err), "results", len(results))
+	}
+	return nil
+}
+
+// GetJobResults retrieves the per-item outcomes of a job of the tenant of ctx ordered by item index
+func (r *JobRepository) GetJobResults(ctx context.Context, jobID int64) ([]models.V1JobResultDal, error) {
+	ctx, done := instrument(ctx, "JobRepository", "GetJobResults")
+	defer done()
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `SELECT * FROM job_results WHERE tenant_id = $1 AND job_id = $2 ORDER BY item_index`
+	var results []models.V1JobResultDal
+	err = r.db.SelectContext(ctx, &results, query, tenantID, jobID)
+	if err != nil {
+		return nil, reportQueryError(ctx, "GetJobResults", fmt.Errorf("failed to get results of job %d: %w", jobID, err), "job_id", jobID)
+	}
+	return results, nil
+}
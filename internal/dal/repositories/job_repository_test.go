@@ -0,0 +1,51 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Lamafout/online-store-api/internal/dal/interfaces"
+	"github.com/Lamafout/online-store-api/internal/dal/models"
+	"github.com/Lamafout/online-store-api/internal/tenant"
+)
+
+func TestJobRepositoryWritesRequireTheLease(t *testing.T) {
+	db, rec := newRecordingDB(t)
+	ctx := tenant.WithTenant(context.Background(), testTenant)
+	jobs := NewJobRepository(db)
+	lease := models.JobLease{JobID: 7, Attempt: 2, ProcessedItems: 500}
+
+	calls := map[string]func() error{
+		"UpdateJobProgress": func() error { return jobs.UpdateJobProgress(ctx, lease, 1000, 3, time.Minute) },
+		"FinishJob":         func() error { return jobs.FinishJob(ctx, lease, "completed", "", "") },
+	}
+	for name, call := range calls {
+		t.Run(name, func(t *testing.T) {
+			// The recorder reports no affected rows, as for a job another worker took over
+			if err := call(); !errors.Is(err, interfaces.ErrJobLeaseLost) {
+				t.Fatalf("error = %v, want ErrJobLeaseLost", err)
+			}
+
+			queries := rec.take()
+			if len(queries) != 1 {
+				t.Fatalf("executed %d statements, want 1", len(queries))
+			}
+			query := strings.Join(strings.Fields(queries[0].query), " ")
+			for column, value := range map[string]int{"attempts": 2, "processed_items": 500} {
+				found := false
+				for _, arg := range queries[0].args {
+					if arg.Value == value && strings.Contains(query, fmt.Sprintf("AND %s = $%d", column, arg.Ordinal)) {
+						found = true
+					}
+				}
+				if !found {
+					t.Errorf("statement does not require %s = %d: %q", column, value, query)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,37 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/Lamafout/online-store-api/internal/logging"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// maxLoggedIDs caps how many IDs of a bulk statement end up in a log record
+const maxLoggedIDs = 20
+
+// reportQueryError marks the method's span failed and returns err annotated with the
+// method and args. It does not log: whoever handles the error logs it once, with the
+// annotations and the request ID. Lookups that found nothing are not failures and are
+// returned unchanged.
+func reportQueryError(ctx context.Context, method string, err error, args ...any) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	span := trace.SpanFromContext(ctx)
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+
+	return logging.ErrorWith(err, append([]any{"repository_method", method}, args...)...)
+}
+
+// logIDs trims ids to the first maxLoggedIDs for logging
+func logIDs(ids []int64) []int64 {
+	if len(ids) > maxLoggedIDs {
+		return ids[:maxLoggedIDs]
+	}
+	return ids
+}
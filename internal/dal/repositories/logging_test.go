@@ -0,0 +1,34 @@
+package repositories
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"slices"
+	"testing"
+
+	"github.com/Lamafout/online-store-api/internal/logging"
+)
+
+func TestReportQueryErrorAnnotatesWithoutLogging(t *testing.T) {
+	var logs bytes.Buffer
+	ctx := logging.WithLogger(context.Background(), slog.New(slog.NewJSONHandler(&logs, nil)))
+	cause := errors.New("connection reset")
+
+	err := reportQueryError(ctx, "GetOrderByID", cause, "order_id", int64(7))
+	if !errors.Is(err, cause) {
+		t.Fatalf("reportQueryError() = %v, want it to wrap %v", err, cause)
+	}
+	if logs.Len() != 0 {
+		t.Fatalf("reportQueryError() logged %q, want the caller to log", logs.String())
+	}
+	if attrs := logging.ErrorAttrs(err); !slices.Equal(attrs, []any{"repository_method", "GetOrderByID", "order_id", int64(7)}) {
+		t.Fatalf("ErrorAttrs() = %v", attrs)
+	}
+
+	if err := reportQueryError(ctx, "GetOrderByID", sql.ErrNoRows); err != sql.ErrNoRows {
+		t.Fatalf("reportQueryError(sql.ErrNoRows) = %v, want it unchanged", err)
+	}
+}
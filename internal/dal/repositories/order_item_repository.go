@@ -4,9 +4,11 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/Lamafout/online-store-api/internal/dal/interfaces"
 	"github.com/Lamafout/online-store-api/internal/dal/models"
+	"github.com/Lamafout/online-store-api/internal/tenant"
 )
 
 // OrderItemRepository handles database operations for order items
@@ -16,71 +18,124 @@ type OrderItemRepository struct {
 
 // NewOrderItemRepository creates a new OrderItemRepository
 func NewOrderItemRepository(db interfaces.DBExecuter) *OrderItemRepository {
-	return &OrderItemRepository{db: db}
+	return &OrderItemRepository{db: traced(db)}
 }
 
-// CreateOrderItem creates a single order item
+// CreateOrderItem creates a single order item in the tenant of ctx
 func (r *OrderItemRepository) CreateOrderItem(ctx context.Context, item *models.V1OrderItemDal) error {
+	ctx, done := instrument(ctx, "OrderItemRepository", "CreateOrderItem")
+	defer done()
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return err
+	}
+
 	query := `
-		INSERT INTO order_items (order_id, product_id, quantity, product_title, product_url, price_cents, price_currency, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		INSERT INTO order_items (tenant_id, order_id, product_id, quantity, product_title, product_url, price_cents, price_currency, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
 		RETURNING id`
 	var id int64
-	err := r.db.QueryRowxContext(ctx, query, item.OrderID, item.ProductID, item.Quantity, item.ProductTitle, item.ProductURL, item.PriceCents, item.PriceCurrency, item.CreatedAt, item.UpdatedAt).Scan(&id)
+	err = r.db.QueryRowxContext(ctx, query, tenantID, item.OrderID, item.ProductID, item.Quantity, item.ProductTitle, item.ProductURL, item.PriceCents, item.PriceCurrency, item.CreatedAt, item.UpdatedAt).Scan(&id)
 	if err != nil {
-		return fmt.Errorf("failed to create order item: %w", err)
+		return reportQueryError(ctx, "CreateOrderItem", fmt.Errorf("failed to create order item: %w", err), "order_id", item.OrderID)
 	}
 	item.ID = id
+	item.TenantID = tenantID
 	return nil
 }
 
-// GetOrderItemsByOrderID retrieves all order items for a given order ID
+// GetOrderItemsByOrderID retrieves all order items of the tenant of ctx for a given order ID
 func (r *OrderItemRepository) GetOrderItemsByOrderID(ctx context.Context, orderID int64) ([]models.V1OrderItemDal, error) {
-	query := `SELECT * FROM order_items WHERE order_id = $1`
+	ctx, done := instrument(ctx, "OrderItemRepository", "GetOrderItemsByOrderID")
+	defer done()
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `SELECT * FROM order_items WHERE tenant_id = $1 AND order_id = $2`
 	var items []models.V1OrderItemDal
-	err := r.db.SelectContext(ctx, &items, query, orderID)
+	err = r.db.SelectContext(ctx, &items, query, tenantID, orderID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get order items for order ID %d: %w", orderID, err)
+		return nil, reportQueryError(ctx, "GetOrderItemsByOrderID", fmt.Errorf("failed to get order items for order ID %d: %w", orderID, err), "order_id", orderID)
 	}
 	return items, nil
 }
 
-// BulkInsertOrderItems inserts multiple order items
+// BulkInsertOrderItems inserts multiple order items into the tenant of ctx using one array
+// parameter per column, chunked like BulkInsertOrders. The inserted items are returned in
+// input order.
 func (r *OrderItemRepository) BulkInsertOrderItems(ctx context.Context, items []models.BulkOrderItemDalModel) ([]models.V1OrderItemDal, error) {
-    if len(items) == 0 {
-        return []models.V1OrderItemDal{}, nil
+    ctx, done := instrument(ctx, "OrderItemRepository", "BulkInsertOrderItems")
+    defer done()
+    tenantID, err := tenant.Require(ctx)
+    if err != nil {
+        return nil, err
     }
 
-    // Build VALUES clause
-    var values []interface{}
-    var placeholders []string
-    for i, item := range items {
-        placeholders = append(placeholders, fmt.Sprintf("($%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d)", 
-            i*9+1, i*9+2, i*9+3, i*9+4, i*9+5, i*9+6, i*9+7, i*9+8, i*9+9))
-        values = append(values, item.OrderID, item.ProductID, item.Quantity, item.ProductTitle, 
-            item.ProductURL, item.PriceCents, item.PriceCurrency, item.CreatedAt, item.UpdatedAt)
+    insertedItems := make([]models.V1OrderItemDal, 0, len(items))
+
+    for start := 0; start < len(items); start += bulkInsertChunkSize {
+        chunk := items[start:min(start+bulkInsertChunkSize, len(items))]
+
+        orderIDs := make([]int64, len(chunk))
+        productIDs := make([]int64, len(chunk))
+        quantities := make([]int64, len(chunk))
+        productTitles := make([]string, len(chunk))
+        productURLs := make([]string, len(chunk))
+        priceCents := make([]int64, len(chunk))
+        priceCurrencies := make([]string, len(chunk))
+        createdAt := make([]time.Time, len(chunk))
+        updatedAt := make([]time.Time, len(chunk))
+        for i, item := range chunk {
+            orderIDs[i] = item.OrderID
+            productIDs[i] = item.ProductID
+            quantities[i] = int64(item.Quantity)
+            productTitles[i] = item.ProductTitle
+            productURLs[i] = item.ProductURL
+            priceCents[i] = item.PriceCents
+            priceCurrencies[i] = item.PriceCurrency
+            createdAt[i] = item.CreatedAt
+            updatedAt[i] = item.UpdatedAt
+        }
+
+        // As in BulkInsertOrders, ids are drawn up front and the result is ordered by the
+        // position of each input row rather than by id
+        query := `
+            WITH input AS (
+                SELECT nextval(pg_get_serial_sequence('order_items', 'id')) AS id, t.*
+                FROM unnest($2::bigint[], $3::bigint[], $4::integer[], $5::text[], $6::text[], $7::bigint[], $8::text[], $9::timestamptz[], $10::timestamptz[])
+                    WITH ORDINALITY AS t(order_id, product_id, quantity, product_title, product_url, price_cents, price_currency, created_at, updated_at, ord)
+            ), inserted AS (
+                INSERT INTO order_items (tenant_id, id, order_id, product_id, quantity, product_title, product_url, price_cents, price_currency, created_at, updated_at)
+                SELECT $1::text, id, order_id, product_id, quantity, product_title, product_url, price_cents, price_currency, created_at, updated_at FROM input
+                RETURNING id, tenant_id, order_id, product_id, quantity, product_title, product_url, price_cents, price_currency, created_at, updated_at
+            )
+            SELECT inserted.* FROM inserted JOIN input USING (id) ORDER BY input.ord`
+
+        var inserted []models.V1OrderItemDal
+        err := r.db.SelectContext(ctx, &inserted, query, tenantID, orderIDs, productIDs, quantities, productTitles, productURLs, priceCents, priceCurrencies, createdAt, updatedAt)
+        if err != nil {
+            return nil, reportQueryError(ctx, "BulkInsertOrderItems", fmt.Errorf("failed to bulk insert order items: %w", err), "items", len(chunk), "order_ids", logIDs(orderIDs))
+        }
+        insertedItems = append(insertedItems, inserted...)
     }
 
-    query := fmt.Sprintf(`
-        INSERT INTO order_items (order_id, product_id, quantity, product_title, product_url, price_cents, price_currency, created_at, updated_at)
-        VALUES %s 
-        RETURNING id, order_id, product_id, quantity, product_title, product_url, price_cents, price_currency, created_at, updated_at`, 
-        strings.Join(placeholders, ", "))
-    
-    var insertedItems []models.V1OrderItemDal
-    err := r.db.SelectContext(ctx, &insertedItems, query, values...)
-    if err != nil {
-        return nil, fmt.Errorf("failed to bulk insert order items: %w", err)
-    }
-    
     return insertedItems, nil
 }
 
 func (r *OrderItemRepository) QueryOrderItems(ctx context.Context, req *models.QueryOrderItemsDalModel) ([]models.V1OrderItemDal, error) {
-    query := `SELECT * FROM order_items WHERE 1=1`
-    var args []interface{}
+    ctx, done := instrument(ctx, "OrderItemRepository", "QueryOrderItems")
+    defer done()
+    tenantID, err := tenant.Require(ctx)
+    if err != nil {
+        return nil, err
+    }
+
+    query := `SELECT * FROM order_items WHERE tenant_id = $1`
+    args := []interface{}{tenantID}
     var conditions []string
-    paramCount := 0
+    paramCount := 1
 
     if len(req.IDs) > 0 {
         paramCount++
@@ -113,9 +168,9 @@ func (r *OrderItemRepository) QueryOrderItems(ctx context.Context, req *models.Q
     }
 
     var items []models.V1OrderItemDal
-    err := r.db.SelectContext(ctx, &items, query, args...)
+    err = r.db.SelectContext(ctx, &items, query, args...)
     if err != nil {
-        return nil, fmt.Errorf("failed to query order items: %w", err)
+        return nil, reportQueryError(ctx, "QueryOrderItems", fmt.Errorf("failed to query order items: %w", err), "order_ids", logIDs(req.OrderIDs))
     }
     return items, nil
 }
\ No newline at end of file
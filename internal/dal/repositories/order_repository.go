@@ -4,11 +4,17 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/Lamafout/online-store-api/internal/dal/interfaces"
 	"github.com/Lamafout/online-store-api/internal/dal/models"
+	"github.com/Lamafout/online-store-api/internal/metrics"
+	"github.com/Lamafout/online-store-api/internal/tenant"
 )
 
+// bulkInsertChunkSize caps the number of rows sent in a single bulk INSERT statement
+const bulkInsertChunkSize = 10000
+
 // OrderRepository handles database operations for orders
 type OrderRepository struct {
 	db interfaces.DBExecuter
@@ -16,82 +22,118 @@ type OrderRepository struct {
 
 // NewOrderRepository creates a new OrderRepository
 func NewOrderRepository(db interfaces.DBExecuter) *OrderRepository {
-	return &OrderRepository{db: db}
+	return &OrderRepository{db: traced(db)}
 }
 
-// CreateOrder creates a single order
+// CreateOrder creates a single order in the tenant of ctx
 func (r *OrderRepository) CreateOrder(ctx context.Context, order *models.V1OrderDal) error {
+	ctx, done := instrument(ctx, "OrderRepository", "CreateOrder")
+	defer done()
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return err
+	}
+
 	query := `
-		INSERT INTO orders (customer_id, delivery_address, total_price_cents, total_price_currency, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6)
+		INSERT INTO orders (tenant_id, customer_id, delivery_address, total_price_cents, total_price_currency, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
 		RETURNING id`
 	var id int64
-	err := r.db.QueryRowxContext(ctx, query, order.CustomerID, order.DeliveryAddress, order.TotalPriceCents, order.TotalPriceCurrency, order.CreatedAt, order.UpdatedAt).Scan(&id)
+	err = r.db.QueryRowxContext(ctx, query, tenantID, order.CustomerID, order.DeliveryAddress, order.TotalPriceCents, order.TotalPriceCurrency, order.CreatedAt, order.UpdatedAt).Scan(&id)
 	if err != nil {
-		return fmt.Errorf("failed to create order: %w", err)
+		return reportQueryError(ctx, "CreateOrder", fmt.Errorf("failed to create order: %w", err), "customer_id", order.CustomerID)
 	}
 	order.ID = id
+	order.TenantID = tenantID
 	return nil
 }
 
-// BulkInsertOrders inserts multiple orders
+// BulkInsertOrders inserts multiple orders into the tenant of ctx. Rows are sent as one
+// array per column and expanded with unnest, so the statement always has 7 parameters
+// regardless of the number of orders; large inputs are split into chunks of
+// bulkInsertChunkSize rows. The inserted orders are returned in input order.
 func (r *OrderRepository) BulkInsertOrders(ctx context.Context, orders []models.BulkOrderDalModel) ([]models.V1OrderDal, error) {
-    if len(orders) == 0 {
-        return []models.V1OrderDal{}, nil
+    ctx, done := instrument(ctx, "OrderRepository", "BulkInsertOrders")
+    defer done()
+    tenantID, err := tenant.Require(ctx)
+    if err != nil {
+        return nil, err
     }
 
-    // Build VALUES clause
-    var values []interface{}
-    var placeholders []string
-    for i, order := range orders {
-        placeholders = append(placeholders, fmt.Sprintf("($%d, $%d, $%d, $%d, $%d, $%d)", i*6+1, i*6+2, i*6+3, i*6+4, i*6+5, i*6+6))
-        values = append(values, order.CustomerID, order.DeliveryAddress, order.TotalPriceCents, order.TotalPriceCurrency, order.CreatedAt, order.UpdatedAt)
-    }
+    insertedOrders := make([]models.V1OrderDal, 0, len(orders))
 
-    query := fmt.Sprintf(`
-        INSERT INTO orders (customer_id, delivery_address, total_price_cents, total_price_currency, created_at, updated_at)
-        VALUES %s 
-        RETURNING id, customer_id, delivery_address, total_price_cents, total_price_currency, created_at, updated_at`, 
-        strings.Join(placeholders, ", "))
-    
-    var insertedOrders []models.V1OrderDal
-    err := r.db.SelectContext(ctx, &insertedOrders, query, values...)
-    if err != nil {
-        return nil, fmt.Errorf("failed to bulk insert orders: %w", err)
+    for start := 0; start < len(orders); start += bulkInsertChunkSize {
+        chunk := orders[start:min(start+bulkInsertChunkSize, len(orders))]
+
+        customerIDs := make([]int64, len(chunk))
+        deliveryAddresses := make([]string, len(chunk))
+        totalPriceCents := make([]int64, len(chunk))
+        totalPriceCurrencies := make([]string, len(chunk))
+        createdAt := make([]time.Time, len(chunk))
+        updatedAt := make([]time.Time, len(chunk))
+        for i, order := range chunk {
+            customerIDs[i] = order.CustomerID
+            deliveryAddresses[i] = order.DeliveryAddress
+            totalPriceCents[i] = order.TotalPriceCents
+            totalPriceCurrencies[i] = order.TotalPriceCurrency
+            createdAt[i] = order.CreatedAt
+            updatedAt[i] = order.UpdatedAt
+        }
+
+        // Postgres inserts the rows in no guaranteed order, so every input row draws its id
+        // up front and keeps its position from WITH ORDINALITY, which orders the result
+        query := `
+            WITH input AS (
+                SELECT nextval(pg_get_serial_sequence('orders', 'id')) AS id, t.*
+                FROM unnest($2::bigint[], $3::text[], $4::bigint[], $5::text[], $6::timestamptz[], $7::timestamptz[])
+                    WITH ORDINALITY AS t(customer_id, delivery_address, total_price_cents, total_price_currency, created_at, updated_at, ord)
+            ), inserted AS (
+                INSERT INTO orders (tenant_id, id, customer_id, delivery_address, total_price_cents, total_price_currency, created_at, updated_at)
+                SELECT $1::text, id, customer_id, delivery_address, total_price_cents, total_price_currency, created_at, updated_at FROM input
+                RETURNING id, tenant_id, customer_id, delivery_address, total_price_cents, total_price_currency, created_at, updated_at
+            )
+            SELECT inserted.* FROM inserted JOIN input USING (id) ORDER BY input.ord`
+
+        var inserted []models.V1OrderDal
+        err := r.db.SelectContext(ctx, &inserted, query, tenantID, customerIDs, deliveryAddresses, totalPriceCents, totalPriceCurrencies, createdAt, updatedAt)
+        if err != nil {
+            return nil, reportQueryError(ctx, "BulkInsertOrders", fmt.Errorf("failed to bulk insert orders: %w", err), "orders", len(chunk), "customer_ids", logIDs(customerIDs))
+        }
+        insertedOrders = append(insertedOrders, inserted...)
     }
-    
+
     return insertedOrders, nil
 }
 
-// GetOrderByID retrieves an order by its ID
+// GetOrderByID retrieves an order of the tenant of ctx by its ID
 func (r *OrderRepository) GetOrderByID(ctx context.Context, id int64) (*models.V1OrderDal, error) {
-	query := `SELECT * FROM orders WHERE id = $1`
+	ctx, done := instrument(ctx, "OrderRepository", "GetOrderByID")
+	defer done()
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `SELECT * FROM orders WHERE tenant_id = $1 AND id = $2`
 	var order models.V1OrderDal
-	err := r.db.GetContext(ctx, &order, query, id)
+	err = r.db.GetContext(ctx, &order, query, tenantID, id)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get order by ID %d: %w", id, err)
+		return nil, reportQueryError(ctx, "GetOrderByID", fmt.Errorf("failed to get order by ID %d: %w", id, err), "order_id", id)
 	}
 	return &order, nil
 }
 
 func (r *OrderRepository) QueryOrders(ctx context.Context, req *models.QueryOrdersDalModel) ([]models.V1OrderDal, error) {
-    query := `SELECT * FROM orders WHERE 1=1`
-    var args []interface{}
-    var conditions []string
-
-    if len(req.IDs) > 0 {
-        conditions = append(conditions, fmt.Sprintf("id = ANY($%d)", len(args)+1))
-        args = append(args, req.IDs)
-    }
-    
-    if len(req.CustomerIDs) > 0 {
-        conditions = append(conditions, fmt.Sprintf("customer_id = ANY($%d)", len(args)+1))
-        args = append(args, req.CustomerIDs)
+    ctx, done := instrument(ctx, "OrderRepository", "QueryOrders")
+    defer done()
+    tenantID, err := tenant.Require(ctx)
+    if err != nil {
+        return nil, err
     }
 
-    if len(conditions) > 0 {
-        query += " AND " + strings.Join(conditions, " AND ")
-    }
+    query, args := buildQueryOrdersFilter(tenantID, req)
+    // Without a fixed order, pages may overlap or skip rows
+    query += " ORDER BY id"
 
     if req.Limit > 0 {
         query += fmt.Sprintf(" LIMIT $%d", len(args)+1)
@@ -104,9 +146,82 @@ func (r *OrderRepository) QueryOrders(ctx context.Context, req *models.QueryOrde
     }
 
     var orders []models.V1OrderDal
-    err := r.db.SelectContext(ctx, &orders, query, args...)
+    err = r.db.SelectContext(ctx, &orders, query, args...)
     if err != nil {
-        return nil, fmt.Errorf("failed to query orders: %w", err)
+        return nil, reportQueryError(ctx, "QueryOrders", fmt.Errorf("failed to query orders: %w", err), "order_ids", logIDs(req.IDs), "customer_ids", logIDs(req.CustomerIDs))
     }
     return orders, nil
+}
+
+// IterateOrders walks all orders matching the filter through a server-side cursor,
+// passing them to fn in batches of at most batchSize rows. Limit and Offset are ignored.
+// Cursors only live inside a transaction, so the repository must be bound to one.
+func (r *OrderRepository) IterateOrders(ctx context.Context, req *models.QueryOrdersDalModel, batchSize int, fn func([]models.V1OrderDal) error) error {
+    if batchSize <= 0 {
+        return fmt.Errorf("batch size must be greater than 0")
+    }
+
+    tenantID, err := tenant.Require(ctx)
+    if err != nil {
+        return err
+    }
+
+    ctx, span := tracer.Start(ctx, "OrderRepository.IterateOrders")
+    defer span.End()
+
+    query, args := buildQueryOrdersFilter(tenantID, req)
+    query += " ORDER BY id"
+
+    // Only the statements are timed; fn runs between fetches and isn't database time
+    start := time.Now()
+    _, err = r.db.ExecContext(ctx, "DECLARE orders_cursor NO SCROLL CURSOR FOR "+query, args...)
+    metrics.ObserveQuery("OrderRepository", "IterateOrders", start)
+    if err != nil {
+        return reportQueryError(ctx, "IterateOrders", fmt.Errorf("failed to declare orders cursor: %w", err), "order_ids", logIDs(req.IDs), "customer_ids", logIDs(req.CustomerIDs))
+    }
+    defer r.db.ExecContext(context.WithoutCancel(ctx), "CLOSE orders_cursor")
+
+    fetch := fmt.Sprintf("FETCH FORWARD %d FROM orders_cursor", batchSize)
+    for {
+        var orders []models.V1OrderDal
+        start := time.Now()
+        err := r.db.SelectContext(ctx, &orders, fetch)
+        metrics.ObserveQuery("OrderRepository", "IterateOrders", start)
+        if err != nil {
+            return reportQueryError(ctx, "IterateOrders", fmt.Errorf("failed to fetch orders from cursor: %w", err), "order_ids", logIDs(req.IDs), "customer_ids", logIDs(req.CustomerIDs))
+        }
+        if len(orders) == 0 {
+            return nil
+        }
+        if err := fn(orders); err != nil {
+            return err
+        }
+        if len(orders) < batchSize {
+            return nil
+        }
+    }
+}
+
+// buildQueryOrdersFilter builds the filtered SELECT shared by QueryOrders and IterateOrders,
+// always restricted to the tenant
+func buildQueryOrdersFilter(tenantID string, req *models.QueryOrdersDalModel) (string, []interface{}) {
+    query := `SELECT * FROM orders WHERE tenant_id = $1`
+    args := []interface{}{tenantID}
+    var conditions []string
+
+    if len(req.IDs) > 0 {
+        conditions = append(conditions, fmt.Sprintf("id = ANY($%d)", len(args)+1))
+        args = append(args, req.IDs)
+    }
+    
+    if len(req.CustomerIDs) > 0 {
+        conditions = append(conditions, fmt.Sprintf("customer_id = ANY($%d)", len(args)+1))
+        args = append(args, req.CustomerIDs)
+    }
+
+    if len(conditions) > 0 {
+        query += " AND " + strings.Join(conditions, " AND ")
+    }
+
+    return query, args
 }
\ No newline at end of file
@@ -0,0 +1,71 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Lamafout/online-store-api/internal/dal/interfaces"
+	"github.com/Lamafout/online-store-api/internal/dal/models"
+	"github.com/Lamafout/online-store-api/internal/tenant"
+)
+
+// RateLimitRepository handles database operations for shared rate limit buckets
+type RateLimitRepository struct {
+	db interfaces.DBExecuter
+}
+
+// NewRateLimitRepository creates a new RateLimitRepository
+func NewRateLimitRepository(db interfaces.DBExecuter) *RateLimitRepository {
+	return &RateLimitRepository{db: traced(db)}
+}
+
+// TakeTokens refills the token bucket under key in the tenant of ctx and takes cost
+// tokens from it if it holds enough. A missing bucket starts full. The refill and the
+// take happen in a single upsert, so concurrent instances never lose updates, and the
+// database clock is used so instance clocks don't need to agree. The returned bucket
+// reports whether the tokens were taken.
+func (r *RateLimitRepository) TakeTokens(ctx context.Context, key string, cost int, rate float64, burst int) (*models.V1RateLimitBucketDal, error) {
+	ctx, done := instrument(ctx, "RateLimitRepository", "TakeTokens")
+	defer done()
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// $3 is the cost, $4 the burst and $5 the refill rate per second
+	query := strings.NewReplacer(
+		":cost", "$3::double precision",
+		":burst", "$4::double precision",
+		":rate", "$5::double precision",
+	).Replace(strings.ReplaceAll(`
+		INSERT INTO rate_limit_buckets AS b (tenant_id, key, tokens, allowed, updated_at)
+		VALUES ($1, $2, CASE WHEN :burst >= :cost THEN :burst - :cost ELSE :burst END, :burst >= :cost, now())
+		ON CONFLICT (tenant_id, key) DO UPDATE
+		SET tokens = CASE WHEN :refilled >= :cost THEN :refilled - :cost ELSE :refilled END,
+			allowed = :refilled >= :cost,
+			updated_at = now()
+		RETURNING *`,
+		":refilled", "LEAST(:burst, b.tokens + EXTRACT(EPOCH FROM (now() - b.updated_at))::double precision * :rate)",
+	))
+	var bucket models.V1RateLimitBucketDal
+	err = r.db.GetContext(ctx, &bucket, query, tenantID, key, cost, burst, rate)
+	if err != nil {
+		return nil, reportQueryError(ctx, "TakeTokens", fmt.Errorf("failed to take rate limit tokens: %w", err), "key", key)
+	}
+	return &bucket, nil
+}
+
+// DeleteIdleBuckets removes buckets of all tenants that were not used for idleFor.
+// It is housekeeping, so it is deliberately not tenant-scoped.
+func (r *RateLimitRepository) DeleteIdleBuckets(ctx context.Context, idleFor time.Duration) (int64, error) {
+	ctx, done := instrument(ctx, "RateLimitRepository", "DeleteIdleBuckets")
+	defer done()
+	query := `DELETE FROM rate_limit_buckets WHERE updated_at < now() - $1 * interval '1 millisecond'`
+	res, err := r.db.ExecContext(ctx, query, idleFor.Milliseconds())
+	if err != nil {
+		return 0, reportQueryError(ctx, "DeleteIdleBuckets", fmt.Errorf("failed to delete idle rate limit buckets: %w", err))
+	}
+	return res.RowsAffected()
+}
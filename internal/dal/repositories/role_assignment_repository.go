@@ -0,0 +1,95 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Lamafout/online-store-api/internal/dal/interfaces"
+	"github.com/Lamafout/online-store-api/internal/dal/models"
+	"github.com/Lamafout/online-store-api/internal/tenant"
+)
+
+// RoleAssignmentRepository handles database operations for role assignments
+type RoleAssignmentRepository struct {
+	db interfaces.DBExecuter
+}
+
+// NewRoleAssignmentRepository creates a new RoleAssignmentRepository
+func NewRoleAssignmentRepository(db interfaces.DBExecuter) *RoleAssignmentRepository {
+	return &RoleAssignmentRepository{db: traced(db)}
+}
+
+// AssignRole gives a role to a subject within the tenant of ctx. Assigning a role the
+// subject already has is a no-op; the result reports whether a row was added.
+func (r *RoleAssignmentRepository) AssignRole(ctx context.Context, assignment *models.V1RoleAssignmentDal) (bool, error) {
+	ctx, done := instrument(ctx, "RoleAssignmentRepository", "AssignRole")
+	defer done()
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	query := `
+		INSERT INTO role_assignments (tenant_id, subject, role, created_by, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (tenant_id, subject, role) DO NOTHING`
+	res, err := r.db.ExecContext(ctx, query, tenantID, assignment.Subject, assignment.Role, assignment.CreatedBy, assignment.CreatedAt)
+	if err != nil {
+		return false, reportQueryError(ctx, "AssignRole", fmt.Errorf("failed to assign role: %w", err), "subject", assignment.Subject, "role", assignment.Role)
+	}
+	assignment.TenantID = tenantID
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, reportQueryError(ctx, "AssignRole", fmt.Errorf("failed to assign role: %w", err), "subject", assignment.Subject, "role", assignment.Role)
+	}
+	return affected > 0, nil
+}
+
+// RevokeRole takes a role away from a subject within the tenant of ctx, reporting
+// whether the subject had it
+func (r *RoleAssignmentRepository) RevokeRole(ctx context.Context, subject, role string) (bool, error) {
+	ctx, done := instrument(ctx, "RoleAssignmentRepository", "RevokeRole")
+	defer done()
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	query := `DELETE FROM role_assignments WHERE tenant_id = $1 AND subject = $2 AND role = $3`
+	res, err := r.db.ExecContext(ctx, query, tenantID, subject, role)
+	if err != nil {
+		return false, reportQueryError(ctx, "RevokeRole", fmt.Errorf("failed to revoke role: %w", err), "subject", subject, "role", role)
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, reportQueryError(ctx, "RevokeRole", fmt.Errorf("failed to revoke role: %w", err), "subject", subject, "role", role)
+	}
+	return affected > 0, nil
+}
+
+// ListRoleAssignments retrieves the role assignments of the tenant of ctx, optionally
+// only those of one subject
+func (r *RoleAssignmentRepository) ListRoleAssignments(ctx context.Context, subject string) ([]models.V1RoleAssignmentDal, error) {
+	ctx, done := instrument(ctx, "RoleAssignmentRepository", "ListRoleAssignments")
+	defer done()
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `SELECT * FROM role_assignments WHERE tenant_id = $1`
+	args := []interface{}{tenantID}
+	if subject != "" {
+		query += ` AND subject = $2`
+		args = append(args, subject)
+	}
+	query += ` ORDER BY subject, role`
+
+	var assignments []models.V1RoleAssignmentDal
+	if err := r.db.SelectContext(ctx, &assignments, query, args...); err != nil {
+		return nil, reportQueryError(ctx, "ListRoleAssignments", fmt.Errorf("failed to list role assignments: %w", err), "subject", subject)
+	}
+	return assignments, nil
+}
@@ -0,0 +1,256 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Lamafout/online-store-api/internal/dal/models"
+	"github.com/Lamafout/online-store-api/internal/tenant"
+	"github.com/jmoiron/sqlx"
+)
+
+const testTenant = "tenant-a"
+
+// recordedQuery is a statement sent to the database together with its arguments
+type recordedQuery struct {
+	query string
+	args  []driver.NamedValue
+}
+
+// recorder is a database/sql connector that records every statement and answers
+// with empty results, which is enough to inspect the SQL the repositories produce
+type recorder struct {
+	mu      sync.Mutex
+	queries []recordedQuery
+}
+
+func (r *recorder) Connect(context.Context) (driver.Conn, error) { return &recordingConn{r}, nil }
+func (r *recorder) Driver() driver.Driver                        { return r }
+func (r *recorder) Open(string) (driver.Conn, error)             { return &recordingConn{r}, nil }
+
+func (r *recorder) record(query string, args []driver.NamedValue) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.queries = append(r.queries, recordedQuery{query: query, args: args})
+}
+
+func (r *recorder) take() []recordedQuery {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	queries := r.queries
+	r.queries = nil
+	return queries
+}
+
+type recordingConn struct {
+	r *recorder
+}
+
+func (c *recordingConn) Prepare(string) (driver.Stmt, error) {
+	return nil, fmt.Errorf("prepare is not supported")
+}
+func (c *recordingConn) Close() error              { return nil }
+func (c *recordingConn) Begin() (driver.Tx, error) { return c, nil }
+func (c *recordingConn) Commit() error             { return nil }
+func (c *recordingConn) Rollback() error           { return nil }
+
+// CheckNamedValue accepts any argument, including the slices sent to unnest
+func (c *recordingConn) CheckNamedValue(*driver.NamedValue) error { return nil }
+
+func (c *recordingConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.r.record(query, args)
+	return driver.RowsAffected(0), nil
+}
+
+func (c *recordingConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.r.record(query, args)
+	return emptyRows{}, nil
+}
+
+type emptyRows struct{}
+
+func (emptyRows) Columns() []string              { return nil }
+func (emptyRows) Close() error                   { return nil }
+func (emptyRows) Next(dest []driver.Value) error { return io.EOF }
+
+func newRecordingDB(t *testing.T) (*sqlx.DB, *recorder) {
+	t.Helper()
+	rec := &recorder{}
+	db := sqlx.NewDb(sql.OpenDB(rec), "pgx")
+	t.Cleanup(func() { db.Close() })
+	return db, rec
+}
+
+// tenantScopedCalls lists every repository method that must only touch rows of the tenant of ctx
+func tenantScopedCalls(db *sqlx.DB) map[string]func(ctx context.Context) error {
+	orders := NewOrderRepository(db)
+	items := NewOrderItemRepository(db)
+	jobs := NewJobRepository(db)
+	keys := NewAPIKeyRepository(db)
+	roles := NewRoleAssignmentRepository(db)
+	rateLimits := NewRateLimitRepository(db)
+	now := time.Now()
+
+	return map[string]func(ctx context.Context) error{
+		"CreateOrder": func(ctx context.Context) error {
+			return orders.CreateOrder(ctx, &models.V1OrderDal{CustomerID: 1})
+		},
+		"BulkInsertOrders": func(ctx context.Context) error {
+			_, err := orders.BulkInsertOrders(ctx, []models.BulkOrderDalModel{{CustomerID: 1}})
+			return err
+		},
+		"GetOrderByID": func(ctx context.Context) error {
+			_, err := orders.GetOrderByID(ctx, 1)
+			return err
+		},
+		"QueryOrders": func(ctx context.Context) error {
+			_, err := orders.QueryOrders(ctx, &models.QueryOrdersDalModel{IDs: []int64{1}, CustomerIDs: []int64{2}, Limit: 10})
+			return err
+		},
+		"IterateOrders": func(ctx context.Context) error {
+			return orders.IterateOrders(ctx, &models.QueryOrdersDalModel{}, 10, func([]models.V1OrderDal) error { return nil })
+		},
+		"CreateOrderItem": func(ctx context.Context) error {
+			return items.CreateOrderItem(ctx, &models.V1OrderItemDal{OrderID: 1})
+		},
+		"GetOrderItemsByOrderID": func(ctx context.Context) error {
+			_, err := items.GetOrderItemsByOrderID(ctx, 1)
+			return err
+		},
+		"BulkInsertOrderItems": func(ctx context.Context) error {
+			_, err := items.BulkInsertOrderItems(ctx, []models.BulkOrderItemDalModel{{OrderID: 1}})
+			return err
+		},
+		"QueryOrderItems": func(ctx context.Context) error {
+			_, err := items.QueryOrderItems(ctx, &models.QueryOrderItemsDalModel{OrderIDs: []int64{1}})
+			return err
+		},
+		"CreateJob": func(ctx context.Context) error {
+			return jobs.CreateJob(ctx, &models.V1JobDal{Payload: []byte("[]")})
+		},
+		"GetJobByID": func(ctx context.Context) error {
+			_, err := jobs.GetJobByID(ctx, 1)
+			return err
+		},
+		"UpdateJobProgress": func(ctx context.Context) error {
+			return jobs.UpdateJobProgress(ctx, models.JobLease{JobID: 1, Attempt: 1}, 1, 0, time.Minute)
+		},
+		"FinishJob": func(ctx context.Context) error {
+			return jobs.FinishJob(ctx, models.JobLease{JobID: 1, Attempt: 1}, "completed", "", "")
+		},
+		"InsertJobResults": func(ctx context.Context) error {
+			return jobs.InsertJobResults(ctx, []models.V1JobResultDal{{JobID: 1}})
+		},
+		"GetJobResults": func(ctx context.Context) error {
+			_, err := jobs.GetJobResults(ctx, 1)
+			return err
+		},
+		"CreateAPIKey": func(ctx context.Context) error {
+			return keys.CreateAPIKey(ctx, &models.V1APIKeyDal{Name: "key"})
+		},
+		"GetAPIKeyByID": func(ctx context.Context) error {
+			_, err := keys.GetAPIKeyByID(ctx, 1)
+			return err
+		},
+		"ListAPIKeys": func(ctx context.Context) error {
+			_, err := keys.ListAPIKeys(ctx)
+			return err
+		},
+		"UpdateAPIKeySecret": func(ctx context.Context) error {
+			return keys.UpdateAPIKeySecret(ctx, 1, "prefix", "hash", now)
+		},
+		"RevokeAPIKey": func(ctx context.Context) error {
+			return keys.RevokeAPIKey(ctx, 1, now)
+		},
+		"AssignRole": func(ctx context.Context) error {
+			_, err := roles.AssignRole(ctx, &models.V1RoleAssignmentDal{Subject: "user-1", Role: "support"})
+			return err
+		},
+		"RevokeRole": func(ctx context.Context) error {
+			_, err := roles.RevokeRole(ctx, "user-1", "support")
+			return err
+		},
+		"ListRoleAssignments": func(ctx context.Context) error {
+			_, err := roles.ListRoleAssignments(ctx, "user-1")
+			return err
+		},
+		"TakeTokens": func(ctx context.Context) error {
+			_, err := rateLimits.TakeTokens(ctx, "orders:user-1", 1, 10, 20)
+			return err
+		},
+	}
+}
+
+func TestRepositoriesScopeEveryStatementToTenant(t *testing.T) {
+	db, rec := newRecordingDB(t)
+	ctx := tenant.WithTenant(context.Background(), testTenant)
+
+	for name, call := range tenantScopedCalls(db) {
+		t.Run(name, func(t *testing.T) {
+			// Lookups by ID find nothing here; only the statements matter
+			_ = call(ctx)
+
+			queries := rec.take()
+			if len(queries) == 0 {
+				t.Fatal("no statement was executed")
+			}
+			// The first statement is the one touching the table; IterateOrders follows
+			// its DECLARE with FETCH and CLOSE, which only read the cursor.
+			assertTenantScoped(t, queries[0])
+		})
+	}
+}
+
+func TestRepositoriesRefuseToRunWithoutTenant(t *testing.T) {
+	db, rec := newRecordingDB(t)
+
+	for name, call := range tenantScopedCalls(db) {
+		t.Run(name, func(t *testing.T) {
+			if err := call(context.Background()); err == nil {
+				t.Fatal("expected an error without a tenant")
+			}
+			if queries := rec.take(); len(queries) > 0 {
+				t.Fatalf("executed %q without a tenant", queries[0].query)
+			}
+		})
+	}
+}
+
+// assertTenantScoped checks that the tenant is bound as a parameter and used either as
+// an equality predicate or as the inserted tenant_id column
+func assertTenantScoped(t *testing.T, q recordedQuery) {
+	t.Helper()
+
+	position := 0
+	for _, arg := range q.args {
+		if arg.Value == testTenant {
+			position = arg.Ordinal
+			break
+		}
+	}
+	if position == 0 {
+		t.Fatalf("tenant is not bound as a parameter of %q", q.query)
+	}
+
+	placeholder := fmt.Sprintf("$%d", position)
+	query := strings.Join(strings.Fields(q.query), " ")
+	// An OR anywhere could widen the predicate past the tenant
+	if strings.Contains(strings.ToUpper(query), " OR ") {
+		t.Fatalf("statement combines conditions with OR: %q", query)
+	}
+	if strings.Contains(query, "tenant_id = "+placeholder) {
+		return
+	}
+	if strings.Contains(query, "INSERT INTO") && strings.Contains(query, "(tenant_id,") &&
+		(strings.Contains(query, "VALUES ("+placeholder+",") || strings.Contains(query, "SELECT "+placeholder+"::text,")) {
+		return
+	}
+	t.Fatalf("statement is not restricted to the tenant: %q", query)
+}
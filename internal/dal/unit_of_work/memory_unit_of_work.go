@@ -0,0 +1,242 @@
+package dal
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/Lamafout/online-store-api/internal/dal/interfaces"
+	"github.com/Lamafout/online-store-api/internal/dal/memory"
+)
+
+// memoryUnitOfWork is the UnitOfWork over an in-memory store. Only orders and order
+// items are stored; the other repositories fail with memory.ErrNotSupported.
+// Isolation levels are ignored, since writing transactions never overlap.
+type memoryUnitOfWork struct {
+	store *memory.Store
+	tx    *memory.Tx
+	// afterCommit holds the hooks to run once the current transaction commits
+	afterCommit []func()
+	// nesting counts the savepoints opened by WithinTransaction, which names them
+	nesting    int
+	savepoints map[string]memorySavepoint
+}
+
+// memorySavepoint is a savepoint together with the number of afterCommit hooks
+// registered when it was created
+type memorySavepoint struct {
+	savepoint memory.Savepoint
+	hookMark  int
+}
+
+// NewMemoryUnitOfWork creates a new UnitOfWork over store
+func NewMemoryUnitOfWork(store *memory.Store) UnitOfWork {
+	return &memoryUnitOfWork{store: store}
+}
+
+// executor returns what the repositories run against: the transaction, if any
+func (u *memoryUnitOfWork) executor() memory.Executor {
+	if u.tx != nil {
+		return u.tx
+	}
+	return u.store
+}
+
+// GetOrderRepo returns an OrderRepository bound to the current transaction
+func (u *memoryUnitOfWork) GetOrderRepo() interfaces.IOrderRepository {
+	return memory.NewOrderRepository(u.executor())
+}
+
+// GetOrderItemRepo returns an OrderItemRepository bound to the current transaction
+func (u *memoryUnitOfWork) GetOrderItemRepo() interfaces.IOrderItemRepository {
+	return memory.NewOrderItemRepository(u.executor())
+}
+
+func (u *memoryUnitOfWork) GetJobRepo() interfaces.IJobRepository {
+	return memory.UnsupportedRepository{}
+}
+
+func (u *memoryUnitOfWork) GetAPIKeyRepo() interfaces.IAPIKeyRepository {
+	return memory.UnsupportedRepository{}
+}
+
+func (u *memoryUnitOfWork) GetRoleAssignmentRepo() interfaces.IRoleAssignmentRepository {
+	return memory.UnsupportedRepository{}
+}
+
+func (u *memoryUnitOfWork) GetRateLimitRepo() interfaces.IRateLimitRepository {
+	return memory.UnsupportedRepository{}
+}
+
+// Begin starts a new transaction
+func (u *memoryUnitOfWork) Begin(ctx context.Context) error {
+	return u.BeginTx(ctx, nil)
+}
+
+// BeginTx starts a new transaction, read-only if opts says so
+func (u *memoryUnitOfWork) BeginTx(ctx context.Context, opts *sql.TxOptions) error {
+	if u.tx != nil {
+		return fmt.Errorf("transaction already started")
+	}
+	tx, err := u.store.Begin(ctx, opts != nil && opts.ReadOnly)
+	if err != nil {
+		return err
+	}
+	u.tx = tx
+	return nil
+}
+
+// BeginRead does nothing: reads outside of a transaction already see committed data
+func (u *memoryUnitOfWork) BeginRead(ctx context.Context) error {
+	return nil
+}
+
+// ReadsFromReplica reports false: the memory store has no replicas
+func (u *memoryUnitOfWork) ReadsFromReplica() bool {
+	return false
+}
+
+// Commit commits the transaction
+func (u *memoryUnitOfWork) Commit() error {
+	if u.tx == nil {
+		return fmt.Errorf("no transaction to commit")
+	}
+	err := u.tx.Commit()
+	hooks := u.afterCommit
+	u.reset()
+	if err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	for _, fn := range hooks {
+		fn()
+	}
+	return nil
+}
+
+// Rollback rolls back the transaction
+func (u *memoryUnitOfWork) Rollback() error {
+	if u.tx == nil {
+		return fmt.Errorf("no transaction to rollback")
+	}
+	err := u.tx.Rollback()
+	u.reset()
+	if err != nil {
+		return fmt.Errorf("failed to rollback transaction: %w", err)
+	}
+	return nil
+}
+
+// AfterCommit runs fn once the current transaction commits, or right away outside of one
+func (u *memoryUnitOfWork) AfterCommit(fn func()) {
+	if u.tx == nil {
+		fn()
+		return
+	}
+	u.afterCommit = append(u.afterCommit, fn)
+}
+
+// Savepoint creates a savepoint inside the current transaction
+func (u *memoryUnitOfWork) Savepoint(ctx context.Context, name string) error {
+	if u.tx == nil {
+		return fmt.Errorf("no transaction to create savepoint in")
+	}
+	if u.savepoints == nil {
+		u.savepoints = make(map[string]memorySavepoint)
+	}
+	u.savepoints[name] = memorySavepoint{savepoint: u.tx.Savepoint(), hookMark: len(u.afterCommit)}
+	return nil
+}
+
+// RollbackToSavepoint discards everything done after the savepoint was created,
+// leaving the transaction usable
+func (u *memoryUnitOfWork) RollbackToSavepoint(ctx context.Context, name string) error {
+	if u.tx == nil {
+		return fmt.Errorf("no transaction to rollback savepoint in")
+	}
+	sp, ok := u.savepoints[name]
+	if !ok {
+		return fmt.Errorf("failed to rollback to savepoint %s: savepoint does not exist", name)
+	}
+	u.tx.RollbackTo(sp.savepoint)
+	if sp.hookMark < len(u.afterCommit) {
+		u.afterCommit = u.afterCommit[:sp.hookMark]
+	}
+	return nil
+}
+
+// ReleaseSavepoint keeps the work done since the savepoint and forgets the savepoint
+func (u *memoryUnitOfWork) ReleaseSavepoint(ctx context.Context, name string) error {
+	if u.tx == nil {
+		return fmt.Errorf("no transaction to release savepoint in")
+	}
+	if _, ok := u.savepoints[name]; !ok {
+		return fmt.Errorf("failed to release savepoint %s: savepoint does not exist", name)
+	}
+	delete(u.savepoints, name)
+	return nil
+}
+
+// WithinTransaction runs fn in a transaction, or under a savepoint when one is running
+func (u *memoryUnitOfWork) WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return u.WithinTransactionTx(ctx, nil, fn)
+}
+
+// WithinTransactionTx runs fn in a transaction started with opts, or under a savepoint
+// when one is running
+func (u *memoryUnitOfWork) WithinTransactionTx(ctx context.Context, opts *sql.TxOptions, fn func(ctx context.Context) error) error {
+	if u.tx != nil {
+		return u.withinSavepoint(ctx, fn)
+	}
+
+	if err := u.BeginTx(ctx, opts); err != nil {
+		return err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			_ = u.Rollback()
+			panic(p)
+		}
+	}()
+
+	if err := fn(ctx); err != nil {
+		if rbErr := u.Rollback(); rbErr != nil {
+			return errors.Join(err, rbErr)
+		}
+		return err
+	}
+	return u.Commit()
+}
+
+// withinSavepoint runs fn under a new savepoint of the current transaction
+func (u *memoryUnitOfWork) withinSavepoint(ctx context.Context, fn func(ctx context.Context) error) error {
+	u.nesting++
+	defer func() { u.nesting-- }()
+	name := fmt.Sprintf("uow_nested_%d", u.nesting)
+
+	if err := u.Savepoint(ctx, name); err != nil {
+		return err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			_ = u.RollbackToSavepoint(ctx, name)
+			panic(p)
+		}
+	}()
+
+	if err := fn(ctx); err != nil {
+		if rbErr := u.RollbackToSavepoint(ctx, name); rbErr != nil {
+			return errors.Join(err, rbErr)
+		}
+		return err
+	}
+	return u.ReleaseSavepoint(ctx, name)
+}
+
+// reset resets the UnitOfWork to non-transactional state
+func (u *memoryUnitOfWork) reset() {
+	u.tx = nil
+	u.afterCommit = nil
+	u.nesting = 0
+	u.savepoints = nil
+}
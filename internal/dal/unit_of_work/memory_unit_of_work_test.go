@@ -0,0 +1,91 @@
+package dal
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/Lamafout/online-store-api/internal/dal/memory"
+	"github.com/Lamafout/online-store-api/internal/dal/models"
+	"github.com/Lamafout/online-store-api/internal/tenant"
+)
+
+func TestMemoryWithinTransactionNestsSavepoints(t *testing.T) {
+	ctx := tenant.WithTenant(context.Background(), "acme")
+	store := memory.NewStore()
+	uow := NewMemoryUnitOfWork(store)
+
+	create := func(ctx context.Context, customerID int64) error {
+		return uow.GetOrderRepo().CreateOrder(ctx, &models.V1OrderDal{CustomerID: customerID})
+	}
+
+	var hooks []string
+	err := uow.WithinTransaction(ctx, func(ctx context.Context) error {
+		uow.AfterCommit(func() { hooks = append(hooks, "outer") })
+		if err := create(ctx, 1); err != nil {
+			return err
+		}
+
+		if err := uow.WithinTransaction(ctx, func(ctx context.Context) error {
+			uow.AfterCommit(func() { hooks = append(hooks, "kept") })
+			return create(ctx, 2)
+		}); err != nil {
+			return err
+		}
+
+		_ = uow.WithinTransaction(ctx, func(ctx context.Context) error {
+			uow.AfterCommit(func() { hooks = append(hooks, "undone") })
+			if err := create(ctx, 3); err != nil {
+				return err
+			}
+			return errors.New("nested failure")
+		})
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("WithinTransaction() = %v", err)
+	}
+
+	orders, err := NewMemoryUnitOfWork(store).GetOrderRepo().QueryOrders(ctx, &models.QueryOrdersDalModel{})
+	if err != nil {
+		t.Fatalf("QueryOrders() = %v", err)
+	}
+	var customers []int64
+	for _, order := range orders {
+		customers = append(customers, order.CustomerID)
+	}
+	if !reflect.DeepEqual(customers, []int64{1, 2}) {
+		t.Fatalf("committed orders of customers %v, want [1 2]", customers)
+	}
+	if !reflect.DeepEqual(hooks, []string{"outer", "kept"}) {
+		t.Fatalf("hooks run = %v, want [outer kept]", hooks)
+	}
+}
+
+func TestMemoryWithinTransactionRollsBackOnPanic(t *testing.T) {
+	ctx := tenant.WithTenant(context.Background(), "acme")
+	uow := NewMemoryUnitOfWork(memory.NewStore())
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("panic was swallowed")
+			}
+		}()
+		_ = uow.WithinTransaction(ctx, func(ctx context.Context) error {
+			_ = uow.GetOrderRepo().CreateOrder(ctx, &models.V1OrderDal{CustomerID: 1})
+			panic("boom")
+		})
+	}()
+
+	orders, err := uow.GetOrderRepo().QueryOrders(ctx, &models.QueryOrdersDalModel{})
+	if err != nil || len(orders) != 0 {
+		t.Fatalf("QueryOrders() = %v, %v, want no orders", orders, err)
+	}
+	// The writer was released, so another transaction can start
+	if err := uow.Begin(ctx); err != nil {
+		t.Fatalf("UnitOfWork is unusable after a panic: %v", err)
+	}
+	_ = uow.Rollback()
+}
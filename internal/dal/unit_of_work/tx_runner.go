@@ -0,0 +1,134 @@
+package dal
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"time"
+
+	"github.com/Lamafout/online-store-api/internal/logging"
+	"github.com/Lamafout/online-store-api/internal/metrics"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Postgres error codes of failures that go away when the transaction is simply run again
+const (
+	pgSerializationFailure = "40001"
+	pgDeadlockDetected     = "40P01"
+)
+
+// RetryPolicy bounds how often and how patiently a TxRunner retries
+type RetryPolicy struct {
+	// MaxAttempts is the total number of runs, the first one included
+	MaxAttempts int
+	// BaseDelay is the backoff before the second attempt; it doubles with every retry
+	BaseDelay time.Duration
+	// MaxDelay caps the backoff
+	MaxDelay time.Duration
+}
+
+// TxRunner runs business functions in transactions. A run that fails with a
+// serialization failure or a deadlock is rolled back and re-run from scratch on a fresh
+// UnitOfWork, after a jittered exponential backoff.
+type TxRunner struct {
+	factory *UnitOfWorkFactory
+	opts    *sql.TxOptions
+	policy  RetryPolicy
+}
+
+// NewTxRunner creates a TxRunner starting its transactions with opts
+func NewTxRunner(factory *UnitOfWorkFactory, opts *sql.TxOptions, policy RetryPolicy) *TxRunner {
+	if policy.MaxAttempts < 1 {
+		policy.MaxAttempts = 1
+	}
+	return &TxRunner{
+		factory: factory,
+		opts:    opts,
+		policy:  policy,
+	}
+}
+
+// Run calls fn inside a transaction and commits when it succeeds. fn may be called
+// several times, so it must not have effects outside the UnitOfWork other than
+// through AfterCommit hooks.
+func (r *TxRunner) Run(ctx context.Context, fn func(ctx context.Context, uow UnitOfWork) error) error {
+	for attempt := 1; ; attempt++ {
+		uow := r.factory.Create()
+		err := uow.WithinTransactionTx(ctx, r.opts, func(ctx context.Context) error {
+			return fn(ctx, uow)
+		})
+
+		reason, retryable := retryReason(err)
+		if !retryable {
+			return err
+		}
+		if attempt >= r.policy.MaxAttempts {
+			metrics.DBTransactionRetriesExhaustedTotal.WithLabelValues(reason).Inc()
+			return err
+		}
+
+		metrics.DBTransactionRetriesTotal.WithLabelValues(reason).Inc()
+		delay := r.backoff(attempt)
+		logging.FromContext(ctx).Warn("Retrying transaction", "attempt", attempt, "reason", reason, "delay", delay, "error", err)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return errors.Join(err, ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
+
+// backoff returns the delay before the retry following attempt: half of the
+// exponential delay plus a random share of the other half, so concurrent
+// transactions that collided don't collide again in lockstep
+func (r *TxRunner) backoff(attempt int) time.Duration {
+	delay := r.policy.MaxDelay
+	// Past 32 doublings the delay is beyond any sensible cap, or overflows
+	if shift := attempt - 1; shift < 32 {
+		if d := r.policy.BaseDelay << shift; d > 0 && (delay <= 0 || d < delay) {
+			delay = d
+		}
+	}
+	if delay <= 0 {
+		return 0
+	}
+	half := delay / 2
+	return half + rand.N(delay-half+1)
+}
+
+// retryReason reports whether err is worth retrying and why
+func retryReason(err error) (string, bool) {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return "", false
+	}
+	switch pgErr.Code {
+	case pgSerializationFailure:
+		return "serialization_failure", true
+	case pgDeadlockDetected:
+		return "deadlock", true
+	}
+	return "", false
+}
+
+// ParseIsolationLevel parses an isolation level name such as "read_committed" or
+// "serializable". An empty name is the database default.
+func ParseIsolationLevel(name string) (sql.IsolationLevel, error) {
+	switch strings.ReplaceAll(strings.ToLower(strings.TrimSpace(name)), " ", "_") {
+	case "", "default":
+		return sql.LevelDefault, nil
+	case "read_committed":
+		return sql.LevelReadCommitted, nil
+	case "repeatable_read":
+		return sql.LevelRepeatableRead, nil
+	case "serializable":
+		return sql.LevelSerializable, nil
+	}
+	return sql.LevelDefault, fmt.Errorf("unknown isolation level %q", name)
+}
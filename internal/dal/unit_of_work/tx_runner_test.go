@@ -0,0 +1,118 @@
+package dal
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func newTestRunner(t *testing.T, maxAttempts int) (*TxRunner, *recordingDriver) {
+	t.Helper()
+	uow, d := newTestUnitOfWork(t)
+	factory := NewUnitOfWorkFactory(uow.(*unitOfWork).db, nil, false)
+	return NewTxRunner(factory, &sql.TxOptions{Isolation: sql.LevelSerializable}, RetryPolicy{
+		MaxAttempts: maxAttempts,
+		BaseDelay:   time.Millisecond,
+		MaxDelay:    5 * time.Millisecond,
+	}), d
+}
+
+func TestTxRunnerRetriesRetryableErrors(t *testing.T) {
+	runner, d := newTestRunner(t, 3)
+
+	attempts := 0
+	err := runner.Run(context.Background(), func(ctx context.Context, uow UnitOfWork) error {
+		attempts++
+		switch attempts {
+		case 1:
+			return fmt.Errorf("failed to create order: %w", &pgconn.PgError{Code: pgSerializationFailure})
+		case 2:
+			return &pgconn.PgError{Code: pgDeadlockDetected}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Run() = %v", err)
+	}
+	if attempts != 3 {
+		t.Fatalf("attempts = %d, want 3", attempts)
+	}
+	if want := 6; len(d.statements) != want {
+		t.Fatalf("statements = %q, want %d", d.statements, want)
+	}
+	if last := d.statements[len(d.statements)-1]; last != "COMMIT" {
+		t.Fatalf("last statement = %q, want COMMIT", last)
+	}
+}
+
+func TestTxRunnerStops(t *testing.T) {
+	tests := []struct {
+		name         string
+		err          error
+		wantAttempts int
+	}{
+		{name: "non-retryable error", err: errors.New("boom"), wantAttempts: 1},
+		{name: "other postgres error", err: &pgconn.PgError{Code: "23505"}, wantAttempts: 1},
+		{name: "attempts exhausted", err: &pgconn.PgError{Code: pgSerializationFailure}, wantAttempts: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner, _ := newTestRunner(t, 2)
+
+			attempts := 0
+			err := runner.Run(context.Background(), func(ctx context.Context, uow UnitOfWork) error {
+				attempts++
+				return tt.err
+			})
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Run() = %v, want %v", err, tt.err)
+			}
+			if attempts != tt.wantAttempts {
+				t.Fatalf("attempts = %d, want %d", attempts, tt.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestTxRunnerBackoff(t *testing.T) {
+	runner := NewTxRunner(nil, nil, RetryPolicy{MaxAttempts: 10, BaseDelay: 10 * time.Millisecond, MaxDelay: 50 * time.Millisecond})
+
+	tests := []struct {
+		attempt  int
+		min, max time.Duration
+	}{
+		{attempt: 1, min: 5 * time.Millisecond, max: 10 * time.Millisecond},
+		{attempt: 2, min: 10 * time.Millisecond, max: 20 * time.Millisecond},
+		{attempt: 4, min: 25 * time.Millisecond, max: 50 * time.Millisecond},
+		{attempt: 40, min: 25 * time.Millisecond, max: 50 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		for range 20 {
+			if got := runner.backoff(tt.attempt); got < tt.min || got > tt.max {
+				t.Fatalf("backoff(%d) = %v, want between %v and %v", tt.attempt, got, tt.min, tt.max)
+			}
+		}
+	}
+}
+
+func TestParseIsolationLevel(t *testing.T) {
+	for name, want := range map[string]sql.IsolationLevel{
+		"":                sql.LevelDefault,
+		"read_committed":  sql.LevelReadCommitted,
+		"Repeatable Read": sql.LevelRepeatableRead,
+		"serializable":    sql.LevelSerializable,
+	} {
+		if got, err := ParseIsolationLevel(name); err != nil || got != want {
+			t.Errorf("ParseIsolationLevel(%q) = %v, %v, want %v", name, got, err, want)
+		}
+	}
+	if _, err := ParseIsolationLevel("snapshot"); err == nil {
+		t.Error("ParseIsolationLevel(snapshot) succeeded")
+	}
+}
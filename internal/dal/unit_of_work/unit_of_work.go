@@ -2,24 +2,101 @@ package dal
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
+	"log/slog"
 
 	"github.com/Lamafout/online-store-api/internal/dal/interfaces"
+	"github.com/Lamafout/online-store-api/internal/dal/replica"
 	"github.com/Lamafout/online-store-api/internal/dal/repositories"
+	"github.com/Lamafout/online-store-api/internal/metrics"
+	"github.com/Lamafout/online-store-api/internal/tracing"
+	"github.com/Lamafout/online-store-api/internal/tenant"
+	"github.com/jackc/pgx/v5"
 	"github.com/jmoiron/sqlx"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-// UnitOfWork manages database transactions and repositories
-type UnitOfWork struct {
+var tracer = otel.Tracer("github.com/Lamafout/online-store-api/internal/dal/unit_of_work")
+
+// UnitOfWork manages database transactions and hands out repositories bound to the
+// current one. A UnitOfWork is used by one goroutine at a time.
+type UnitOfWork interface {
+	GetOrderRepo() interfaces.IOrderRepository
+	GetOrderItemRepo() interfaces.IOrderItemRepository
+	GetJobRepo() interfaces.IJobRepository
+	GetAPIKeyRepo() interfaces.IAPIKeyRepository
+	GetRoleAssignmentRepo() interfaces.IRoleAssignmentRepository
+	GetRateLimitRepo() interfaces.IRateLimitRepository
+
+	// Begin starts a read-write transaction with the default isolation level
+	Begin(ctx context.Context) error
+	// BeginTx starts a transaction with the given isolation level and access mode;
+	// nil opts means the defaults. The transaction is rolled back if ctx is done
+	// before it commits.
+	BeginTx(ctx context.Context, opts *sql.TxOptions) error
+	BeginRead(ctx context.Context) error
+	// ReadsFromReplica reports whether BeginRead sent the reads to a replica, which may
+	// not have replayed the latest writes of other clients
+	ReadsFromReplica() bool
+	Commit() error
+	Rollback() error
+	AfterCommit(fn func())
+
+	Savepoint(ctx context.Context, name string) error
+	RollbackToSavepoint(ctx context.Context, name string) error
+	ReleaseSavepoint(ctx context.Context, name string) error
+
+	// WithinTransaction runs fn in a transaction that commits when fn succeeds and
+	// rolls back when it fails or panics. Called inside a transaction, fn runs under a
+	// savepoint instead, so a failing nested step only undoes its own work.
+	WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error
+	// WithinTransactionTx is WithinTransaction starting the outermost transaction with
+	// opts. Nested calls inherit the options of the enclosing transaction.
+	WithinTransactionTx(ctx context.Context, opts *sql.TxOptions, fn func(ctx context.Context) error) error
+}
+
+// unitOfWork is the UnitOfWork over a Postgres connection pool
+type unitOfWork struct {
 	db            *sqlx.DB
 	tx            *sqlx.Tx
 	currentDB     interfaces.DBExecuter
 	isTransaction bool
+	// rowLevelSecurity makes every transaction set app.tenant_id for the Postgres
+	// row-level security policies
+	rowLevelSecurity bool
+	// afterCommit holds the hooks to run once the current transaction commits
+	afterCommit []func()
+	// span covers the current transaction from begin to commit or rollback
+	span trace.Span
+	// nesting counts the savepoints opened by WithinTransaction, which names them
+	nesting int
+	// hookMarks remembers how many afterCommit hooks were registered when each
+	// savepoint was created, so rolling back to it drops the hooks of the undone work
+	hookMarks map[string]int
+	// replicas serve BeginRead when set; onReplica is true while non-transactional
+	// reads go to one of them
+	replicas  *replica.Pool
+	onReplica bool
+	// fromReplica is true while the reads started by BeginRead, transactional or not,
+	// go to a replica
+	fromReplica bool
+	// session is the read_your_writes session of the current transaction, which
+	// learns the LSN of its commit
+	session  *replica.Session
+	readOnly bool
 }
 
 // NewUnitOfWork creates a new UnitOfWork
-func NewUnitOfWork(db *sqlx.DB) *UnitOfWork {
-	return &UnitOfWork{
+func NewUnitOfWork(db *sqlx.DB) UnitOfWork {
+	return newUnitOfWork(db)
+}
+
+func newUnitOfWork(db *sqlx.DB) *unitOfWork {
+	return &unitOfWork{
 		db:            db,
 		currentDB:     db,
 		isTransaction: false,
@@ -27,58 +104,328 @@ func NewUnitOfWork(db *sqlx.DB) *UnitOfWork {
 }
 
 // GetOrderRepo lazily initializes and returns the OrderRepository
-func (u *UnitOfWork) GetOrderRepo() interfaces.IOrderRepository {
+func (u *unitOfWork) GetOrderRepo() interfaces.IOrderRepository {
 	return repositories.NewOrderRepository(u.currentDB)
 }
 
 // GetOrderItemRepo lazily initializes and returns the OrderItemRepository
-func (u *UnitOfWork) GetOrderItemRepo() interfaces.IOrderItemRepository {
+func (u *unitOfWork) GetOrderItemRepo() interfaces.IOrderItemRepository {
 	return repositories.NewOrderItemRepository(u.currentDB)
 }
 
+// GetJobRepo lazily initializes and returns the JobRepository
+func (u *unitOfWork) GetJobRepo() interfaces.IJobRepository {
+	return repositories.NewJobRepository(u.currentDB)
+}
+
+// GetAPIKeyRepo lazily initializes and returns the APIKeyRepository
+func (u *unitOfWork) GetAPIKeyRepo() interfaces.IAPIKeyRepository {
+	return repositories.NewAPIKeyRepository(u.currentDB)
+}
+
+// GetRoleAssignmentRepo lazily initializes and returns the RoleAssignmentRepository
+func (u *unitOfWork) GetRoleAssignmentRepo() interfaces.IRoleAssignmentRepository {
+	return repositories.NewRoleAssignmentRepository(u.currentDB)
+}
+
+// GetRateLimitRepo lazily initializes and returns the RateLimitRepository
+func (u *unitOfWork) GetRateLimitRepo() interfaces.IRateLimitRepository {
+	return repositories.NewRateLimitRepository(u.currentDB)
+}
+
 // Begin starts a new transaction
-func (u *UnitOfWork) Begin(ctx context.Context) error {
+func (u *unitOfWork) Begin(ctx context.Context) error {
+	return u.BeginTx(ctx, nil)
+}
+
+// BeginTx starts a new transaction with opts
+func (u *unitOfWork) BeginTx(ctx context.Context, opts *sql.TxOptions) error {
 	if u.isTransaction {
 		return fmt.Errorf("transaction already started")
 	}
-	tx, err := u.db.Beginx()
+	tx, err := u.db.BeginTxx(ctx, opts)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	return u.startTransaction(ctx, tx, opts)
+}
+
+// BeginRead prepares the UnitOfWork for reads. With replicas configured, reads go to a
+// healthy replica that has replayed the writes of the read_your_writes session of ctx,
+// or to the primary when there is none. With row-level security enabled the tenant can
+// only be applied inside a transaction, so a read-only one is started; otherwise reads
+// go straight to the pool. Callers release it with Rollback, whose error is meaningless
+// when no transaction was started.
+func (u *unitOfWork) BeginRead(ctx context.Context) error {
+	if u.isTransaction {
+		return nil
+	}
+	db := u.db
+	if u.replicas != nil {
+		var minLSN replica.LSN
+		if session, ok := replica.SessionFromContext(ctx); ok {
+			minLSN = session.MinLSN()
+		}
+		if r := u.replicas.Pick(minLSN); r != nil {
+			db = r
+		}
+		target := "primary"
+		if db != u.db {
+			target = "replica"
+		}
+		metrics.DBReadsTotal.WithLabelValues(target).Inc()
+	}
+
+	if !u.rowLevelSecurity {
+		u.currentDB = db
+		u.onReplica = db != u.db
+		u.fromReplica = u.onReplica
+		return nil
+	}
+	opts := &sql.TxOptions{ReadOnly: true}
+	tx, err := db.BeginTxx(ctx, opts)
 	if err != nil {
 		return fmt.Errorf("failed to begin transaction: %w", err)
 	}
+	if err := u.startTransaction(ctx, tx, opts); err != nil {
+		return err
+	}
+	u.fromReplica = db != u.db
+	return nil
+}
+
+// ReadsFromReplica reports whether BeginRead sent the reads to a replica
+func (u *unitOfWork) ReadsFromReplica() bool {
+	return u.fromReplica
+}
+
+// startTransaction binds the UnitOfWork to tx, applying the tenant of ctx for row-level
+// security. set_config with is_local=true scopes the setting to the transaction, so it
+// never leaks to the next user of the pooled connection.
+func (u *unitOfWork) startTransaction(ctx context.Context, tx *sqlx.Tx, opts *sql.TxOptions) error {
+	if u.rowLevelSecurity {
+		tenantID, err := tenant.Require(ctx)
+		if err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "SELECT set_config('app.tenant_id', $1, true)", tenantID); err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("failed to set tenant for transaction: %w", err)
+		}
+	}
 	u.tx = tx
 	u.currentDB = tx
 	u.isTransaction = true
+	u.onReplica = false
+	if opts == nil {
+		opts = &sql.TxOptions{}
+	}
+	u.readOnly = opts.ReadOnly
+	u.session = nil
+	if session, ok := replica.SessionFromContext(ctx); ok && u.replicas != nil {
+		u.session = session
+	}
+	_, u.span = tracer.Start(ctx, "UnitOfWork.Transaction", trace.WithAttributes(
+		attribute.Bool("db.transaction.read_only", opts.ReadOnly),
+		attribute.String("db.transaction.isolation_level", opts.Isolation.String()),
+	))
 	return nil
 }
 
 // Commit commits the transaction
-func (u *UnitOfWork) Commit() error {
+func (u *unitOfWork) Commit() error {
 	if !u.isTransaction {
 		return fmt.Errorf("no transaction to commit")
 	}
 	if err := u.tx.Commit(); err != nil {
+		metrics.DBTransactionsTotal.WithLabelValues("commit_failed").Inc()
+		u.endSpan("commit_failed", err)
+		// The transaction is over either way; its hooks must not run
+		u.reset()
 		return fmt.Errorf("failed to commit transaction: %w", err)
 	}
+	metrics.DBTransactionsTotal.WithLabelValues("commit").Inc()
+	u.endSpan("commit", nil)
+	if u.session != nil && !u.readOnly {
+		u.observeCommit()
+	}
+	hooks := u.afterCommit
 	u.reset()
+	for _, fn := range hooks {
+		fn()
+	}
 	return nil
 }
 
 // Rollback rolls back the transaction
-func (u *UnitOfWork) Rollback() error {
+func (u *unitOfWork) Rollback() error {
+	if u.onReplica {
+		u.reset()
+		return nil
+	}
 	if !u.isTransaction {
 		return fmt.Errorf("no transaction to rollback")
 	}
-	if err := u.tx.Rollback(); err != nil {
+	// The transaction is over even when the rollback fails, e.g. on a broken
+	// connection, so the UnitOfWork is released either way
+	err := u.tx.Rollback()
+	metrics.DBTransactionsTotal.WithLabelValues("rollback").Inc()
+	u.endSpan("rollback", err)
+	u.reset()
+	if err != nil {
 		return fmt.Errorf("failed to rollback transaction: %w", err)
 	}
-	u.reset()
 	return nil
 }
 
+// AfterCommit runs fn once the work done so far is durable: when the current transaction
+// commits, or right away outside of one. Hooks of a rolled back transaction, or of work
+// rolled back to a savepoint, never run.
+func (u *unitOfWork) AfterCommit(fn func()) {
+	if !u.isTransaction {
+		fn()
+		return
+	}
+	u.afterCommit = append(u.afterCommit, fn)
+}
+
+// Savepoint creates a savepoint inside the current transaction
+func (u *unitOfWork) Savepoint(ctx context.Context, name string) error {
+	if !u.isTransaction {
+		return fmt.Errorf("no transaction to create savepoint in")
+	}
+	if _, err := u.tx.ExecContext(ctx, "SAVEPOINT "+pgx.Identifier{name}.Sanitize()); err != nil {
+		return fmt.Errorf("failed to create savepoint %s: %w", name, err)
+	}
+	if u.hookMarks == nil {
+		u.hookMarks = make(map[string]int)
+	}
+	u.hookMarks[name] = len(u.afterCommit)
+	return nil
+}
+
+// RollbackToSavepoint discards everything done after the savepoint was created,
+// leaving the transaction usable
+func (u *unitOfWork) RollbackToSavepoint(ctx context.Context, name string) error {
+	if !u.isTransaction {
+		return fmt.Errorf("no transaction to rollback savepoint in")
+	}
+	if _, err := u.tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+pgx.Identifier{name}.Sanitize()); err != nil {
+		return fmt.Errorf("failed to rollback to savepoint %s: %w", name, err)
+	}
+	if mark, ok := u.hookMarks[name]; ok && mark < len(u.afterCommit) {
+		u.afterCommit = u.afterCommit[:mark]
+	}
+	return nil
+}
+
+// ReleaseSavepoint keeps the work done since the savepoint and forgets the savepoint
+func (u *unitOfWork) ReleaseSavepoint(ctx context.Context, name string) error {
+	if !u.isTransaction {
+		return fmt.Errorf("no transaction to release savepoint in")
+	}
+	if _, err := u.tx.ExecContext(ctx, "RELEASE SAVEPOINT "+pgx.Identifier{name}.Sanitize()); err != nil {
+		return fmt.Errorf("failed to release savepoint %s: %w", name, err)
+	}
+	return nil
+}
+
+// WithinTransaction runs fn in a transaction, or under a savepoint when one is running
+func (u *unitOfWork) WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return u.WithinTransactionTx(ctx, nil, fn)
+}
+
+// WithinTransactionTx runs fn in a transaction started with opts, or under a savepoint
+// when one is running
+func (u *unitOfWork) WithinTransactionTx(ctx context.Context, opts *sql.TxOptions, fn func(ctx context.Context) error) (err error) {
+	if u.isTransaction {
+		return u.withinSavepoint(ctx, fn)
+	}
+
+	if err := u.BeginTx(ctx, opts); err != nil {
+		return err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			_ = u.Rollback()
+			panic(p)
+		}
+	}()
+
+	if err := fn(trace.ContextWithSpan(ctx, u.span)); err != nil {
+		if rbErr := u.Rollback(); rbErr != nil {
+			return errors.Join(err, rbErr)
+		}
+		return err
+	}
+	return u.Commit()
+}
+
+// withinSavepoint runs fn under a new savepoint of the current transaction, releasing
+// it when fn succeeds and rolling back to it when fn fails or panics
+func (u *unitOfWork) withinSavepoint(ctx context.Context, fn func(ctx context.Context) error) (err error) {
+	u.nesting++
+	defer func() { u.nesting-- }()
+	name := fmt.Sprintf("uow_nested_%d", u.nesting)
+
+	if err := u.Savepoint(ctx, name); err != nil {
+		return err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			_ = u.RollbackToSavepoint(ctx, name)
+			panic(p)
+		}
+	}()
+
+	if err := fn(ctx); err != nil {
+		if rbErr := u.RollbackToSavepoint(ctx, name); rbErr != nil {
+			return errors.Join(err, rbErr)
+		}
+		return err
+	}
+	return u.ReleaseSavepoint(ctx, name)
+}
+
+// endSpan ends the span of the current transaction with its result
+func (u *unitOfWork) endSpan(result string, err error) {
+	if u.span == nil {
+		return
+	}
+	u.span.SetAttributes(attribute.String("db.transaction.result", result))
+	tracing.End(u.span, err)
+	u.span = nil
+}
+
+// observeCommit records the LSN of the transaction that just committed in its session.
+// The current WAL position of the primary is at or past the commit record, so a replica
+// that has replayed up to it sees the writes. Failing to get it only risks a stale read.
+func (u *unitOfWork) observeCommit() {
+	var value string
+	if err := u.db.GetContext(context.Background(), &value, "SELECT pg_current_wal_lsn()::text"); err != nil {
+		slog.Warn("Failed to get the LSN of a commit", "error", err)
+		return
+	}
+	lsn, err := replica.ParseLSN(value)
+	if err != nil {
+		slog.Warn("Failed to get the LSN of a commit", "error", err)
+		return
+	}
+	u.session.Observe(lsn)
+}
+
 // reset resets the UnitOfWork to non-transactional state
-func (u *UnitOfWork) reset() {
+func (u *unitOfWork) reset() {
 	u.tx = nil
 	u.currentDB = u.db
+	u.onReplica = false
+	u.fromReplica = false
+	u.session = nil
+	u.readOnly = false
 	u.isTransaction = false
+	u.afterCommit = nil
+	u.nesting = 0
+	u.hookMarks = nil
 	// No need to reset repos, as they use u.currentDB
 }
\ No newline at end of file
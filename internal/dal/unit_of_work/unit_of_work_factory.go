@@ -0,0 +1,44 @@
+package dal
+
+import (
+	"github.com/Lamafout/online-store-api/internal/dal/memory"
+	"github.com/Lamafout/online-store-api/internal/dal/replica"
+	"github.com/jmoiron/sqlx"
+)
+
+// UnitOfWorkFactory creates UnitOfWorks sharing one connection pool, its read replicas
+// and settings, or one in-memory store
+type UnitOfWorkFactory struct {
+	db               *sqlx.DB
+	replicas         *replica.Pool
+	rowLevelSecurity bool
+	memory           *memory.Store
+}
+
+// NewUnitOfWorkFactory creates a new UnitOfWorkFactory. Reads started with BeginRead go to
+// replicas when it is not nil. With rowLevelSecurity set, every transaction applies the
+// tenant of its context to the Postgres row-level security policies.
+func NewUnitOfWorkFactory(db *sqlx.DB, replicas *replica.Pool, rowLevelSecurity bool) *UnitOfWorkFactory {
+	return &UnitOfWorkFactory{
+		db:               db,
+		replicas:         replicas,
+		rowLevelSecurity: rowLevelSecurity,
+	}
+}
+
+// NewMemoryUnitOfWorkFactory creates a UnitOfWorkFactory whose UnitOfWorks keep orders
+// in store instead of Postgres
+func NewMemoryUnitOfWorkFactory(store *memory.Store) *UnitOfWorkFactory {
+	return &UnitOfWorkFactory{memory: store}
+}
+
+// Create returns a new non-transactional UnitOfWork
+func (f *UnitOfWorkFactory) Create() UnitOfWork {
+	if f.memory != nil {
+		return NewMemoryUnitOfWork(f.memory)
+	}
+	uow := newUnitOfWork(f.db)
+	uow.rowLevelSecurity = f.rowLevelSecurity
+	uow.replicas = f.replicas
+	return uow
+}
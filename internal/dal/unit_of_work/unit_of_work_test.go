@@ -0,0 +1,178 @@
+package dal
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// recordingDriver is a database/sql driver that accepts every statement and records
+// the transaction control flow, which is all a UnitOfWork issues on its own
+type recordingDriver struct {
+	mu         sync.Mutex
+	statements []string
+	// rollbackErr makes rollbacks fail after being recorded
+	rollbackErr error
+}
+
+func (d *recordingDriver) Open(string) (driver.Conn, error) { return &recordingConn{d: d}, nil }
+
+func (d *recordingDriver) record(statement string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.statements = append(d.statements, statement)
+}
+
+type recordingConn struct{ d *recordingDriver }
+
+func (c *recordingConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c *recordingConn) Close() error                        { return nil }
+func (c *recordingConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *recordingConn) BeginTx(_ context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	statement := "BEGIN"
+	if opts.Isolation != driver.IsolationLevel(sql.LevelDefault) {
+		statement += " " + sql.IsolationLevel(opts.Isolation).String()
+	}
+	c.d.record(statement)
+	return &recordingTx{d: c.d}, nil
+}
+
+func (c *recordingConn) ExecContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Result, error) {
+	c.d.record(query)
+	return driver.RowsAffected(0), nil
+}
+
+type recordingTx struct{ d *recordingDriver }
+
+func (t *recordingTx) Commit() error   { t.d.record("COMMIT"); return nil }
+func (t *recordingTx) Rollback() error { t.d.record("ROLLBACK"); return t.d.rollbackErr }
+
+var registerOnce sync.Once
+var testDriver = &recordingDriver{}
+
+func newTestUnitOfWork(t *testing.T) (UnitOfWork, *recordingDriver) {
+	t.Helper()
+	registerOnce.Do(func() { sql.Register("uow-recording", testDriver) })
+	testDriver.statements = nil
+	testDriver.rollbackErr = nil
+
+	db, err := sqlx.Open("uow-recording", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	return NewUnitOfWork(db), testDriver
+}
+
+func TestWithinTransactionCommitsOrRollsBack(t *testing.T) {
+	ctx := context.Background()
+	uow, d := newTestUnitOfWork(t)
+
+	if err := uow.WithinTransactionTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable}, func(context.Context) error {
+		return nil
+	}); err != nil {
+		t.Fatalf("WithinTransactionTx() = %v", err)
+	}
+
+	failure := errors.New("boom")
+	if err := uow.WithinTransaction(ctx, func(context.Context) error { return failure }); !errors.Is(err, failure) {
+		t.Fatalf("WithinTransaction() = %v, want %v", err, failure)
+	}
+
+	want := []string{"BEGIN Serializable", "COMMIT", "BEGIN", "ROLLBACK"}
+	if !reflect.DeepEqual(d.statements, want) {
+		t.Fatalf("statements = %q, want %q", d.statements, want)
+	}
+}
+
+func TestWithinTransactionNestsSavepoints(t *testing.T) {
+	ctx := context.Background()
+	uow, d := newTestUnitOfWork(t)
+
+	var hooks []string
+	err := uow.WithinTransaction(ctx, func(ctx context.Context) error {
+		uow.AfterCommit(func() { hooks = append(hooks, "outer") })
+
+		if err := uow.WithinTransaction(ctx, func(ctx context.Context) error {
+			uow.AfterCommit(func() { hooks = append(hooks, "kept") })
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		_ = uow.WithinTransaction(ctx, func(ctx context.Context) error {
+			uow.AfterCommit(func() { hooks = append(hooks, "undone") })
+			return uow.WithinTransaction(ctx, func(context.Context) error { return errors.New("nested failure") })
+		})
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("WithinTransaction() = %v", err)
+	}
+
+	want := []string{
+		"BEGIN",
+		`SAVEPOINT "uow_nested_1"`, `RELEASE SAVEPOINT "uow_nested_1"`,
+		`SAVEPOINT "uow_nested_1"`, `SAVEPOINT "uow_nested_2"`,
+		`ROLLBACK TO SAVEPOINT "uow_nested_2"`, `ROLLBACK TO SAVEPOINT "uow_nested_1"`,
+		"COMMIT",
+	}
+	if !reflect.DeepEqual(d.statements, want) {
+		t.Fatalf("statements:\n%s\nwant:\n%s", strings.Join(d.statements, "\n"), strings.Join(want, "\n"))
+	}
+	if !reflect.DeepEqual(hooks, []string{"outer", "kept"}) {
+		t.Fatalf("hooks run = %v, want [outer kept]", hooks)
+	}
+}
+
+func TestWithinTransactionRollsBackOnPanic(t *testing.T) {
+	uow, d := newTestUnitOfWork(t)
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("panic was swallowed")
+			}
+		}()
+		_ = uow.WithinTransaction(context.Background(), func(context.Context) error { panic("boom") })
+	}()
+
+	if want := []string{"BEGIN", "ROLLBACK"}; !reflect.DeepEqual(d.statements, want) {
+		t.Fatalf("statements = %q, want %q", d.statements, want)
+	}
+	if err := uow.Begin(context.Background()); err != nil {
+		t.Fatalf("UnitOfWork is unusable after a panic: %v", err)
+	}
+}
+
+func TestRollbackReleasesUnitOfWorkWhenItFails(t *testing.T) {
+	ctx := context.Background()
+	uow, d := newTestUnitOfWork(t)
+	d.rollbackErr = driver.ErrBadConn
+
+	if err := uow.Begin(ctx); err != nil {
+		t.Fatalf("Begin() = %v", err)
+	}
+	if err := uow.Rollback(); !errors.Is(err, driver.ErrBadConn) {
+		t.Fatalf("Rollback() = %v, want %v", err, driver.ErrBadConn)
+	}
+
+	ran := false
+	uow.AfterCommit(func() { ran = true })
+	if !ran {
+		t.Fatal("UnitOfWork is still in the failed transaction")
+	}
+	if err := uow.Begin(ctx); err != nil {
+		t.Fatalf("Begin() after the failed rollback = %v", err)
+	}
+}
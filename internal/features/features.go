@@ -0,0 +1,59 @@
+// Package features switches optional endpoints and modes on and off at runtime.
+package features
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"github.com/Lamafout/online-store-api/internal/problem"
+)
+
+// CodeFeatureDisabled is the problem code of requests for a switched off feature
+const CodeFeatureDisabled = "feature_disabled"
+
+// Flags tells which optional features are on
+type Flags struct {
+	OrderImport  bool
+	OrderExport  bool
+	AsyncBatches bool
+}
+
+// All has every feature on
+var All = Flags{OrderImport: true, OrderExport: true, AsyncBatches: true}
+
+// Toggles holds the current Flags. Requests read them as they arrive, so flags can
+// be swapped while the server runs.
+type Toggles struct {
+	flags atomic.Pointer[Flags]
+}
+
+// NewToggles creates Toggles starting with flags
+func NewToggles(flags Flags) *Toggles {
+	t := &Toggles{}
+	t.Store(flags)
+	return t
+}
+
+// Load returns the current flags
+func (t *Toggles) Load() Flags {
+	return *t.flags.Load()
+}
+
+// Store replaces the flags
+func (t *Toggles) Store(flags Flags) {
+	t.flags.Store(&flags)
+}
+
+// Require answers 404 with CodeFeatureDisabled while enabled reports the feature off,
+// as if the route did not exist
+func Require(t *Toggles, enabled func(Flags) bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !enabled(t.Load()) {
+				problem.Error(w, r, http.StatusNotFound, CodeFeatureDisabled, "This feature is disabled")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,31 @@
+package features
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequireFollowsStoredFlags(t *testing.T) {
+	toggles := NewToggles(All)
+	h := Require(toggles, func(f Flags) bool { return f.OrderExport })(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	serve := func() int {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/export", nil))
+		return rec.Code
+	}
+	if code := serve(); code != http.StatusNoContent {
+		t.Fatalf("enabled status = %d, want %d", code, http.StatusNoContent)
+	}
+
+	toggles.Store(Flags{OrderImport: true})
+	if code := serve(); code != http.StatusNotFound {
+		t.Fatalf("disabled status = %d, want %d", code, http.StatusNotFound)
+	}
+	if got := toggles.Load(); !got.OrderImport || got.OrderExport || got.AsyncBatches {
+		t.Fatalf("Load() = %+v", got)
+	}
+}
@@ -0,0 +1,185 @@
+package v1
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/Lamafout/online-store-api/core/models/common"
+	"github.com/Lamafout/online-store-api/core/models/dto"
+	"github.com/Lamafout/online-store-api/internal/auth"
+	"github.com/Lamafout/online-store-api/internal/bll/services"
+	dal "github.com/Lamafout/online-store-api/internal/dal/unit_of_work"
+	"github.com/Lamafout/online-store-api/internal/problem"
+	"github.com/go-chi/chi/v5"
+)
+
+type APIKeyHandler struct {
+	uowFactory *dal.UnitOfWorkFactory
+	service    *services.APIKeyService
+}
+
+func NewAPIKeyHandler(uowFactory *dal.UnitOfWorkFactory, service *services.APIKeyService) *APIKeyHandler {
+	return &APIKeyHandler{
+		uowFactory: uowFactory,
+		service:    service,
+	}
+}
+
+func (h *APIKeyHandler) Routes() chi.Router {
+	r := chi.NewRouter()
+	r.Post("/", h.CreateAPIKey)
+	r.Get("/", h.ListAPIKeys)
+	r.Post("/{id}/rotate", h.RotateAPIKey)
+	r.Delete("/{id}", h.RevokeAPIKey)
+	return r
+}
+
+// @Summary Create an API key
+// @Description Issues a new API key for a service client. The key is only returned in this response.
+// @Tags API keys
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body dto.V1CreateAPIKeyRequest true "API key data"
+// @Success 201 {object} dto.V1APIKeySecretResponse
+// @Failure 400 {object} dto.V1Problem
+// @Failure 401 {object} dto.V1Problem
+// @Failure 403 {object} dto.V1Problem
+// @Failure 422 {object} dto.V1Problem
+// @Failure 429 {object} dto.V1Problem
+// @Failure 500 {object} dto.V1Problem
+// @Router /api-keys [post]
+func (h *APIKeyHandler) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	principal, ok := authorize(w, r, auth.PermissionAPIKeysAdmin)
+	if !ok {
+		return
+	}
+	uow := h.uowFactory.Create()
+
+	var req dto.V1CreateAPIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidRequestBody, "Invalid request body")
+		return
+	}
+
+	key, secret, err := h.service.CreateAPIKey(ctx, uow, &req, principal.Subject)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(dto.V1APIKeySecretResponse{APIKey: *key, Key: secret})
+}
+
+// @Summary List API keys
+// @Description Lists all API keys, including revoked ones, without their secrets
+// @Tags API keys
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {object} dto.V1ListAPIKeysResponse
+// @Failure 401 {object} dto.V1Problem
+// @Failure 403 {object} dto.V1Problem
+// @Failure 429 {object} dto.V1Problem
+// @Failure 500 {object} dto.V1Problem
+// @Router /api-keys [get]
+func (h *APIKeyHandler) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if _, ok := authorize(w, r, auth.PermissionAPIKeysAdmin); !ok {
+		return
+	}
+	uow := h.uowFactory.Create()
+
+	keys, err := h.service.ListAPIKeys(ctx, uow)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	response := dto.V1ListAPIKeysResponse{
+		APIKeys: make([]common.APIKey, len(keys)),
+	}
+	for i, key := range keys {
+		response.APIKeys[i] = *key
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(response)
+}
+
+// @Summary Rotate an API key
+// @Description Replaces the secret of an API key. The previous key stops working immediately and the new one is only returned in this response.
+// @Tags API keys
+// @Security BearerAuth
+// @Produce json
+// @Param id path int true "API key ID"
+// @Success 200 {object} dto.V1APIKeySecretResponse
+// @Failure 400 {object} dto.V1Problem
+// @Failure 401 {object} dto.V1Problem
+// @Failure 403 {object} dto.V1Problem
+// @Failure 404 {object} dto.V1Problem
+// @Failure 409 {object} dto.V1Problem
+// @Failure 429 {object} dto.V1Problem
+// @Failure 500 {object} dto.V1Problem
+// @Router /api-keys/{id}/rotate [post]
+func (h *APIKeyHandler) RotateAPIKey(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if _, ok := authorize(w, r, auth.PermissionAPIKeysAdmin); !ok {
+		return
+	}
+	uow := h.uowFactory.Create()
+
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidParameter, "Invalid API key ID")
+		return
+	}
+
+	key, secret, err := h.service.RotateAPIKey(ctx, uow, id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(dto.V1APIKeySecretResponse{APIKey: *key, Key: secret})
+}
+
+// @Summary Revoke an API key
+// @Description Permanently disables an API key
+// @Tags API keys
+// @Security BearerAuth
+// @Param id path int true "API key ID"
+// @Success 204
+// @Failure 400 {object} dto.V1Problem
+// @Failure 401 {object} dto.V1Problem
+// @Failure 403 {object} dto.V1Problem
+// @Failure 404 {object} dto.V1Problem
+// @Failure 429 {object} dto.V1Problem
+// @Failure 500 {object} dto.V1Problem
+// @Router /api-keys/{id} [delete]
+func (h *APIKeyHandler) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if _, ok := authorize(w, r, auth.PermissionAPIKeysAdmin); !ok {
+		return
+	}
+	uow := h.uowFactory.Create()
+
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidParameter, "Invalid API key ID")
+		return
+	}
+
+	if err := h.service.RevokeAPIKey(ctx, uow, id); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,53 @@
+package v1
+
+import (
+	"net/http"
+
+	"github.com/Lamafout/online-store-api/core/models/dto"
+	"github.com/Lamafout/online-store-api/internal/auth"
+	"github.com/Lamafout/online-store-api/internal/problem"
+)
+
+// requirePrincipal returns the authenticated caller, answering 401 when there is none
+func requirePrincipal(w http.ResponseWriter, r *http.Request) (*auth.Principal, bool) {
+	principal, ok := auth.PrincipalFromContext(r.Context())
+	if !ok {
+		problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthenticated, "Authentication required")
+		return nil, false
+	}
+	return principal, true
+}
+
+// authorize applies the access policy before a handler touches any service: it returns
+// the authenticated caller when they hold the permission, and otherwise answers 401 or
+// a 403 naming the missing permission
+func authorize(w http.ResponseWriter, r *http.Request, permission string) (*auth.Principal, bool) {
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return nil, false
+	}
+	if !principal.HasPermission(permission) {
+		problem.Error(w, r, http.StatusForbidden, problem.CodeForbidden, "Missing permission "+permission)
+		return nil, false
+	}
+	return principal, true
+}
+
+// scopeQueryToPrincipal limits an order query to the customers the principal may read.
+// Customer principals only see their own orders; asking for anyone else's is refused.
+func scopeQueryToPrincipal(principal *auth.Principal, req *dto.V1QueryOrdersRequest) bool {
+	if principal.CanAccessAllCustomers() {
+		return true
+	}
+
+	if len(req.CustomerIDs) == 0 {
+		req.CustomerIDs = []int64{principal.CustomerID}
+		return true
+	}
+	for _, customerID := range req.CustomerIDs {
+		if !principal.CanAccessCustomer(customerID) {
+			return false
+		}
+	}
+	return true
+}
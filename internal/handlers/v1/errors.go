@@ -0,0 +1,71 @@
+package v1
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Lamafout/online-store-api/internal/bll/services"
+	"github.com/Lamafout/online-store-api/internal/logging"
+	"github.com/Lamafout/online-store-api/internal/problem"
+)
+
+// logServerError records an error the client is about to receive as a 500, together
+// with the attributes code below annotated it with, such as the failed repository
+// method. It is the only place such errors are logged. The request's logger carries its
+// request ID, which ties the record to the access log.
+func logServerError(r *http.Request, err error) {
+	args := []any{"method", r.Method, "path", r.URL.Path, "error", err}
+	logging.FromContext(r.Context()).Error("Request failed", append(args, logging.ErrorAttrs(err)...)...)
+}
+
+// writeError answers with the problem matching a service error. Domain errors map to
+// their status and code; anything else is logged and reported as a 500 without
+// details, so database errors never reach the client.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	var (
+		notFound *services.NotFoundError
+		invalid  *services.ValidationError
+		mismatch *services.TotalMismatchError
+		conflict *services.ConflictError
+	)
+	switch {
+	case errors.As(err, &notFound):
+		problem.Error(w, r, http.StatusNotFound, notFound.Code(), notFound.Error())
+	case errors.As(err, &invalid):
+		p := problem.New(http.StatusUnprocessableEntity, invalid.Code(), invalid.Error())
+		if p.Errors = fieldErrors(r, invalid); p.Errors != nil {
+			// The raw validator message would only repeat the field list, less readably
+			p.Detail = "One or more fields are invalid"
+		}
+		problem.Write(w, r, p)
+	case errors.As(err, &mismatch):
+		problem.Error(w, r, http.StatusUnprocessableEntity, mismatch.Code(), mismatch.Error())
+	case errors.As(err, &conflict):
+		problem.Error(w, r, http.StatusConflict, conflict.Code(), conflict.Error())
+	default:
+		logServerError(r, err)
+		problem.Error(w, r, http.StatusInternalServerError, problem.CodeInternal, "An unexpected error occurred")
+	}
+}
+
+// clientError returns the code and message err may be reported with inside an
+// otherwise successful response, such as one order of a batch. Domain errors speak
+// for themselves; anything else is logged and reported generically.
+func clientError(r *http.Request, err error) (code, message string) {
+	var coded interface {
+		error
+		Code() string
+	}
+	if errors.As(err, &coded) {
+		return coded.Code(), coded.Error()
+	}
+	logServerError(r, err)
+	return problem.CodeInternal, "An unexpected error occurred"
+}
+
+// writeServerError reports a failure outside the services, e.g. of the transaction
+// itself, as a 500 with a fixed detail
+func writeServerError(w http.ResponseWriter, r *http.Request, err error, detail string) {
+	logServerError(r, err)
+	problem.Error(w, r, http.StatusInternalServerError, problem.CodeInternal, detail)
+}
@@ -0,0 +1,150 @@
+package v1
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Lamafout/online-store-api/core/models/common"
+	"github.com/Lamafout/online-store-api/core/models/dto"
+	"github.com/Lamafout/online-store-api/internal/bll/services"
+	"github.com/Lamafout/online-store-api/internal/logging"
+	"github.com/Lamafout/online-store-api/internal/problem"
+	"github.com/Lamafout/online-store-api/internal/validation"
+)
+
+func TestWriteError(t *testing.T) {
+	validationErr := validation.Validator().Struct(&common.Order{})
+
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+		wantCode   string
+		wantFields bool
+	}{
+		{
+			name:       "not found",
+			err:        fmt.Errorf("failed to get order: %w", &services.NotFoundError{Resource: "order", ID: 7, Err: sql.ErrNoRows}),
+			wantStatus: http.StatusNotFound,
+			wantCode:   "order_not_found",
+		},
+		{
+			name:       "validation",
+			err:        &services.ValidationError{Err: validationErr},
+			wantStatus: http.StatusUnprocessableEntity,
+			wantCode:   services.CodeValidationFailed,
+			wantFields: true,
+		},
+		{
+			name:       "total mismatch",
+			err:        &services.TotalMismatchError{Expected: 100, Got: 90},
+			wantStatus: http.StatusUnprocessableEntity,
+			wantCode:   services.CodeTotalPriceMismatch,
+		},
+		{
+			name:       "conflict",
+			err:        &services.ConflictError{ErrCode: services.CodeAPIKeyRevoked, Message: "api key 3 is revoked"},
+			wantStatus: http.StatusConflict,
+			wantCode:   services.CodeAPIKeyRevoked,
+		},
+		{
+			name:       "unexpected",
+			err:        errors.New(`pq: relation "orders" does not exist`),
+			wantStatus: http.StatusInternalServerError,
+			wantCode:   problem.CodeInternal,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/orders/7", nil)
+			rec := httptest.NewRecorder()
+			writeError(rec, req, tt.err)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != problem.ContentType {
+				t.Fatalf("Content-Type = %q, want %q", ct, problem.ContentType)
+			}
+			if strings.Contains(rec.Body.String(), "relation") {
+				t.Fatalf("body leaks the underlying error: %s", rec.Body.String())
+			}
+
+			var body dto.V1Problem
+			if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+				t.Fatalf("decode body: %v", err)
+			}
+			if body.Status != tt.wantStatus || body.Code != tt.wantCode {
+				t.Fatalf("problem = %d %q, want %d %q", body.Status, body.Code, tt.wantStatus, tt.wantCode)
+			}
+			if body.Title != http.StatusText(tt.wantStatus) || body.Instance != "/api/v1/orders/7" {
+				t.Fatalf("problem title = %q, instance = %q", body.Title, body.Instance)
+			}
+			if (len(body.Errors) > 0) != tt.wantFields {
+				t.Fatalf("field errors = %v", body.Errors)
+			}
+		})
+	}
+}
+
+func TestWriteErrorLogsServerErrorOnceWithAnnotations(t *testing.T) {
+	var logs bytes.Buffer
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/orders/7", nil)
+	req = req.WithContext(logging.WithLogger(req.Context(), slog.New(slog.NewJSONHandler(&logs, nil))))
+	err := fmt.Errorf("failed to get order: %w",
+		logging.ErrorWith(errors.New("connection reset"), "repository_method", "GetOrderByID", "order_id", 7))
+
+	writeError(httptest.NewRecorder(), req, err)
+
+	records := strings.Split(strings.TrimSpace(logs.String()), "\n")
+	if len(records) != 1 {
+		t.Fatalf("logged %d records, want 1: %s", len(records), logs.String())
+	}
+	var record map[string]any
+	if err := json.Unmarshal([]byte(records[0]), &record); err != nil {
+		t.Fatalf("decode log record: %v", err)
+	}
+	if record["repository_method"] != "GetOrderByID" || record["order_id"] != float64(7) || record["path"] != "/api/v1/orders/7" {
+		t.Fatalf("log record = %v, want the repository annotations and the request", record)
+	}
+}
+
+func TestFieldErrors(t *testing.T) {
+	order := dto.V1CreateOrder{
+		CustomerID:         1,
+		DeliveryAddress:    "Main st. 1",
+		TotalPriceCents:    100,
+		TotalPriceCurrency: "GBP",
+		OrderItems:         []dto.V1CreateOrderItem{{ProductID: 1, Quantity: 1, ProductTitle: "Book", ProductURL: "not a url", PriceCents: 100, PriceCurrency: "USD"}},
+	}
+	err := services.NewOrderService().ValidateCreateOrder(3, &order)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/orders/batch-create", nil)
+	req.Header.Set("Accept-Language", "ru-RU,en;q=0.8")
+	got := fieldErrors(req, err)
+
+	if len(got) != 2 {
+		t.Fatalf("fieldErrors() = %+v, want 2 entries", got)
+	}
+	want := []dto.V1FieldError{
+		{FieldPath: "orders[3].total_price_currency", Rule: "oneof", Param: "USD EUR"},
+		{FieldPath: "orders[3].order_items[0].product_url", Rule: "url"},
+	}
+	for i := range want {
+		if got[i].FieldPath != want[i].FieldPath || got[i].Rule != want[i].Rule || got[i].Param != want[i].Param {
+			t.Errorf("field error %d = %+v, want %+v", i, got[i], want[i])
+		}
+		if got[i].Message == "" || strings.Contains(got[i].Message, "must be") {
+			t.Errorf("field error %d message %q is not translated", i, got[i].Message)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package v1
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/Lamafout/online-store-api/internal/auth"
+	"github.com/Lamafout/online-store-api/internal/bll/services"
+	dal "github.com/Lamafout/online-store-api/internal/dal/unit_of_work"
+	"github.com/Lamafout/online-store-api/internal/problem"
+	"github.com/go-chi/chi/v5"
+)
+
+type JobHandler struct {
+	uowFactory *dal.UnitOfWorkFactory
+	service    *services.JobService
+}
+
+func NewJobHandler(uowFactory *dal.UnitOfWorkFactory, service *services.JobService) *JobHandler {
+	return &JobHandler{
+		uowFactory: uowFactory,
+		service:    service,
+	}
+}
+
+func (h *JobHandler) Routes() chi.Router {
+	r := chi.NewRouter()
+	r.Get("/{id}", h.GetJob)
+	return r
+}
+
+// @Summary Get a job by ID
+// @Description Reports the status and progress of a background job with the per-item results recorded so far
+// @Tags Jobs
+// @Security BearerAuth
+// @Produce json
+// @Param id path int true "Job ID"
+// @Success 200 {object} common.Job
+// @Failure 400 {object} dto.V1Problem
+// @Failure 401 {object} dto.V1Problem
+// @Failure 403 {object} dto.V1Problem
+// @Failure 404 {object} dto.V1Problem
+// @Failure 429 {object} dto.V1Problem
+// @Failure 500 {object} dto.V1Problem
+// @Router /jobs/{id} [get]
+func (h *JobHandler) GetJob(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	principal, ok := authorize(w, r, auth.PermissionJobsRead)
+	if !ok {
+		return
+	}
+	uow := h.uowFactory.Create()
+
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidParameter, "Invalid job ID")
+		return
+	}
+
+	job, err := h.service.GetJob(ctx, uow, id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	if !principal.CanAccessAllCustomers() && job.CreatedBy != principal.Subject {
+		writeError(w, r, &services.NotFoundError{Resource: "job", ID: id})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(job)
+}
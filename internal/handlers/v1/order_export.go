@@ -0,0 +1,295 @@
+package v1
+
+import (
+	"compress/gzip"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Lamafout/online-store-api/core/models/common"
+	"github.com/Lamafout/online-store-api/core/models/dto"
+	"github.com/Lamafout/online-store-api/internal/auth"
+	"github.com/Lamafout/online-store-api/internal/logging"
+	"github.com/Lamafout/online-store-api/internal/problem"
+)
+
+const (
+	exportFormatCSV    = "csv"
+	exportFormatNDJSON = "ndjson"
+
+	// exportBatchSize is the number of orders fetched from the cursor per round trip
+	exportBatchSize = 1000
+)
+
+var exportCSVHeader = []string{
+	"order_id", "customer_id", "delivery_address", "total_price_cents", "total_price_currency", "created_at", "updated_at",
+	"item_id", "product_id", "quantity", "product_title", "product_url", "price_cents", "price_currency",
+}
+
+// @Summary Export orders
+// @Description Streams all orders matching the filters as CSV or NDJSON. CSV rows are flattened with items when include_order_items is set. The response is gzip-compressed when the client accepts it.
+// @Tags Orders
+// @Security BearerAuth
+// @Produce text/csv
+// @Produce application/x-ndjson
+// @Param format query string false "Export format" Enums(csv, ndjson) default(csv)
+// @Param ids query string false "Comma-separated order IDs"
+// @Param customer_ids query string false "Comma-separated customer IDs"
+// @Param include_order_items query bool false "Include order items"
+// @Success 200 {file} file
+// @Failure 400 {object} dto.V1Problem
+// @Failure 401 {object} dto.V1Problem
+// @Failure 403 {object} dto.V1Problem
+// @Failure 429 {object} dto.V1Problem
+// @Failure 500 {object} dto.V1Problem
+// @Router /orders/export [get]
+func (h *OrderHandler) ExportOrders(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	principal, ok := authorize(w, r, auth.PermissionOrdersExport)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+
+	format := query.Get("format")
+	if format == "" {
+		format = exportFormatCSV
+	}
+	if format != exportFormatCSV && format != exportFormatNDJSON {
+		problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidParameter, "Format must be csv or ndjson")
+		return
+	}
+
+	var req dto.V1QueryOrdersRequest
+	var err error
+	if req.IDs, err = parseInt64List(query["ids"]); err != nil {
+		problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidParameter, "Invalid ids")
+		return
+	}
+	if req.CustomerIDs, err = parseInt64List(query["customer_ids"]); err != nil {
+		problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidParameter, "Invalid customer_ids")
+		return
+	}
+	if v := query.Get("include_order_items"); v != "" {
+		if req.IncludeOrderItems, err = strconv.ParseBool(v); err != nil {
+			problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidParameter, "Invalid include_order_items")
+			return
+		}
+	}
+
+	if !scopeQueryToPrincipal(principal, &req) {
+		problem.Error(w, r, http.StatusForbidden, problem.CodeForbidden, "Not allowed to read orders of other customers")
+		return
+	}
+
+	// The cursor needs a transaction; a read-only one doesn't hold the memory store's
+	// writer lock for the whole export
+	uow := h.uowFactory.Create()
+	if err := uow.BeginTx(ctx, &sql.TxOptions{ReadOnly: true}); err != nil {
+		writeServerError(w, r, err, "Failed to start transaction")
+		return
+	}
+	defer uow.Rollback()
+
+	// Exports stream for as long as there are orders, past the server's write timeout
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	stream := newExportStream(w, r, format)
+	err = h.service.ExportOrders(ctx, uow, &req, exportBatchSize, stream.writeBatch)
+	if err == nil {
+		err = stream.close()
+	}
+	if err == nil {
+		return
+	}
+	if !stream.started {
+		// Nothing was sent yet, e.g. the cursor could not be opened, so the client
+		// still gets a proper error
+		writeServerError(w, r, err, "Failed to export orders")
+		return
+	}
+	// Headers and part of the body are already sent. Ending the response normally,
+	// or closing the gzip stream, would make the truncated export look complete, so
+	// the connection is aborted instead, reported as the failure it is.
+	logServerError(r, err)
+	logging.AbortResponse(w, http.StatusInternalServerError)
+}
+
+// exportStream writes an export in the requested format. The headers go out with the
+// first batch, so a failure before any order was read can still be reported as an error.
+type exportStream struct {
+	w       http.ResponseWriter
+	r       *http.Request
+	format  string
+	started bool
+
+	gz *gzip.Writer
+	cw *csv.Writer
+	// enc writes NDJSON when format is exportFormatNDJSON
+	enc *json.Encoder
+}
+
+func newExportStream(w http.ResponseWriter, r *http.Request, format string) *exportStream {
+	return &exportStream{w: w, r: r, format: format}
+}
+
+// start sends the headers and sets up the writers of the body
+func (s *exportStream) start() error {
+	s.started = true
+
+	filename := fmt.Sprintf("orders-%s.%s", time.Now().UTC().Format("20060102T150405Z"), s.format)
+	if s.format == exportFormatCSV {
+		s.w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	} else {
+		s.w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	s.w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	s.w.Header().Add("Vary", "Accept-Encoding")
+
+	var out io.Writer = s.w
+	if acceptsGzip(s.r) {
+		s.w.Header().Set("Content-Encoding", "gzip")
+		s.gz = gzip.NewWriter(s.w)
+		out = s.gz
+	}
+	s.w.WriteHeader(http.StatusOK)
+
+	if s.format == exportFormatNDJSON {
+		s.enc = json.NewEncoder(out)
+		return nil
+	}
+	s.cw = csv.NewWriter(out)
+	return s.cw.Write(exportCSVHeader)
+}
+
+// writeBatch writes orders and flushes them to the client
+func (s *exportStream) writeBatch(orders []*common.Order) error {
+	if !s.started {
+		if err := s.start(); err != nil {
+			return err
+		}
+	}
+
+	if s.enc != nil {
+		for _, order := range orders {
+			if err := s.enc.Encode(order); err != nil {
+				return err
+			}
+		}
+	} else {
+		if err := writeOrdersCSV(s.cw, orders); err != nil {
+			return err
+		}
+		s.cw.Flush()
+		if err := s.cw.Error(); err != nil {
+			return err
+		}
+	}
+
+	if s.gz != nil {
+		if err := s.gz.Flush(); err != nil {
+			return err
+		}
+	}
+	if f, ok := s.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+// close completes the export. Exports without orders still get their headers, and CSV
+// its header row.
+func (s *exportStream) close() error {
+	if !s.started {
+		if err := s.start(); err != nil {
+			return err
+		}
+	}
+	if s.cw != nil {
+		s.cw.Flush()
+		if err := s.cw.Error(); err != nil {
+			return err
+		}
+	}
+	if s.gz != nil {
+		return s.gz.Close()
+	}
+	return nil
+}
+
+// writeOrdersCSV writes one row per order item, or a single row with empty item
+// columns for orders that have no items (or were exported without them).
+func writeOrdersCSV(cw *csv.Writer, orders []*common.Order) error {
+	for _, order := range orders {
+		orderColumns := []string{
+			strconv.FormatInt(order.ID, 10),
+			strconv.FormatInt(order.CustomerID, 10),
+			order.DeliveryAddress,
+			strconv.FormatInt(order.TotalPriceCents, 10),
+			order.TotalPriceCurrency,
+			order.CreatedAt.UTC().Format(time.RFC3339Nano),
+			order.UpdatedAt.UTC().Format(time.RFC3339Nano),
+		}
+
+		if len(order.Items) == 0 {
+			if err := cw.Write(append(orderColumns, "", "", "", "", "", "", "")); err != nil {
+				return err
+			}
+			continue
+		}
+
+		for _, item := range order.Items {
+			row := append(append([]string{}, orderColumns...),
+				strconv.FormatInt(item.ID, 10),
+				strconv.FormatInt(item.ProductID, 10),
+				strconv.Itoa(item.Quantity),
+				item.ProductTitle,
+				item.ProductURL,
+				strconv.FormatInt(item.PriceCents, 10),
+				item.PriceCurrency,
+			)
+			if err := cw.Write(row); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// parseInt64List parses repeated and/or comma-separated integer query values
+func parseInt64List(values []string) ([]int64, error) {
+	var result []int64
+	for _, value := range values {
+		for _, part := range strings.Split(value, ",") {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+			id, err := strconv.ParseInt(part, 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, id)
+		}
+	}
+	return result, nil
+}
+
+// acceptsGzip reports whether the client accepts a gzip-encoded response
+func acceptsGzip(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(coding), "gzip") {
+			continue
+		}
+		return strings.ReplaceAll(strings.TrimSpace(params), " ", "") != "q=0"
+	}
+	return false
+}
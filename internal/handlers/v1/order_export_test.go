@@ -0,0 +1,215 @@
+package v1
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Lamafout/online-store-api/core/models/common"
+	"github.com/Lamafout/online-store-api/core/models/dto"
+	"github.com/Lamafout/online-store-api/internal/auth"
+	"github.com/Lamafout/online-store-api/internal/bll/services"
+	"github.com/Lamafout/online-store-api/internal/dal/memory"
+	dal "github.com/Lamafout/online-store-api/internal/dal/unit_of_work"
+	"github.com/Lamafout/online-store-api/internal/features"
+)
+
+// seedOrders creates count orders, alternating between customers 1 and 2
+func seedOrders(t *testing.T, h http.Handler, count int) {
+	t.Helper()
+	orders := make([]string, count)
+	for i := range orders {
+		orders[i] = testOrderJSON("order_items", int64(i%2+1), 100)
+	}
+	serveJSON(t, h, http.MethodPost, "/batch-create", `{"orders": [`+strings.Join(orders, ",")+`]}`, http.StatusCreated, nil)
+}
+
+// serveExport runs an export request and reports whether the handler aborted the response
+func serveExport(t *testing.T, h http.Handler, req *http.Request, w http.ResponseWriter) (aborted bool) {
+	t.Helper()
+	defer func() {
+		if p := recover(); p != nil {
+			if p != http.ErrAbortHandler {
+				panic(p)
+			}
+			aborted = true
+		}
+	}()
+	h.ServeHTTP(w, req)
+	return false
+}
+
+func TestOrderHandlerExportCSV(t *testing.T) {
+	h := newTestOrderRouter(t)
+	seedOrders(t, h, 3)
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/export?customer_ids=1&include_order_items=true", nil)
+	if serveExport(t, h, req, rec) {
+		t.Fatal("export was aborted")
+	}
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "text/csv; charset=utf-8" {
+		t.Fatalf("status = %d, content type = %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+
+	rows, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("invalid CSV: %v", err)
+	}
+	if len(rows) != 3 || strings.Join(rows[0], ",") != strings.Join(exportCSVHeader, ",") {
+		t.Fatalf("rows = %v, want the header and two orders", rows)
+	}
+	for _, row := range rows[1:] {
+		if row[1] != "1" || row[9] != "1" || row[11] != "https://example.com/book" {
+			t.Errorf("row = %v, want an item of customer 1", row)
+		}
+	}
+}
+
+func TestOrderHandlerExportNDJSONGzip(t *testing.T) {
+	h := newTestOrderRouter(t)
+	seedOrders(t, h, 3)
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/export?format=ndjson", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	if serveExport(t, h, req, rec) {
+		t.Fatal("export was aborted")
+	}
+	if rec.Header().Get("Content-Encoding") != "gzip" || rec.Header().Get("Content-Type") != "application/x-ndjson" {
+		t.Fatalf("headers = %v", rec.Header())
+	}
+
+	gz, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatalf("invalid gzip: %v", err)
+	}
+	var orders []common.Order
+	scanner := bufio.NewScanner(gz)
+	for scanner.Scan() {
+		var order common.Order
+		if err := json.Unmarshal(scanner.Bytes(), &order); err != nil {
+			t.Fatalf("invalid NDJSON line %q: %v", scanner.Text(), err)
+		}
+		orders = append(orders, order)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatalf("failed to read export: %v", err)
+	}
+	if len(orders) != 3 || orders[0].ID >= orders[2].ID || len(orders[0].Items) != 0 {
+		t.Fatalf("orders = %+v, want three orders without items", orders)
+	}
+}
+
+func TestOrderHandlerExportWithoutOrders(t *testing.T) {
+	h := newTestOrderRouter(t)
+
+	rec := httptest.NewRecorder()
+	if serveExport(t, h, httptest.NewRequest(http.MethodGet, "/export", nil), rec) {
+		t.Fatal("export was aborted")
+	}
+	if rec.Code != http.StatusOK || strings.TrimSpace(rec.Body.String()) != strings.Join(exportCSVHeader, ",") {
+		t.Fatalf("status = %d, body = %q, want the header row only", rec.Code, rec.Body.String())
+	}
+}
+
+func TestOrderHandlerExportFailsBeforeStreaming(t *testing.T) {
+	// Without a tenant the orders cannot be read at all
+	factory := dal.NewMemoryUnitOfWorkFactory(memory.NewStore())
+	txRunner := dal.NewTxRunner(factory, nil, dal.RetryPolicy{MaxAttempts: 1})
+	orderService := services.NewOrderService()
+	routes := NewOrderHandler(factory, txRunner, orderService, services.NewJobService(orderService), features.NewToggles(features.All)).Routes()
+	principal := &auth.Principal{Subject: "tester", Role: auth.RoleAdmin, Scopes: []string{auth.ScopeAdmin}}
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		routes.ServeHTTP(w, r.WithContext(auth.WithPrincipal(r.Context(), principal)))
+	})
+
+	rec := httptest.NewRecorder()
+	if serveExport(t, h, httptest.NewRequest(http.MethodGet, "/export", nil), rec) {
+		t.Fatal("export was aborted instead of answered")
+	}
+	var failed dto.V1Problem
+	if err := json.NewDecoder(rec.Body).Decode(&failed); err != nil || rec.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, problem = %+v, %v, want a 500 problem", rec.Code, failed, err)
+	}
+	if rec.Header().Get("Content-Disposition") != "" {
+		t.Fatalf("error response is announced as a download: %v", rec.Header())
+	}
+}
+
+// cancelingWriter cancels the request once the first bytes of the body are written
+type cancelingWriter struct {
+	*httptest.ResponseRecorder
+	cancel context.CancelFunc
+}
+
+func (w *cancelingWriter) Write(p []byte) (int, error) {
+	w.cancel()
+	return w.ResponseRecorder.Write(p)
+}
+
+// writingWriter creates an order through h while the export streams its first batch
+type writingWriter struct {
+	*httptest.ResponseRecorder
+	h      http.Handler
+	status int
+}
+
+func (w *writingWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequestWithContext(ctx, http.MethodPost, "/batch-create", strings.NewReader(`{"orders": [`+testOrderJSON("order_items", 1, 100)+`]}`))
+		req.Header.Set("Content-Type", "application/json")
+		w.h.ServeHTTP(rec, req)
+		w.status = rec.Code
+	}
+	return w.ResponseRecorder.Write(p)
+}
+
+func TestOrderHandlerExportDoesNotBlockWrites(t *testing.T) {
+	h := newTestOrderRouter(t)
+	seedOrders(t, h, 2)
+
+	rec := &writingWriter{ResponseRecorder: httptest.NewRecorder(), h: h}
+	if serveExport(t, h, httptest.NewRequest(http.MethodGet, "/export?format=ndjson", nil), rec) {
+		t.Fatal("export was aborted")
+	}
+	if rec.status != http.StatusCreated {
+		t.Fatalf("order created during the export answered %d, want %d", rec.status, http.StatusCreated)
+	}
+}
+
+func TestOrderHandlerExportAbortsMidStream(t *testing.T) {
+	h := newTestOrderRouter(t)
+	// One more order than fits in a batch, so reading stops after the first one
+	seedOrders(t, h, exportBatchSize+1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rec := &cancelingWriter{ResponseRecorder: httptest.NewRecorder(), cancel: cancel}
+	req := httptest.NewRequestWithContext(ctx, http.MethodGet, "/export?format=ndjson", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	if !serveExport(t, h, req, rec) {
+		t.Fatal("truncated export was not aborted")
+	}
+
+	// The gzip stream has no trailer, so the client notices the truncation
+	gz, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatalf("invalid gzip: %v", err)
+	}
+	if _, err := io.ReadAll(gz); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("reading the truncated export error = %v, want io.ErrUnexpectedEOF", err)
+	}
+}
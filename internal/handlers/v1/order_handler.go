@@ -1,27 +1,40 @@
 package v1
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 	"strconv"
 
 	"github.com/Lamafout/online-store-api/core/models/common"
 	"github.com/Lamafout/online-store-api/core/models/dto"
+	"github.com/Lamafout/online-store-api/internal/auth"
 	"github.com/Lamafout/online-store-api/internal/bll/services"
 	dal "github.com/Lamafout/online-store-api/internal/dal/unit_of_work"
+	"github.com/Lamafout/online-store-api/internal/features"
+	"github.com/Lamafout/online-store-api/internal/metrics"
+	"github.com/Lamafout/online-store-api/internal/problem"
 	"github.com/go-chi/chi/v5"
-	"github.com/jmoiron/sqlx"
 )
 
 type OrderHandler struct {
-	db      *sqlx.DB
-	service *services.OrderService
+	uowFactory *dal.UnitOfWorkFactory
+	// txRunner runs the transactions of mutating endpoints, retrying serialization
+	// failures and deadlocks
+	txRunner   *dal.TxRunner
+	service    *services.OrderService
+	jobService *services.JobService
+	// features switch import, export and asynchronous batches on and off
+	features *features.Toggles
 }
 
-func NewOrderHandler(db *sqlx.DB, service *services.OrderService) *OrderHandler {
+func NewOrderHandler(uowFactory *dal.UnitOfWorkFactory, txRunner *dal.TxRunner, service *services.OrderService, jobService *services.JobService, toggles *features.Toggles) *OrderHandler {
 	return &OrderHandler{
-		db:      db,
-		service: service,
+		uowFactory: uowFactory,
+		txRunner:   txRunner,
+		service:    service,
+		jobService: jobService,
+		features:   toggles,
 	}
 }
 
@@ -30,6 +43,8 @@ func (h *OrderHandler) Routes() chi.Router {
 	r.Post("/", h.CreateOrder)
 	r.Post("/batch-create", h.BatchCreateOrders)
 	r.Post("/query", h.QueryOrders)
+	r.With(features.Require(h.features, func(f features.Flags) bool { return f.OrderExport })).Get("/export", h.ExportOrders)
+	r.With(features.Require(h.features, func(f features.Flags) bool { return f.OrderImport })).Post("/import", h.ImportOrders)
 	r.Get("/{id}", h.GetOrder)
 	return r
 }
@@ -37,37 +52,42 @@ func (h *OrderHandler) Routes() chi.Router {
 // @Summary Create a new order
 // @Description Creates a new order with items
 // @Tags Orders
+// @Security BearerAuth
 // @Accept json
 // @Produce json
 // @Param order body common.Order true "Order data"
+// @Param Accept-Language header string false "Language of validation messages: en, de, es, fr or ru"
 // @Success 201 {object} common.Order
-// @Failure 400 {object} map[string]string
-// @Failure 500 {object} map[string]string
+// @Failure 400 {object} dto.V1Problem
+// @Failure 401 {object} dto.V1Problem
+// @Failure 403 {object} dto.V1Problem
+// @Failure 422 {object} dto.V1Problem
+// @Failure 429 {object} dto.V1Problem
+// @Failure 500 {object} dto.V1Problem
 // @Router /orders [post]
 func (h *OrderHandler) CreateOrder(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	uow := dal.NewUnitOfWork(h.db)
-
-	if err := uow.Begin(ctx); err != nil {
-		http.Error(w, `{"error": "Failed to start transaction"}`, http.StatusInternalServerError)
+	principal, ok := authorize(w, r, auth.PermissionOrdersCreate)
+	if !ok {
 		return
 	}
-	
-	defer uow.Rollback()
 
 	var order common.Order
 	if err := json.NewDecoder(r.Body).Decode(&order); err != nil {
-		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+		problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidRequestBody, "Invalid request body")
 		return
 	}
 
-	if err := h.service.CreateOrder(ctx, uow, &order); err != nil {
-		http.Error(w, `{"error": "`+err.Error()+`"}`, http.StatusInternalServerError)
+	if !principal.CanAccessCustomer(order.CustomerID) {
+		problem.Error(w, r, http.StatusForbidden, problem.CodeForbidden, "Not allowed to create orders for this customer")
 		return
 	}
 
-	if err := uow.Commit(); err != nil {
-		http.Error(w, `{"error": "Failed to commit transaction"}`, http.StatusInternalServerError)
+	err := h.txRunner.Run(ctx, func(ctx context.Context, uow dal.UnitOfWork) error {
+		return h.service.CreateOrder(ctx, uow, &order)
+	})
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
 
@@ -77,32 +97,46 @@ func (h *OrderHandler) CreateOrder(w http.ResponseWriter, r *http.Request) {
 }
 
 // @Summary Batch create orders
-// @Description Creates multiple orders in batch
+// @Description Creates multiple orders in batch. With partial=true every order is created under its own savepoint and the response lists the outcome per order with status 207. With async=true the orders are queued as a background job and 202 is returned with the job ID; poll /jobs/{id} for progress and per-order results.
 // @Tags Orders
+// @Security BearerAuth
 // @Accept json
 // @Produce json
 // @Param request body dto.V1CreateOrderRequest true "Orders data"
+// @Param async query bool false "Process the batch in the background"
+// @Param partial query bool false "Create valid orders and report per-order outcomes instead of failing the whole batch"
+// @Param Accept-Language header string false "Language of validation messages: en, de, es, fr or ru"
 // @Success 201 {object} dto.V1CreateOrderResponse
-// @Failure 400 {object} map[string]string
-// @Failure 500 {object} map[string]string
+// @Success 202 {object} dto.V1JobAcceptedResponse
+// @Success 207 {object} dto.V1BatchCreateOrdersPartialResponse
+// @Failure 400 {object} dto.V1Problem
+// @Failure 401 {object} dto.V1Problem
+// @Failure 403 {object} dto.V1Problem
+// @Failure 413 {object} dto.V1Problem
+// @Failure 422 {object} dto.V1Problem
+// @Failure 429 {object} dto.V1Problem
+// @Failure 500 {object} dto.V1Problem
 // @Router /orders/batch-create [post]
 func (h *OrderHandler) BatchCreateOrders(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	uow := dal.NewUnitOfWork(h.db)
-
-	if err := uow.Begin(ctx); err != nil {
-		http.Error(w, `{"error": "Failed to start transaction"}`, http.StatusInternalServerError)
+	principal, ok := authorize(w, r, auth.PermissionOrdersCreate)
+	if !ok {
 		return
 	}
 
-	defer uow.Rollback()
-
 	var req dto.V1CreateOrderRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+		problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidRequestBody, "Invalid request body")
 		return
 	}
 
+	for _, orderReq := range req.Orders {
+		if !principal.CanAccessCustomer(orderReq.CustomerID) {
+			problem.Error(w, r, http.StatusForbidden, problem.CodeForbidden, "Not allowed to create orders for this customer")
+			return
+		}
+	}
+
 	orders := make([]*common.Order, len(req.Orders))
 	for i, orderReq := range req.Orders {
 		items := make([]common.OrderItem, len(orderReq.OrderItems))
@@ -126,14 +160,108 @@ func (h *OrderHandler) BatchCreateOrders(w http.ResponseWriter, r *http.Request)
 		}
 	}
 
-	createdOrders, err := h.service.BatchCreateOrders(ctx, uow, orders)
-	if err != nil {
-		http.Error(w, `{"error": "`+err.Error()+`"}`, http.StatusInternalServerError)
+	if async, _ := strconv.ParseBool(r.URL.Query().Get("async")); async {
+		if !h.features.Load().AsyncBatches {
+			problem.Error(w, r, http.StatusBadRequest, features.CodeFeatureDisabled, "Asynchronous batches are disabled")
+			return
+		}
+		metrics.OrderBatchSize.WithLabelValues("async").Observe(float64(len(orders)))
+		var job *common.Job
+		err := h.txRunner.Run(ctx, func(ctx context.Context, uow dal.UnitOfWork) error {
+			var err error
+			job, err = h.jobService.EnqueueBatchCreateOrders(ctx, uow, orders, principal.Subject)
+			return err
+		})
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		statusURL := "/api/v1/jobs/" + strconv.FormatInt(job.ID, 10)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Location", statusURL)
+		w.WriteHeader(http.StatusAccepted)
+		_ = json.NewEncoder(w).Encode(dto.V1JobAcceptedResponse{
+			JobID:     job.ID,
+			Status:    job.Status,
+			StatusURL: statusURL,
+		})
+		return
+	}
+
+	if partial, _ := strconv.ParseBool(r.URL.Query().Get("partial")); partial {
+		metrics.OrderBatchSize.WithLabelValues("partial").Observe(float64(len(orders)))
+
+		// Orders breaking the request rules are reported with paths into the request
+		// body and never reach the service
+		outcomes := make([]services.BatchCreateOutcome, len(orders))
+		var valid []*common.Order
+		var validIndexes []int
+		for i := range req.Orders {
+			if err := h.service.ValidateCreateOrder(i, &req.Orders[i]); err != nil {
+				outcomes[i].Err = err
+				continue
+			}
+			valid = append(valid, orders[i])
+			validIndexes = append(validIndexes, i)
+		}
+
+		// An empty batch still goes to the service, which rejects it
+		if len(valid) > 0 || len(orders) == 0 {
+			err := h.txRunner.Run(ctx, func(ctx context.Context, uow dal.UnitOfWork) error {
+				created, err := h.service.BatchCreateOrdersPartial(ctx, uow, valid)
+				if err != nil {
+					return err
+				}
+				for j, outcome := range created {
+					outcomes[validIndexes[j]] = outcome
+				}
+				return nil
+			})
+			if err != nil {
+				writeError(w, r, err)
+				return
+			}
+		}
+
+		response := dto.V1BatchCreateOrdersPartialResponse{
+			Results: make([]dto.V1BatchCreateOrderResult, len(outcomes)),
+		}
+		for i, outcome := range outcomes {
+			result := dto.V1BatchCreateOrderResult{Index: i}
+			if outcome.Err != nil {
+				response.Failed++
+				result.Status = "failed"
+				result.Code, result.Error = clientError(r, outcome.Err)
+				result.Errors = fieldErrors(r, outcome.Err)
+			} else {
+				response.Created++
+				result.Status = "created"
+				result.Order = outcome.Order
+			}
+			response.Results[i] = result
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusMultiStatus)
+		_ = json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	if err := h.service.ValidateCreateOrdersRequest(&req); err != nil {
+		writeError(w, r, err)
 		return
 	}
 
-	if err := uow.Commit(); err != nil {
-		http.Error(w, `{"error": "Failed to commit transaction"}`, http.StatusInternalServerError)
+	metrics.OrderBatchSize.WithLabelValues("sync").Observe(float64(len(orders)))
+	var createdOrders []*common.Order
+	err := h.txRunner.Run(ctx, func(ctx context.Context, uow dal.UnitOfWork) error {
+		var err error
+		createdOrders, err = h.service.BatchCreateOrders(ctx, uow, orders)
+		return err
+	})
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
 
@@ -154,36 +282,56 @@ func (h *OrderHandler) BatchCreateOrders(w http.ResponseWriter, r *http.Request)
 // @Summary Query orders
 // @Description Query orders with filters
 // @Tags Orders
+// @Security BearerAuth
 // @Accept json
 // @Produce json
 // @Param request body dto.V1QueryOrdersRequest true "Query filters"
+// @Param Cache-Control header string false "no-cache reads past the cache"
 // @Success 200 {object} dto.V1QueryOrdersResponse
-// @Failure 400 {object} map[string]string
-// @Failure 500 {object} map[string]string
+// @Failure 400 {object} dto.V1Problem
+// @Failure 401 {object} dto.V1Problem
+// @Failure 403 {object} dto.V1Problem
+// @Failure 429 {object} dto.V1Problem
+// @Failure 500 {object} dto.V1Problem
 // @Router /orders/query [post]
 func (h *OrderHandler) QueryOrders(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	uow := dal.NewUnitOfWork(h.db)
+	principal, ok := authorize(w, r, auth.PermissionOrdersRead)
+	if !ok {
+		return
+	}
+	uow := h.uowFactory.Create()
 
 	var req dto.V1QueryOrdersRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+		problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidRequestBody, "Invalid request body")
 		return
 	}
 
 	if req.Page != nil && *req.Page < 1 {
-		http.Error(w, `{"error": "Page must be greater than 0"}`, http.StatusBadRequest)
+		problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidParameter, "Page must be greater than 0")
 		return
 	}
 
 	if req.PageSize != nil && *req.PageSize < 1 {
-		http.Error(w, `{"error": "PageSize must be greater than 0"}`, http.StatusBadRequest)
+		problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidParameter, "PageSize must be greater than 0")
+		return
+	}
+
+	if !scopeQueryToPrincipal(principal, &req) {
+		problem.Error(w, r, http.StatusForbidden, problem.CodeForbidden, "Not allowed to read orders of other customers")
+		return
+	}
+
+	if err := uow.BeginRead(ctx); err != nil {
+		writeServerError(w, r, err, "Failed to start transaction")
 		return
 	}
+	defer uow.Rollback()
 
 	orders, err := h.service.QueryOrders(ctx, uow, &req)
 	if err != nil {
-		http.Error(w, `{"error": "`+err.Error()+`"}`, http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
@@ -201,26 +349,48 @@ func (h *OrderHandler) QueryOrders(w http.ResponseWriter, r *http.Request) {
 // @Summary Get an order by ID
 // @Description Retrieves an order with its items by ID
 // @Tags Orders
+// @Security BearerAuth
 // @Produce json
 // @Param id path int true "Order ID"
+// @Param Cache-Control header string false "no-cache reads past the cache"
 // @Success 200 {object} common.Order
-// @Failure 400 {object} map[string]string
-// @Failure 500 {object} map[string]string
+// @Failure 400 {object} dto.V1Problem
+// @Failure 401 {object} dto.V1Problem
+// @Failure 403 {object} dto.V1Problem
+// @Failure 404 {object} dto.V1Problem
+// @Failure 429 {object} dto.V1Problem
+// @Failure 500 {object} dto.V1Problem
 // @Router /orders/{id} [get]
 func (h *OrderHandler) GetOrder(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	uow := dal.NewUnitOfWork(h.db)
+	principal, ok := authorize(w, r, auth.PermissionOrdersRead)
+	if !ok {
+		return
+	}
+	uow := h.uowFactory.Create()
 
 	idStr := chi.URLParam(r, "id")
 	id, err := strconv.ParseInt(idStr, 10, 64)
 	if err != nil {
-		http.Error(w, `{"error": "Invalid order ID"}`, http.StatusBadRequest)
+		problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidParameter, "Invalid order ID")
+		return
+	}
+
+	if err := uow.BeginRead(ctx); err != nil {
+		writeServerError(w, r, err, "Failed to start transaction")
 		return
 	}
+	defer uow.Rollback()
 
 	order, err := h.service.GetOrder(ctx, uow, id)
 	if err != nil {
-		http.Error(w, `{"error": "`+err.Error()+`"}`, http.StatusInternalServerError)
+		writeError(w, r, err)
+		return
+	}
+
+	// Answer as if the order did not exist rather than confirming it belongs to someone else
+	if !principal.CanAccessCustomer(order.CustomerID) {
+		writeError(w, r, &services.NotFoundError{Resource: "order", ID: id})
 		return
 	}
 
@@ -0,0 +1,191 @@
+package v1
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Lamafout/online-store-api/core/models/common"
+	"github.com/Lamafout/online-store-api/core/models/dto"
+	"github.com/Lamafout/online-store-api/internal/auth"
+	"github.com/Lamafout/online-store-api/internal/bll/services"
+	"github.com/Lamafout/online-store-api/internal/dal/memory"
+	dal "github.com/Lamafout/online-store-api/internal/dal/unit_of_work"
+	"github.com/Lamafout/online-store-api/internal/features"
+	"github.com/Lamafout/online-store-api/internal/tenant"
+)
+
+// newTestOrderRouter serves the order routes over an in-memory store, as an admin of
+// the "test" tenant
+func newTestOrderRouter(t *testing.T) http.Handler {
+	t.Helper()
+	factory := dal.NewMemoryUnitOfWorkFactory(memory.NewStore())
+	txRunner := dal.NewTxRunner(factory, nil, dal.RetryPolicy{MaxAttempts: 1})
+	orderService := services.NewOrderService()
+	routes := NewOrderHandler(factory, txRunner, orderService, services.NewJobService(orderService), features.NewToggles(features.All)).Routes()
+
+	principal := &auth.Principal{Subject: "tester", Role: auth.RoleAdmin, Scopes: []string{auth.ScopeAdmin}}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := auth.WithPrincipal(tenant.WithTenant(r.Context(), "test"), principal)
+		routes.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// serveJSON sends body as JSON and decodes the response into out, checking its status
+func serveJSON(t *testing.T, h http.Handler, method, target, body string, wantStatus int, out any) {
+	t.Helper()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != wantStatus {
+		t.Fatalf("%s %s status = %d, want %d: %s", method, target, rec.Code, wantStatus, rec.Body.String())
+	}
+	if out != nil {
+		if err := json.NewDecoder(rec.Body).Decode(out); err != nil {
+			t.Fatalf("decode %s %s response: %v", method, target, err)
+		}
+	}
+}
+
+// testOrderJSON is a valid order of customerID with a single item. Created orders list
+// their items under "items", orders of a batch under "order_items".
+func testOrderJSON(itemsKey string, customerID int64, priceCents int64) string {
+	return fmt.Sprintf(`{
+		"customer_id": %d,
+		"delivery_address": "1 Test Street",
+		"total_price_cents": %d,
+		"total_price_currency": "USD",
+		"%s": [{"product_id": 1, "quantity": 1, "product_title": "Book", "product_url": "https://example.com/book", "price_cents": %d, "price_currency": "USD"}]
+	}`, customerID, priceCents, itemsKey, priceCents)
+}
+
+func TestOrderHandlerCreateAndGetOrder(t *testing.T) {
+	h := newTestOrderRouter(t)
+
+	var created common.Order
+	serveJSON(t, h, http.MethodPost, "/", testOrderJSON("items", 7, 1500), http.StatusCreated, &created)
+	if created.ID == 0 || len(created.Items) != 1 || created.Items[0].OrderID != created.ID {
+		t.Fatalf("created order = %+v", created)
+	}
+
+	var got common.Order
+	serveJSON(t, h, http.MethodGet, fmt.Sprintf("/%d", created.ID), "", http.StatusOK, &got)
+	if got.ID != created.ID || got.CustomerID != 7 || len(got.Items) != 1 || got.Items[0].ID != created.Items[0].ID {
+		t.Fatalf("GET order = %+v, want %+v", got, created)
+	}
+
+	var missing dto.V1Problem
+	serveJSON(t, h, http.MethodGet, fmt.Sprintf("/%d", created.ID+1), "", http.StatusNotFound, &missing)
+	if missing.Code != "order_not_found" {
+		t.Fatalf("problem code = %q, want order_not_found", missing.Code)
+	}
+
+	serveJSON(t, h, http.MethodGet, "/abc", "", http.StatusBadRequest, nil)
+}
+
+func TestOrderHandlerCreateOrderRollsBack(t *testing.T) {
+	h := newTestOrderRouter(t)
+
+	// Items are validated after the order row is written, which must then be undone
+	body := strings.Replace(testOrderJSON("items", 7, 1500), "https://example.com/book", "not a url", 1)
+	var rejected dto.V1Problem
+	serveJSON(t, h, http.MethodPost, "/", body, http.StatusUnprocessableEntity, &rejected)
+	if rejected.Code != services.CodeValidationFailed {
+		t.Fatalf("problem code = %q, want %q", rejected.Code, services.CodeValidationFailed)
+	}
+
+	var result dto.V1QueryOrdersResponse
+	serveJSON(t, h, http.MethodPost, "/query", `{}`, http.StatusOK, &result)
+	if len(result.Orders) != 0 {
+		t.Fatalf("rejected order was stored: %+v", result.Orders)
+	}
+}
+
+func TestOrderHandlerBatchCreateOrders(t *testing.T) {
+	h := newTestOrderRouter(t)
+
+	batch := fmt.Sprintf(`{"orders": [%s, %s]}`,
+		testOrderJSON("order_items", 1, 100), testOrderJSON("order_items", 2, 200))
+	var created dto.V1CreateOrderResponse
+	serveJSON(t, h, http.MethodPost, "/batch-create", batch, http.StatusCreated, &created)
+	if len(created.Orders) != 2 || created.Orders[1].CustomerID != 2 || created.Orders[1].Items[0].OrderID != created.Orders[1].ID {
+		t.Fatalf("created orders = %+v", created.Orders)
+	}
+
+	invalid := strings.Replace(testOrderJSON("order_items", 4, 400), "https://example.com/book", "not a url", 1)
+	partial := fmt.Sprintf(`{"orders": [%s, %s]}`, testOrderJSON("order_items", 3, 300), invalid)
+	var outcome dto.V1BatchCreateOrdersPartialResponse
+	serveJSON(t, h, http.MethodPost, "/batch-create?partial=true", partial, http.StatusMultiStatus, &outcome)
+	if outcome.Created != 1 || outcome.Failed != 1 || outcome.Results[1].Status != "failed" {
+		t.Fatalf("partial outcome = %+v", outcome)
+	}
+	if len(outcome.Results[1].Errors) != 1 || outcome.Results[1].Errors[0].FieldPath != "orders[1].order_items[0].product_url" {
+		t.Fatalf("field errors = %+v", outcome.Results[1].Errors)
+	}
+
+	// Sync batches are all or nothing
+	var rejected dto.V1Problem
+	serveJSON(t, h, http.MethodPost, "/batch-create", partial, http.StatusUnprocessableEntity, &rejected)
+
+	var result dto.V1QueryOrdersResponse
+	serveJSON(t, h, http.MethodPost, "/query", `{}`, http.StatusOK, &result)
+	if len(result.Orders) != 3 {
+		t.Fatalf("stored %d orders, want 3", len(result.Orders))
+	}
+}
+
+func TestOrderHandlerQueryOrdersPagination(t *testing.T) {
+	h := newTestOrderRouter(t)
+
+	orders := make([]string, 5)
+	for i := range orders {
+		orders[i] = testOrderJSON("order_items", int64(i%2+1), 100)
+	}
+	var created dto.V1CreateOrderResponse
+	serveJSON(t, h, http.MethodPost, "/batch-create", `{"orders": [`+strings.Join(orders, ",")+`]}`, http.StatusCreated, &created)
+
+	var page dto.V1QueryOrdersResponse
+	serveJSON(t, h, http.MethodPost, "/query", `{"page": 3, "page_size": 2, "include_order_items": true}`, http.StatusOK, &page)
+	if len(page.Orders) != 1 || page.Orders[0].ID != created.Orders[4].ID || len(page.Orders[0].Items) != 1 {
+		t.Fatalf("page 3 = %+v, want order %d with its item", page.Orders, created.Orders[4].ID)
+	}
+
+	var byCustomer dto.V1QueryOrdersResponse
+	serveJSON(t, h, http.MethodPost, "/query", `{"customer_ids": [2], "page": 1, "page_size": 10}`, http.StatusOK, &byCustomer)
+	if len(byCustomer.Orders) != 2 || byCustomer.Orders[0].ID != created.Orders[1].ID || byCustomer.Orders[1].ID != created.Orders[3].ID {
+		t.Fatalf("orders of customer 2 = %+v", byCustomer.Orders)
+	}
+
+	serveJSON(t, h, http.MethodPost, "/query", `{"page": 0}`, http.StatusBadRequest, nil)
+}
+
+func TestOrderHandlerDisabledFeatures(t *testing.T) {
+	factory := dal.NewMemoryUnitOfWorkFactory(memory.NewStore())
+	txRunner := dal.NewTxRunner(factory, nil, dal.RetryPolicy{MaxAttempts: 1})
+	orderService := services.NewOrderService()
+	routes := NewOrderHandler(factory, txRunner, orderService, services.NewJobService(orderService), features.NewToggles(features.Flags{})).Routes()
+	principal := &auth.Principal{Subject: "tester", Role: auth.RoleAdmin, Scopes: []string{auth.ScopeAdmin}}
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		routes.ServeHTTP(w, r.WithContext(auth.WithPrincipal(tenant.WithTenant(r.Context(), "test"), principal)))
+	})
+
+	var disabled dto.V1Problem
+	serveJSON(t, h, http.MethodGet, "/export", "", http.StatusNotFound, &disabled)
+	if disabled.Code != features.CodeFeatureDisabled {
+		t.Fatalf("problem code = %q, want %q", disabled.Code, features.CodeFeatureDisabled)
+	}
+	serveJSON(t, h, http.MethodPost, "/import", "", http.StatusNotFound, nil)
+
+	batch := fmt.Sprintf(`{"orders": [%s]}`, testOrderJSON("order_items", 1, 100))
+	serveJSON(t, h, http.MethodPost, "/batch-create?async=true", batch, http.StatusBadRequest, &disabled)
+	if disabled.Code != features.CodeFeatureDisabled {
+		t.Fatalf("async problem code = %q, want %q", disabled.Code, features.CodeFeatureDisabled)
+	}
+	// Synchronous batches are not affected
+	serveJSON(t, h, http.MethodPost, "/batch-create", batch, http.StatusCreated, nil)
+}
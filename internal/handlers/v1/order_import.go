@@ -0,0 +1,516 @@
+package v1
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Lamafout/online-store-api/core/models/common"
+	"github.com/Lamafout/online-store-api/core/models/dto"
+	"github.com/Lamafout/online-store-api/internal/auth"
+	"github.com/Lamafout/online-store-api/internal/bll/services"
+	dal "github.com/Lamafout/online-store-api/internal/dal/unit_of_work"
+	"github.com/Lamafout/online-store-api/internal/logging"
+	"github.com/Lamafout/online-store-api/internal/problem"
+	"github.com/Lamafout/online-store-api/internal/ratelimit"
+)
+
+const (
+	importOnErrorStop = "stop"
+	importOnErrorSkip = "skip"
+
+	defaultImportChunkSize = 500
+	maxImportChunkSize     = 5000
+
+	// maxImportLineBytes is the longest NDJSON line an import accepts
+	maxImportLineBytes = 1 << 20
+	// maxImportFailures is the number of failed records an import report lists; the
+	// counts cover all of them
+	maxImportFailures = 1000
+	// maxImportCreated is the number of imported records an import report lists with
+	// the ID of the order created for them
+	maxImportCreated = 10000
+)
+
+// importRecord is one order read from the import stream. Err is set when the
+// record could not be parsed; the stream itself is still readable.
+type importRecord struct {
+	Line     int
+	SourceID string
+	Order    *common.Order
+	Err      error
+}
+
+// importReader yields order records until io.EOF
+type importReader interface {
+	Next() (*importRecord, error)
+}
+
+// @Summary Import orders
+// @Description Streams orders from NDJSON (one order object per line of at most 1 MiB, as produced by the export) or CSV (the flattened export layout, rows with the same order_id form one order). Orders are validated and inserted in chunks, each chunk in its own transaction and charged to the batch-create rate limit per order. With on_error=stop the import stops at the first bad record after inserting everything before it; with on_error=skip bad records are reported and skipped. The report counts imported and failed orders, lists the first 10000 imported records with the ID of the order created for each, so source rows can be mapped to the new orders, and lists the first 1000 failures. Larger imports are split into several requests to get the whole mapping. When the stream itself breaks off, the report says so in error, and everything read before was imported. Gzip-encoded bodies are accepted.
+// @Tags Orders
+// @Security BearerAuth
+// @Accept text/csv
+// @Accept application/x-ndjson
+// @Produce json
+// @Param format query string false "Import format, defaults to the request Content-Type" Enums(csv, ndjson)
+// @Param on_error query string false "Error handling mode" Enums(stop, skip) default(stop)
+// @Param chunk_size query int false "Orders inserted per transaction" default(500)
+// @Success 200 {object} dto.V1ImportOrdersResponse
+// @Failure 400 {object} dto.V1Problem
+// @Failure 401 {object} dto.V1Problem
+// @Failure 403 {object} dto.V1Problem
+// @Failure 429 {object} dto.V1Problem
+// @Failure 500 {object} dto.V1Problem
+// @Router /orders/import [post]
+func (h *OrderHandler) ImportOrders(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	principal, ok := authorize(w, r, auth.PermissionOrdersImport)
+	if !ok {
+		return
+	}
+	if !principal.CanAccessAllCustomers() {
+		problem.Error(w, r, http.StatusForbidden, problem.CodeForbidden, "Customers may not import orders")
+		return
+	}
+
+	query := r.URL.Query()
+
+	format := query.Get("format")
+	if format == "" {
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		switch mediaType {
+		case "text/csv":
+			format = exportFormatCSV
+		case "application/x-ndjson", "application/ndjson", "application/jsonl":
+			format = exportFormatNDJSON
+		}
+	}
+	if format != exportFormatCSV && format != exportFormatNDJSON {
+		problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidParameter, "Format must be csv or ndjson")
+		return
+	}
+
+	onError := query.Get("on_error")
+	if onError == "" {
+		onError = importOnErrorStop
+	}
+	if onError != importOnErrorStop && onError != importOnErrorSkip {
+		problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidParameter, "on_error must be stop or skip")
+		return
+	}
+
+	chunkSize := defaultImportChunkSize
+	if v := query.Get("chunk_size"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxImportChunkSize {
+			problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidParameter, "chunk_size must be between 1 and "+strconv.Itoa(maxImportChunkSize))
+			return
+		}
+		chunkSize = n
+	}
+
+	// Imports read and process the body for as long as it takes, past the server's
+	// read and write timeouts
+	rc := http.NewResponseController(w)
+	_ = rc.SetReadDeadline(time.Time{})
+	_ = rc.SetWriteDeadline(time.Time{})
+
+	var body io.Reader = r.Body
+	if strings.EqualFold(r.Header.Get("Content-Encoding"), "gzip") {
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidRequestBody, "Invalid gzip body")
+			return
+		}
+		defer gz.Close()
+		body = gz
+	}
+
+	var reader importReader
+	if format == exportFormatCSV {
+		csvReader, err := newCSVImportReader(body)
+		if err != nil {
+			problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidRequestBody, err.Error())
+			return
+		}
+		reader = csvReader
+	} else {
+		reader = newNDJSONImportReader(body)
+	}
+
+	// Only so many records are listed, so the report stays bounded however long the
+	// import runs
+	response := dto.V1ImportOrdersResponse{Created: []dto.V1ImportOrderCreated{}, Failures: []dto.V1ImportOrderFailure{}}
+	var chunk []*importRecord
+
+	created := func(record *importRecord, order *common.Order) {
+		response.Imported++
+		if len(response.Created) >= maxImportCreated {
+			response.CreatedTruncated = true
+			return
+		}
+		response.Created = append(response.Created, dto.V1ImportOrderCreated{
+			Line:     record.Line,
+			SourceID: record.SourceID,
+			OrderID:  order.ID,
+		})
+	}
+
+	fail := func(record *importRecord, err error) {
+		response.Failed++
+		if len(response.Failures) >= maxImportFailures {
+			response.FailuresTruncated = true
+			return
+		}
+		code, message := clientError(r, err)
+		response.Failures = append(response.Failures, dto.V1ImportOrderFailure{
+			Line:     record.Line,
+			SourceID: record.SourceID,
+			Code:     code,
+			Error:    message,
+		})
+	}
+
+	// flush inserts the pending chunk in its own transaction and reports whether it succeeded
+	flush := func() bool {
+		if len(chunk) == 0 {
+			return true
+		}
+		records := chunk
+		chunk = nil
+
+		orders := make([]*common.Order, len(records))
+		for i, record := range records {
+			orders[i] = record.Order
+		}
+
+		// Created orders come back in input order, one per record
+		var createdOrders []*common.Order
+		err := ratelimit.Wait(ctx, len(orders))
+		if err == nil {
+			err = h.txRunner.Run(ctx, func(ctx context.Context, uow dal.UnitOfWork) error {
+				var err error
+				createdOrders, err = h.service.BatchCreateOrders(ctx, uow, orders)
+				return err
+			})
+		}
+		if err != nil {
+			for _, record := range records {
+				fail(record, err)
+			}
+			return false
+		}
+
+		for i, record := range records {
+			created(record, createdOrders[i])
+		}
+		return true
+	}
+
+	for {
+		record, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			// Earlier chunks are committed, so the client gets the report of what was
+			// imported rather than a bare error
+			flush()
+			response.Stopped = true
+			response.Error = "Failed to read import stream"
+			var tooLong *lineTooLongError
+			if errors.As(err, &tooLong) {
+				response.Error = tooLong.Error()
+			}
+			logging.FromContext(ctx).Warn("Order import stream failed", "error", err, "imported", response.Imported)
+			break
+		}
+
+		if record.Err == nil {
+			record.Err = h.service.ValidateOrder(record.Order)
+		} else {
+			// Records that could not be parsed are as much the client's fault as invalid ones
+			record.Err = &services.ValidationError{Err: record.Err}
+		}
+		if record.Err != nil {
+			if onError == importOnErrorStop {
+				// Everything before the bad record is still imported
+				flush()
+				fail(record, record.Err)
+				response.Stopped = true
+				break
+			}
+			fail(record, record.Err)
+			continue
+		}
+
+		chunk = append(chunk, record)
+		if len(chunk) >= chunkSize {
+			if !flush() && onError == importOnErrorStop {
+				response.Stopped = true
+				break
+			}
+		}
+	}
+
+	if !response.Stopped {
+		if !flush() && onError == importOnErrorStop {
+			response.Stopped = true
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(response)
+}
+
+// lineTooLongError reports an NDJSON line longer than maxImportLineBytes
+type lineTooLongError struct {
+	Line int
+}
+
+func (e *lineTooLongError) Error() string {
+	return fmt.Sprintf("Line %d is longer than %d bytes", e.Line, maxImportLineBytes)
+}
+
+// ndjsonImportReader reads one common.Order JSON object per line
+type ndjsonImportReader struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+func newNDJSONImportReader(r io.Reader) *ndjsonImportReader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxImportLineBytes)
+	return &ndjsonImportReader{scanner: scanner}
+}
+
+func (r *ndjsonImportReader) Next() (*importRecord, error) {
+	for r.scanner.Scan() {
+		r.line++
+		data := bytes.TrimSpace(r.scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+
+		record := &importRecord{Line: r.line}
+		var order common.Order
+		if jsonErr := json.Unmarshal(data, &order); jsonErr != nil {
+			record.Err = fmt.Errorf("invalid JSON: %w", jsonErr)
+			return record, nil
+		}
+		if order.ID != 0 {
+			record.SourceID = strconv.FormatInt(order.ID, 10)
+		}
+		resetImportedIDs(&order)
+		record.Order = &order
+		return record, nil
+	}
+
+	if err := r.scanner.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			return nil, &lineTooLongError{Line: r.line + 1}
+		}
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+// csvImportReader reads the flattened export layout. Consecutive rows sharing a
+// non-empty order_id are merged into one order; rows without order_id stand alone.
+type csvImportReader struct {
+	r       *csv.Reader
+	columns map[string]int
+
+	pending     []string
+	pendingLine int
+	pendingErr  error
+}
+
+var requiredCSVImportColumns = []string{"customer_id", "delivery_address", "total_price_cents", "total_price_currency"}
+
+func newCSVImportReader(r io.Reader) (*csvImportReader, error) {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header")
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.TrimSpace(name)] = i
+	}
+	for _, name := range requiredCSVImportColumns {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("missing CSV column %s", name)
+		}
+	}
+
+	return &csvImportReader{r: cr, columns: columns}, nil
+}
+
+// readRow buffers the next CSV row; io.EOF is returned once the stream is exhausted
+func (r *csvImportReader) readRow() error {
+	row, err := r.r.Read()
+	if errors.Is(err, io.EOF) {
+		return io.EOF
+	}
+
+	var parseErr *csv.ParseError
+	if err != nil && !errors.As(err, &parseErr) {
+		return err
+	}
+
+	line, _ := r.r.FieldPos(0)
+	if parseErr != nil {
+		line = parseErr.StartLine
+	}
+	r.pending = row
+	r.pendingLine = line
+	r.pendingErr = err
+	return nil
+}
+
+func (r *csvImportReader) Next() (*importRecord, error) {
+	if r.pending == nil && r.pendingErr == nil {
+		if err := r.readRow(); err != nil {
+			return nil, err
+		}
+	}
+
+	row, line, rowErr := r.pending, r.pendingLine, r.pendingErr
+	r.pending, r.pendingErr = nil, nil
+
+	record := &importRecord{Line: line}
+	if rowErr != nil {
+		record.Err = rowErr
+		return record, nil
+	}
+
+	record.SourceID = r.get(row, "order_id")
+	order, err := r.parseOrder(row)
+	if err != nil {
+		record.Err = err
+	} else {
+		record.Order = order
+	}
+
+	// Merge the following rows of the same order
+	for record.SourceID != "" {
+		if err := r.readRow(); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, err
+		}
+		if r.pendingErr != nil || r.get(r.pending, "order_id") != record.SourceID {
+			break
+		}
+
+		if record.Err == nil {
+			if err := r.addItem(record.Order, r.pending); err != nil {
+				record.Err = fmt.Errorf("line %d: %w", r.pendingLine, err)
+			}
+		}
+		r.pending = nil
+	}
+
+	if record.Err != nil {
+		record.Order = nil
+	}
+	return record, nil
+}
+
+func (r *csvImportReader) get(row []string, column string) string {
+	i, ok := r.columns[column]
+	if !ok || i >= len(row) {
+		return ""
+	}
+	return strings.TrimSpace(row[i])
+}
+
+func (r *csvImportReader) parseOrder(row []string) (*common.Order, error) {
+	order := &common.Order{
+		DeliveryAddress:    r.get(row, "delivery_address"),
+		TotalPriceCurrency: r.get(row, "total_price_currency"),
+		Items:              []common.OrderItem{},
+	}
+
+	var err error
+	if order.CustomerID, err = parseCSVInt(r.get(row, "customer_id"), "customer_id"); err != nil {
+		return nil, err
+	}
+	if order.TotalPriceCents, err = parseCSVInt(r.get(row, "total_price_cents"), "total_price_cents"); err != nil {
+		return nil, err
+	}
+	if v := r.get(row, "created_at"); v != "" {
+		if order.CreatedAt, err = time.Parse(time.RFC3339Nano, v); err != nil {
+			return nil, fmt.Errorf("invalid created_at: %s", v)
+		}
+	}
+
+	if err := r.addItem(order, row); err != nil {
+		return nil, err
+	}
+	return order, nil
+}
+
+// addItem appends the item columns of row to order; rows without product_id carry no item
+func (r *csvImportReader) addItem(order *common.Order, row []string) error {
+	if r.get(row, "product_id") == "" {
+		return nil
+	}
+
+	item := common.OrderItem{
+		ProductTitle:  r.get(row, "product_title"),
+		ProductURL:    r.get(row, "product_url"),
+		PriceCurrency: r.get(row, "price_currency"),
+	}
+
+	var err error
+	if item.ProductID, err = parseCSVInt(r.get(row, "product_id"), "product_id"); err != nil {
+		return err
+	}
+	quantity, err := parseCSVInt(r.get(row, "quantity"), "quantity")
+	if err != nil {
+		return err
+	}
+	item.Quantity = int(quantity)
+	if item.PriceCents, err = parseCSVInt(r.get(row, "price_cents"), "price_cents"); err != nil {
+		return err
+	}
+
+	order.Items = append(order.Items, item)
+	return nil
+}
+
+func parseCSVInt(value, column string) (int64, error) {
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %q", column, value)
+	}
+	return n, nil
+}
+
+// resetImportedIDs drops identifiers from the source system so new ones are assigned
+func resetImportedIDs(order *common.Order) {
+	order.ID = 0
+	order.UpdatedAt = time.Time{}
+	for i := range order.Items {
+		order.Items[i].ID = 0
+		order.Items[i].OrderID = 0
+		order.Items[i].CreatedAt = time.Time{}
+		order.Items[i].UpdatedAt = time.Time{}
+	}
+}
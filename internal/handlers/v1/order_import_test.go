@@ -0,0 +1,176 @@
+package v1
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Lamafout/online-store-api/core/models/dto"
+	"github.com/Lamafout/online-store-api/internal/bll/services"
+)
+
+// testOrderLine is testOrderJSON on a single NDJSON line
+func testOrderLine(customerID int64) string {
+	return strings.Join(strings.Fields(testOrderJSON("items", customerID, 100)), " ")
+}
+
+// serveImport sends body to the import endpoint and decodes the report
+func serveImport(t *testing.T, h http.Handler, query string, contentType string, body io.Reader) dto.V1ImportOrdersResponse {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/import"+query, body)
+	req.Header.Set("Content-Type", contentType)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("import status = %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var report dto.V1ImportOrdersResponse
+	if err := json.NewDecoder(rec.Body).Decode(&report); err != nil {
+		t.Fatalf("decode import report: %v", err)
+	}
+	return report
+}
+
+func storedOrders(t *testing.T, h http.Handler) int {
+	t.Helper()
+	var result dto.V1QueryOrdersResponse
+	serveJSON(t, h, http.MethodPost, "/query", `{}`, http.StatusOK, &result)
+	return len(result.Orders)
+}
+
+func TestOrderHandlerImportNDJSON(t *testing.T) {
+	h := newTestOrderRouter(t)
+
+	body := testOrderLine(1) + "\n\n" + testOrderLine(2) + "\n" + testOrderLine(3)
+	report := serveImport(t, h, "?chunk_size=2", "application/x-ndjson", strings.NewReader(body))
+	if report.Imported != 3 || report.Failed != 0 || report.Stopped || len(report.Failures) != 0 {
+		t.Fatalf("report = %+v, want 3 imported", report)
+	}
+	if got := storedOrders(t, h); got != 3 {
+		t.Fatalf("stored %d orders, want 3", got)
+	}
+
+	// Line 2 is blank, so the records are on lines 1, 3 and 4
+	if len(report.Created) != 3 {
+		t.Fatalf("created = %+v, want 3 entries", report.Created)
+	}
+	seen := make(map[int64]bool)
+	for i, line := range []int{1, 3, 4} {
+		entry := report.Created[i]
+		if entry.Line != line || entry.OrderID == 0 || seen[entry.OrderID] {
+			t.Fatalf("created[%d] = %+v, want line %d with a new order ID", i, entry, line)
+		}
+		seen[entry.OrderID] = true
+
+		var order dto.V1QueryOrdersResponse
+		serveJSON(t, h, http.MethodPost, "/query", fmt.Sprintf(`{"ids": [%d]}`, entry.OrderID), http.StatusOK, &order)
+		if len(order.Orders) != 1 || order.Orders[0].CustomerID != int64(i+1) {
+			t.Fatalf("order %d of line %d = %+v, want customer %d", entry.OrderID, line, order.Orders, i+1)
+		}
+	}
+}
+
+func TestOrderHandlerImportCSV(t *testing.T) {
+	h := newTestOrderRouter(t)
+
+	body := strings.Join(exportCSVHeader, ",") + "\n" +
+		"10,1,1 Test Street,300,USD,,,,1,1,Book,https://example.com/book,100,USD\n" +
+		"10,1,1 Test Street,300,USD,,,,2,1,Pen,https://example.com/pen,200,USD\n" +
+		"11,2,2 Test Street,100,USD,,,,3,1,Cup,https://example.com/cup,100,USD\n"
+	report := serveImport(t, h, "", "text/csv", strings.NewReader(body))
+	if report.Imported != 2 || report.Failed != 0 {
+		t.Fatalf("report = %+v, want 2 imported", report)
+	}
+	if len(report.Created) != 2 || report.Created[0].SourceID != "10" || report.Created[1].SourceID != "11" ||
+		report.Created[0].Line != 2 || report.Created[1].Line != 4 {
+		t.Fatalf("created = %+v, want source orders 10 and 11 from lines 2 and 4", report.Created)
+	}
+
+	var result dto.V1QueryOrdersResponse
+	serveJSON(t, h, http.MethodPost, "/query", `{"include_order_items": true}`, http.StatusOK, &result)
+	if len(result.Orders) != 2 || len(result.Orders[0].Items) != 2 || result.Orders[0].TotalPriceCents != 300 {
+		t.Fatalf("imported orders = %+v, want the rows of order 10 merged", result.Orders)
+	}
+}
+
+func TestOrderHandlerImportReportsFailures(t *testing.T) {
+	h := newTestOrderRouter(t)
+	body := testOrderLine(1) + "\n{not json\n" + testOrderLine(2) + "\n"
+
+	skipped := serveImport(t, h, "?on_error=skip", "application/x-ndjson", strings.NewReader(body))
+	if skipped.Imported != 2 || skipped.Failed != 1 || skipped.Stopped || len(skipped.Failures) != 1 {
+		t.Fatalf("skip report = %+v", skipped)
+	}
+	if failure := skipped.Failures[0]; failure.Line != 2 || failure.Code != services.CodeValidationFailed {
+		t.Fatalf("failure = %+v, want line 2 rejected", failure)
+	}
+	if len(skipped.Created) != 2 || skipped.Created[0].Line != 1 || skipped.Created[1].Line != 3 {
+		t.Fatalf("created = %+v, want lines 1 and 3", skipped.Created)
+	}
+
+	stopped := serveImport(t, h, "", "application/x-ndjson", strings.NewReader(body))
+	if stopped.Imported != 1 || stopped.Failed != 1 || !stopped.Stopped {
+		t.Fatalf("stop report = %+v", stopped)
+	}
+	if got := storedOrders(t, h); got != 3 {
+		t.Fatalf("stored %d orders, want 3", got)
+	}
+}
+
+func TestOrderHandlerImportListsLimitedFailures(t *testing.T) {
+	h := newTestOrderRouter(t)
+
+	body := strings.Repeat("{not json\n", maxImportFailures+5)
+	report := serveImport(t, h, "?on_error=skip", "application/x-ndjson", strings.NewReader(body))
+	if report.Failed != maxImportFailures+5 || len(report.Failures) != maxImportFailures || !report.FailuresTruncated {
+		t.Fatalf("report counts %d failures and lists %d, truncated = %v", report.Failed, len(report.Failures), report.FailuresTruncated)
+	}
+}
+
+func TestOrderHandlerImportListsLimitedCreated(t *testing.T) {
+	h := newTestOrderRouter(t)
+
+	body := strings.Repeat(testOrderLine(1)+"\n", maxImportCreated+5)
+	report := serveImport(t, h, "?chunk_size=5000", "application/x-ndjson", strings.NewReader(body))
+	if report.Imported != maxImportCreated+5 || len(report.Created) != maxImportCreated || !report.CreatedTruncated {
+		t.Fatalf("report counts %d imported and lists %d, truncated = %v", report.Imported, len(report.Created), report.CreatedTruncated)
+	}
+}
+
+// failingReader fails like a connection that broke off
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) {
+	return 0, errors.New("connection reset by peer")
+}
+
+func TestOrderHandlerImportStreamFailureKeepsReport(t *testing.T) {
+	h := newTestOrderRouter(t)
+
+	// The last complete line is pending in a chunk when the stream breaks off
+	lines := fmt.Sprintf("%s\n%s\n%s\n", testOrderLine(1), testOrderLine(2), testOrderLine(3))
+	body := io.MultiReader(strings.NewReader(lines), failingReader{})
+	report := serveImport(t, h, "?chunk_size=2", "application/x-ndjson", body)
+	if report.Imported != 3 || !report.Stopped || report.Error != "Failed to read import stream" {
+		t.Fatalf("report = %+v, want the 3 orders read before the failure imported", report)
+	}
+	if got := storedOrders(t, h); got != 3 {
+		t.Fatalf("stored %d orders, want 3", got)
+	}
+}
+
+func TestOrderHandlerImportRejectsOverlongLine(t *testing.T) {
+	h := newTestOrderRouter(t)
+
+	body := testOrderLine(1) + "\n" + strings.Repeat(" ", maxImportLineBytes+1) + "\n" + testOrderLine(2) + "\n"
+	report := serveImport(t, h, "", "application/x-ndjson", strings.NewReader(body))
+	if report.Imported != 1 || !report.Stopped || !strings.HasPrefix(report.Error, "Line 2 is longer than") {
+		t.Fatalf("report = %+v, want line 1 imported and line 2 refused", report)
+	}
+}
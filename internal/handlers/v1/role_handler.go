@@ -0,0 +1,151 @@
+package v1
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/Lamafout/online-store-api/core/models/common"
+	"github.com/Lamafout/online-store-api/core/models/dto"
+	"github.com/Lamafout/online-store-api/internal/auth"
+	"github.com/Lamafout/online-store-api/internal/bll/services"
+	dal "github.com/Lamafout/online-store-api/internal/dal/unit_of_work"
+	"github.com/Lamafout/online-store-api/internal/problem"
+	"github.com/go-chi/chi/v5"
+)
+
+type RoleHandler struct {
+	uowFactory *dal.UnitOfWorkFactory
+	service    *services.RoleService
+}
+
+func NewRoleHandler(uowFactory *dal.UnitOfWorkFactory, service *services.RoleService) *RoleHandler {
+	return &RoleHandler{
+		uowFactory: uowFactory,
+		service:    service,
+	}
+}
+
+func (h *RoleHandler) Routes() chi.Router {
+	r := chi.NewRouter()
+	r.Post("/", h.AssignRole)
+	r.Get("/", h.ListRoleAssignments)
+	r.Delete("/", h.RevokeRole)
+	return r
+}
+
+// @Summary Assign a role
+// @Description Gives a role to a subject within the current tenant. The subject is a token subject or api-key:<id> for API keys. Roles: staff (read, create, import, export), support (read, cancel), warehouse (read, ship), finance (read, refund, export), admin (everything).
+// @Tags Roles
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body dto.V1AssignRoleRequest true "Role assignment"
+// @Success 201 {object} common.RoleAssignment
+// @Failure 400 {object} dto.V1Problem
+// @Failure 401 {object} dto.V1Problem
+// @Failure 403 {object} dto.V1Problem
+// @Failure 422 {object} dto.V1Problem
+// @Failure 429 {object} dto.V1Problem
+// @Failure 500 {object} dto.V1Problem
+// @Router /role-assignments [post]
+func (h *RoleHandler) AssignRole(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	principal, ok := authorize(w, r, auth.PermissionRolesAdmin)
+	if !ok {
+		return
+	}
+	uow := h.uowFactory.Create()
+
+	var req dto.V1AssignRoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidRequestBody, "Invalid request body")
+		return
+	}
+
+	assignment, err := h.service.AssignRole(ctx, uow, &req, principal.Subject)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(assignment)
+}
+
+// @Summary List role assignments
+// @Description Lists the role assignments of the current tenant
+// @Tags Roles
+// @Security BearerAuth
+// @Produce json
+// @Param subject query string false "Only assignments of this subject"
+// @Success 200 {object} dto.V1ListRoleAssignmentsResponse
+// @Failure 401 {object} dto.V1Problem
+// @Failure 403 {object} dto.V1Problem
+// @Failure 429 {object} dto.V1Problem
+// @Failure 500 {object} dto.V1Problem
+// @Router /role-assignments [get]
+func (h *RoleHandler) ListRoleAssignments(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if _, ok := authorize(w, r, auth.PermissionRolesAdmin); !ok {
+		return
+	}
+	uow := h.uowFactory.Create()
+
+	assignments, err := h.service.ListRoleAssignments(ctx, uow, r.URL.Query().Get("subject"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	response := dto.V1ListRoleAssignmentsResponse{
+		RoleAssignments: make([]common.RoleAssignment, len(assignments)),
+	}
+	for i, assignment := range assignments {
+		response.RoleAssignments[i] = *assignment
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(response)
+}
+
+// @Summary Revoke a role
+// @Description Takes a role away from a subject within the current tenant
+// @Tags Roles
+// @Security BearerAuth
+// @Param subject query string true "Subject"
+// @Param role query string true "Role"
+// @Success 204
+// @Failure 400 {object} dto.V1Problem
+// @Failure 401 {object} dto.V1Problem
+// @Failure 403 {object} dto.V1Problem
+// @Failure 404 {object} dto.V1Problem
+// @Failure 429 {object} dto.V1Problem
+// @Failure 500 {object} dto.V1Problem
+// @Router /role-assignments [delete]
+func (h *RoleHandler) RevokeRole(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if _, ok := authorize(w, r, auth.PermissionRolesAdmin); !ok {
+		return
+	}
+	uow := h.uowFactory.Create()
+
+	subject := r.URL.Query().Get("subject")
+	role := r.URL.Query().Get("role")
+	if subject == "" || role == "" {
+		problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidParameter, "Subject and role are required")
+		return
+	}
+
+	revoked, err := h.service.RevokeRole(ctx, uow, subject, role)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if !revoked {
+		problem.Error(w, r, http.StatusNotFound, problem.CodeNotFound, "Role assignment not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
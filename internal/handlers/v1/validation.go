@@ -0,0 +1,30 @@
+package v1
+
+import (
+	"net/http"
+
+	"github.com/Lamafout/online-store-api/core/models/dto"
+	"github.com/Lamafout/online-store-api/internal/bll/services"
+	"github.com/Lamafout/online-store-api/internal/validation"
+)
+
+// fieldErrors extracts per-field validation failures from err, or returns nil when
+// err is not a validation error. Paths use the JSON names of the request body and
+// messages are translated to the language of the request's Accept-Language.
+func fieldErrors(r *http.Request, err error) []dto.V1FieldError {
+	fields := services.FieldErrors(err, validation.Translator(r.Header.Get("Accept-Language")))
+	if fields == nil {
+		return nil
+	}
+
+	result := make([]dto.V1FieldError, len(fields))
+	for i, field := range fields {
+		result[i] = dto.V1FieldError{
+			FieldPath: field.FieldPath,
+			Rule:      field.Rule,
+			Param:     field.Param,
+			Message:   field.Message,
+		}
+	}
+	return result
+}
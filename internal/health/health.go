@@ -0,0 +1,124 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/Lamafout/online-store-api/internal/logging"
+	"github.com/jmoiron/sqlx"
+	"github.com/pressly/goose/v3"
+)
+
+// checkTimeout bounds each readiness check, so a hung dependency fails the probe
+// instead of stalling it
+const checkTimeout = 2 * time.Second
+
+// Check reports whether a dependency the service needs is usable
+type Check func(ctx context.Context) error
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// Response is the body of the liveness and readiness endpoints
+type Response struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// Handler serves the liveness and readiness probes
+type Handler struct {
+	checks   []namedCheck
+	draining atomic.Bool
+}
+
+// NewHandler creates a Handler without readiness checks
+func NewHandler() *Handler {
+	return &Handler{}
+}
+
+// AddCheck adds a check that must pass for the service to be ready
+func (h *Handler) AddCheck(name string, check Check) {
+	h.checks = append(h.checks, namedCheck{name: name, check: check})
+}
+
+// Drain makes readiness fail from now on, so load balancers stop sending new requests
+// while the ones in flight finish
+func (h *Handler) Drain() {
+	h.draining.Store(true)
+}
+
+// Live answers 200 as long as the process can serve HTTP at all
+func (h *Handler) Live(w http.ResponseWriter, r *http.Request) {
+	writeResponse(w, http.StatusOK, Response{Status: "ok"})
+}
+
+// Ready answers 200 when every check passes and 503 otherwise, or while draining. Probes
+// are unauthenticated, so a failing check is reported as "unavailable" and its error,
+// which may name hosts or schema details, is only logged.
+func (h *Handler) Ready(w http.ResponseWriter, r *http.Request) {
+	if h.draining.Load() {
+		writeResponse(w, http.StatusServiceUnavailable, Response{Status: "draining"})
+		return
+	}
+
+	response := Response{Status: "ready", Checks: make(map[string]string, len(h.checks))}
+	status := http.StatusOK
+	for _, c := range h.checks {
+		ctx, cancel := context.WithTimeout(r.Context(), checkTimeout)
+		err := c.check(ctx)
+		cancel()
+		if err != nil {
+			logging.FromContext(r.Context()).Warn("Readiness check failed", "check", c.name, "error", err)
+			response.Checks[c.name] = "unavailable"
+			response.Status = "not ready"
+			status = http.StatusServiceUnavailable
+			continue
+		}
+		response.Checks[c.name] = "ok"
+	}
+	writeResponse(w, status, response)
+}
+
+// DatabaseCheck pings the database
+func DatabaseCheck(db *sqlx.DB) Check {
+	return func(ctx context.Context) error {
+		return db.PingContext(ctx)
+	}
+}
+
+// MigrationsCheck passes once the database has at least the newest migration found in
+// migrations. A newer schema is accepted, since during a rolling deploy the migrations of
+// the next release run while this one is still serving.
+func MigrationsCheck(db *sqlx.DB, migrations fs.FS) (Check, error) {
+	provider, err := goose.NewProvider(goose.DialectPostgres, db.DB, migrations)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load migrations: %w", err)
+	}
+	sources := provider.ListSources()
+	expected := sources[len(sources)-1].Version
+
+	return func(ctx context.Context) error {
+		current, err := provider.GetDBVersion(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get schema version: %w", err)
+		}
+		if current < expected {
+			return fmt.Errorf("schema version %d is behind the expected %d", current, expected)
+		}
+		return nil
+	}, nil
+}
+
+func writeResponse(w http.ResponseWriter, status int, response Response) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,53 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func serve(t *testing.T, handler http.HandlerFunc) (int, Response) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	var response Response
+	if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+		t.Fatalf("invalid response body: %v", err)
+	}
+	return rec.Code, response
+}
+
+func TestReady(t *testing.T) {
+	dbErr := errors.New("dial tcp 10.0.0.5:5432: connection refused")
+	var dbDown bool
+
+	h := NewHandler()
+	h.AddCheck("database", func(context.Context) error {
+		if dbDown {
+			return dbErr
+		}
+		return nil
+	})
+
+	if code, response := serve(t, h.Ready); code != http.StatusOK || response.Checks["database"] != "ok" {
+		t.Fatalf("healthy: status = %d, response = %+v", code, response)
+	}
+
+	dbDown = true
+	if code, response := serve(t, h.Ready); code != http.StatusServiceUnavailable || response.Checks["database"] != "unavailable" {
+		t.Fatalf("database down: status = %d, response = %+v", code, response)
+	}
+
+	dbDown = false
+	h.Drain()
+	if code, response := serve(t, h.Ready); code != http.StatusServiceUnavailable || response.Status != "draining" {
+		t.Fatalf("draining: status = %d, response = %+v", code, response)
+	}
+	if code, _ := serve(t, h.Live); code != http.StatusOK {
+		t.Fatalf("liveness while draining = %d, want %d", code, http.StatusOK)
+	}
+}
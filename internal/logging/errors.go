@@ -0,0 +1,43 @@
+package logging
+
+import "errors"
+
+// attrError carries log attributes describing where an error happened up to the code
+// that finally logs it
+type attrError struct {
+	err  error
+	args []any
+}
+
+func (e *attrError) Error() string {
+	return e.err.Error()
+}
+
+func (e *attrError) Unwrap() error {
+	return e.err
+}
+
+// ErrorWith returns err annotated with the attributes, for a caller further up to log
+// with ErrorAttrs. Code deep in the stack uses it instead of logging the error itself,
+// so that a failure is logged once, where it is handled.
+func ErrorWith(err error, args ...any) error {
+	if err == nil {
+		return nil
+	}
+	return &attrError{err: err, args: args}
+}
+
+// ErrorAttrs returns the attributes err was annotated with by ErrorWith, outermost
+// first, or nil when there are none
+func ErrorAttrs(err error) []any {
+	var args []any
+	for err != nil {
+		var annotated *attrError
+		if !errors.As(err, &annotated) {
+			break
+		}
+		args = append(args, annotated.args...)
+		err = annotated.err
+	}
+	return args
+}
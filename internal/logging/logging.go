@@ -0,0 +1,53 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+type loggerKey struct{}
+
+// New creates a logger writing JSON, or logfmt-style text, records at or above level.
+// Pass a *slog.LevelVar to change the level while the logger is in use.
+func New(w io.Writer, level slog.Leveler, format string) (*slog.Logger, error) {
+	opts := &slog.HandlerOptions{Level: level}
+
+	switch strings.ToLower(format) {
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	case "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("invalid log format %q", format)
+	}
+}
+
+// ParseLevel parses a level name such as info or warn
+func ParseLevel(level string) (slog.Level, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return 0, fmt.Errorf("invalid log level %q", level)
+	}
+	return lvl, nil
+}
+
+// WithLogger returns a copy of ctx carrying the logger
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// FromContext returns the logger carried by ctx, or the default logger
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// With returns a copy of ctx whose logger adds the attributes to every record
+func With(ctx context.Context, args ...any) context.Context {
+	return WithLogger(ctx, FromContext(ctx).With(args...))
+}
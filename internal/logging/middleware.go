@@ -0,0 +1,160 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// RequestIDHeader carries the request ID in both directions
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds client-supplied request IDs so they can't bloat the logs
+const maxRequestIDLength = 128
+
+type requestIDKey struct{}
+
+// RequestIDFromContext returns the ID of the request ctx belongs to, if any
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// RequestID makes every request carry an ID: the client's X-Request-ID when it sent a
+// usable one, otherwise a generated one. The ID is echoed in the response and attached
+// to the request's logger, so every record logged while serving it can be correlated.
+func RequestID(logger *slog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := r.Header.Get(RequestIDHeader)
+			if !validRequestID(id) {
+				id = newRequestID()
+			}
+			w.Header().Set(RequestIDHeader, id)
+
+			ctx := context.WithValue(r.Context(), requestIDKey{}, id)
+			ctx = WithLogger(ctx, logger.With("request_id", id))
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// AccessLog logs one record per request with its status, size and latency. It must run
+// inside RequestID to pick up the request's logger. The record is logged for requests
+// aborted with AbortResponse too.
+func AccessLog() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			// Deferred, so the record is logged while an aborted handler unwinds
+			defer func() {
+				level := slog.LevelInfo
+				if rec.status >= http.StatusInternalServerError {
+					level = slog.LevelError
+				}
+				args := []any{
+					"method", r.Method,
+					"path", r.URL.Path,
+					"status", rec.status,
+					"bytes", rec.bytes,
+					"duration_ms", float64(time.Since(start).Microseconds()) / 1000,
+					"remote_addr", r.RemoteAddr,
+					"user_agent", r.UserAgent(),
+				}
+				if rec.aborted {
+					args = append(args, "aborted", true)
+				}
+				FromContext(r.Context()).Log(r.Context(), level, "HTTP request", args...)
+			}()
+			next.ServeHTTP(rec, r)
+		})
+	}
+}
+
+// Aborter is implemented by response writers of middleware reporting on requests, to
+// learn the status of a response cut short by AbortResponse
+type Aborter interface {
+	Abort(status int)
+}
+
+// AbortResponse ends a response that failed after its headers were sent, such as a
+// truncated stream. Every Aborter wrapping w records status, which the client never
+// sees, and the handler is aborted with http.ErrAbortHandler, so the connection is
+// closed instead of the response looking complete.
+func AbortResponse(w http.ResponseWriter, status int) {
+	for w != nil {
+		if a, ok := w.(Aborter); ok {
+			a.Abort(status)
+		}
+		u, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			break
+		}
+		w = u.Unwrap()
+	}
+	panic(http.ErrAbortHandler)
+}
+
+// statusRecorder captures the status code and body size written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int
+	wroteHeader bool
+	aborted     bool
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += n
+	return n, err
+}
+
+// Abort records the status of a response cut short by AbortResponse
+func (r *statusRecorder) Abort(status int) {
+	r.status = status
+	r.aborted = true
+}
+
+// Flush keeps streaming responses such as exports working through the recorder
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		if c < 0x21 || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,117 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequestID(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   string
+		wantSame bool
+	}{
+		{name: "client ID is kept", header: "abc-123", wantSame: true},
+		{name: "missing ID is generated"},
+		{name: "ID with spaces is replaced", header: "abc 123"},
+		{name: "oversized ID is replaced", header: strings.Repeat("a", maxRequestIDLength+1)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotID string
+			handler := RequestID(slog.Default())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotID = RequestIDFromContext(r.Context())
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				req.Header.Set(RequestIDHeader, tt.header)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if gotID == "" {
+				t.Fatal("request ID is not set in the context")
+			}
+			if rec.Header().Get(RequestIDHeader) != gotID {
+				t.Fatalf("response header = %q, want %q", rec.Header().Get(RequestIDHeader), gotID)
+			}
+			if (gotID == tt.header) != tt.wantSame {
+				t.Fatalf("request ID = %q for header %q", gotID, tt.header)
+			}
+		})
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+
+	handler := RequestID(logger)(AccessLog()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		FromContext(r.Context()).Info("Handler ran")
+		http.Error(w, "boom", http.StatusInternalServerError)
+	})))
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/orders", nil)
+	req.Header.Set(RequestIDHeader, "req-1")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	var records []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var record map[string]any
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("invalid JSON record %q: %v", line, err)
+		}
+		records = append(records, record)
+	}
+	if len(records) != 2 {
+		t.Fatalf("got %d records, want 2", len(records))
+	}
+	for _, record := range records {
+		if record["request_id"] != "req-1" {
+			t.Fatalf("record %v does not carry the request ID", record)
+		}
+	}
+
+	access := records[1]
+	if access["level"] != "ERROR" || access["status"] != float64(http.StatusInternalServerError) ||
+		access["method"] != http.MethodPost || access["path"] != "/api/v1/orders" {
+		t.Fatalf("unexpected access log record %v", access)
+	}
+	if _, ok := access["duration_ms"]; !ok {
+		t.Fatalf("access log record %v has no latency", access)
+	}
+}
+
+func TestAccessLogRecordsAbortedResponses(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+
+	handler := RequestID(logger)(AccessLog()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("partial"))
+		AbortResponse(w, http.StatusInternalServerError)
+	})))
+
+	func() {
+		defer func() {
+			if p := recover(); p != http.ErrAbortHandler {
+				t.Fatalf("handler panicked with %v, want http.ErrAbortHandler", p)
+			}
+		}()
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/v1/orders/export", nil))
+	}()
+
+	var record map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("invalid JSON record %q: %v", buf.String(), err)
+	}
+	if record["level"] != "ERROR" || record["status"] != float64(http.StatusInternalServerError) || record["aborted"] != true || record["bytes"] != float64(len("partial")) {
+		t.Fatalf("access record = %v, want an aborted 500 after the partial body", record)
+	}
+}
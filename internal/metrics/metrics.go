@@ -0,0 +1,124 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// namespace prefixes every metric of the service
+const namespace = "online_store"
+
+var (
+	// HTTPRequestsTotal counts served requests by chi route pattern and status code
+	HTTPRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests served, by method, route pattern and status code.",
+	}, []string{"method", "route", "status"})
+
+	// HTTPRequestDuration measures request latency by chi route pattern
+	HTTPRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency, by method and route pattern.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	// OrdersCreatedTotal counts committed orders
+	OrdersCreatedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "orders_created_total",
+		Help:      "Orders created and committed.",
+	})
+
+	// OrderItemsCreatedTotal counts committed order items
+	OrderItemsCreatedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "order_items_created_total",
+		Help:      "Order items created and committed.",
+	})
+
+	// OrderBatchSize measures the number of orders per batch creation request, by mode
+	// (sync, partial or async)
+	OrderBatchSize = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "order_batch_size",
+		Help:      "Orders per batch creation request, by mode.",
+		Buckets:   prometheus.ExponentialBuckets(1, 4, 9),
+	}, []string{"mode"})
+
+	// DBQueryDuration measures the time spent in each repository method
+	DBQueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_query_duration_seconds",
+		Help:      "Database query latency, by repository and method.",
+		Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 14),
+	}, []string{"repository", "method"})
+
+	// DBTransactionsTotal counts finished UnitOfWork transactions by result
+	// (commit, commit_failed or rollback)
+	DBTransactionsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "db_transactions_total",
+		Help:      "Database transactions finished, by result.",
+	}, []string{"result"})
+
+	// DBTransactionRetriesTotal counts transactions re-run after a retryable failure,
+	// by reason (serialization_failure or deadlock)
+	DBTransactionRetriesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "db_transaction_retries_total",
+		Help:      "Transactions retried after a serialization failure or deadlock, by reason.",
+	}, []string{"reason"})
+
+	// DBTransactionRetriesExhaustedTotal counts transactions that still failed with a
+	// retryable error on their last allowed attempt
+	DBTransactionRetriesExhaustedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "db_transaction_retries_exhausted_total",
+		Help:      "Transactions given up on after the maximum number of attempts, by reason.",
+	}, []string{"reason"})
+
+	// CacheRequestsTotal counts cache lookups by cache and result (hit, miss or bypass)
+	CacheRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_requests_total",
+		Help:      "Cache lookups, by cache and result.",
+	}, []string{"cache", "result"})
+
+	// DBReplicaHealthy is 1 while the last health check of a read replica succeeded
+	DBReplicaHealthy = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "db_replica_healthy",
+		Help:      "Whether the last health check of a read replica succeeded, by replica.",
+	}, []string{"replica"})
+
+	// DBReadsTotal counts reads started with UnitOfWork.BeginRead by the server that
+	// served them (replica or primary)
+	DBReadsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "db_reads_total",
+		Help:      "Reads started outside of write transactions, by the server that served them.",
+	}, []string{"target"})
+)
+
+// ObserveQuery records the duration of a repository call that started at start
+func ObserveQuery(repository, method string, start time.Time) {
+	DBQueryDuration.WithLabelValues(repository, method).Observe(time.Since(start).Seconds())
+}
+
+// RegisterDBStats exports the connection pool statistics of db
+func RegisterDBStats(db *sqlx.DB) {
+	prometheus.MustRegister(collectors.NewDBStatsCollector(db.DB, namespace))
+}
+
+// Handler serves the registered metrics in the Prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
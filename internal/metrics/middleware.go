@@ -0,0 +1,61 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// unmatchedRoute labels requests that did not match any route, so that arbitrary
+// paths can't blow up the number of series
+const unmatchedRoute = "unmatched"
+
+// Middleware records the count and latency of every request under its chi route pattern.
+// It must be installed on the root router, where the full pattern is known once the
+// request has been routed. Requests aborted with logging.AbortResponse are recorded
+// with the status given there.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := &responseWriter{WrapResponseWriter: middleware.NewWrapResponseWriter(w, r.ProtoMajor)}
+		// Deferred, so an aborted handler is recorded while it unwinds
+		defer func() {
+			status := ww.Status()
+			if ww.aborted != 0 {
+				status = ww.aborted
+			}
+			if status == 0 {
+				status = http.StatusOK
+			}
+			route := unmatchedRoute
+			if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+				route = rctx.RoutePattern()
+			}
+
+			HTTPRequestsTotal.WithLabelValues(r.Method, route, strconv.Itoa(status)).Inc()
+			HTTPRequestDuration.WithLabelValues(r.Method, route).Observe(time.Since(start).Seconds())
+		}()
+		next.ServeHTTP(ww, r)
+	})
+}
+
+// responseWriter learns the status of responses aborted with logging.AbortResponse
+type responseWriter struct {
+	middleware.WrapResponseWriter
+	aborted int
+}
+
+// Abort records the status of a response cut short after its headers were sent
+func (w *responseWriter) Abort(status int) {
+	w.aborted = status
+}
+
+// Flush keeps streaming responses such as exports working through the writer
+func (w *responseWriter) Flush() {
+	if f, ok := w.WrapResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,73 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Lamafout/online-store-api/internal/logging"
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func counterValue(t *testing.T, c prometheus.Counter) float64 {
+	t.Helper()
+	var m dto.Metric
+	if err := c.Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetCounter().GetValue()
+}
+
+func TestMiddlewareLabelsRequestsByRoutePattern(t *testing.T) {
+	orders := chi.NewRouter()
+	orders.Get("/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	r := chi.NewRouter()
+	r.Use(Middleware)
+	r.Mount("/api/v1/orders", orders)
+
+	matched := HTTPRequestsTotal.WithLabelValues(http.MethodGet, "/api/v1/orders/{id}", "404")
+	unmatched := HTTPRequestsTotal.WithLabelValues(http.MethodGet, unmatchedRoute, "404")
+	matchedBefore, unmatchedBefore := counterValue(t, matched), counterValue(t, unmatched)
+
+	for _, path := range []string{"/api/v1/orders/1", "/api/v1/orders/2", "/elsewhere"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	if got := counterValue(t, matched) - matchedBefore; got != 2 {
+		t.Fatalf("requests counted under the route pattern = %v, want 2", got)
+	}
+	if got := counterValue(t, unmatched) - unmatchedBefore; got != 1 {
+		t.Fatalf("unmatched requests = %v, want 1", got)
+	}
+}
+
+func TestMiddlewareRecordsAbortedResponses(t *testing.T) {
+	r := chi.NewRouter()
+	r.Use(Middleware)
+	r.Get("/export", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		if _, ok := w.(http.Flusher); !ok {
+			t.Error("the response writer cannot flush")
+		}
+		logging.AbortResponse(w, http.StatusInternalServerError)
+	})
+
+	failed := HTTPRequestsTotal.WithLabelValues(http.MethodGet, "/export", "500")
+	before := counterValue(t, failed)
+	func() {
+		defer func() {
+			if p := recover(); p != http.ErrAbortHandler {
+				t.Fatalf("handler panicked with %v, want http.ErrAbortHandler", p)
+			}
+		}()
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/export", nil))
+	}()
+
+	if got := counterValue(t, failed) - before; got != 1 {
+		t.Fatalf("aborted requests counted as 500 = %v, want 1", got)
+	}
+}
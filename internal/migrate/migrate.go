@@ -0,0 +1,138 @@
+// Package migrate applies and inspects the embedded schema migrations with goose.
+package migrate
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/Lamafout/online-store-api/migrations"
+	"github.com/pressly/goose/v3"
+	"github.com/pressly/goose/v3/lock"
+)
+
+// versionWidth is how many digits the version prefix of a migration file has
+const versionWidth = 4
+
+var unsafeNameChars = regexp.MustCompile(`[^a-z0-9]+`)
+
+// NewProvider creates a provider for the embedded migrations. Migrating through it
+// holds a Postgres advisory lock, so instances starting at the same time apply each
+// migration once.
+func NewProvider(db *sql.DB, opts ...goose.ProviderOption) (*goose.Provider, error) {
+	locker, err := lock.NewPostgresSessionLocker()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create migration lock: %w", err)
+	}
+	opts = append([]goose.ProviderOption{goose.WithSessionLocker(locker)}, opts...)
+	provider, err := goose.NewProvider(goose.DialectPostgres, db, migrations.FS, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load migrations: %w", err)
+	}
+	return provider, nil
+}
+
+// Up applies all pending migrations
+func Up(ctx context.Context, db *sql.DB, opts ...goose.ProviderOption) ([]*goose.MigrationResult, error) {
+	provider, err := NewProvider(db, opts...)
+	if err != nil {
+		return nil, err
+	}
+	results, err := provider.Up(ctx)
+	if err != nil {
+		return results, fmt.Errorf("failed to run migrations: %w", err)
+	}
+	return results, nil
+}
+
+// Validate checks that the migrations in fsys are numbered 1, 2, 3... without gaps or
+// duplicates and that each of them has an up section
+func Validate(fsys fs.FS) error {
+	names, err := fs.Glob(fsys, "*.sql")
+	if err != nil {
+		return err
+	}
+	if len(names) == 0 {
+		return fmt.Errorf("no migrations found")
+	}
+	versions := make(map[int64]string, len(names))
+	for _, name := range names {
+		version, err := goose.NumericComponent(name)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if other, ok := versions[version]; ok {
+			return fmt.Errorf("%s and %s have the same version %d", other, name, version)
+		}
+		versions[version] = name
+
+		hasUp, err := hasUpSection(fsys, name)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if !hasUp {
+			return fmt.Errorf("%s has no -- +goose Up section", name)
+		}
+	}
+	for version := int64(1); version <= int64(len(names)); version++ {
+		if _, ok := versions[version]; !ok {
+			return fmt.Errorf("migration %d is missing", version)
+		}
+	}
+	return nil
+}
+
+// Create writes an empty migration named after name to dir, numbered after the last
+// migration in it, and returns its path
+func Create(dir, name string) (string, error) {
+	slug := strings.Trim(unsafeNameChars.ReplaceAllString(strings.ToLower(name), "_"), "_")
+	if slug == "" {
+		return "", fmt.Errorf("invalid migration name %q", name)
+	}
+	names, err := fs.Glob(os.DirFS(dir), "*.sql")
+	if err != nil {
+		return "", err
+	}
+	var last int64
+	for _, existing := range names {
+		version, err := goose.NumericComponent(existing)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", existing, err)
+		}
+		last = max(last, version)
+	}
+
+	file := filepath.Join(dir, fmt.Sprintf("%0*d_%s.sql", versionWidth, last+1, slug))
+	content := "-- +goose Up\n\n-- +goose Down\n"
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return "", fmt.Errorf("failed to create migration: %w", err)
+	}
+	if _, err := f.WriteString(content); err != nil {
+		f.Close()
+		return "", fmt.Errorf("failed to write migration: %w", err)
+	}
+	return file, f.Close()
+}
+
+func hasUpSection(fsys fs.FS, name string) (bool, error) {
+	file, err := fsys.Open(name)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if strings.HasPrefix(strings.TrimSpace(scanner.Text()), "-- +goose Up") {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
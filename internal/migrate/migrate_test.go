@@ -0,0 +1,68 @@
+package migrate
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/Lamafout/online-store-api/migrations"
+)
+
+func TestEmbeddedMigrationsAreValid(t *testing.T) {
+	if err := Validate(migrations.FS); err != nil {
+		t.Fatalf("Validate() = %v", err)
+	}
+}
+
+func TestValidateRejects(t *testing.T) {
+	up := &fstest.MapFile{Data: []byte("-- +goose Up\nSELECT 1;\n")}
+	tests := []struct {
+		name string
+		fsys fstest.MapFS
+		want string
+	}{
+		{"empty", fstest.MapFS{}, "no migrations"},
+		{"gap", fstest.MapFS{"0001_a.sql": up, "0003_c.sql": up}, "migration 2 is missing"},
+		{"duplicate", fstest.MapFS{"0001_a.sql": up, "01_b.sql": up}, "same version 1"},
+		{"no up section", fstest.MapFS{"0001_a.sql": {Data: []byte("SELECT 1;\n")}}, "no -- +goose Up"},
+		{"unnumbered", fstest.MapFS{"init.sql": up}, "init.sql"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate(tt.fsys)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("Validate() = %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreateNumbersAfterTheLastMigration(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"0001_init.sql", "0009_jobs.sql"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	file, err := Create(dir, "Add order notes")
+	if err != nil {
+		t.Fatalf("Create() = %v", err)
+	}
+	if want := filepath.Join(dir, "0010_add_order_notes.sql"); file != want {
+		t.Fatalf("Create() = %s, want %s", file, want)
+	}
+	content, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), "-- +goose Up") || !strings.Contains(string(content), "-- +goose Down") {
+		t.Fatalf("created migration = %q", content)
+	}
+
+	if _, err := Create(dir, "!!"); err == nil {
+		t.Fatal("Create() with an empty name succeeded")
+	}
+}
@@ -0,0 +1,61 @@
+// Package problem writes error responses as RFC 7807 problem details, so every
+// failure the API reports has the same shape and a stable machine-readable code
+package problem
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/Lamafout/online-store-api/core/models/dto"
+	"github.com/Lamafout/online-store-api/internal/logging"
+)
+
+// ContentType is the media type of problem responses
+const ContentType = "application/problem+json"
+
+// Codes shared by handlers and middleware. Clients branch on codes rather than on the
+// detail text, so a code is never renamed once published.
+const (
+	CodeInvalidRequestBody = "invalid_request_body"
+	CodeInvalidParameter   = "invalid_parameter"
+	CodeUnauthenticated    = "unauthenticated"
+	CodeForbidden          = "forbidden"
+	CodeNotFound           = "not_found"
+	CodeRequestTooLarge    = "request_too_large"
+	CodeRateLimited        = "rate_limited"
+	CodeInternal           = "internal_error"
+)
+
+// New builds a problem for status with a machine-readable code and a detail meant
+// for people
+func New(status int, code, detail string) *dto.V1Problem {
+	return &dto.V1Problem{
+		Status: status,
+		Code:   code,
+		Detail: detail,
+	}
+}
+
+// Write sends p, filling in the members that follow from the status and the request
+func Write(w http.ResponseWriter, r *http.Request, p *dto.V1Problem) {
+	if p.Type == "" {
+		p.Type = "about:blank"
+	}
+	if p.Title == "" {
+		p.Title = http.StatusText(p.Status)
+	}
+	if p.Instance == "" {
+		p.Instance = r.URL.Path
+	}
+	p.RequestID = logging.RequestIDFromContext(r.Context())
+
+	w.Header().Set("Content-Type", ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	_ = json.NewEncoder(w).Encode(p)
+}
+
+// Error replies with a problem, the counterpart of http.Error
+func Error(w http.ResponseWriter, r *http.Request, status int, code, detail string) {
+	Write(w, r, New(status, code, detail))
+}
@@ -0,0 +1,74 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// memorySweepInterval is how often idle buckets are dropped from a MemoryStore
+const memorySweepInterval = time.Minute
+
+type memoryBucket struct {
+	tokens    float64
+	updatedAt time.Time
+	// fullAt is when the bucket will have refilled completely, after which it is
+	// indistinguishable from a fresh one and can be dropped
+	fullAt time.Time
+}
+
+// MemoryStore keeps buckets in process memory. Limits are per instance, so it suits
+// single-instance deployments.
+type MemoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*memoryBucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// NewMemoryStore creates a new MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		buckets: make(map[string]*memoryBucket),
+		now:     time.Now,
+	}
+}
+
+func (s *MemoryStore) Take(_ context.Context, key string, cost int, limit Limit) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.sweep(now)
+
+	bucket, ok := s.buckets[key]
+	if !ok {
+		bucket = &memoryBucket{tokens: float64(limit.Burst), updatedAt: now}
+		s.buckets[key] = bucket
+	}
+
+	tokens := refill(bucket.tokens, now.Sub(bucket.updatedAt), limit)
+	allowed := tokens >= float64(cost)
+	if allowed {
+		tokens -= float64(cost)
+	}
+	bucket.tokens = tokens
+	bucket.updatedAt = now
+
+	result := resultFor(allowed, tokens, cost, limit)
+	bucket.fullAt = now.Add(result.Reset)
+	return result, nil
+}
+
+// sweep drops buckets that have refilled completely; it runs at most once per interval
+func (s *MemoryStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < memorySweepInterval {
+		return
+	}
+	s.lastSweep = now
+	for key, bucket := range s.buckets {
+		if !now.Before(bucket.fullAt) {
+			delete(s.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,281 @@
+package ratelimit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"path"
+	"slices"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/Lamafout/online-store-api/internal/auth"
+	"github.com/Lamafout/online-store-api/internal/logging"
+	"github.com/Lamafout/online-store-api/internal/problem"
+	"github.com/Lamafout/online-store-api/internal/tenant"
+)
+
+// maxCostBodyBytes caps the body a CostFunc may read, so pricing a request never
+// buffers more than this in memory
+const maxCostBodyBytes = 32 << 20
+
+// CostFunc returns how many tokens a request costs
+type CostFunc func(r *http.Request) (int, error)
+
+// Rule applies a limit to the requests it matches. Every rule has its own buckets, so
+// a caller exhausting one rule is not throttled on the others.
+type Rule struct {
+	// Name identifies the rule's buckets
+	Name string
+	// Method matches the request method; empty matches any
+	Method string
+	// Pattern matches the request path with path.Match syntax, e.g. /api/v1/orders/*
+	Pattern string
+	Limit   Limit
+	// Cost defaults to one token per request
+	Cost CostFunc
+	// Deferred rules charge nothing up front; the handler pays for its work with Wait
+	// as it goes, e.g. per chunk of a streamed import whose size is not known in advance
+	Deferred bool
+}
+
+func (rule Rule) matches(r *http.Request) bool {
+	if rule.Method != "" && rule.Method != r.Method {
+		return false
+	}
+	ok, err := path.Match(rule.Pattern, r.URL.Path)
+	return err == nil && ok
+}
+
+// Rules holds the rules a Middleware applies. They can be replaced while requests are
+// served; buckets are kept, so a caller's tokens carry over to the new limits.
+type Rules struct {
+	set atomic.Pointer[ruleSet]
+}
+
+type ruleSet struct {
+	rules    []Rule
+	fallback Rule
+}
+
+// NewRules creates Rules applying the first of rules that matches a request, or else
+// fallback
+func NewRules(rules []Rule, fallback Rule) *Rules {
+	r := &Rules{}
+	r.Store(rules, fallback)
+	return r
+}
+
+// Store replaces the rules
+func (r *Rules) Store(rules []Rule, fallback Rule) {
+	r.set.Store(&ruleSet{rules: slices.Clone(rules), fallback: fallback})
+}
+
+// match returns the rule applying to req
+func (r *Rules) match(req *http.Request) Rule {
+	set := r.set.Load()
+	for _, candidate := range set.rules {
+		if candidate.matches(req) {
+			return candidate
+		}
+	}
+	return set.fallback
+}
+
+// Middleware throttles requests with a token bucket per caller and rule, the rule
+// being the one rules match to the request. Callers are told apart by API key or
+// token subject, and by client IP when the request is not authenticated, within the
+// request's tenant. Every response carries RateLimit-Limit, RateLimit-Remaining and
+// RateLimit-Reset headers; throttled requests get 429 with Retry-After. When the store
+// fails the request is let through, since refusing all traffic would be worse.
+func Middleware(store Store, rules *Rules) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rule := rules.match(r)
+
+			cost := 1
+			if rule.Deferred {
+				cost = 0
+			} else if rule.Cost != nil {
+				r.Body = http.MaxBytesReader(w, r.Body, maxCostBodyBytes)
+				var err error
+				if cost, err = rule.Cost(r); err != nil {
+					var tooLarge *http.MaxBytesError
+					if errors.As(err, &tooLarge) {
+						problem.Error(w, r, http.StatusRequestEntityTooLarge, problem.CodeRequestTooLarge,
+							fmt.Sprintf("Request body is larger than %d bytes", tooLarge.Limit))
+						return
+					}
+					problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidRequestBody, "Invalid request body")
+					return
+				}
+			}
+			if cost > rule.Limit.Burst {
+				problem.Error(w, r, http.StatusRequestEntityTooLarge, problem.CodeRequestTooLarge,
+					fmt.Sprintf("Request costs %d, more than the limit of %d allows at once", cost, rule.Limit.Burst))
+				return
+			}
+
+			key := rule.Name + ":" + callerKey(r)
+			r = r.WithContext(context.WithValue(r.Context(), chargerKey{}, &charger{store: store, rule: rule, key: key}))
+
+			result, err := store.Take(r.Context(), key, cost, rule.Limit)
+			if err != nil {
+				logging.FromContext(r.Context()).Error("Rate limiter unavailable, letting request through", "rule", rule.Name, "error", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("RateLimit-Limit", strconv.Itoa(rule.Limit.Burst))
+			w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+			w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+			if !result.Allowed {
+				w.Header().Set("Retry-After", strconv.Itoa(max(1, ceilSeconds(result.RetryAfter))))
+				problem.Error(w, r, http.StatusTooManyRequests, problem.CodeRateLimited, "Rate limit exceeded")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+type chargerKey struct{}
+
+// charger is the bucket Middleware charged a request to
+type charger struct {
+	store Store
+	rule  Rule
+	key   string
+}
+
+// CostError reports work that costs more tokens than its rule's bucket can ever hold
+type CostError struct {
+	Cost  int
+	Burst int
+}
+
+func (e *CostError) Error() string {
+	return fmt.Sprintf("work costs %d, more than the limit of %d allows at once", e.Cost, e.Burst)
+}
+
+// Code returns problem.CodeRequestTooLarge
+func (e *CostError) Code() string {
+	return problem.CodeRequestTooLarge
+}
+
+// Wait takes cost tokens from the bucket Middleware charged the request of ctx to,
+// waiting for the bucket to refill when it holds too few. Handlers of Deferred rules
+// call it before each piece of work, which paces them to the rule's rate. It does
+// nothing when the request is not rate limited, lets the work through when the store
+// fails, and fails with a CostError when cost exceeds the burst.
+func Wait(ctx context.Context, cost int) error {
+	c, ok := ctx.Value(chargerKey{}).(*charger)
+	if !ok {
+		return nil
+	}
+	if cost > c.rule.Limit.Burst {
+		return &CostError{Cost: cost, Burst: c.rule.Limit.Burst}
+	}
+
+	for {
+		result, err := c.store.Take(ctx, c.key, cost, c.rule.Limit)
+		if err != nil {
+			logging.FromContext(ctx).Error("Rate limiter unavailable, letting work through", "rule", c.rule.Name, "error", err)
+			return nil
+		}
+		if result.Allowed {
+			return nil
+		}
+
+		timer := time.NewTimer(result.RetryAfter)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// PreAuthMiddleware throttles requests per client IP before anything is known about the
+// caller. It runs ahead of authentication, so floods of requests, with bad credentials
+// or none, are turned away before API key lookups and role queries reach the database.
+// Every request costs one token of the rule rules match to it; the per-caller limits of
+// Middleware still apply once the caller is authenticated. Like Middleware it lets
+// requests through when the store fails.
+func PreAuthMiddleware(store Store, rules *Rules) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rule := rules.match(r)
+			result, err := store.Take(r.Context(), rule.Name+":ip:"+clientIP(r), 1, rule.Limit)
+			if err != nil {
+				logging.FromContext(r.Context()).Error("Rate limiter unavailable, letting request through", "rule", rule.Name, "error", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+			if !result.Allowed {
+				w.Header().Set("Retry-After", strconv.Itoa(max(1, ceilSeconds(result.RetryAfter))))
+				problem.Error(w, r, http.StatusTooManyRequests, problem.CodeRateLimited, "Rate limit exceeded")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// JSONArrayCost charges one token per element of a top-level array field of a JSON body,
+// e.g. the orders of a batch, and at least one token. The body, at most maxCostBodyBytes
+// of it, is buffered and handed on to the handler unchanged.
+func JSONArrayCost(field string) CostFunc {
+	return func(r *http.Request) (int, error) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return 0, err
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		var payload map[string]json.RawMessage
+		if err := json.Unmarshal(body, &payload); err != nil {
+			return 0, err
+		}
+		var elements []json.RawMessage
+		if raw, ok := payload[field]; ok {
+			if err := json.Unmarshal(raw, &elements); err != nil {
+				return 0, err
+			}
+		}
+		return max(1, len(elements)), nil
+	}
+}
+
+// callerKey identifies who is making the request within its tenant
+func callerKey(r *http.Request) string {
+	tenantID, _ := tenant.FromContext(r.Context())
+	if principal, ok := auth.PrincipalFromContext(r.Context()); ok {
+		return tenantID + ":" + principal.Subject
+	}
+	return tenantID + ":ip:" + clientIP(r)
+}
+
+// clientIP returns the address the request came from, without its port, so that every
+// connection of a client shares its buckets. Behind trusted proxies, clientip.Middleware
+// has already replaced RemoteAddr with the address of the client.
+func clientIP(r *http.Request) string {
+	ip := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	return ip
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,56 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	dal "github.com/Lamafout/online-store-api/internal/dal/unit_of_work"
+	"github.com/Lamafout/online-store-api/internal/logging"
+)
+
+const (
+	// postgresSweepInterval is how often an instance deletes idle buckets
+	postgresSweepInterval = time.Minute
+	// postgresIdleBucketTTL is how long an unused bucket is kept. Buckets that take
+	// longer than this to refill are forgotten early, i.e. treated as full.
+	postgresIdleBucketTTL = time.Hour
+)
+
+// PostgresStore keeps buckets in Postgres so every instance shares the same limits
+type PostgresStore struct {
+	uowFactory *dal.UnitOfWorkFactory
+
+	mu        sync.Mutex
+	lastSweep time.Time
+}
+
+// NewPostgresStore creates a new PostgresStore
+func NewPostgresStore(uowFactory *dal.UnitOfWorkFactory) *PostgresStore {
+	return &PostgresStore{uowFactory: uowFactory}
+}
+
+func (s *PostgresStore) Take(ctx context.Context, key string, cost int, limit Limit) (Result, error) {
+	s.sweep(ctx)
+
+	bucket, err := s.uowFactory.Create().GetRateLimitRepo().TakeTokens(ctx, key, cost, limit.Rate, limit.Burst)
+	if err != nil {
+		return Result{}, err
+	}
+	return resultFor(bucket.Allowed, bucket.Tokens, cost, limit), nil
+}
+
+// sweep deletes idle buckets at most once per interval per instance
+func (s *PostgresStore) sweep(ctx context.Context) {
+	s.mu.Lock()
+	if time.Since(s.lastSweep) < postgresSweepInterval {
+		s.mu.Unlock()
+		return
+	}
+	s.lastSweep = time.Now()
+	s.mu.Unlock()
+
+	if _, err := s.uowFactory.Create().GetRateLimitRepo().DeleteIdleBuckets(ctx, postgresIdleBucketTTL); err != nil {
+		logging.FromContext(ctx).Error("Failed to delete idle rate limit buckets", "error", err)
+	}
+}
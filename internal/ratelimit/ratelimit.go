@@ -0,0 +1,118 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limit configures a token bucket: it holds at most Burst tokens and refills at Rate
+// tokens per second. A request costs one token unless its rule says otherwise.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// ParseLimit parses a limit written as rate:burst, e.g. 20:40
+func ParseLimit(value string) (Limit, error) {
+	rateStr, burstStr, ok := strings.Cut(strings.TrimSpace(value), ":")
+	if !ok {
+		return Limit{}, fmt.Errorf("expected rate:burst, got %q", value)
+	}
+	rate, err := strconv.ParseFloat(rateStr, 64)
+	if err != nil || rate <= 0 || math.IsInf(rate, 0) {
+		return Limit{}, fmt.Errorf("invalid rate in %q", value)
+	}
+	burst, err := strconv.Atoi(burstStr)
+	if err != nil || burst <= 0 {
+		return Limit{}, fmt.Errorf("invalid burst in %q", value)
+	}
+	return Limit{Rate: rate, Burst: burst}, nil
+}
+
+// ParseRules parses comma-separated per-route limits written as
+// "METHOD /path/pattern=rate:burst", e.g. "GET /api/v1/orders/export=0.5:2".
+// The method may be omitted to match any method.
+func ParseRules(value string) ([]Rule, error) {
+	var rules []Rule
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		route, limitStr, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("expected route=rate:burst, got %q", entry)
+		}
+		limit, err := ParseLimit(limitStr)
+		if err != nil {
+			return nil, err
+		}
+
+		rule := Rule{Name: strings.TrimSpace(route), Limit: limit}
+		fields := strings.Fields(route)
+		switch len(fields) {
+		case 1:
+			rule.Pattern = fields[0]
+		case 2:
+			rule.Method = strings.ToUpper(fields[0])
+			rule.Pattern = fields[1]
+		default:
+			return nil, fmt.Errorf("invalid route %q", route)
+		}
+		if _, err := path.Match(rule.Pattern, "/"); err != nil {
+			return nil, fmt.Errorf("invalid route pattern %q: %w", rule.Pattern, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// Result describes the bucket after a Take
+type Result struct {
+	Allowed bool
+	// Remaining is the number of whole tokens left
+	Remaining int
+	// RetryAfter is how long until the denied cost would fit; zero when allowed
+	RetryAfter time.Duration
+	// Reset is how long until the bucket is full again
+	Reset time.Duration
+}
+
+// Store keeps token buckets. Take removes cost tokens from the bucket under key if it
+// holds enough of them, and leaves it untouched otherwise.
+type Store interface {
+	Take(ctx context.Context, key string, cost int, limit Limit) (Result, error)
+}
+
+// refill returns the tokens a bucket holds after elapsed time, capped at the burst
+func refill(tokens float64, elapsed time.Duration, limit Limit) float64 {
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	return math.Min(float64(limit.Burst), tokens+elapsed.Seconds()*limit.Rate)
+}
+
+// resultFor builds the Result for a bucket holding tokens after the Take
+func resultFor(allowed bool, tokens float64, cost int, limit Limit) Result {
+	result := Result{
+		Allowed:   allowed,
+		Remaining: int(math.Max(0, math.Floor(tokens))),
+		Reset:     secondsToDuration((float64(limit.Burst) - tokens) / limit.Rate),
+	}
+	if !allowed {
+		result.RetryAfter = secondsToDuration((float64(cost) - tokens) / limit.Rate)
+	}
+	return result
+}
+
+func secondsToDuration(seconds float64) time.Duration {
+	if seconds <= 0 {
+		return 0
+	}
+	return time.Duration(seconds * float64(time.Second))
+}
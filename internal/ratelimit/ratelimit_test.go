@@ -0,0 +1,241 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Lamafout/online-store-api/internal/auth"
+	"github.com/Lamafout/online-store-api/internal/tenant"
+)
+
+func newTestStore() (*MemoryStore, *time.Time) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := NewMemoryStore()
+	store.now = func() time.Time { return now }
+	return store, &now
+}
+
+func TestMemoryStoreRefills(t *testing.T) {
+	store, now := newTestStore()
+	limit := Limit{Rate: 1, Burst: 2}
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if result, _ := store.Take(ctx, "k", 1, limit); !result.Allowed {
+			t.Fatalf("take %d was denied", i)
+		}
+	}
+
+	result, _ := store.Take(ctx, "k", 1, limit)
+	if result.Allowed {
+		t.Fatal("take beyond the burst was allowed")
+	}
+	if result.RetryAfter != time.Second {
+		t.Fatalf("RetryAfter = %v, want 1s", result.RetryAfter)
+	}
+
+	*now = now.Add(time.Second)
+	if result, _ := store.Take(ctx, "k", 1, limit); !result.Allowed {
+		t.Fatal("take after refill was denied")
+	}
+	if result, _ := store.Take(ctx, "other", 2, limit); !result.Allowed {
+		t.Fatal("buckets are not separate per key")
+	}
+}
+
+func TestParseRules(t *testing.T) {
+	rules, err := ParseRules("GET /api/v1/orders/export=0.5:2, /api/v1/jobs/*=5:10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 2 {
+		t.Fatalf("got %d rules, want 2", len(rules))
+	}
+	if rules[0].Method != http.MethodGet || rules[0].Pattern != "/api/v1/orders/export" || rules[0].Limit != (Limit{Rate: 0.5, Burst: 2}) {
+		t.Fatalf("unexpected first rule %+v", rules[0])
+	}
+	if rules[1].Method != "" || rules[1].Pattern != "/api/v1/jobs/*" {
+		t.Fatalf("unexpected second rule %+v", rules[1])
+	}
+
+	for _, invalid := range []string{"GET /x", "GET /x=1", "GET /x=0:1", "GET /x=1:0", "A B C=1:1"} {
+		if _, err := ParseRules(invalid); err == nil {
+			t.Errorf("ParseRules(%q) succeeded", invalid)
+		}
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	store, _ := newTestStore()
+	rules := []Rule{{
+		Name:    "batch",
+		Method:  http.MethodPost,
+		Pattern: "/api/v1/orders/batch-create",
+		Limit:   Limit{Rate: 1, Burst: 5},
+		Cost:    JSONArrayCost("orders"),
+	}}
+	fallback := Rule{Name: "default", Limit: Limit{Rate: 1, Burst: 1}}
+
+	var gotBody string
+	limits := NewRules(rules, fallback)
+	handler := Middleware(store, limits)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		gotBody = string(body)
+	}))
+
+	send := func(subject, method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		ctx := tenant.WithTenant(req.Context(), "tenant-a")
+		if subject != "" {
+			ctx = auth.WithPrincipal(ctx, &auth.Principal{Subject: subject, Role: auth.RoleStaff})
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req.WithContext(ctx))
+		return rec
+	}
+
+	rec := send("alice", http.MethodGet, "/api/v1/orders/1", "")
+	if rec.Code != http.StatusOK || rec.Header().Get("RateLimit-Limit") != "1" || rec.Header().Get("RateLimit-Remaining") != "0" {
+		t.Fatalf("first request: status %d, headers %v", rec.Code, rec.Header())
+	}
+
+	rec = send("alice", http.MethodGet, "/api/v1/orders/1", "")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "1" {
+		t.Fatalf("second request: status %d, headers %v", rec.Code, rec.Header())
+	}
+
+	if rec = send("bob", http.MethodGet, "/api/v1/orders/1", ""); rec.Code != http.StatusOK {
+		t.Fatalf("another caller was throttled: status %d", rec.Code)
+	}
+	if rec = send("", http.MethodGet, "/api/v1/orders/1", ""); rec.Code != http.StatusOK {
+		t.Fatalf("anonymous caller shares a bucket with a user: status %d", rec.Code)
+	}
+
+	batch := `{"orders": [{}, {}, {}]}`
+	rec = send("alice", http.MethodPost, "/api/v1/orders/batch-create", batch)
+	if rec.Code != http.StatusOK || rec.Header().Get("RateLimit-Remaining") != "2" {
+		t.Fatalf("batch: status %d, headers %v", rec.Code, rec.Header())
+	}
+	if gotBody != batch {
+		t.Fatalf("handler got body %q, want %q", gotBody, batch)
+	}
+
+	if rec = send("alice", http.MethodPost, "/api/v1/orders/batch-create", batch); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("batch over quota: status %d", rec.Code)
+	}
+
+	rec = send("carol", http.MethodPost, "/api/v1/orders/batch-create", `{"orders": [{}, {}, {}, {}, {}, {}]}`)
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("batch larger than the burst: status %d", rec.Code)
+	}
+
+	// Replaced rules apply to the next request
+	limits.Store(nil, Rule{Name: "default", Limit: Limit{Rate: 1, Burst: 10}})
+	if rec = send("carol", http.MethodPost, "/api/v1/orders/batch-create", `{"orders": [{}, {}, {}, {}, {}, {}]}`); rec.Code != http.StatusOK || rec.Header().Get("RateLimit-Limit") != "10" {
+		t.Fatalf("after replacing rules: status %d, headers %v", rec.Code, rec.Header())
+	}
+}
+
+func TestPreAuthMiddleware(t *testing.T) {
+	store, _ := newTestStore()
+	limits := NewRules(nil, Rule{Name: "pre-auth", Limit: Limit{Rate: 1, Burst: 2}})
+	reached := 0
+	handler := PreAuthMiddleware(store, limits)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached++
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+
+	send := func(remoteAddr, credential string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/orders/1", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("Authorization", "Bearer "+credential)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Bad credentials count against the client's IP whatever they are, before any
+	// lookup would run
+	for i, credential := range []string{"guess-1", "guess-2"} {
+		if rec := send("192.0.2.1:1000", credential); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("request %d: status %d", i, rec.Code)
+		}
+	}
+	rec := send("192.0.2.1:2000", "guess-3")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "1" {
+		t.Fatalf("request over the limit: status %d, headers %v", rec.Code, rec.Header())
+	}
+	if reached != 2 {
+		t.Fatalf("%d requests reached authentication, want 2", reached)
+	}
+	if rec := send("192.0.2.2:1000", "guess-1"); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("another client was throttled: status %d", rec.Code)
+	}
+}
+
+// endlessReader yields an unbounded stream of spaces
+type endlessReader struct{}
+
+func (endlessReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = ' '
+	}
+	return len(p), nil
+}
+
+func TestMiddlewareLimitsBodyReadForCost(t *testing.T) {
+	store, _ := newTestStore()
+	rules := []Rule{{Name: "batch", Pattern: "/batch", Limit: Limit{Rate: 1, Burst: 5}, Cost: JSONArrayCost("orders")}}
+	handler := Middleware(store, NewRules(rules, Rule{Name: "default", Limit: Limit{Rate: 1, Burst: 1}}))(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			t.Fatal("oversized request reached the handler")
+		}))
+
+	body := io.MultiReader(strings.NewReader(`{"orders": [`), endlessReader{})
+	req := httptest.NewRequest(http.MethodPost, "/batch", body)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status = %d, want 413", rec.Code)
+	}
+}
+
+func TestWaitChargesDeferredRule(t *testing.T) {
+	store, now := newTestStore()
+	rules := []Rule{{Name: "batch", Method: http.MethodPost, Pattern: "/import", Limit: Limit{Rate: 1000, Burst: 2}, Deferred: true}}
+	handler := Middleware(store, NewRules(rules, Rule{Name: "default", Limit: Limit{Rate: 1, Burst: 1}}))(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// The bucket holds two tokens; the third one is waited for
+			for i := 0; i < 3; i++ {
+				if i == 2 {
+					*now = now.Add(time.Second)
+				}
+				if err := Wait(r.Context(), 1); err != nil {
+					t.Errorf("Wait() %d error = %v", i, err)
+				}
+			}
+			var costErr *CostError
+			if err := Wait(r.Context(), 3); !errors.As(err, &costErr) || costErr.Burst != 2 {
+				t.Errorf("Wait() beyond the burst error = %v, want CostError", err)
+			}
+		}))
+
+	req := httptest.NewRequest(http.MethodPost, "/import", nil)
+	req = req.WithContext(auth.WithPrincipal(tenant.WithTenant(req.Context(), "tenant-a"), &auth.Principal{Subject: "alice"}))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	// Starting the import costs nothing
+	if rec.Header().Get("RateLimit-Remaining") != "2" {
+		t.Fatalf("headers = %v, want the request itself to be free", rec.Header())
+	}
+
+	if err := Wait(context.Background(), 100); err != nil {
+		t.Fatalf("Wait() outside a rate limited request error = %v", err)
+	}
+}
@@ -0,0 +1,84 @@
+package tenant
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/Lamafout/online-store-api/internal/auth"
+	"github.com/Lamafout/online-store-api/internal/problem"
+)
+
+// Codes of the problems reported when the tenant of a request is missing or malformed
+const (
+	CodeTenantUnresolved = "tenant_unresolved"
+	CodeInvalidTenant    = "invalid_tenant"
+)
+
+// Resolver decides which tenant a request belongs to
+type Resolver struct {
+	// Header carries the tenant ID explicitly, e.g. X-Tenant-ID
+	Header string
+	// Hosts maps storefront host names to tenant IDs
+	Hosts map[string]string
+	// Default is used when nothing else identifies the tenant; empty rejects such requests
+	Default string
+}
+
+// Middleware scopes the request context to a tenant. A principal bound to a tenant
+// (through a token claim or API key) always gets that tenant, and asking for another
+// one via header or host is refused. Unbound customers are confined to the default
+// tenant; other unbound principals pick the tenant by header, then by host, then fall
+// back to the default.
+func Middleware(resolver Resolver) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requested := resolver.fromRequest(r)
+
+			tenantID := requested
+			if principal, ok := auth.PrincipalFromContext(r.Context()); ok {
+				if principal.TenantID != "" {
+					if requested != "" && requested != principal.TenantID {
+						problem.Error(w, r, http.StatusForbidden, problem.CodeForbidden, "Credentials do not belong to the requested tenant")
+						return
+					}
+					tenantID = principal.TenantID
+				} else if principal.Role == auth.RoleCustomer {
+					if resolver.Default == "" || (requested != "" && requested != resolver.Default) {
+						problem.Error(w, r, http.StatusForbidden, problem.CodeForbidden, "Customer credentials must be bound to a tenant")
+						return
+					}
+					tenantID = resolver.Default
+				}
+			}
+
+			if tenantID == "" {
+				tenantID = resolver.Default
+			}
+			if tenantID == "" {
+				problem.Error(w, r, http.StatusBadRequest, CodeTenantUnresolved, "Tenant could not be determined")
+				return
+			}
+			if err := Validate(tenantID); err != nil {
+				problem.Error(w, r, http.StatusBadRequest, CodeInvalidTenant, "Invalid tenant ID")
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(WithTenant(r.Context(), tenantID)))
+		})
+	}
+}
+
+func (res Resolver) fromRequest(r *http.Request) string {
+	if res.Header != "" {
+		if tenantID := strings.TrimSpace(r.Header.Get(res.Header)); tenantID != "" {
+			return tenantID
+		}
+	}
+
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return res.Hosts[strings.ToLower(host)]
+}
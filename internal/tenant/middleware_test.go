@@ -0,0 +1,88 @@
+package tenant
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Lamafout/online-store-api/internal/auth"
+)
+
+func TestMiddleware(t *testing.T) {
+	resolver := Resolver{
+		Header:  "X-Tenant-ID",
+		Hosts:   map[string]string{"shop-b.example.com": "tenant-b"},
+		Default: "default",
+	}
+
+	staff := &auth.Principal{Subject: "staff", Role: auth.RoleStaff}
+	boundStaff := &auth.Principal{Subject: "staff", Role: auth.RoleStaff, TenantID: "tenant-a"}
+	customer := &auth.Principal{Subject: "customer", Role: auth.RoleCustomer, CustomerID: 1}
+	boundCustomer := &auth.Principal{Subject: "customer", Role: auth.RoleCustomer, CustomerID: 1, TenantID: "tenant-a"}
+
+	tests := []struct {
+		name       string
+		principal  *auth.Principal
+		header     string
+		host       string
+		wantStatus int
+		wantTenant string
+	}{
+		{name: "token tenant", principal: boundStaff, wantStatus: http.StatusOK, wantTenant: "tenant-a"},
+		{name: "token tenant matches header", principal: boundStaff, header: "tenant-a", wantStatus: http.StatusOK, wantTenant: "tenant-a"},
+		{name: "token tenant wins over host", principal: boundStaff, host: "shop-a.example.com", wantStatus: http.StatusOK, wantTenant: "tenant-a"},
+		{name: "token tenant conflicts with header", principal: boundStaff, header: "tenant-b", wantStatus: http.StatusForbidden},
+		{name: "token tenant conflicts with host", principal: boundStaff, host: "shop-b.example.com", wantStatus: http.StatusForbidden},
+		{name: "bound customer conflicts with header", principal: boundCustomer, header: "tenant-b", wantStatus: http.StatusForbidden},
+		{name: "header", principal: staff, header: "tenant-b", wantStatus: http.StatusOK, wantTenant: "tenant-b"},
+		{name: "header wins over host", principal: staff, header: "tenant-a", host: "shop-b.example.com", wantStatus: http.StatusOK, wantTenant: "tenant-a"},
+		{name: "host", principal: staff, host: "shop-b.example.com:8080", wantStatus: http.StatusOK, wantTenant: "tenant-b"},
+		{name: "default", principal: staff, wantStatus: http.StatusOK, wantTenant: "default"},
+		{name: "unbound customer gets default", principal: customer, wantStatus: http.StatusOK, wantTenant: "default"},
+		{name: "unbound customer cannot pick tenant", principal: customer, header: "tenant-b", wantStatus: http.StatusForbidden},
+		{name: "invalid tenant", principal: staff, header: "Tenant B", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotTenant string
+			handler := Middleware(resolver)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotTenant, _ = FromContext(r.Context())
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/orders/1", nil)
+			if tt.host != "" {
+				req.Host = tt.host
+			}
+			if tt.header != "" {
+				req.Header.Set("X-Tenant-ID", tt.header)
+			}
+			req = req.WithContext(auth.WithPrincipal(req.Context(), tt.principal))
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if gotTenant != tt.wantTenant {
+				t.Fatalf("tenant = %q, want %q", gotTenant, tt.wantTenant)
+			}
+		})
+	}
+}
+
+func TestMiddlewareWithoutDefault(t *testing.T) {
+	handler := Middleware(Resolver{Header: "X-Tenant-ID"})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Fatal("request without a tenant reached the handler")
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/orders/1", nil)
+	req = req.WithContext(auth.WithPrincipal(req.Context(), &auth.Principal{Subject: "staff", Role: auth.RoleStaff}))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
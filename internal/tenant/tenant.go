@@ -0,0 +1,40 @@
+package tenant
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+)
+
+var idPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+
+type tenantKey struct{}
+
+// WithTenant returns a copy of ctx scoped to the tenant
+func WithTenant(ctx context.Context, tenantID string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenantID)
+}
+
+// FromContext returns the tenant ctx is scoped to, if any
+func FromContext(ctx context.Context) (string, bool) {
+	tenantID, ok := ctx.Value(tenantKey{}).(string)
+	return tenantID, ok && tenantID != ""
+}
+
+// Require returns the tenant ctx is scoped to, or an error when there is none.
+// Data access must never fall back to an unscoped query.
+func Require(ctx context.Context) (string, error) {
+	tenantID, ok := FromContext(ctx)
+	if !ok {
+		return "", fmt.Errorf("tenant is not set")
+	}
+	return tenantID, nil
+}
+
+// Validate checks that a tenant ID is a lowercase slug of at most 63 characters
+func Validate(tenantID string) error {
+	if !idPattern.MatchString(tenantID) {
+		return fmt.Errorf("invalid tenant ID %q", tenantID)
+	}
+	return nil
+}
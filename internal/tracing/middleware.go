@@ -0,0 +1,41 @@
+package tracing
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Middleware starts a server span for every request, continuing the trace of the caller
+// when it sent a traceparent header. Once the request has been routed the span is named
+// after its chi route pattern, so it must be installed on the root router.
+func Middleware(next http.Handler) http.Handler {
+	return otelhttp.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r)
+
+		if pattern := routePattern(r); pattern != "" {
+			span := trace.SpanFromContext(r.Context())
+			span.SetName(spanName("", r))
+			span.SetAttributes(semconv.HTTPRoute(pattern))
+		}
+	}), "", otelhttp.WithSpanNameFormatter(spanName))
+}
+
+// spanName names a server span "METHOD /route/{pattern}", or just the method until the
+// request has been routed
+func spanName(_ string, r *http.Request) string {
+	if pattern := routePattern(r); pattern != "" {
+		return r.Method + " " + pattern
+	}
+	return r.Method
+}
+
+func routePattern(r *http.Request) string {
+	if rctx := chi.RouteContext(r.Context()); rctx != nil {
+		return rctx.RoutePattern()
+	}
+	return ""
+}
@@ -0,0 +1,73 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ServiceName identifies the service in exported spans
+const ServiceName = "online-store-api"
+
+// Options configures where spans are exported
+type Options struct {
+	// Exporter is otlp, to send spans to a collector, or stdout
+	Exporter string
+	// OTLPEndpoint is the collector URL, e.g. http://localhost:4318
+	OTLPEndpoint string
+	// SampleRatio is the fraction of new traces that are recorded. Traces started by
+	// a sampled caller are always recorded.
+	SampleRatio float64
+}
+
+// Setup installs the global tracer provider and the W3C trace-context propagator. The
+// returned function flushes pending spans and must be called before the process exits.
+func Setup(ctx context.Context, opts Options) (func(context.Context) error, error) {
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch opts.Exporter {
+	case "otlp":
+		exporter, err = otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(opts.OTLPEndpoint))
+	case "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q", opts.Exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trace exporter: %w", err)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(ServiceName)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trace resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(opts.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	return provider.Shutdown, nil
+}
+
+// End ends span, marking it failed when err is not nil
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,131 @@
+// Package validation provides the validator shared by the services and the translation
+// of its errors into the language the client asked for
+package validation
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-playground/locales"
+	"github.com/go-playground/locales/de"
+	"github.com/go-playground/locales/en"
+	"github.com/go-playground/locales/es"
+	"github.com/go-playground/locales/fr"
+	"github.com/go-playground/locales/ru"
+	ut "github.com/go-playground/universal-translator"
+	"github.com/go-playground/validator/v10"
+	de_translations "github.com/go-playground/validator/v10/translations/de"
+	en_translations "github.com/go-playground/validator/v10/translations/en"
+	es_translations "github.com/go-playground/validator/v10/translations/es"
+	fr_translations "github.com/go-playground/validator/v10/translations/fr"
+	ru_translations "github.com/go-playground/validator/v10/translations/ru"
+)
+
+// registerFunc adds the default messages of a language to a validator
+type registerFunc func(v *validator.Validate, trans ut.Translator) error
+
+// languages lists the supported languages; the first one is the fallback
+var languages = []struct {
+	locale   locales.Translator
+	register registerFunc
+}{
+	{en.New(), en_translations.RegisterDefaultTranslations},
+	{de.New(), de_translations.RegisterDefaultTranslations},
+	{es.New(), es_translations.RegisterDefaultTranslations},
+	{fr.New(), fr_translations.RegisterDefaultTranslations},
+	{ru.New(), ru_translations.RegisterDefaultTranslations},
+}
+
+var (
+	once      sync.Once
+	validate  *validator.Validate
+	universal *ut.UniversalTranslator
+)
+
+// Validator returns the shared validator. It names fields after their JSON tags, so
+// error namespaces read like paths into the request body, e.g.
+// "orders[3].order_items[1].price_cents". Translations are registered on this
+// instance only, which is why every service uses it instead of its own.
+func Validator() *validator.Validate {
+	once.Do(setup)
+	return validate
+}
+
+// Translator returns the translator for the best supported match of an Accept-Language
+// header, falling back to English
+func Translator(acceptLanguage string) ut.Translator {
+	once.Do(setup)
+	trans, _ := universal.FindTranslator(preferredLocales(acceptLanguage)...)
+	return trans
+}
+
+func setup() {
+	validate = validator.New()
+	validate.RegisterTagNameFunc(jsonName)
+
+	all := make([]locales.Translator, len(languages))
+	for i, lang := range languages {
+		all[i] = lang.locale
+	}
+	universal = ut.New(all[0], all...)
+
+	for _, lang := range languages {
+		trans, _ := universal.GetTranslator(lang.locale.Locale())
+		if err := lang.register(validate, trans); err != nil {
+			panic("validation: failed to register " + lang.locale.Locale() + " translations: " + err.Error())
+		}
+	}
+}
+
+// jsonName names a struct field after its JSON tag. Untagged fields keep their Go name.
+func jsonName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "-" {
+		return ""
+	}
+	return name
+}
+
+// preferredLocales turns an Accept-Language header into locale names ordered by
+// preference. A regional tag is followed by its base language, so "de-AT" still
+// matches German: "de-AT,en;q=0.5" -> [de_AT de en].
+func preferredLocales(acceptLanguage string) []string {
+	type candidate struct {
+		tag string
+		q   float64
+	}
+
+	var candidates []candidate
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		tag = strings.TrimSpace(tag)
+		if tag == "" || tag == "*" {
+			continue
+		}
+
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil || parsed <= 0 {
+				continue
+			}
+			q = parsed
+		}
+		candidates = append(candidates, candidate{tag: tag, q: q})
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].q > candidates[j].q })
+
+	result := make([]string, 0, len(candidates)*2)
+	for _, c := range candidates {
+		base, region, found := strings.Cut(c.tag, "-")
+		base = strings.ToLower(base)
+		if found {
+			result = append(result, base+"_"+strings.ToUpper(region))
+		}
+		result = append(result, base)
+	}
+	return result
+}
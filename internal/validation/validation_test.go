@@ -0,0 +1,80 @@
+package validation
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/Lamafout/online-store-api/core/models/dto"
+	"github.com/go-playground/validator/v10"
+)
+
+func TestPreferredLocales(t *testing.T) {
+	tests := []struct {
+		header string
+		want   []string
+	}{
+		{header: "", want: []string{}},
+		{header: "ru", want: []string{"ru"}},
+		{header: "de-AT,en;q=0.5", want: []string{"de_AT", "de", "en"}},
+		{header: "en;q=0.3, fr;q=0.9, *;q=0.1", want: []string{"fr", "en"}},
+		{header: "es;q=0, ru", want: []string{"ru"}},
+	}
+
+	for _, tt := range tests {
+		if got := preferredLocales(tt.header); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("preferredLocales(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestTranslator(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{header: "", want: "en"},
+		{header: "ru-RU,ru;q=0.9", want: "ru"},
+		{header: "ja, de;q=0.8", want: "de"},
+		{header: "ja", want: "en"},
+	}
+
+	for _, tt := range tests {
+		if got := Translator(tt.header).Locale(); got != tt.want {
+			t.Errorf("Translator(%q) = %s, want %s", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestValidatorUsesJSONNames(t *testing.T) {
+	req := dto.V1CreateOrderRequest{Orders: []dto.V1CreateOrder{{
+		CustomerID:         1,
+		DeliveryAddress:    "Main st. 1",
+		TotalPriceCents:    100,
+		TotalPriceCurrency: "USD",
+		OrderItems: []dto.V1CreateOrderItem{{
+			ProductID:     1,
+			Quantity:      1,
+			ProductTitle:  "Book",
+			ProductURL:    "https://example.com/book",
+			PriceCents:    0,
+			PriceCurrency: "USD",
+		}},
+	}}}
+
+	var validationErrors validator.ValidationErrors
+	if err := Validator().Struct(&req); !errors.As(err, &validationErrors) || len(validationErrors) != 1 {
+		t.Fatalf("Struct() = %v, want one field error", err)
+	}
+
+	fe := validationErrors[0]
+	if want := "V1CreateOrderRequest.orders[0].order_items[0].price_cents"; fe.Namespace() != want {
+		t.Fatalf("namespace = %q, want %q", fe.Namespace(), want)
+	}
+	if got, want := fe.Translate(Translator("en")), "price_cents is a required field"; got != want {
+		t.Fatalf("english message = %q, want %q", got, want)
+	}
+	if fe.Translate(Translator("ru")) == fe.Translate(Translator("en")) {
+		t.Fatal("russian message is not translated")
+	}
+}
@@ -0,0 +1,32 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS jobs (
+    id BIGSERIAL NOT NULL PRIMARY KEY,
+    type TEXT NOT NULL,
+    status TEXT NOT NULL,
+    payload JSONB NOT NULL,
+    total_items INTEGER NOT NULL,
+    processed_items INTEGER NOT NULL DEFAULT 0,
+    failed_items INTEGER NOT NULL DEFAULT 0,
+    error TEXT,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    locked_until TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    started_at TIMESTAMP WITH TIME ZONE,
+    finished_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_job_status ON jobs (status, id) WHERE status IN ('pending', 'running');
+
+CREATE TABLE IF NOT EXISTS job_results (
+    job_id BIGINT NOT NULL,
+    item_index INTEGER NOT NULL,
+    order_id BIGINT,
+    error TEXT,
+    PRIMARY KEY (job_id, item_index),
+    CONSTRAINT fk_job_id FOREIGN KEY (job_id) REFERENCES jobs(id) ON DELETE CASCADE
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS job_results;
+DROP TABLE IF EXISTS jobs;
@@ -0,0 +1,5 @@
+-- +goose Up
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS created_by TEXT NOT NULL DEFAULT '';
+
+-- +goose Down
+ALTER TABLE jobs DROP COLUMN IF EXISTS created_by;
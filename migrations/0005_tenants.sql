@@ -0,0 +1,56 @@
+-- +goose Up
+-- Every tenant-owned table carries tenant_id. Existing rows belong to the 'default'
+-- tenant; the default is dropped afterwards so new rows must name their tenant.
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS tenant_id TEXT NOT NULL DEFAULT 'default';
+ALTER TABLE orders ALTER COLUMN tenant_id DROP DEFAULT;
+ALTER TABLE order_items ADD COLUMN IF NOT EXISTS tenant_id TEXT NOT NULL DEFAULT 'default';
+ALTER TABLE order_items ALTER COLUMN tenant_id DROP DEFAULT;
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS tenant_id TEXT NOT NULL DEFAULT 'default';
+ALTER TABLE jobs ALTER COLUMN tenant_id DROP DEFAULT;
+ALTER TABLE job_results ADD COLUMN IF NOT EXISTS tenant_id TEXT NOT NULL DEFAULT 'default';
+ALTER TABLE job_results ALTER COLUMN tenant_id DROP DEFAULT;
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS tenant_id TEXT NOT NULL DEFAULT 'default';
+ALTER TABLE api_keys ALTER COLUMN tenant_id DROP DEFAULT;
+
+DROP INDEX IF EXISTS idx_order_customer_id;
+CREATE INDEX IF NOT EXISTS idx_order_tenant_customer_id ON orders (tenant_id, customer_id);
+CREATE INDEX IF NOT EXISTS idx_order_tenant_id ON orders (tenant_id, id);
+DROP INDEX IF EXISTS idx_order_item_order_id;
+CREATE INDEX IF NOT EXISTS idx_order_item_tenant_order_id ON order_items (tenant_id, order_id);
+CREATE INDEX IF NOT EXISTS idx_job_tenant_id ON jobs (tenant_id, id);
+CREATE INDEX IF NOT EXISTS idx_api_key_tenant_id ON api_keys (tenant_id, id);
+
+-- Row-level security backs up the tenant predicate the repositories add to every query.
+-- Table owners bypass it, so it only takes effect when the API connects as a separate,
+-- non-owner role with DB_ROW_LEVEL_SECURITY=true, which makes every transaction set
+-- app.tenant_id. Jobs and API keys are looked up across tenants by the worker and the
+-- authentication middleware, so they rely on the repository predicate alone.
+ALTER TABLE orders ENABLE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON orders
+    USING (tenant_id = current_setting('app.tenant_id', true))
+    WITH CHECK (tenant_id = current_setting('app.tenant_id', true));
+
+ALTER TABLE order_items ENABLE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON order_items
+    USING (tenant_id = current_setting('app.tenant_id', true))
+    WITH CHECK (tenant_id = current_setting('app.tenant_id', true));
+
+-- +goose Down
+DROP POLICY IF EXISTS tenant_isolation ON order_items;
+ALTER TABLE order_items DISABLE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS tenant_isolation ON orders;
+ALTER TABLE orders DISABLE ROW LEVEL SECURITY;
+
+DROP INDEX IF EXISTS idx_api_key_tenant_id;
+DROP INDEX IF EXISTS idx_job_tenant_id;
+DROP INDEX IF EXISTS idx_order_item_tenant_order_id;
+CREATE INDEX IF NOT EXISTS idx_order_item_order_id ON order_items (order_id);
+DROP INDEX IF EXISTS idx_order_tenant_id;
+DROP INDEX IF EXISTS idx_order_tenant_customer_id;
+CREATE INDEX IF NOT EXISTS idx_order_customer_id ON orders (customer_id);
+
+ALTER TABLE api_keys DROP COLUMN IF EXISTS tenant_id;
+ALTER TABLE job_results DROP COLUMN IF EXISTS tenant_id;
+ALTER TABLE jobs DROP COLUMN IF EXISTS tenant_id;
+ALTER TABLE order_items DROP COLUMN IF EXISTS tenant_id;
+ALTER TABLE orders DROP COLUMN IF EXISTS tenant_id;
@@ -0,0 +1,12 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS role_assignments (
+    tenant_id TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    role TEXT NOT NULL,
+    created_by TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    PRIMARY KEY (tenant_id, subject, role)
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS role_assignments;
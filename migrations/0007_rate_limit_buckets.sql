@@ -0,0 +1,14 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS rate_limit_buckets (
+    tenant_id TEXT NOT NULL,
+    key TEXT NOT NULL,
+    tokens DOUBLE PRECISION NOT NULL,
+    allowed BOOLEAN NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    PRIMARY KEY (tenant_id, key)
+);
+
+CREATE INDEX IF NOT EXISTS idx_rate_limit_bucket_updated_at ON rate_limit_buckets (updated_at);
+
+-- +goose Down
+DROP TABLE IF EXISTS rate_limit_buckets;
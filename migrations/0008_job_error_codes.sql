@@ -0,0 +1,9 @@
+-- +goose Up
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS error_code TEXT;
+ALTER TABLE job_results ADD COLUMN IF NOT EXISTS error_code TEXT;
+ALTER TABLE job_results ADD COLUMN IF NOT EXISTS field_errors JSONB;
+
+-- +goose Down
+ALTER TABLE job_results DROP COLUMN IF EXISTS field_errors;
+ALTER TABLE job_results DROP COLUMN IF EXISTS error_code;
+ALTER TABLE jobs DROP COLUMN IF EXISTS error_code;
@@ -0,0 +1,10 @@
+// Package migrations embeds the SQL migrations of the schema, so that binaries apply
+// them regardless of the directory they are started from.
+package migrations
+
+import "embed"
+
+// FS holds the migration files at its root
+//
+//go:embed *.sql
+var FS embed.FS